@@ -166,12 +166,17 @@ func Routes(ctx context.Context, cluster cluster.Interface,
 	nsHandler := &NamespaceHandler{C: cluster.GetClient()}
 	routes.register("core", "v1", "namespaces", ActionList, nsHandler.List)
 
+	netpolHandler := &NetworkPolicyHandler{C: cluster.GetClient()}
+	routes.register("networkpolicy.system", "v1", "simulate", ActionList, netpolHandler.Simulate)
+	routes.register("networkpolicy.system", "v1", "matrix", ActionList, netpolHandler.Matrix)
+
 	kubectlHandler := KubectlHandler{cluster: cluster, options: kubectlOptions}
 	routes.register("system", "v1", "kubectl", ActionList, kubectlHandler.ExecKubectl)
 
 	podHandler := PodHandler{cluster: cluster}
 	routes.register("core", "v1", "pods", ActionList, podHandler.List)
 	routes.register("core", "v1", "pods", "shell", podHandler.ExecPods)
+	routes.register("core", "v1", "pods", "exec", podHandler.ExecCommand)
 	routes.register("core", "v1", "pods", "debug", kubectlHandler.DebugPod)
 	routes.register("core", "v1", "pods", "logs", podHandler.GetContainerLogs)
 	routes.register("core", "v1", "pods", "file", podHandler.DownloadFileFromPod)
@@ -0,0 +1,217 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apis
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"kubegems.io/kubegems/pkg/apis/gems"
+	"kubegems.io/kubegems/pkg/utils/networkpolicy"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type NetworkPolicyHandler struct {
+	C client.Client
+}
+
+// NetworkPolicyMatrix 租户下环境之间的连通性矩阵
+type NetworkPolicyMatrix struct {
+	Namespaces   []string                 `json:"namespaces"`
+	Environments []string                 `json:"environments"`
+	Results      [][]networkpolicy.Result `json:"results"` // [source][destination]
+}
+
+// @Tags        Agent.V1
+// @Summary     根据NetworkPolicy模拟两个端点间的连通性
+// @Description 仅根据NetworkPolicy对象计算,不发送流量;目标可以是pod或者service
+// @Accept      json
+// @Produce     json
+// @Param       cluster      path     string                                                     true  "cluster"
+// @Param       srcNamespace query    string                                                     true  "源namespace"
+// @Param       srcPod       query    string                                                     false "源pod,为空时表示namespace下任意没有标签的pod"
+// @Param       dstNamespace query    string                                                     true  "目标namespace"
+// @Param       dstPod       query    string                                                     false "目标pod"
+// @Param       dstService   query    string                                                     false "目标service,与dstPod二选一"
+// @Param       port         query    int                                                        false "目标端口,目标为service时为service端口;为空时忽略端口规则"
+// @Param       protocol     query    string                                                     false "协议,默认TCP"
+// @Success     200          {object} handlers.ResponseStruct{Data=networkpolicy.Result}        "result"
+// @Router      /v1/proxy/cluster/{cluster}/custom/networkpolicy.system/v1/simulate [get]
+// @Security    JWT
+func (h *NetworkPolicyHandler) Simulate(c *gin.Context) {
+	ctx := c.Request.Context()
+	port, _ := strconv.Atoi(c.Query("port"))
+	protocol := corev1.Protocol(c.DefaultQuery("protocol", string(corev1.ProtocolTCP)))
+
+	src, err := h.podEndpoint(ctx, c.Query("srcNamespace"), c.Query("srcPod"))
+	if err != nil {
+		NotOK(c, err)
+		return
+	}
+	var dst *networkpolicy.Endpoint
+	if svcname := c.Query("dstService"); svcname != "" {
+		dst, port, err = h.serviceEndpoint(ctx, c.Query("dstNamespace"), svcname, int32(port), protocol)
+	} else {
+		dst, err = h.podEndpoint(ctx, c.Query("dstNamespace"), c.Query("dstPod"))
+	}
+	if err != nil {
+		NotOK(c, err)
+		return
+	}
+	policies, err := h.listPolicies(ctx)
+	if err != nil {
+		NotOK(c, err)
+		return
+	}
+	OK(c, networkpolicy.Simulate(policies, *src, *dst, protocol, int32(port)))
+}
+
+// @Tags        Agent.V1
+// @Summary     租户下环境之间的连通性矩阵
+// @Description 以每个环境namespace下没有标签的pod作为端点,计算两两之间的连通性
+// @Accept      json
+// @Produce     json
+// @Param       cluster  path     string                                                     true  "cluster"
+// @Param       tenant   query    string                                                     true  "租户"
+// @Param       port     query    int                                                        false "目标端口,为空时忽略端口规则"
+// @Param       protocol query    string                                                     false "协议,默认TCP"
+// @Success     200      {object} handlers.ResponseStruct{Data=NetworkPolicyMatrix}          "matrix"
+// @Router      /v1/proxy/cluster/{cluster}/custom/networkpolicy.system/v1/matrix [get]
+// @Security    JWT
+func (h *NetworkPolicyHandler) Matrix(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenant := c.Query("tenant")
+	if tenant == "" {
+		NotOK(c, fmt.Errorf("tenant is required"))
+		return
+	}
+	port, _ := strconv.Atoi(c.Query("port"))
+	protocol := corev1.Protocol(c.DefaultQuery("protocol", string(corev1.ProtocolTCP)))
+
+	nslist := &corev1.NamespaceList{}
+	if err := h.C.List(ctx, nslist, client.MatchingLabels{gems.LabelTenant: tenant}); err != nil {
+		NotOK(c, err)
+		return
+	}
+	sort.Slice(nslist.Items, func(i, j int) bool { return nslist.Items[i].Name < nslist.Items[j].Name })
+
+	ret := NetworkPolicyMatrix{}
+	endpoints := []networkpolicy.Endpoint{}
+	for _, ns := range nslist.Items {
+		ret.Namespaces = append(ret.Namespaces, ns.Name)
+		ret.Environments = append(ret.Environments, ns.Labels[gems.LabelEnvironment])
+		endpoints = append(endpoints, networkpolicy.Endpoint{Namespace: ns.Name, NamespaceLabels: ns.Labels})
+	}
+	policies, err := h.listPolicies(ctx)
+	if err != nil {
+		NotOK(c, err)
+		return
+	}
+	ret.Results = networkpolicy.Matrix(policies, endpoints, protocol, int32(port))
+	OK(c, ret)
+}
+
+func (h *NetworkPolicyHandler) listPolicies(ctx context.Context) ([]netv1.NetworkPolicy, error) {
+	list := &netv1.NetworkPolicyList{}
+	if err := h.C.List(ctx, list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+func (h *NetworkPolicyHandler) podEndpoint(ctx context.Context, namespace, podname string) (*networkpolicy.Endpoint, error) {
+	if namespace == "" {
+		return nil, fmt.Errorf("namespace is required")
+	}
+	ns := &corev1.Namespace{}
+	if err := h.C.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+		return nil, err
+	}
+	ep := &networkpolicy.Endpoint{Namespace: namespace, NamespaceLabels: ns.Labels}
+	if podname == "" {
+		return ep, nil
+	}
+	pod := &corev1.Pod{}
+	if err := h.C.Get(ctx, client.ObjectKey{Namespace: namespace, Name: podname}, pod); err != nil {
+		return nil, err
+	}
+	fillPodEndpoint(ep, pod)
+	return ep, nil
+}
+
+// serviceEndpoint 使用service的一个后端pod作为目标,并将service端口转换为pod端口
+func (h *NetworkPolicyHandler) serviceEndpoint(ctx context.Context, namespace, svcname string, port int32, protocol corev1.Protocol) (*networkpolicy.Endpoint, int, error) {
+	ep, err := h.podEndpoint(ctx, namespace, "")
+	if err != nil {
+		return nil, 0, err
+	}
+	svc := &corev1.Service{}
+	if err := h.C.Get(ctx, client.ObjectKey{Namespace: namespace, Name: svcname}, svc); err != nil {
+		return nil, 0, err
+	}
+	if len(svc.Spec.Selector) == 0 {
+		return nil, 0, fmt.Errorf("service %s has no selector", svcname)
+	}
+	ep.Labels = svc.Spec.Selector
+	pods := &corev1.PodList{}
+	if err := h.C.List(ctx, pods, client.InNamespace(namespace), client.MatchingLabels(svc.Spec.Selector)); err != nil {
+		return nil, 0, err
+	}
+	if len(pods.Items) > 0 {
+		fillPodEndpoint(ep, &pods.Items[0])
+	}
+	if port == 0 {
+		return ep, 0, nil
+	}
+	for _, sp := range svc.Spec.Ports {
+		spprotocol := sp.Protocol
+		if spprotocol == "" {
+			spprotocol = corev1.ProtocolTCP
+		}
+		if sp.Port != port || spprotocol != protocol {
+			continue
+		}
+		switch {
+		case sp.TargetPort.Type == intstr.Int && sp.TargetPort.IntVal != 0:
+			return ep, int(sp.TargetPort.IntVal), nil
+		case sp.TargetPort.Type == intstr.String:
+			for _, cp := range ep.Ports {
+				if cp.Name == sp.TargetPort.StrVal {
+					return ep, int(cp.ContainerPort), nil
+				}
+			}
+			return nil, 0, fmt.Errorf("can't resolve target port %s of service %s", sp.TargetPort.StrVal, svcname)
+		default:
+			return ep, int(sp.Port), nil
+		}
+	}
+	return nil, 0, fmt.Errorf("service %s has no port %s/%d", svcname, protocol, port)
+}
+
+func fillPodEndpoint(ep *networkpolicy.Endpoint, pod *corev1.Pod) {
+	ep.Pod = pod.Name
+	ep.Labels = pod.Labels
+	ep.IP = pod.Status.PodIP
+	ep.Ports = nil
+	for _, container := range pod.Spec.Containers {
+		ep.Ports = append(ep.Ports, container.Ports...)
+	}
+}
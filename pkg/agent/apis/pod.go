@@ -225,6 +225,46 @@ func (h *PodHandler) ExecPods(c *gin.Context) {
 	})
 }
 
+type ExecCommandForm struct {
+	Container string   `json:"container"`
+	Command   []string `json:"command" binding:"required"`
+}
+
+type ExecCommandResult struct {
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+}
+
+// ExecCommand 在容器中执行一次命令并返回输出,用于备份钩子等非交互场景
+// @Tags        Agent.V1
+// @Summary     在容器中执行命令
+// @Description 在容器中执行一次命令并返回输出,命令退出码不为 0 时返回错误
+// @Accept      json
+// @Produce     json
+// @Param       cluster   path     string                                                true "cluster"
+// @Param       namespace path     string                                                true "namespace"
+// @Param       name      path     string                                                true "pod"
+// @Param       body      body     ExecCommandForm                                       true "命令"
+// @Success     200       {object} handlers.ResponseStruct{Data=ExecCommandResult}       "ok"
+// @Router      /v1/proxy/cluster/{cluster}/custom/core/v1/namespaces/{namespace}/pods/{name}/actions/exec [post]
+// @Security    JWT
+func (h *PodHandler) ExecCommand(c *gin.Context) {
+	form := &ExecCommandForm{}
+	if err := c.ShouldBindJSON(form); err != nil {
+		NotOK(c, err)
+		return
+	}
+	stdout, stderr, err := execCmdOnce(c.Request.Context(), h.cluster, c.Param("namespace"), c.Param("name"), form.Container, form.Command)
+	if err != nil {
+		if len(stderr) > 0 {
+			err = fmt.Errorf("%w: %s", err, stderr)
+		}
+		NotOK(c, err)
+		return
+	}
+	OK(c, ExecCommandResult{Stdout: string(stdout), Stderr: string(stderr)})
+}
+
 // GetContainerLogs 获取容器的stdout输出
 // @Tags        Agent.V1
 // @Summary     实时获取日志STDOUT输出(websocket)
@@ -662,7 +702,7 @@ func execCmdOnce(ctx context.Context, cluster cluster.Interface, namespace, podn
 		},
 	}
 	if err := pe.Execute(ctx); err != nil {
-		return nil, nil, err
+		return stdout.Bytes(), stderr.Bytes(), err
 	}
 	return stdout.Bytes(), stderr.Bytes(), nil
 }
@@ -67,17 +67,27 @@ var stateMap = map[string]int{
 // @Param       cluster path     string                               true "cluster"
 // @Param       query   query    string                               false "query"
 // @Param       notnull query    bool                                 false "notnull"
+// @Param       time    query    string                               false "查询时间, RFC3339 格式, 默认为当前时间"
 // @Success     200     {object} handlers.ResponseStruct{Data=object} "vector"
 // @Router      /v1/proxy/cluster/{cluster}/custom/prometheus/v1/vector [get]
 // @Security    JWT
 func (p *prometheusHandler) Vector(c *gin.Context) {
 	query := c.Query("query")
+	ts := time.Now()
+	if t := c.Query("time"); t != "" {
+		parsed, err := time.Parse(time.RFC3339, t)
+		if err != nil {
+			NotOK(c, err)
+			return
+		}
+		ts = parsed
+	}
 
 	v1api := v1.NewAPI(p.client)
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
 
-	obj, _, err := v1api.Query(ctx, query, time.Now())
+	obj, _, err := v1api.Query(ctx, query, ts)
 	if err != nil {
 		NotOK(c, err)
 		return
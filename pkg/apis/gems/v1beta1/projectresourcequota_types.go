@@ -0,0 +1,95 @@
+/*
+Copyright 2021 kubegems.io.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ProjectResourceQuotaSpec defines the desired state of ProjectResourceQuota
+type ProjectResourceQuotaSpec struct {
+	// Tenant 租户
+	Tenant string `json:"tenant"`
+	// Project 项目
+	Project string `json:"project"`
+	// Hard 项目在本集群可以使用的总资源限制,从租户的资源中划分
+	Hard corev1.ResourceList `json:"hard,omitempty"`
+}
+
+// ProjectResourceQuotaStatus defines the observed state of ProjectResourceQuota
+type ProjectResourceQuotaStatus struct {
+	// Hard 项目在本集群的总资源限制
+	Hard corev1.ResourceList `json:"hard,omitempty"`
+	// Allocated 已经划分给环境的资源
+	Allocated corev1.ResourceList `json:"allocated,omitempty"`
+	// Used 实际使用了的资源
+	Used corev1.ResourceList `json:"used,omitempty"`
+	// Children 每个环境的资源划分与使用情况
+	Children []ChildResourceQuotaStatus `json:"children,omitempty"`
+	// LastUpdateTime last update time
+	LastUpdateTime metav1.Time `json:"lastUpdateTime,omitempty"`
+}
+
+// ChildResourceQuotaStatus is the allocated and used resources of a child in the quota hierarchy.
+type ChildResourceQuotaStatus struct {
+	// Kind 子级类型, Project 或 Environment
+	Kind string `json:"kind"`
+	// Name 子级名称
+	Name string `json:"name"`
+	// Allocated 划分给子级的资源
+	Allocated corev1.ResourceList `json:"allocated,omitempty"`
+	// Used 子级实际使用了的资源
+	Used corev1.ResourceList `json:"used,omitempty"`
+}
+
+//+genclient
+//+genclient:nonNamespaced
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster,shortName=pquota,path=projectresourcequotas
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Tenant",type="string",JSONPath=".spec.tenant"
+//+kubebuilder:printcolumn:name="Project",type="string",JSONPath=".spec.project"
+//+kubebuilder:rbac:groups=gems,resources=ProjectResourceQuota,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=gems,resources=ProjectResourceQuota/status,verbs=get;list;watch;create;update;patch;delete
+
+// ProjectResourceQuota is the Schema for the projectresourcequota API
+type ProjectResourceQuota struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ProjectResourceQuotaSpec   `json:"spec,omitempty"`
+	Status ProjectResourceQuotaStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ProjectResourceQuotaList contains a list of ProjectResourceQuota
+type ProjectResourceQuotaList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ProjectResourceQuota `json:"items"`
+}
+
+const (
+	ChildKindProject     = "Project"
+	ChildKindEnvironment = "Environment"
+)
+
+func init() {
+	SchemeBuilder.Register(&ProjectResourceQuota{}, &ProjectResourceQuotaList{})
+}
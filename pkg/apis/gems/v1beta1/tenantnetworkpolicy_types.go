@@ -17,6 +17,7 @@ limitations under the License.
 package v1beta1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -32,16 +33,78 @@ type ProjectNetworkPolicy struct {
 	Name string `json:"name,omitempty"`
 }
 
+// NetworkPolicyPort 端口规则
+type NetworkPolicyPort struct {
+	// Protocol TCP, UDP 或 SCTP, 默认TCP
+	Protocol corev1.Protocol `json:"protocol,omitempty"`
+	// Port 端口
+	Port int32 `json:"port"`
+	// EndPort 端口范围的结束端口,设置时表示 [Port, EndPort] 范围
+	EndPort *int32 `json:"endPort,omitempty"`
+}
+
+// NetworkPolicyRule 一条允许规则,CIDRs 与 DNSNames 至少设置一个
+type NetworkPolicyRule struct {
+	// CIDRs 允许的网段
+	CIDRs []string `json:"cidrs,omitempty"`
+	// Except 在 CIDRs 中排除的网段
+	Except []string `json:"except,omitempty"`
+	// DNSNames 允许的域名,支持 "*.example.com" 形式的通配;仅对出口规则生效,且需要CNI支持(cilium)
+	DNSNames []string `json:"dnsNames,omitempty"`
+	// Ports 允许的端口,为空时允许所有端口
+	Ports []NetworkPolicyPort `json:"ports,omitempty"`
+}
+
+// RuleNetworkPolicy 作用于租户下部分环境的网段与端口规则
+type RuleNetworkPolicy struct {
+	// Name 规则名称
+	Name string `json:"name"`
+	// Project 作用的项目,为空时作用于租户下所有项目
+	Project string `json:"project,omitempty"`
+	// Environments 作用的环境,为空时作用于项目下所有环境
+	Environments []string `json:"environments,omitempty"`
+	// NamespaceSelector 进一步通过namespace标签筛选作用的环境
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// Ingress 允许访问环境的外部网段;设置后环境仅允许同namespace,隔离策略和这些网段的入口流量
+	Ingress []NetworkPolicyRule `json:"ingress,omitempty"`
+	// DefaultDenyEgress 默认拒绝出口流量,仅允许同namespace,集群DNS以及 Egress 中的流量
+	DefaultDenyEgress bool `json:"defaultDenyEgress,omitempty"`
+	// Egress 允许的出口规则,设置后总是默认拒绝其他出口流量
+	Egress []NetworkPolicyRule `json:"egress,omitempty"`
+	// DeniedEgressCIDRs 拒绝访问的外部网段,默认拒绝出口时从 Egress 允许的网段中排除
+	DeniedEgressCIDRs []string `json:"deniedEgressCIDRs,omitempty"`
+}
+
 // TenantNetworkPolicySpec defines the desired state of TenantNetworkPolicy
 type TenantNetworkPolicySpec struct {
 	Tenant                     string                     `json:"tenant,omitempty"`
 	TenantIsolated             bool                       `json:"tenantIsolated,omitempty"`
 	ProjectNetworkPolicies     []ProjectNetworkPolicy     `json:"projectNetworkPolicies,omitempty"`
 	EnvironmentNetworkPolicies []EnvironmentNetworkPolicy `json:"environmentNetworkPolicies,omitempty"`
+	// RuleNetworkPolicies 出口控制以及网段,端口,域名规则
+	RuleNetworkPolicies []RuleNetworkPolicy `json:"ruleNetworkPolicies,omitempty"`
+}
+
+// NamespaceNetworkPolicyStatus 单个namespace下生效的网络策略
+type NamespaceNetworkPolicyStatus struct {
+	Namespace   string `json:"namespace"`
+	Environment string `json:"environment,omitempty"`
+	// Isolation 生效的隔离级别, tenant, project 或 environment
+	Isolation []string `json:"isolation,omitempty"`
+	// Rules 生效的规则名称
+	Rules             []string            `json:"rules,omitempty"`
+	DefaultDenyEgress bool                `json:"defaultDenyEgress,omitempty"`
+	Ingress           []NetworkPolicyRule `json:"ingress,omitempty"`
+	Egress            []NetworkPolicyRule `json:"egress,omitempty"`
+	DeniedEgressCIDRs []string            `json:"deniedEgressCIDRs,omitempty"`
+	// Message 规则未能完全生效的原因,例如CNI不支持域名规则
+	Message string `json:"message,omitempty"`
 }
 
 // TenantNetworkPolicyStatus defines the observed state of TenantNetworkPolicy
 type TenantNetworkPolicyStatus struct {
+	// Namespaces 每个namespace下生效的网络策略
+	Namespaces []NamespaceNetworkPolicyStatus `json:"namespaces,omitempty"`
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 	LastUpdateTime metav1.Time `json:"lastUpdateTime,omitempty"`
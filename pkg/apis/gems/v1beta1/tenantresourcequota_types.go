@@ -38,6 +38,8 @@ type TenantResourceQuotaStatus struct {
 	Allocated corev1.ResourceList `json:"allocated,omitempty"`
 	// Used 实际使用了的资源
 	Used corev1.ResourceList `json:"used,omitempty"`
+	// Children 每个项目的资源划分与使用情况,仅包含设置了ProjectResourceQuota的项目
+	Children []ChildResourceQuotaStatus `json:"children,omitempty"`
 	// Deprecated: duplicate with LastUpdateTime.
 	// LastCountTime last count time
 	LastCountTime metav1.Time `json:"lastCountTime,omitempty"`
@@ -57,6 +57,28 @@ type PluginSpec struct {
 	// Ref can be a configmap or secret.
 	// +kubebuilder:validation:Optional
 	ValuesFrom []ValuesFrom `json:"valuesFrom,omitempty"`
+
+	// Verify configures the signature verification of the downloaded bundle.
+	// +kubebuilder:validation:Optional
+	Verify *VerifySpec `json:"verify,omitempty"`
+}
+
+const (
+	// VerifyKeyKeyring is the key of the PGP public keyring used to verify helm provenance files.
+	VerifyKeyKeyring = "keyring"
+	// VerifyKeyCosignPublicKey is the key of the PEM encoded public key used to verify cosign signatures.
+	VerifyKeyCosignPublicKey = "cosign.pub"
+)
+
+type VerifySpec struct {
+	// Required refuses to install the bundle if the verification failed.
+	// If false, the verification result is only reported in status.
+	Required bool `json:"required,omitempty"`
+	// SecretRef is the name of the secret in the bundle namespace which holds the public keys.
+	// Key "keyring" is the PGP public keyring used to verify helm provenance(.prov) files,
+	// key "cosign.pub" is the PEM encoded public key used to verify cosign signatures.
+	// +kubebuilder:validation:Required
+	SecretRef string `json:"secretRef"`
 }
 
 const (
@@ -109,8 +131,39 @@ type PluginStatus struct {
 
 	// Resources is a list of resources created/managed by the bundle.
 	Resources []ManagedResource `json:"resources,omitempty"`
+
+	// Verification is the result of the last signature verification of the bundle.
+	Verification *VerificationStatus `json:"verification,omitempty"`
 }
 
+type VerificationStatus struct {
+	// Verified is true if the bundle signature is valid.
+	Verified bool `json:"verified,omitempty"`
+
+	// Method is the method used to verify the bundle.
+	Method VerifyMethod `json:"method,omitempty"`
+
+	// Signer is the identity of the key which signed the bundle.
+	Signer string `json:"signer,omitempty"`
+
+	// Digest is the digest of the verified artifact.
+	Digest string `json:"digest,omitempty"`
+
+	// Message is the reason of the verification failure.
+	Message string `json:"message,omitempty"`
+
+	// Timestamp is the time when the bundle was verified.
+	Timestamp metav1.Time `json:"timestamp,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=helm-provenance;cosign
+type VerifyMethod string
+
+const (
+	VerifyMethodHelmProvenance VerifyMethod = "helm-provenance"
+	VerifyMethodCosign         VerifyMethod = "cosign"
+)
+
 type ManagedResource struct {
 	APIVersion string `json:"apiVersion,omitempty"`
 	Kind       string `json:"kind,omitempty"`
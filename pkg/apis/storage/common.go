@@ -20,4 +20,7 @@ const (
 	AnnotationVolumeSnapshotAnnotationKeyPersistentVolumeClaim = GroupName + "/persistentvolumevlaim"
 
 	AnnotationStorageProvisioner = "volume.beta.kubernetes.io/storage-provisioner"
+
+	// LabelBackup 应用备份创建的卷快照上的备份名称
+	LabelBackup = GroupName + "/backup"
 )
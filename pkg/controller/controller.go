@@ -152,6 +152,12 @@ func setupControllers(mgr ctrl.Manager, options *Options, setupLog logr.Logger)
 		setupLog.Error(err, "unable to create controller", "controller", "TenantResourceQuota")
 		return err
 	}
+	if err := (&gemscontroller.ProjectResourceQuotaReconciler{
+		Client: mgr.GetClient(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ProjectResourceQuota")
+		return err
+	}
 	if err := (&gemscontroller.TenantNetworkPolicyReconciler{
 		Client: mgr.GetClient(), Scheme: mgr.GetScheme(),
 		Log:      ctrl.Log.WithName("controllers").WithName("TenantNetworkPolicy"),
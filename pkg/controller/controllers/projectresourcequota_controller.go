@@ -0,0 +1,151 @@
+/*
+Copyright 2021 kubegems.io.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	gemlabels "kubegems.io/kubegems/pkg/apis/gems"
+	gemsv1beta1 "kubegems.io/kubegems/pkg/apis/gems/v1beta1"
+	"kubegems.io/kubegems/pkg/utils/statistics"
+)
+
+// ProjectResourceQuotaReconciler reconciles a ProjectResourceQuota object
+type ProjectResourceQuotaReconciler struct {
+	client.Client
+}
+
+//+kubebuilder:rbac:groups=gems.kubegems.io,resources=projectresourcequotas,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=gems.kubegems.io,resources=projectresourcequotas/status,verbs=get;update;patch
+
+func (r *ProjectResourceQuotaReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	/*
+		调度逻辑:
+		筛选项目下的所有环境,环境的ResourceQuota加起来就是项目已划分的和使用的
+	*/
+	log := ctrl.LoggerFrom(ctx)
+
+	var prq gemsv1beta1.ProjectResourceQuota
+	if err := r.Get(ctx, req.NamespacedName, &prq); err != nil {
+		log.Error(err, "get project resource quota")
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	var envList gemsv1beta1.EnvironmentList
+	if err := r.List(ctx, &envList); err != nil {
+		log.Error(err, "list environments")
+		return ctrl.Result{}, err
+	}
+	var resourceQuotaList corev1.ResourceQuotaList
+	if err := r.List(ctx, &resourceQuotaList,
+		client.MatchingLabels{gemlabels.LabelTenant: prq.Spec.Tenant, gemlabels.LabelProject: prq.Spec.Project},
+		client.InNamespace(metav1.NamespaceAll),
+	); err != nil {
+		log.Error(err, "list resource quota")
+		return ctrl.Result{}, err
+	}
+	usedByEnv := map[string]corev1.ResourceList{}
+	for _, item := range resourceQuotaList.Items {
+		env := item.Labels[gemlabels.LabelEnvironment]
+		if _, ok := usedByEnv[env]; !ok {
+			usedByEnv[env] = corev1.ResourceList{}
+		}
+		statistics.AddResourceList(usedByEnv[env], item.Status.Used)
+	}
+
+	emptyResouces := corev1.ResourceList{}
+	for name := range prq.Spec.Hard {
+		emptyResouces[name] = resource.MustParse("0")
+	}
+	used, allocated := emptyResouces.DeepCopy(), emptyResouces.DeepCopy()
+	children := []gemsv1beta1.ChildResourceQuotaStatus{}
+	for _, env := range envList.Items {
+		if env.Spec.Tenant != prq.Spec.Tenant || env.Spec.Project != prq.Spec.Project {
+			continue
+		}
+		envUsed := fixInvalidResourceName(usedByEnv[env.Name].DeepCopy())
+		statistics.AddResourceList(used, envUsed)
+		statistics.AddResourceList(allocated, env.Spec.ResourceQuota)
+		children = append(children, gemsv1beta1.ChildResourceQuotaStatus{
+			Kind:      gemsv1beta1.ChildKindEnvironment,
+			Name:      env.Name,
+			Allocated: env.Spec.ResourceQuota.DeepCopy(),
+			Used:      envUsed,
+		})
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].Name < children[j].Name })
+
+	if !equality.Semantic.DeepEqual(prq.Status.Used, used) ||
+		!equality.Semantic.DeepEqual(prq.Status.Allocated, allocated) ||
+		!equality.Semantic.DeepEqual(prq.Status.Hard, prq.Spec.Hard) ||
+		!equality.Semantic.DeepEqual(prq.Status.Children, children) {
+		log.Info("updateing status")
+		prq.Status.LastUpdateTime = metav1.Now()
+		prq.Status.Hard = prq.Spec.Hard.DeepCopy()
+		prq.Status.Used = used
+		prq.Status.Allocated = allocated
+		prq.Status.Children = children
+		if err := r.Status().Update(ctx, &prq); err != nil {
+			log.Error(err, "update project resource quota status")
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+func (r *ProjectResourceQuotaReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&gemsv1beta1.ProjectResourceQuota{}).
+		Watches(&source.Kind{Type: &gemsv1beta1.Environment{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForProject)).
+		Watches(&source.Kind{Type: &corev1.ResourceQuota{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForProject)).
+		Complete(r)
+}
+
+// requestsForProject 环境或者ResourceQuota变更的时候,让对应项目的ProjectResourceQuota重新计算
+func (r *ProjectResourceQuotaReconciler) requestsForProject(obj client.Object) []reconcile.Request {
+	labels := obj.GetLabels()
+	tenant, project := labels[gemlabels.LabelTenant], labels[gemlabels.LabelProject]
+	if env, ok := obj.(*gemsv1beta1.Environment); ok {
+		tenant, project = env.Spec.Tenant, env.Spec.Project
+	}
+	if tenant == "" || project == "" {
+		return nil
+	}
+	prqs := &gemsv1beta1.ProjectResourceQuotaList{}
+	if err := r.List(context.Background(), prqs); err != nil {
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, item := range prqs.Items {
+		if item.Spec.Tenant == tenant && item.Spec.Project == project {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: item.Name}})
+		}
+	}
+	return requests
+}
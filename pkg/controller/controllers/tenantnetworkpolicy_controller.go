@@ -25,6 +25,7 @@ import (
 	netv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/record"
@@ -43,6 +44,8 @@ const (
 	isoKindTenant      = "tenant"
 	isoKindProject     = "project"
 	isoKindEnvironment = "environment"
+
+	defaultNetworkPolicyName = "default"
 )
 
 type NetworkPolicyAction struct {
@@ -110,6 +113,7 @@ func (r *TenantNetworkPolicyReconciler) Reconcile(ctx context.Context, req ctrl.
 		for _, np := range nplist.Items {
 			r.Delete(ctx, &np)
 		}
+		r.deleteDNSRuleNetworkPolicies(ctx, tenantname)
 		netpol.SetOwnerReferences(nil)
 		netpol.SetFinalizers(nil)
 		r.Update(ctx, &netpol)
@@ -143,6 +147,17 @@ func (r *TenantNetworkPolicyReconciler) Reconcile(ctx context.Context, req ctrl.
 		}
 	}
 
+	// 出口控制以及网段,端口,域名规则
+	namespaces := r.handleRuleNetworkPolicies(ctx, &netpol, statusMap)
+	if !equality.Semantic.DeepEqual(netpol.Status.Namespaces, namespaces) {
+		netpol.Status.Namespaces = namespaces
+		netpol.Status.LastUpdateTime = metav1.Now()
+		if err := r.Status().Update(ctx, &netpol); err != nil {
+			log.Error(err, "update tenantnetworkpolicy status")
+			return ctrl.Result{}, err
+		}
+	}
+
 	if !controllerutil.ContainsFinalizer(&netpol, gemlabels.FinalizerNetworkPolicy) {
 		controllerutil.AddFinalizer(&netpol, gemlabels.FinalizerNetworkPolicy)
 		r.Update(ctx, &netpol)
@@ -179,7 +194,7 @@ func (r *TenantNetworkPolicyReconciler) handleStatusMap(ctx context.Context, st
 	}
 
 	for ns, action := range st {
-		defaultnetpol := DefaultNetworkPolicy(ns, "default", cidrs)
+		defaultnetpol := DefaultNetworkPolicy(ns, defaultNetworkPolicyName, cidrs)
 		action.Modify = &defaultnetpol
 		if action.TenantISO {
 			AddNamespaceSelector(action.Modify, gemlabels.LabelTenant, action.Tenant)
@@ -222,6 +237,10 @@ func (r *TenantNetworkPolicyReconciler) handleRelatedObjectList(ctx context.Cont
 	})
 	npmap := map[string]netv1.NetworkPolicy{}
 	for _, np := range nplist.Items {
+		// 仅处理隔离策略,规则策略由 handleRuleNetworkPolicies 处理
+		if np.Name != defaultNetworkPolicyName {
+			continue
+		}
 		npmap[np.Namespace] = np
 	}
 	for _, ns := range nslist.Items {
@@ -0,0 +1,401 @@
+/*
+Copyright 2021 kubegems.io.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	gemlabels "kubegems.io/kubegems/pkg/apis/gems"
+	gemsv1beta1 "kubegems.io/kubegems/pkg/apis/gems/v1beta1"
+	"kubegems.io/kubegems/pkg/utils/maps"
+)
+
+const (
+	// RuleNetworkPolicyName 由 RuleNetworkPolicies 生成的 NetworkPolicy 名称
+	RuleNetworkPolicyName = "rules"
+	// DNSRuleNetworkPolicyName 由域名规则生成的 CiliumNetworkPolicy 名称
+	DNSRuleNetworkPolicyName = "rules-dns"
+)
+
+var ciliumNetworkPolicyGVK = schema.GroupVersionKind{Group: "cilium.io", Version: "v2", Kind: "CiliumNetworkPolicy"}
+
+// handleRuleNetworkPolicies 将规则同步到租户下的每个namespace,并返回每个namespace下生效的网络策略
+func (r *TenantNetworkPolicyReconciler) handleRuleNetworkPolicies(ctx context.Context, netpol *gemsv1beta1.TenantNetworkPolicy, st map[string]NetworkPolicyAction) []gemsv1beta1.NamespaceNetworkPolicyStatus {
+	log := r.Log.WithValues("tenantnetworkpolicy", netpol.Name)
+
+	nslist := &corev1.NamespaceList{}
+	if err := r.List(ctx, nslist, client.MatchingLabels{gemlabels.LabelTenant: netpol.Spec.Tenant}); err != nil {
+		log.Error(err, "list tenant namespaces")
+		return nil
+	}
+	dnsSupported := r.dnsRuleSupported()
+
+	statuses := []gemsv1beta1.NamespaceNetworkPolicyStatus{}
+	for _, ns := range nslist.Items {
+		status := gemsv1beta1.NamespaceNetworkPolicyStatus{
+			Namespace:   ns.Name,
+			Environment: ns.Labels[gemlabels.LabelEnvironment],
+		}
+		if action, ok := st[ns.Name]; ok {
+			if action.TenantISO {
+				status.Isolation = append(status.Isolation, isoKindTenant)
+			}
+			if action.ProjectISO {
+				status.Isolation = append(status.Isolation, isoKindProject)
+			}
+			if action.EnvironmentISO {
+				status.Isolation = append(status.Isolation, isoKindEnvironment)
+			}
+		}
+		for _, rule := range netpol.Spec.RuleNetworkPolicies {
+			if !ruleMatchNamespace(rule, &ns) {
+				continue
+			}
+			status.Rules = append(status.Rules, rule.Name)
+			status.Ingress = append(status.Ingress, rule.Ingress...)
+			status.Egress = append(status.Egress, rule.Egress...)
+			status.DefaultDenyEgress = status.DefaultDenyEgress || rule.DefaultDenyEgress
+			status.DeniedEgressCIDRs = append(status.DeniedEgressCIDRs, rule.DeniedEgressCIDRs...)
+		}
+		// 存在出口白名单时总是默认拒绝,与 CiliumNetworkPolicy 的语义保持一致;
+		// 其他规则中的拒绝列表从白名单中排除
+		status.DefaultDenyEgress = status.DefaultDenyEgress || len(status.Egress) > 0
+
+		commonLabels := maps.GetLabels(ns.Labels, gemlabels.CommonLabels)
+		var rulepolicy client.Object
+		if np := BuildRuleNetworkPolicy(ns.Name, RuleNetworkPolicyName, &status); np != nil {
+			rulepolicy = np
+		}
+		if err := r.syncObject(ctx, ns.Name, RuleNetworkPolicyName, rulepolicy, commonLabels); err != nil {
+			log.Error(err, "sync rule networkpolicy", "namespace", ns.Name)
+			status.Message = err.Error()
+		}
+
+		var dnspolicy client.Object
+		if hasDNSRules(status.Egress) {
+			if dnsSupported {
+				dnspolicy = BuildDNSRuleNetworkPolicy(ns.Name, DNSRuleNetworkPolicyName, status.Egress, status.DeniedEgressCIDRs)
+			} else {
+				status.Message = "dns rules are ignored, the CNI of the cluster doesn't support CiliumNetworkPolicy"
+			}
+		}
+		if dnsSupported {
+			if err := r.syncObject(ctx, ns.Name, DNSRuleNetworkPolicyName, dnspolicy, commonLabels); err != nil {
+				log.Error(err, "sync dns rule networkpolicy", "namespace", ns.Name)
+				status.Message = err.Error()
+			}
+		}
+		if len(status.Isolation) > 0 || len(status.Rules) > 0 {
+			statuses = append(statuses, status)
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Namespace < statuses[j].Namespace })
+	return statuses
+}
+
+// syncObject 创建或更新desired,desired为nil时删除已存在的对象
+func (r *TenantNetworkPolicyReconciler) syncObject(ctx context.Context, namespace, name string, desired client.Object, commonLabels map[string]string) error {
+	if desired == nil {
+		var existing client.Object = &netv1.NetworkPolicy{}
+		if name == DNSRuleNetworkPolicyName {
+			u := &unstructured.Unstructured{}
+			u.SetGroupVersionKind(ciliumNetworkPolicyGVK)
+			existing = u
+		}
+		existing.SetNamespace(namespace)
+		existing.SetName(name)
+		return client.IgnoreNotFound(r.Delete(ctx, existing))
+	}
+	desired.SetLabels(labels.Merge(desired.GetLabels(), commonLabels))
+	switch obj := desired.(type) {
+	case *netv1.NetworkPolicy:
+		existing := &netv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+		_, err := controllerutil.CreateOrUpdate(ctx, r.Client, existing, func() error {
+			existing.Labels = labels.Merge(existing.Labels, obj.Labels)
+			if !equality.Semantic.DeepEqual(existing.Spec, obj.Spec) {
+				existing.Spec = obj.Spec
+			}
+			return nil
+		})
+		return err
+	case *unstructured.Unstructured:
+		existing := &unstructured.Unstructured{}
+		existing.SetGroupVersionKind(obj.GroupVersionKind())
+		existing.SetNamespace(namespace)
+		existing.SetName(name)
+		_, err := controllerutil.CreateOrUpdate(ctx, r.Client, existing, func() error {
+			existing.SetLabels(labels.Merge(existing.GetLabels(), obj.GetLabels()))
+			existing.Object["spec"] = obj.Object["spec"]
+			return nil
+		})
+		return err
+	}
+	return nil
+}
+
+// dnsRuleSupported 集群中存在 CiliumNetworkPolicy 时支持域名规则
+func (r *TenantNetworkPolicyReconciler) dnsRuleSupported() bool {
+	_, err := r.RESTMapper().RESTMapping(ciliumNetworkPolicyGVK.GroupKind(), ciliumNetworkPolicyGVK.Version)
+	return err == nil
+}
+
+// deleteDNSRuleNetworkPolicies 删除租户的所有 CiliumNetworkPolicy
+func (r *TenantNetworkPolicyReconciler) deleteDNSRuleNetworkPolicies(ctx context.Context, tenant string) {
+	if !r.dnsRuleSupported() {
+		return
+	}
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(ciliumNetworkPolicyGVK.GroupVersion().WithKind(ciliumNetworkPolicyGVK.Kind + "List"))
+	if err := r.List(ctx, list, client.MatchingLabels{gemlabels.LabelTenant: tenant}); err != nil {
+		return
+	}
+	for i := range list.Items {
+		if err := r.Delete(ctx, &list.Items[i]); err != nil && !errors.IsNotFound(err) {
+			r.Log.Error(err, "delete dns rule networkpolicy", "namespace", list.Items[i].GetNamespace())
+		}
+	}
+}
+
+func ruleMatchNamespace(rule gemsv1beta1.RuleNetworkPolicy, ns *corev1.Namespace) bool {
+	if rule.Project != "" && ns.Labels[gemlabels.LabelProject] != rule.Project {
+		return false
+	}
+	if len(rule.Environments) > 0 {
+		matched := false
+		for _, env := range rule.Environments {
+			if ns.Labels[gemlabels.LabelEnvironment] == env {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if rule.NamespaceSelector != nil {
+		sel, err := metav1.LabelSelectorAsSelector(rule.NamespaceSelector)
+		if err != nil || !sel.Matches(labels.Set(ns.Labels)) {
+			return false
+		}
+	}
+	return true
+}
+
+// BuildRuleNetworkPolicy 根据namespace下生效的规则生成 NetworkPolicy,没有规则时返回nil.
+// 存在出口规则(网段或域名)时总是默认拒绝出口流量: 网段白名单只有在默认拒绝下才有意义,
+// 而域名规则生成的 CiliumNetworkPolicy 本身就会默认拒绝其他出口流量
+func BuildRuleNetworkPolicy(namespace, name string, status *gemsv1beta1.NamespaceNetworkPolicyStatus) *netv1.NetworkPolicy {
+	np := &netv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       netv1.NetworkPolicySpec{PodSelector: metav1.LabelSelector{}},
+	}
+	// 入口: 外部网段白名单
+	for _, rule := range status.Ingress {
+		peers := ipBlockPeers(rule)
+		if len(peers) == 0 {
+			continue
+		}
+		np.Spec.Ingress = append(np.Spec.Ingress, netv1.NetworkPolicyIngressRule{From: peers, Ports: networkPolicyPorts(rule.Ports)})
+	}
+	if len(np.Spec.Ingress) > 0 {
+		// 同namespace,与出口保持一致
+		np.Spec.Ingress = append([]netv1.NetworkPolicyIngressRule{
+			{From: []netv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}}},
+		}, np.Spec.Ingress...)
+		np.Spec.PolicyTypes = append(np.Spec.PolicyTypes, netv1.PolicyTypeIngress)
+	}
+
+	// 出口
+	switch {
+	case status.DefaultDenyEgress || len(status.Egress) > 0:
+		np.Spec.Egress = append(np.Spec.Egress,
+			// 同namespace
+			netv1.NetworkPolicyEgressRule{To: []netv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}}},
+			// 集群DNS
+			netv1.NetworkPolicyEgressRule{
+				To: []netv1.NetworkPolicyPeer{{NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{corev1.LabelMetadataName: metav1.NamespaceSystem},
+				}}},
+				Ports: networkPolicyPorts([]gemsv1beta1.NetworkPolicyPort{{Protocol: corev1.ProtocolUDP, Port: 53}, {Protocol: corev1.ProtocolTCP, Port: 53}}),
+			},
+		)
+		for _, rule := range status.Egress {
+			// 白名单中排除拒绝列表
+			rule.Except = append(append([]string{}, rule.Except...), status.DeniedEgressCIDRs...)
+			if peers := ipBlockPeers(rule); len(peers) > 0 {
+				np.Spec.Egress = append(np.Spec.Egress, netv1.NetworkPolicyEgressRule{To: peers, Ports: networkPolicyPorts(rule.Ports)})
+			}
+		}
+	case len(status.DeniedEgressCIDRs) > 0:
+		np.Spec.Egress = append(np.Spec.Egress,
+			// 集群内部
+			netv1.NetworkPolicyEgressRule{To: []netv1.NetworkPolicyPeer{{NamespaceSelector: &metav1.LabelSelector{}}}},
+			// 除拒绝列表外的所有网段
+			netv1.NetworkPolicyEgressRule{To: ipBlockPeers(gemsv1beta1.NetworkPolicyRule{
+				CIDRs:  []string{"0.0.0.0/0", "::/0"},
+				Except: status.DeniedEgressCIDRs,
+			})},
+		)
+	}
+	if len(np.Spec.Egress) > 0 {
+		np.Spec.PolicyTypes = append(np.Spec.PolicyTypes, netv1.PolicyTypeEgress)
+	}
+
+	if len(np.Spec.PolicyTypes) == 0 {
+		return nil
+	}
+	return np
+}
+
+// BuildDNSRuleNetworkPolicy 根据出口域名规则生成 CiliumNetworkPolicy,拒绝列表中的网段即使域名匹配也不允许访问
+func BuildDNSRuleNetworkPolicy(namespace, name string, egress []gemsv1beta1.NetworkPolicyRule, denied []string) *unstructured.Unstructured {
+	egressRules := []interface{}{
+		// 域名规则需要cilium代理DNS请求
+		map[string]interface{}{
+			"toEndpoints": []interface{}{map[string]interface{}{
+				"matchLabels": map[string]interface{}{
+					"k8s:io.kubernetes.pod.namespace": metav1.NamespaceSystem,
+				},
+			}},
+			"toPorts": []interface{}{map[string]interface{}{
+				"ports": []interface{}{map[string]interface{}{"port": "53", "protocol": "ANY"}},
+				"rules": map[string]interface{}{"dns": []interface{}{map[string]interface{}{"matchPattern": "*"}}},
+			}},
+		},
+	}
+	for _, rule := range egress {
+		if len(rule.DNSNames) == 0 {
+			continue
+		}
+		fqdns := []interface{}{}
+		for _, dnsname := range rule.DNSNames {
+			if strings.Contains(dnsname, "*") {
+				fqdns = append(fqdns, map[string]interface{}{"matchPattern": dnsname})
+			} else {
+				fqdns = append(fqdns, map[string]interface{}{"matchName": dnsname})
+			}
+		}
+		item := map[string]interface{}{"toFQDNs": fqdns}
+		if len(rule.Ports) > 0 {
+			ports := []interface{}{}
+			for _, port := range rule.Ports {
+				protocol := port.Protocol
+				if protocol == "" {
+					protocol = corev1.ProtocolTCP
+				}
+				p := map[string]interface{}{"port": strconv.Itoa(int(port.Port)), "protocol": string(protocol)}
+				if port.EndPort != nil {
+					p["endPort"] = int64(*port.EndPort)
+				}
+				ports = append(ports, p)
+			}
+			item["toPorts"] = []interface{}{map[string]interface{}{"ports": ports}}
+		}
+		egressRules = append(egressRules, item)
+	}
+	spec := map[string]interface{}{
+		"endpointSelector": map[string]interface{}{},
+		"egress":           egressRules,
+	}
+	if len(denied) > 0 {
+		cidrs := []interface{}{}
+		for _, cidr := range denied {
+			cidrs = append(cidrs, cidr)
+		}
+		spec["egressDeny"] = []interface{}{map[string]interface{}{"toCIDR": cidrs}}
+	}
+	u := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	u.SetGroupVersionKind(ciliumNetworkPolicyGVK)
+	u.SetNamespace(namespace)
+	u.SetName(name)
+	return u
+}
+
+func hasDNSRules(rules []gemsv1beta1.NetworkPolicyRule) bool {
+	for _, rule := range rules {
+		if len(rule.DNSNames) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func ipBlockPeers(rule gemsv1beta1.NetworkPolicyRule) []netv1.NetworkPolicyPeer {
+	peers := []netv1.NetworkPolicyPeer{}
+	for _, cidr := range rule.CIDRs {
+		block := &netv1.IPBlock{CIDR: cidr}
+		for _, except := range rule.Except {
+			if cidrContains(cidr, except) {
+				block.Except = append(block.Except, except)
+			}
+		}
+		peers = append(peers, netv1.NetworkPolicyPeer{IPBlock: block})
+	}
+	return peers
+}
+
+// cidrContains 判断 child 网段是否在 parent 网段内
+func cidrContains(parent, child string) bool {
+	_, pnet, err := net.ParseCIDR(parent)
+	if err != nil {
+		return false
+	}
+	_, cnet, err := net.ParseCIDR(child)
+	if err != nil {
+		return false
+	}
+	pones, _ := pnet.Mask.Size()
+	cones, _ := cnet.Mask.Size()
+	return pnet.Contains(cnet.IP) && pones <= cones
+}
+
+func networkPolicyPorts(ports []gemsv1beta1.NetworkPolicyPort) []netv1.NetworkPolicyPort {
+	if len(ports) == 0 {
+		return nil
+	}
+	ret := make([]netv1.NetworkPolicyPort, 0, len(ports))
+	for _, port := range ports {
+		protocol := port.Protocol
+		if protocol == "" {
+			protocol = corev1.ProtocolTCP
+		}
+		p := intstr.FromInt(int(port.Port))
+		np := netv1.NetworkPolicyPort{Protocol: &protocol, Port: &p}
+		if port.EndPort != nil {
+			np.EndPort = pointer.Int32(*port.EndPort)
+		}
+		ret = append(ret, np)
+	}
+	return ret
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2021 kubegems.io.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	gemsv1beta1 "kubegems.io/kubegems/pkg/apis/gems/v1beta1"
+)
+
+func TestBuildRuleNetworkPolicy_Egress(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        gemsv1beta1.NamespaceNetworkPolicyStatus
+		wantNil       bool
+		wantEgress    int
+		wantAllowCIDR string
+	}{
+		{
+			name:    "no rules",
+			status:  gemsv1beta1.NamespaceNetworkPolicyStatus{},
+			wantNil: true,
+		},
+		{
+			name: "cidr egress without defaultDenyEgress",
+			status: gemsv1beta1.NamespaceNetworkPolicyStatus{
+				Egress: []gemsv1beta1.NetworkPolicyRule{{CIDRs: []string{"10.0.0.0/8"}}},
+			},
+			// 同namespace,集群DNS,网段白名单
+			wantEgress:    3,
+			wantAllowCIDR: "10.0.0.0/8",
+		},
+		{
+			name: "dns egress only",
+			status: gemsv1beta1.NamespaceNetworkPolicyStatus{
+				Egress: []gemsv1beta1.NetworkPolicyRule{{DNSNames: []string{"*.example.com"}}},
+			},
+			// 同namespace,集群DNS
+			wantEgress: 2,
+		},
+		{
+			name: "denied cidrs",
+			status: gemsv1beta1.NamespaceNetworkPolicyStatus{
+				DeniedEgressCIDRs: []string{"169.254.0.0/16", "fd00::/8"},
+			},
+			// 集群内部,除拒绝列表外的所有网段
+			wantEgress: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			np := BuildRuleNetworkPolicy("ns", RuleNetworkPolicyName, &tt.status)
+			if tt.wantNil {
+				if np != nil {
+					t.Fatalf("BuildRuleNetworkPolicy() = %v, want nil", np)
+				}
+				return
+			}
+			if np == nil {
+				t.Fatal("BuildRuleNetworkPolicy() = nil")
+			}
+			if len(np.Spec.PolicyTypes) != 1 || np.Spec.PolicyTypes[0] != netv1.PolicyTypeEgress {
+				t.Errorf("PolicyTypes = %v, want [Egress]", np.Spec.PolicyTypes)
+			}
+			if len(np.Spec.Egress) != tt.wantEgress {
+				t.Fatalf("len(Egress) = %d, want %d", len(np.Spec.Egress), tt.wantEgress)
+			}
+			if tt.wantAllowCIDR != "" {
+				last := np.Spec.Egress[len(np.Spec.Egress)-1]
+				if len(last.To) != 1 || last.To[0].IPBlock == nil || last.To[0].IPBlock.CIDR != tt.wantAllowCIDR {
+					t.Errorf("allowed egress = %v, want %s", last.To, tt.wantAllowCIDR)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildRuleNetworkPolicy_DeniedCIDRsIPv6(t *testing.T) {
+	status := &gemsv1beta1.NamespaceNetworkPolicyStatus{
+		DeniedEgressCIDRs: []string{"169.254.0.0/16", "fd00::/8"},
+	}
+	np := BuildRuleNetworkPolicy("ns", RuleNetworkPolicyName, status)
+	blocks := map[string][]string{}
+	for _, peer := range np.Spec.Egress[1].To {
+		blocks[peer.IPBlock.CIDR] = peer.IPBlock.Except
+	}
+	if except := blocks["0.0.0.0/0"]; len(except) != 1 || except[0] != "169.254.0.0/16" {
+		t.Errorf("ipv4 except = %v, want [169.254.0.0/16]", except)
+	}
+	if except := blocks["::/0"]; len(except) != 1 || except[0] != "fd00::/8" {
+		t.Errorf("ipv6 except = %v, want [fd00::/8]", except)
+	}
+}
+
+func TestBuildRuleNetworkPolicy_Ingress(t *testing.T) {
+	status := &gemsv1beta1.NamespaceNetworkPolicyStatus{
+		Ingress: []gemsv1beta1.NetworkPolicyRule{{CIDRs: []string{"192.168.0.0/16"}}},
+	}
+	np := BuildRuleNetworkPolicy("ns", RuleNetworkPolicyName, status)
+	if len(np.Spec.PolicyTypes) != 1 || np.Spec.PolicyTypes[0] != netv1.PolicyTypeIngress {
+		t.Fatalf("PolicyTypes = %v, want [Ingress]", np.Spec.PolicyTypes)
+	}
+	if len(np.Spec.Ingress) != 2 {
+		t.Fatalf("len(Ingress) = %d, want 2", len(np.Spec.Ingress))
+	}
+	// 同namespace的流量不受影响
+	if from := np.Spec.Ingress[0].From; len(from) != 1 || from[0].PodSelector == nil || from[0].IPBlock != nil {
+		t.Errorf("first ingress rule = %v, want same namespace", from)
+	}
+	if from := np.Spec.Ingress[1].From; len(from) != 1 || from[0].IPBlock == nil || from[0].IPBlock.CIDR != "192.168.0.0/16" {
+		t.Errorf("second ingress rule = %v, want 192.168.0.0/16", from)
+	}
+}
+
+func TestBuildRuleNetworkPolicy_DeniedCIDRsWithEgress(t *testing.T) {
+	// 两条规则合并: 一条出口白名单,一条拒绝列表
+	status := &gemsv1beta1.NamespaceNetworkPolicyStatus{
+		DefaultDenyEgress: true,
+		Egress:            []gemsv1beta1.NetworkPolicyRule{{CIDRs: []string{"10.0.0.0/8"}}, {DNSNames: []string{"example.com"}}},
+		DeniedEgressCIDRs: []string{"10.1.0.0/16", "172.16.0.0/12"},
+	}
+	np := BuildRuleNetworkPolicy("ns", RuleNetworkPolicyName, status)
+	last := np.Spec.Egress[len(np.Spec.Egress)-1]
+	if len(last.To) != 1 || last.To[0].IPBlock == nil {
+		t.Fatalf("allowed egress = %v", last.To)
+	}
+	if except := last.To[0].IPBlock.Except; len(except) != 1 || except[0] != "10.1.0.0/16" {
+		t.Errorf("except = %v, want [10.1.0.0/16]", except)
+	}
+
+	cnp := BuildDNSRuleNetworkPolicy("ns", DNSRuleNetworkPolicyName, status.Egress, status.DeniedEgressCIDRs)
+	deny, _, _ := unstructured.NestedSlice(cnp.Object, "spec", "egressDeny")
+	if len(deny) != 1 {
+		t.Fatalf("egressDeny = %v, want 1 rule", deny)
+	}
+	if cidrs, _, _ := unstructured.NestedStringSlice(deny[0].(map[string]interface{}), "toCIDR"); len(cidrs) != 2 {
+		t.Errorf("egressDeny toCIDR = %v, want 2 cidrs", cidrs)
+	}
+}
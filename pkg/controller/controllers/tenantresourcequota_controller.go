@@ -18,6 +18,7 @@ package controllers
 
 import (
 	"context"
+	"sort"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
@@ -29,6 +30,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	gemlabels "kubegems.io/kubegems/pkg/apis/gems"
@@ -83,9 +85,31 @@ func (r *TenantResourceQuotaReconciler) Reconcile(ctx context.Context, req ctrl.
 	// just set limits.storage same with requests.storage in oder have same behavior with other resources
 	hard, used = fixInvalidResourceName(hard), fixInvalidResourceName(used)
 
-	if !equality.Semantic.DeepEqual(rq.Status.Used, used) || !equality.Semantic.DeepEqual(rq.Status.Allocated, hard) {
+	// 划分了项目资源限制的项目
+	var projectResourceQuotaList gemsv1beta1.ProjectResourceQuotaList
+	if err := r.List(ctx, &projectResourceQuotaList); err != nil {
+		log.Error(err, "list project resource quota")
+		return ctrl.Result{}, err
+	}
+	children := []gemsv1beta1.ChildResourceQuotaStatus{}
+	for _, item := range projectResourceQuotaList.Items {
+		if item.Spec.Tenant != rq.Name {
+			continue
+		}
+		children = append(children, gemsv1beta1.ChildResourceQuotaStatus{
+			Kind:      gemsv1beta1.ChildKindProject,
+			Name:      item.Spec.Project,
+			Allocated: item.Spec.Hard.DeepCopy(),
+			Used:      item.Status.Used.DeepCopy(),
+		})
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].Name < children[j].Name })
+
+	if !equality.Semantic.DeepEqual(rq.Status.Used, used) || !equality.Semantic.DeepEqual(rq.Status.Allocated, hard) ||
+		!equality.Semantic.DeepEqual(rq.Status.Children, children) {
 		log.Info("updateing status")
 		rq.Status.LastUpdateTime = metav1.Now()
+		rq.Status.Children = children
 		rq.Status.Used = used
 		rq.Status.Allocated = hard // Hard is the set of enforced hard limits for each named resource.
 		rq.Status.Hard = hard      // Hard is the set of enforced hard limits for each named resource.
@@ -108,6 +132,15 @@ func (r *TenantResourceQuotaReconciler) SetupWithManager(mgr ctrl.Manager) error
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&gemsv1beta1.TenantResourceQuota{}).
 		Watches(&source.Kind{Type: &corev1.ResourceQuota{}}, NewResourceQuotaHandler()).
+		Watches(&source.Kind{Type: &gemsv1beta1.ProjectResourceQuota{}}, handler.EnqueueRequestsFromMapFunc(
+			func(obj client.Object) []reconcile.Request {
+				prq, ok := obj.(*gemsv1beta1.ProjectResourceQuota)
+				if !ok || prq.Spec.Tenant == "" {
+					return nil
+				}
+				return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: prq.Spec.Tenant}}}
+			},
+		)).
 		Complete(r)
 }
 
@@ -36,6 +36,11 @@ var (
 		Version: gemsv1beta1.GroupVersion.Version,
 		Kind:    "TenantResourceQuota",
 	}
+	gkvProjectResourceQuota = metav1.GroupVersionKind{
+		Group:   gemsv1beta1.GroupVersion.Group,
+		Version: gemsv1beta1.GroupVersion.Version,
+		Kind:    "ProjectResourceQuota",
+	}
 	gkvTenantNetworkPolicy = metav1.GroupVersionKind{
 		Group:   gemsv1beta1.GroupVersion.Group,
 		Version: gemsv1beta1.GroupVersion.Version,
@@ -72,6 +72,8 @@ func (r *ResourceValidate) Handle(ctx context.Context, req admission.Request) ad
 		return r.ValidateTenant(ctx, req)
 	case gkvTenantResourceQuota:
 		return r.ValidateTenantResourceQuota(ctx, req)
+	case gkvProjectResourceQuota:
+		return r.ValidateProjectResourceQuota(ctx, req)
 	case gkvTenantGateway:
 		return r.ValidateTenantGateway(ctx, req)
 	case gkvTenantNetworkPolicy:
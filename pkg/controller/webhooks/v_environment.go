@@ -66,6 +66,12 @@ func (r *ResourceValidate) ValidateEnvironment(ctx context.Context, req admissio
 		if enough, msgs := r.tenantResourceIsEnough(&tenantRq, env, &old); !enough {
 			return admission.Denied(strings.Join(msgs, ";"))
 		}
+		// 检查项目的资源是否足够
+		if enough, msgs, err := r.projectResourceIsEnough(ctx, env); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		} else if !enough {
+			return admission.Denied(strings.Join(msgs, ";"))
+		}
 
 		// 3. 检查LimitRange是否合法
 		if errmsg, invalid := resourcequota.IsLimitRangeInvalid(env.Spec.LimitRage); invalid {
@@ -99,6 +105,11 @@ func (r *ResourceValidate) ValidateEnvironment(ctx context.Context, req admissio
 			if enough, msgs := r.tenantResourceIsEnough(&tenantRq, env, &old); !enough {
 				return admission.Denied(strings.Join(msgs, ";"))
 			}
+			if enough, msgs, err := r.projectResourceIsEnough(ctx, env); err != nil {
+				return admission.Errored(http.StatusBadRequest, err)
+			} else if !enough {
+				return admission.Denied(strings.Join(msgs, ";"))
+			}
 		}
 		if errmsg, invalid := resourcequota.IsLimitRangeInvalid(env.Spec.LimitRage); invalid {
 			msg := fmt.Sprintf("LimitRange format error: %v", strings.Join(errmsg, ";"))
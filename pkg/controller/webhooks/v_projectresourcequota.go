@@ -0,0 +1,143 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	gemsv1beta1 "kubegems.io/kubegems/pkg/apis/gems/v1beta1"
+	"kubegems.io/kubegems/pkg/utils/resourcequota"
+	"kubegems.io/kubegems/pkg/utils/statistics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func (r *ResourceValidate) ValidateProjectResourceQuota(ctx context.Context, req admission.Request) admission.Response {
+	prq := &gemsv1beta1.ProjectResourceQuota{}
+	switch req.Operation {
+	case v1.Create, v1.Update:
+		if err := r.decoder.DecodeRaw(req.Object, prq); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		if prq.Spec.Tenant == "" || prq.Spec.Project == "" {
+			return admission.Denied("field tenant and project are required")
+		}
+		if req.Operation == v1.Update {
+			old := &gemsv1beta1.ProjectResourceQuota{}
+			if err := r.decoder.DecodeRaw(req.OldObject, old); err != nil {
+				return admission.Errored(http.StatusBadRequest, err)
+			}
+			if old.Spec.Tenant != prq.Spec.Tenant || old.Spec.Project != prq.Spec.Project {
+				return admission.Denied("field tenant and project are immutable")
+			}
+		}
+
+		// 1. 项目划分的资源总和不能超过租户的资源
+		trq := &gemsv1beta1.TenantResourceQuota{}
+		if err := r.Client.Get(ctx, types.NamespacedName{Name: prq.Spec.Tenant}, trq); err != nil {
+			if errors.IsNotFound(err) {
+				return admission.Denied(fmt.Sprintf("ProjectResourceQuota related Tenant %s has no TenantResourceQuota", prq.Spec.Tenant))
+			}
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		prqs := &gemsv1beta1.ProjectResourceQuotaList{}
+		if err := r.Client.List(ctx, prqs); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		allocated := prq.Spec.Hard.DeepCopy()
+		for _, item := range prqs.Items {
+			if item.Spec.Tenant != prq.Spec.Tenant || item.Name == prq.Name {
+				continue
+			}
+			if item.Spec.Project == prq.Spec.Project {
+				return admission.Denied(fmt.Sprintf("project %s already has ProjectResourceQuota %s", prq.Spec.Project, item.Name))
+			}
+			statistics.AddResourceList(allocated, item.Spec.Hard)
+		}
+		keys := ResourceKeys(resourcequota.GetDefaultTeantResourceQuota())
+		if over, msgs := resourcequota.ResourceOverAllocated(trq.Spec.Hard, allocated, keys); over {
+			return admission.Denied("tenant: " + strings.Join(msgs, ";"))
+		}
+
+		// 2. 项目的资源不能少于已经划分给环境的资源
+		envAllocated, err := r.getProjectAllocatedResource(ctx, prq.Spec.Tenant, prq.Spec.Project, "")
+		if err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		if over, msgs := resourcequota.ResourceOverAllocated(prq.Spec.Hard, envAllocated, keys); over {
+			return admission.Denied("environments: " + strings.Join(msgs, ";"))
+		}
+		return admission.Allowed("pass")
+	default:
+		return admission.Allowed("pass")
+	}
+}
+
+// getProjectResourceQuota 获取项目在本集群的资源限制,项目未设置资源限制时返回nil
+func (r *ResourceValidate) getProjectResourceQuota(ctx context.Context, tenant, project string) (*gemsv1beta1.ProjectResourceQuota, error) {
+	prqs := &gemsv1beta1.ProjectResourceQuotaList{}
+	if err := r.Client.List(ctx, prqs); err != nil {
+		return nil, err
+	}
+	for i, item := range prqs.Items {
+		if item.Spec.Tenant == tenant && item.Spec.Project == project {
+			return &prqs.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// getProjectAllocatedResource 获取项目已经划分给环境的资源总和,忽略名为 exclude 的环境
+func (r *ResourceValidate) getProjectAllocatedResource(ctx context.Context, tenant, project, exclude string) (corev1.ResourceList, error) {
+	total := corev1.ResourceList{}
+	envs := &gemsv1beta1.EnvironmentList{}
+	if err := r.Client.List(ctx, envs); err != nil {
+		return total, err
+	}
+	for _, env := range envs.Items {
+		if env.Spec.Tenant != tenant || env.Spec.Project != project || env.Name == exclude {
+			continue
+		}
+		statistics.AddResourceList(total, env.Spec.ResourceQuota)
+	}
+	return total, nil
+}
+
+func (r *ResourceValidate) projectResourceIsEnough(ctx context.Context, env *gemsv1beta1.Environment) (bool, []string, error) {
+	prq, err := r.getProjectResourceQuota(ctx, env.Spec.Tenant, env.Spec.Project)
+	if err != nil {
+		return false, nil, err
+	}
+	// 项目未设置资源限制,仅由租户资源限制
+	if prq == nil {
+		return true, nil, nil
+	}
+	allocated, err := r.getProjectAllocatedResource(ctx, env.Spec.Tenant, env.Spec.Project, env.Name)
+	if err != nil {
+		return false, nil, err
+	}
+	statistics.AddResourceList(allocated, env.Spec.ResourceQuota)
+	over, msgs := resourcequota.ResourceOverAllocated(prq.Spec.Hard, allocated, ResourceKeys(resourcequota.GetDefaultTeantResourceQuota()))
+	for i := range msgs {
+		msgs[i] = "project: " + msgs[i]
+	}
+	return !over, msgs, nil
+}
@@ -17,9 +17,12 @@ package webhooks
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
+	"strings"
 
 	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -34,7 +37,12 @@ func (r *ResourceValidate) ValidateTenantNetworkPolicy(ctx context.Context, req
 	}
 	switch req.Operation {
 	case v1.Create, v1.Update:
-		// TODO:
+		if err := r.decoder.DecodeRaw(req.Object, tnetpol); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		if err := validateRuleNetworkPolicies(tnetpol.Spec.RuleNetworkPolicies); err != nil {
+			return admission.Denied(err.Error())
+		}
 		return admission.Allowed("pass")
 	case v1.Delete:
 		if err := r.Client.Get(ctx, key, tnetpol); err != nil {
@@ -55,3 +63,70 @@ func (r *ResourceValidate) ValidateTenantNetworkPolicy(ctx context.Context, req
 		return admission.Allowed("pass")
 	}
 }
+
+func validateRuleNetworkPolicies(rules []gemsv1beta1.RuleNetworkPolicy) error {
+	names := map[string]bool{}
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule name is required")
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("duplicated rule %s", rule.Name)
+		}
+		names[rule.Name] = true
+		if rule.NamespaceSelector != nil {
+			if _, err := metav1.LabelSelectorAsSelector(rule.NamespaceSelector); err != nil {
+				return fmt.Errorf("rule %s: invalid namespaceSelector: %w", rule.Name, err)
+			}
+		}
+		for _, item := range rule.Ingress {
+			if len(item.DNSNames) > 0 {
+				return fmt.Errorf("rule %s: dnsNames are only supported in egress", rule.Name)
+			}
+			if err := validateNetworkPolicyRule(item); err != nil {
+				return fmt.Errorf("rule %s: ingress: %w", rule.Name, err)
+			}
+		}
+		for _, item := range rule.Egress {
+			if err := validateNetworkPolicyRule(item); err != nil {
+				return fmt.Errorf("rule %s: egress: %w", rule.Name, err)
+			}
+		}
+		for _, cidr := range rule.DeniedEgressCIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("rule %s: invalid cidr %s", rule.Name, cidr)
+			}
+		}
+	}
+	return nil
+}
+
+func validateNetworkPolicyRule(rule gemsv1beta1.NetworkPolicyRule) error {
+	if len(rule.CIDRs) == 0 && len(rule.DNSNames) == 0 {
+		return fmt.Errorf("one of cidrs and dnsNames is required")
+	}
+	for _, cidr := range append(append([]string{}, rule.CIDRs...), rule.Except...) {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid cidr %s", cidr)
+		}
+	}
+	for _, name := range rule.DNSNames {
+		if name == "" || strings.Count(name, "*") > 0 && !strings.HasPrefix(name, "*.") {
+			return fmt.Errorf("invalid dns name %s", name)
+		}
+	}
+	for _, port := range rule.Ports {
+		if port.Port < 1 || port.Port > 65535 {
+			return fmt.Errorf("invalid port %d", port.Port)
+		}
+		if port.EndPort != nil && (*port.EndPort < port.Port || *port.EndPort > 65535) {
+			return fmt.Errorf("invalid port range %d-%d", port.Port, *port.EndPort)
+		}
+		switch port.Protocol {
+		case "", corev1.ProtocolTCP, corev1.ProtocolUDP, corev1.ProtocolSCTP:
+		default:
+			return fmt.Errorf("invalid protocol %s", port.Protocol)
+		}
+	}
+	return nil
+}
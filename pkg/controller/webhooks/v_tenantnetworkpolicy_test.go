@@ -0,0 +1,80 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhooks
+
+import (
+	"testing"
+
+	gemsv1beta1 "kubegems.io/kubegems/pkg/apis/gems/v1beta1"
+)
+
+func Test_validateRuleNetworkPolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []gemsv1beta1.RuleNetworkPolicy
+		wantErr bool
+	}{
+		{
+			name: "cidr egress",
+			rules: []gemsv1beta1.RuleNetworkPolicy{{
+				Name:   "a",
+				Egress: []gemsv1beta1.NetworkPolicyRule{{CIDRs: []string{"10.0.0.0/8"}}},
+			}},
+		},
+		{
+			name: "dns egress",
+			rules: []gemsv1beta1.RuleNetworkPolicy{{
+				Name:   "a",
+				Egress: []gemsv1beta1.NetworkPolicyRule{{DNSNames: []string{"*.example.com"}}},
+			}},
+		},
+		{
+			name: "denied cidrs with ipv6",
+			rules: []gemsv1beta1.RuleNetworkPolicy{{
+				Name:              "a",
+				DeniedEgressCIDRs: []string{"169.254.0.0/16", "fd00::/8"},
+			}},
+		},
+		{
+			// 拒绝列表从允许的网段中排除
+			name: "egress with denied cidrs",
+			rules: []gemsv1beta1.RuleNetworkPolicy{{
+				Name:              "a",
+				Egress:            []gemsv1beta1.NetworkPolicyRule{{DNSNames: []string{"example.com"}}},
+				DeniedEgressCIDRs: []string{"169.254.0.0/16"},
+			}},
+		},
+		{
+			name: "dns names in ingress",
+			rules: []gemsv1beta1.RuleNetworkPolicy{{
+				Name:    "a",
+				Ingress: []gemsv1beta1.NetworkPolicyRule{{DNSNames: []string{"example.com"}}},
+			}},
+			wantErr: true,
+		},
+		{
+			name:    "duplicated name",
+			rules:   []gemsv1beta1.RuleNetworkPolicy{{Name: "a"}, {Name: "a"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateRuleNetworkPolicies(tt.rules); (err != nil) != tt.wantErr {
+				t.Errorf("validateRuleNetworkPolicies() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
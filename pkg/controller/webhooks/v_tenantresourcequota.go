@@ -27,6 +27,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	gemsv1beta1 "kubegems.io/kubegems/pkg/apis/gems/v1beta1"
 	"kubegems.io/kubegems/pkg/utils/resourcequota"
+	"kubegems.io/kubegems/pkg/utils/statistics"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
@@ -56,10 +57,19 @@ func (r *ResourceValidate) ValidateTenantResourceQuota(ctx context.Context, req
 			need = resourcequota.SubResource(oldtrq.Spec.Hard, trq.Spec.Hard)
 		}
 		enough, errmsg := resourcequota.ResourceEnough(capacity, allocated, need)
-		if enough {
-			return admission.Allowed("pass")
+		if !enough {
+			return admission.Denied(strings.Join(errmsg, ";"))
 		}
-		return admission.Denied(strings.Join(errmsg, ";"))
+		// 租户的资源不能少于已经划分给项目的资源
+		projectAllocated, err := r.getProjectsAllocatedResource(ctx, trq.Name)
+		if err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		keys := ResourceKeys(resourcequota.GetDefaultTeantResourceQuota())
+		if over, msgs := resourcequota.ResourceOverAllocated(trq.Spec.Hard, projectAllocated, keys); over {
+			return admission.Denied("projects: " + strings.Join(msgs, ";"))
+		}
+		return admission.Allowed("pass")
 	case v1.Delete:
 		key := types.NamespacedName{
 			Name: req.Name,
@@ -120,3 +130,19 @@ func (r *ResourceValidate) getAllocatedResource(ctx context.Context) (corev1.Res
 	}
 	return total, nil
 }
+
+// getProjectsAllocatedResource 获取租户已经划分给项目的资源总和
+func (r *ResourceValidate) getProjectsAllocatedResource(ctx context.Context, tenant string) (corev1.ResourceList, error) {
+	total := corev1.ResourceList{}
+	prqs := gemsv1beta1.ProjectResourceQuotaList{}
+	if err := r.Client.List(ctx, &prqs); err != nil {
+		return total, err
+	}
+	for _, prq := range prqs.Items {
+		if prq.Spec.Tenant != tenant {
+			continue
+		}
+		statistics.AddResourceList(total, prq.Spec.Hard)
+	}
+	return total, nil
+}
@@ -92,6 +92,11 @@ func (b *BundleApplier) Apply(ctx context.Context, bundle *pluginsv1beta1.Plugin
 	if err != nil {
 		return fmt.Errorf("download: %w", err)
 	}
+	return b.ApplyFrom(ctx, bundle, into)
+}
+
+// ApplyFrom applies the bundle from the downloaded path, eg. the path returned by Verify.
+func (b *BundleApplier) ApplyFrom(ctx context.Context, bundle *pluginsv1beta1.Plugin, into string) error {
 	if apply, ok := b.appliers[bundle.Spec.Kind]; ok {
 		return apply.Apply(ctx, bundle, into)
 	}
@@ -146,7 +146,11 @@ func DownloadZip(ctx context.Context, uri, subpath, into string) error {
 	if err != nil {
 		return err
 	}
+	return UnZip(raw, subpath, into)
+}
 
+// UnZip extracts files under subpath of the zip archive into directory.
+func UnZip(raw []byte, subpath, into string) error {
 	r := bytes.NewReader(raw)
 	zipr, err := zip.NewReader(r, r.Size())
 	if err != nil {
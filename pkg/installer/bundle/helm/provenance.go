@@ -0,0 +1,119 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/openpgp" //nolint
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+const ProvenanceFileExt = ".prov"
+
+// VerifyHTTPClient is used to download the artifacts and signatures to verify.
+var VerifyHTTPClient = &http.Client{Timeout: 5 * time.Minute}
+
+// VerifyChart verifies the chart archive against its provenance file using the public keyring.
+// The provenance file is downloaded from the chart repository if it not exists alongside the chart.
+func VerifyChart(ctx context.Context, repoURL, name, version, chartfile string, keyring []byte) (*provenance.Verification, error) {
+	if fi, err := os.Stat(chartfile); err != nil {
+		return nil, err
+	} else if fi.IsDir() {
+		return nil, fmt.Errorf("chart %s is not an archive", chartfile)
+	}
+	entities, err := ReadKeyRing(keyring)
+	if err != nil {
+		return nil, fmt.Errorf("read keyring: %w", err)
+	}
+	provfile := chartfile + ProvenanceFileExt
+	if _, err := os.Stat(provfile); err != nil {
+		if err := DownloadProvenance(ctx, repoURL, name, version, provfile); err != nil {
+			return nil, fmt.Errorf("download provenance: %w", err)
+		}
+	}
+	signatory := &provenance.Signatory{KeyRing: entities}
+	return signatory.Verify(chartfile, provfile)
+}
+
+// ReadKeyRing reads an armored or binary PGP public keyring.
+func ReadKeyRing(keyring []byte) (openpgp.EntityList, error) {
+	if len(keyring) == 0 {
+		return nil, fmt.Errorf("empty keyring")
+	}
+	if entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(keyring)); err == nil {
+		return entities, nil
+	}
+	return openpgp.ReadKeyRing(bytes.NewReader(keyring))
+}
+
+// DownloadProvenance downloads the provenance file of chart {name}-{version} into file.
+func DownloadProvenance(ctx context.Context, repoURL, name, version, into string) error {
+	repou, err := url.Parse(repoURL)
+	if err != nil {
+		return err
+	}
+	index, err := LoadIndex(ctx, repoURL)
+	if err != nil {
+		return err
+	}
+	cv, err := index.Get(name, version)
+	if err != nil {
+		return fmt.Errorf("%s-%s not found in repository %s", name, version, repoURL)
+	}
+	if len(cv.URLs) == 0 {
+		return fmt.Errorf("%s-%s has no downloadable URLs", name, version)
+	}
+	if repou.Scheme == FileProtocolSchema {
+		chartfile := cv.URLs[0]
+		if !filepath.IsAbs(chartfile) {
+			chartfile = filepath.Join(repou.Path, chartfile)
+		}
+		data, err := os.ReadFile(chartfile + ProvenanceFileExt)
+		if err != nil {
+			return err
+		}
+		return AtomicWriteFile(into, bytes.NewReader(data), DefaultFileMode)
+	}
+	chartURL, err := repo.ResolveReferenceURL(repoURL, cv.URLs[0])
+	if err != nil {
+		return fmt.Errorf("failed to make chart URL absolute: %s", cv.URLs[0])
+	}
+	if strings.HasPrefix(chartURL, "oci://") {
+		return fmt.Errorf("provenance of oci chart %s is not supported", chartURL)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, chartURL+ProvenanceFileExt, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := VerifyHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch %s : %s", req.URL, resp.Status)
+	}
+	return AtomicWriteFile(into, resp.Body, DefaultFileMode)
+}
@@ -0,0 +1,196 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bundle
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	pluginsv1beta1 "kubegems.io/kubegems/pkg/apis/plugins/v1beta1"
+	"kubegems.io/kubegems/pkg/installer/bundle/helm"
+)
+
+const CosignSignatureExt = ".sig"
+
+var ErrVerifyNotSupported = errors.New("signature verification is not supported for this bundle source")
+
+// VerifyKeys are the public keys used to verify a bundle.
+type VerifyKeys struct {
+	// Keyring is a PGP public keyring used to verify helm provenance files.
+	Keyring []byte
+	// PublicKey is a PEM encoded public key used to verify cosign signatures.
+	PublicKey []byte
+}
+
+// Verify verifies the signature of the bundle and returns the verification result.
+// Helm charts are verified with their provenance files, tarballs with cosign blob signatures.
+// The returned path is the verified content, pass it to ApplyFrom to install exactly what has been verified.
+func (b *BundleApplier) Verify(ctx context.Context, bundle *pluginsv1beta1.Plugin, keys VerifyKeys) (*pluginsv1beta1.VerificationStatus, string, error) {
+	status := &pluginsv1beta1.VerificationStatus{Timestamp: metav1.Now()}
+	verified, err := b.verify(ctx, bundle, keys, status)
+	if err != nil {
+		status.Message = err.Error()
+		return status, "", err
+	}
+	status.Verified = true
+	return status, verified, nil
+}
+
+func (b *BundleApplier) verify(ctx context.Context, bundle *pluginsv1beta1.Plugin, keys VerifyKeys, status *pluginsv1beta1.VerificationStatus) (string, error) {
+	repo := bundle.Spec.URL
+	name, version := bundle.Name, bundle.Spec.Version
+	if bundle.Spec.Chart != "" {
+		name = bundle.Spec.Chart
+	}
+	if version == "" {
+		version = bundle.Status.Version
+	}
+	cachedir := PerRepoCacheDir(repo, b.Options.CacheDir)
+	switch {
+	case strings.HasSuffix(repo, ".git"), strings.HasPrefix(repo, "oci://"):
+		return "", ErrVerifyNotSupported
+	case strings.HasSuffix(repo, ".zip"), strings.HasSuffix(repo, ".tar.gz"), strings.HasSuffix(repo, ".tgz"):
+		status.Method = pluginsv1beta1.VerifyMethodCosign
+		artifact, err := fetch(ctx, repo)
+		if err != nil {
+			return "", fmt.Errorf("fetch %s: %w", repo, err)
+		}
+		signature, err := fetch(ctx, repo+CosignSignatureExt)
+		if err != nil {
+			return "", fmt.Errorf("fetch signature: %w", err)
+		}
+		status.Digest = Digest(artifact)
+		signer, err := VerifyCosignBlob(artifact, signature, keys.PublicKey)
+		if err != nil {
+			return "", err
+		}
+		status.Signer = signer
+		// extract the verified artifact into a directory named by its digest,
+		// the bundle is installed from it instead of downloading again.
+		into := filepath.Join(cachedir, "verified", strings.TrimPrefix(status.Digest, "sha256:"))
+		if err := os.RemoveAll(into); err != nil {
+			return "", err
+		}
+		if strings.HasSuffix(repo, ".zip") {
+			err = UnZip(artifact, bundle.Spec.Path, into)
+		} else {
+			err = UnTarGz(bytes.NewReader(artifact), bundle.Spec.Path, into)
+		}
+		if err != nil {
+			return "", fmt.Errorf("extract %s: %w", repo, err)
+		}
+		return into, nil
+	default:
+		status.Method = pluginsv1beta1.VerifyMethodHelmProvenance
+		// always use the chart archive, an expanded chart directory in cache can't be verified.
+		chartfile, _, err := helm.Download(ctx, repo, name, version, cachedir)
+		if err != nil {
+			return "", fmt.Errorf("download: %w", err)
+		}
+		verification, err := helm.VerifyChart(ctx, repo, name, version, chartfile, keys.Keyring)
+		if err != nil {
+			return "", err
+		}
+		status.Digest = verification.FileHash
+		if verification.SignedBy != nil {
+			for identity := range verification.SignedBy.Identities {
+				status.Signer = identity
+				break
+			}
+		}
+		return chartfile, nil
+	}
+}
+
+// VerifyCosignBlob verifies a base64 encoded signature created by "cosign sign-blob"
+// against the PEM encoded public key and returns the fingerprint of the key.
+func VerifyCosignBlob(blob, signature, publickey []byte) (string, error) {
+	block, _ := pem.Decode(publickey)
+	if block == nil {
+		return "", errors.New("invalid public key: no PEM block found")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(signature)))
+	if err != nil {
+		return "", fmt.Errorf("invalid signature: %w", err)
+	}
+	sum := sha256.Sum256(blob)
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, sum[:], sig) {
+			return "", errors.New("invalid signature")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+			return "", fmt.Errorf("invalid signature: %w", err)
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, blob, sig) {
+			return "", errors.New("invalid signature")
+		}
+	default:
+		return "", fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return Digest(block.Bytes), nil
+}
+
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func fetch(ctx context.Context, uri string) ([]byte, error) {
+	if strings.HasPrefix(uri, "file://") {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, err
+		}
+		return os.ReadFile(u.Path)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := helm.VerifyHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s : %s", uri, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,149 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pluginsv1beta1 "kubegems.io/kubegems/pkg/apis/plugins/v1beta1"
+)
+
+func TestVerifyCosignBlob(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	blob := []byte("bundle content")
+	sum := sha256.Sum256(blob)
+	rawsig, err := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := []byte(base64.StdEncoding.EncodeToString(rawsig) + "\n")
+
+	tests := []struct {
+		name    string
+		blob    []byte
+		sig     []byte
+		pub     []byte
+		wantErr bool
+	}{
+		{name: "valid", blob: blob, sig: sig, pub: pub},
+		{name: "tampered", blob: []byte("tampered content"), sig: sig, pub: pub, wantErr: true},
+		{name: "invalid signature", blob: blob, sig: []byte("!invalid"), pub: pub, wantErr: true},
+		{name: "no public key", blob: blob, sig: sig, pub: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := VerifyCosignBlob(tt.blob, tt.sig, tt.pub)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyCosignBlob() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && signer != Digest(der) {
+				t.Errorf("VerifyCosignBlob() = %v, want %v", signer, Digest(der))
+			}
+		})
+	}
+}
+
+func TestBundleApplier_VerifyCosign(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	// bundle.tgz with a single manifest
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	content := []byte("kind: ConfigMap")
+	if err := tw.WriteHeader(&tar.Header{Name: "manifests/cm.yaml", Mode: 0o644, Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	gw.Close()
+
+	dir := t.TempDir()
+	artifact := filepath.Join(dir, "bundle.tgz")
+	sum := sha256.Sum256(buf.Bytes())
+	rawsig, err := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(artifact, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(artifact+CosignSignatureExt, []byte(base64.StdEncoding.EncodeToString(rawsig)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	applier := &BundleApplier{Options: &Options{CacheDir: filepath.Join(dir, "cache")}}
+	plugin := &pluginsv1beta1.Plugin{}
+	plugin.Name = "bundle"
+	plugin.Spec.URL = "file://" + artifact
+	plugin.Spec.Path = "manifests"
+
+	status, verified, err := applier.Verify(context.Background(), plugin, VerifyKeys{PublicKey: pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Verified || status.Digest != Digest(buf.Bytes()) {
+		t.Errorf("unexpected status %+v", status)
+	}
+	// the verified content is extracted and used to install
+	got, err := os.ReadFile(filepath.Join(verified, "cm.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("verified content = %s, want %s", got, content)
+	}
+
+	// tampered artifact
+	if err := os.WriteFile(artifact, append(buf.Bytes(), 0), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if status, verified, err := applier.Verify(context.Background(), plugin, VerifyKeys{PublicKey: pub}); err == nil || status.Verified || verified != "" {
+		t.Errorf("tampered artifact verified: %+v %s", status, verified)
+	}
+}
@@ -256,7 +256,18 @@ func (r *Reconciler) Sync(ctx context.Context, bundle *pluginsv1beta1.Plugin) er
 		if err := r.resolveValuesRef(ctx, bundle); err != nil {
 			return err
 		}
-		if err := r.Applier.Apply(ctx, bundle); err != nil {
+		// verify signature before install
+		verified, err := r.verify(ctx, bundle)
+		if err != nil {
+			return err
+		}
+		if verified != "" {
+			// install the exact content that has been verified
+			err = r.Applier.ApplyFrom(ctx, bundle, verified)
+		} else {
+			err = r.Applier.Apply(ctx, bundle)
+		}
+		if err != nil {
 			return err
 		}
 		return r.checkResourcesStatus(ctx, bundle)
@@ -292,6 +303,34 @@ func (r *Reconciler) checkPluginStatus(ctx context.Context, managed *pluginsv1be
 	return nil
 }
 
+// verify verifies the bundle and returns the path of verified content,
+// empty path is returned if the verification is not enabled or failed but not required.
+func (r *Reconciler) verify(ctx context.Context, plugin *pluginsv1beta1.Plugin) (string, error) {
+	if plugin.Spec.Verify == nil {
+		plugin.Status.Verification = nil
+		return "", nil
+	}
+	log := logr.FromContextOrDiscard(ctx)
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: plugin.Spec.Verify.SecretRef, Namespace: plugin.Namespace}}
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(secret), secret); err != nil {
+		return "", fmt.Errorf("get verify keys: %w", err)
+	}
+	keys := bundle.VerifyKeys{
+		Keyring:   secret.Data[pluginsv1beta1.VerifyKeyKeyring],
+		PublicKey: secret.Data[pluginsv1beta1.VerifyKeyCosignPublicKey],
+	}
+	status, verified, err := r.Applier.Verify(ctx, plugin, keys)
+	plugin.Status.Verification = status
+	if err != nil {
+		if plugin.Spec.Verify.Required {
+			return "", fmt.Errorf("verify: %w", err)
+		}
+		log.Info("plugin verification failed, continue to install", "reason", err.Error())
+	}
+	return verified, nil
+}
+
 type DependencyError struct {
 	Reason string
 	Object corev1.ObjectReference
@@ -55,6 +55,7 @@ type PluginVersion struct {
 	Files            map[string]string           `json:"files,omitempty"`
 	ValuesFrom       []pluginsv1beta1.ValuesFrom `json:"valuesFrom,omitempty"`
 	Priority         int                         `json:"priority,omitempty"`
+	Verify           *pluginsv1beta1.VerifySpec  `json:"verify,omitempty"`
 }
 
 func (pv PluginVersion) ToPlugin() *pluginsv1beta1.Plugin {
@@ -80,6 +81,7 @@ func (pv PluginVersion) ToPlugin() *pluginsv1beta1.Plugin {
 			Version:          pv.Version,
 			Values:           pv.Values,
 			ValuesFrom:       pv.ValuesFrom,
+			Verify:           pv.Verify,
 		},
 	}
 }
@@ -106,6 +108,7 @@ func PluginVersionFrom(plugin pluginsv1beta1.Plugin) PluginVersion {
 		Values:           plugin.Spec.Values,
 		ValuesFrom:       plugin.Spec.ValuesFrom,
 		Required:         required,
+		Verify:           plugin.Spec.Verify,
 	}
 	if plugin.Status.Phase == pluginsv1beta1.PhaseInstalled {
 		pv.Healthy = true
@@ -170,6 +173,7 @@ func PluginVersionFromRepoChartVersion(repo *Repository, cv *repo.ChartVersion)
 		}(),
 		MainCategory: maincate,
 		Category:     cate,
+		Verify:       repo.VerifySpec(),
 	}
 }
 
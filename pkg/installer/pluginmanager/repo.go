@@ -27,6 +27,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"kubegems.io/kubegems/pkg/apis/plugins"
+	pluginsv1beta1 "kubegems.io/kubegems/pkg/apis/plugins/v1beta1"
 	"kubegems.io/kubegems/pkg/installer/bundle"
 	"kubegems.io/kubegems/pkg/installer/bundle/helm"
 	"kubegems.io/kubegems/pkg/log"
@@ -43,6 +44,24 @@ type Repository struct {
 	Static   bool                       `json:"static,omitempty"` // is static repository
 	Plugins  map[string][]PluginVersion `json:"plugins,omitempty"`
 	LastSync time.Time                  `json:"lastSync,omitempty"`
+	// Keyring is the PGP public keyring used to verify helm provenance files of charts in this repository.
+	Keyring string `json:"keyring,omitempty"`
+	// CosignPublicKey is the PEM encoded public key used to verify cosign signatures.
+	CosignPublicKey string `json:"cosignPublicKey,omitempty"`
+	// VerifyRequired refuses to install plugins from this repository if the verification failed.
+	VerifyRequired bool `json:"verifyRequired,omitempty"`
+}
+
+// VerifySpec returns the verify spec of plugins in this repository,
+// the public keys are stored in the repository secret.
+func (repository *Repository) VerifySpec() *pluginsv1beta1.VerifySpec {
+	if repository.Keyring == "" && repository.CosignPublicKey == "" {
+		return nil
+	}
+	return &pluginsv1beta1.VerifySpec{
+		Required:  repository.VerifyRequired,
+		SecretRef: PluginRepositoriesNamePrefix + repository.Name,
+	}
 }
 
 func (repository *Repository) RefreshRepoIndex(ctx context.Context) error {
@@ -166,12 +185,16 @@ func repoFromSecret(secret corev1.Secret) Repository {
 	_ = json.Unmarshal(secret.Data["plugins"], &plugins)
 	lastsync, _ := time.Parse(time.RFC3339, string(secret.Data["lastSync"]))
 	priority, _ := strconv.Atoi(string(secret.Data["priority"]))
+	verifyRequired, _ := strconv.ParseBool(string(secret.Data["verifyRequired"]))
 	return Repository{
-		Name:     strings.TrimPrefix(secret.GetName(), PluginRepositoriesNamePrefix),
-		Address:  string(secret.Data["address"]),
-		Plugins:  plugins,
-		LastSync: lastsync,
-		Priority: priority,
+		Name:            strings.TrimPrefix(secret.GetName(), PluginRepositoriesNamePrefix),
+		Address:         string(secret.Data["address"]),
+		Plugins:         plugins,
+		LastSync:        lastsync,
+		Priority:        priority,
+		Keyring:         string(secret.Data[pluginsv1beta1.VerifyKeyKeyring]),
+		CosignPublicKey: string(secret.Data[pluginsv1beta1.VerifyKeyCosignPublicKey]),
+		VerifyRequired:  verifyRequired,
 	}
 }
 
@@ -197,6 +220,18 @@ func (p *PluginManager) UpdateRepo(ctx context.Context, repo *Repository) error
 		reposecret.Data["plugins"] = pluginsraw
 		reposecret.Data["address"] = []byte(repo.Address)
 		reposecret.Data["lastSync"] = []byte(repo.LastSync.String())
+		// keys removed from the repository are removed from the secret as well
+		if repo.Keyring != "" {
+			reposecret.Data[pluginsv1beta1.VerifyKeyKeyring] = []byte(repo.Keyring)
+		} else {
+			delete(reposecret.Data, pluginsv1beta1.VerifyKeyKeyring)
+		}
+		if repo.CosignPublicKey != "" {
+			reposecret.Data[pluginsv1beta1.VerifyKeyCosignPublicKey] = []byte(repo.CosignPublicKey)
+		} else {
+			delete(reposecret.Data, pluginsv1beta1.VerifyKeyCosignPublicKey)
+		}
+		reposecret.Data["verifyRequired"] = []byte(strconv.FormatBool(repo.VerifyRequired))
 		return nil
 	})
 	return err
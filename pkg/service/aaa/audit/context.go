@@ -73,6 +73,17 @@ func (audit *DefaultAuditInstance) SetExtraAuditDataByClusterNamespace(c *gin.Co
 	audit.SetExtraAuditData(c, models.ResEnvironment, env.GetID())
 }
 
+// SetAuditFreezeOverride 记录在冻结窗口内强制变更的窗口以及原因
+func SetAuditFreezeOverride(c *gin.Context, window, reason string) {
+	ctxdata := map[string]string{}
+	if extra, exist := c.Get(AuditExtraDataKey); exist {
+		ctxdata = extra.(map[string]string)
+	}
+	ctxdata["freezewindow"] = window
+	ctxdata["freezeoverride"] = reason
+	c.Set(AuditExtraDataKey, ctxdata)
+}
+
 func GetExtraAuditData(c *gin.Context) (string, map[string]string) {
 	var tenant string
 	tags := map[string]string{}
@@ -103,6 +114,12 @@ func GetExtraAuditData(c *gin.Context) (string, map[string]string) {
 	if v, exist := contextDatas["namespace"]; exist {
 		tags["namespace"] = v
 	}
+	if v, exist := contextDatas["freezewindow"]; exist {
+		tags["冻结窗口"] = v
+	}
+	if v, exist := contextDatas["freezeoverride"]; exist {
+		tags["豁免原因"] = v
+	}
 	return tenant, tags
 }
 
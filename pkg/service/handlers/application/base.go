@@ -124,7 +124,7 @@ func (h *BaseHandler) DirectRefNameFunc(c *gin.Context, ref *PathRef) error {
 	params := &struct {
 		Tenant      string `uri:"tenant" binding:"required"`
 		Project     string `uri:"project" binding:"required"`
-		Environment string `uri:"environment_id"`
+		Environment string `uri:"environment" binding:"required"`
 		Name        string `uri:"name" binding:"required"`
 	}{}
 
@@ -17,6 +17,7 @@ package application
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -25,9 +26,12 @@ import (
 	"kubegems.io/kubegems/pkg/apis/application"
 	"kubegems.io/kubegems/pkg/apis/gems"
 	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/handlers/application/options"
 	"kubegems.io/kubegems/pkg/service/handlers/base"
+	"kubegems.io/kubegems/pkg/utils"
 	"kubegems.io/kubegems/pkg/utils/argo"
 	"kubegems.io/kubegems/pkg/utils/git"
+	"kubegems.io/kubegems/pkg/utils/workflow"
 )
 
 const StatusNoArgoApp = "NoArgoApp"
@@ -78,7 +82,7 @@ type ManifestDeploy struct {
 	Contents  []unstructured.Unstructured
 }
 
-func MustNewApplicationDeployHandler(gitoptions *git.Options, argocli *argo.Client, commonbase base.BaseHandler) *ApplicationHandler {
+func MustNewApplicationDeployHandler(gitoptions *git.Options, argocli *argo.Client, analysis *options.AnalysisOptions, commonbase base.BaseHandler) *ApplicationHandler {
 	provider, err := git.NewProvider(gitoptions)
 	if err != nil {
 		panic(err)
@@ -102,7 +106,7 @@ func MustNewApplicationDeployHandler(gitoptions *git.Options, argocli *argo.Clie
 			ManifestProcessor: &ManifestProcessor{GitProvider: provider},
 		},
 		Task:                 NewTaskHandler(base),
-		ApplicationProcessor: NewApplicationProcessor(database, provider, argocli, redis, agents),
+		ApplicationProcessor: NewApplicationProcessor(database, provider, argocli, redis, agents, analysis),
 	}
 	return h
 }
@@ -165,14 +169,16 @@ func (h *ApplicationHandler) Create(c *gin.Context) {
 
 // @Tags        Application
 // @Summary     批量部署应用
-// @Description 批量部署应用
+// @Description 批量部署应用,deploy 为 true 时按照项目下的部署依赖分批次同步,前一批次的应用健康后再同步下一批次.
+// @Description deploy 为 true 时先检查冻结窗口和审批, 创建编排和同步都在审批通过后的任务中执行
 // @Accept      json
 // @Produce     json
-// @Param       tenant_id      path     int                                            true "tenaut id"
-// @Param       project_id     path     int                                            true "project id"
-// @Param       environment_id path     int                                            true "environment_id"
-// @Param       body           body     []DeploiedManifest                             true "body"
-// @Success     200            {object} handlers.ResponseStruct{Data=DeploiedManifest} "Application"
+// @Param       tenant_id      path     int                                                  true  "tenaut id"
+// @Param       project_id     path     int                                                  true  "project id"
+// @Param       environment_id path     int                                                  true  "environment_id"
+// @Param       deploy         query    bool                                                 false "是否同步到集群"
+// @Param       body           body     []DeploiedManifest                                   true  "body"
+// @Success     200            {object} handlers.ResponseStruct{Data=base.ApprovalResult} "deploy 为 true 时返回任务是否提交或者等待的审批"
 // @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications-batch [post]
 // @Security    JWT
 func (h *ApplicationHandler) CreateBatch(c *gin.Context) {
@@ -184,6 +190,18 @@ func (h *ApplicationHandler) CreateBatch(c *gin.Context) {
 			names = append(names, v.Name)
 		}
 		h.SetAuditData(c, "批量创建", "应用", ref.Name)
+		if deploy, _ := strconv.ParseBool(c.Query("deploy")); deploy {
+			// 冻结窗口和审批在修改编排之前检查, 编排在审批通过后的任务中创建
+			if err := h.CheckEnvironmentFreeze(c, utils.ToUint(c.Param("environment_id"))); err != nil {
+				return nil, err
+			}
+			create := workflow.Step{
+				Name:     "create",
+				Function: TaskFunction_Application_CreateBatch,
+				Args:     workflow.ArgsOf(ref, names),
+			}
+			return h.submitSyncWaves(c, ctx, ref, names, create)
+		}
 		if err := h.ApplicationProcessor.CreateBatch(ctx, ref, names); err != nil {
 			return nil, err
 		}
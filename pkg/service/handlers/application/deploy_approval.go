@@ -0,0 +1,71 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"kubegems.io/kubegems/pkg/service/handlers/base"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/workflow"
+)
+
+// requireApproval 环境配置了该操作的审批策略时,返回创建的待审批请求,任务在审批通过后提交
+func (h *ApplicationHandler) requireApproval(c *gin.Context, ctx context.Context, ref PathRef,
+	action, target, typ string, steps []workflow.Step,
+) (*models.ApprovalRequest, error) {
+	envid, _ := strconv.Atoi(c.Param("environment_id"))
+	if envid == 0 {
+		return nil, nil
+	}
+	return h.requireEnvironmentApproval(c, ctx, ref, uint(envid), action, target, typ, steps)
+}
+
+// requireEnvironmentApproval 用于路径中没有环境的操作,例如环境间的提升
+func (h *ApplicationHandler) requireEnvironmentApproval(c *gin.Context, ctx context.Context, ref PathRef, envid uint,
+	action, target, typ string, steps []workflow.Step,
+) (*models.ApprovalRequest, error) {
+	task := NewTask(ctx, ref, typ, steps)
+	return h.RequireApproval(c, envid, action, target, &task)
+}
+
+// submitTaskOrRequireApproval 不需要审批时直接提交任务
+func (h *ApplicationHandler) submitTaskOrRequireApproval(c *gin.Context, ctx context.Context, ref PathRef,
+	action, target, typ string, steps []workflow.Step,
+) (*base.ApprovalResult, error) {
+	envid, _ := strconv.Atoi(c.Param("environment_id"))
+	return h.submitEnvironmentTaskOrRequireApproval(c, ctx, ref, uint(envid), action, target, typ, steps)
+}
+
+// submitEnvironmentTaskOrRequireApproval 用于路径中没有环境的操作
+func (h *ApplicationHandler) submitEnvironmentTaskOrRequireApproval(c *gin.Context, ctx context.Context, ref PathRef, envid uint,
+	action, target, typ string, steps []workflow.Step,
+) (*base.ApprovalResult, error) {
+	if envid != 0 {
+		req, err := h.requireEnvironmentApproval(c, ctx, ref, envid, action, target, typ, steps)
+		if err != nil {
+			return nil, err
+		}
+		if req != nil {
+			return &base.ApprovalResult{Approval: req}, nil
+		}
+	}
+	if err := h.Task.Processor.SubmitTask(ctx, ref, typ, steps); err != nil {
+		return nil, err
+	}
+	return &base.ApprovalResult{Submitted: true}, nil
+}
@@ -30,7 +30,10 @@ import (
 	gemlabels "kubegems.io/kubegems/pkg/apis/gems"
 	"kubegems.io/kubegems/pkg/log"
 	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/handlers/base"
+	"kubegems.io/kubegems/pkg/service/models"
 	"kubegems.io/kubegems/pkg/utils/argo"
+	"kubegems.io/kubegems/pkg/utils/workflow"
 )
 
 type SyncRequest struct {
@@ -42,22 +45,37 @@ type SyncRequest struct {
 // @Description Sync同步
 // @Accept      json
 // @Produce     json
-// @Param       tenant_id      path     int                                  true  "tenaut id"
-// @Param       project_id     path     int                                  true  "project id"
-// @param       environment_id path     int                                  true  "environment id"
-// @Param       name           path     string                               true  "name"
-// @Param       body           body     SyncRequest                          false "指定需要同步的资源，否则全部同步"
-// @Success     200            {object} handlers.ResponseStruct{Data=string} "ok"
+// @Param       tenant_id      path     int                                               true  "tenaut id"
+// @Param       project_id     path     int                                               true  "project id"
+// @param       environment_id path     int                                               true  "environment id"
+// @Param       name           path     string                                            true  "name"
+// @Param       body           body     SyncRequest                                       false "指定需要同步的资源，否则全部同步"
+// @Success     200            {object} handlers.ResponseStruct{Data=base.ApprovalResult} "需要审批时返回待审批的请求"
 // @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/sync [post]
 // @Security    JWT
 func (h *ApplicationHandler) Sync(c *gin.Context) {
 	body := &SyncRequest{}
 	h.NamedRefFunc(c, body, func(ctx context.Context, ref PathRef) (interface{}, error) {
 		h.SetAuditData(c, "同步", "应用", ref.Name)
+		// 需要审批时,审批通过后异步同步
+		steps := []workflow.Step{
+			{
+				Name:     "sync",
+				Function: TaskFunction_Application_Sync,
+				Args:     workflow.ArgsOf(ref, body.Resources),
+			},
+		}
+		approval, err := h.requireApproval(c, ctx, ref, models.ApprovalActionSync, ref.Name, "sync", steps)
+		if err != nil {
+			return nil, err
+		}
+		if approval != nil {
+			return base.ApprovalResult{Approval: approval}, nil
+		}
 		if err := h.ApplicationProcessor.Sync(ctx, ref, body.Resources...); err != nil {
 			return nil, err
 		}
-		return "ok", nil
+		return base.ApprovalResult{Submitted: true}, nil
 	})
 }
 
@@ -169,7 +187,7 @@ type ImageHistory struct {
 // @Param       project_id     path     int                                  true "project id"
 // @param       environment_id path     int                                  true "environment id"
 // @Param       name           path     string                               true "name"
-// @Success     200            {object} handlers.ResponseStruct{Data=string} "history"
+// @Success     200 {object} handlers.ResponseStruct{Data=string} "history"
 // @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/imagehistory [get]
 // @Security    JWT
 func (h *ApplicationHandler) ImageHistory(c *gin.Context) {
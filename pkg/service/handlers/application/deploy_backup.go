@@ -0,0 +1,378 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/workflow"
+)
+
+type BackupPlanForm struct {
+	Name string `json:"name" binding:"required"`
+	// 标准的5段 cron 表达式,为空时仅手动备份
+	Schedule string `json:"schedule"`
+	// 保留最近的备份数量,为 0 时不清理
+	Retention       int                 `json:"retention"`
+	SnapshotVolumes bool                `json:"snapshotVolumes"`
+	Hooks           []models.BackupHook `json:"hooks"`
+	Enabled         bool                `json:"enabled"`
+}
+
+type RestoreForm struct {
+	// 同一项目下任意环境中的备份
+	BackupID uint `json:"backupID" binding:"required"`
+}
+
+// @Tags        Application
+// @Summary     应用的备份计划
+// @Description 应用的备份计划
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                               true "tenaut id"
+// @Param       project_id     path     int                                               true "project id"
+// @Param       environment_id path     int                                               true "environment_id"
+// @Param       name           path     string                                            true "application name"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]models.BackupPlan} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/backupplans [get]
+// @Security    JWT
+func (h *ApplicationHandler) ListBackupPlans(c *gin.Context) {
+	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		list := []models.BackupPlan{}
+		if err := h.GetDB().WithContext(ctx).
+			Where("environment_id = ? and application_name = ?", c.Param("environment_id"), ref.Name).
+			Find(&list).Error; err != nil {
+			return nil, err
+		}
+		return list, nil
+	})
+}
+
+// @Tags        Application
+// @Summary     创建备份计划
+// @Description 备份应用编排的 git 版本以及 PVC 的卷快照,可以在快照前后在 pod 中执行钩子命令. schedule 为空时仅手动备份
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                             true "tenaut id"
+// @Param       project_id     path     int                                             true "project id"
+// @Param       environment_id path     int                                             true "environment_id"
+// @Param       name           path     string                                          true "application name"
+// @Param       body           body     BackupPlanForm                                  true "备份计划"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.BackupPlan} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/backupplans [post]
+// @Security    JWT
+func (h *ApplicationHandler) CreateBackupPlan(c *gin.Context) {
+	body := &BackupPlanForm{}
+	h.NamedRefFunc(c, body, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		envid, _ := strconv.Atoi(c.Param("environment_id"))
+		plan := &models.BackupPlan{
+			EnvironmentID:   uint(envid),
+			ApplicationName: ref.Name,
+			Creator:         AuthorFromContext(ctx).Name,
+		}
+		if err := body.applyTo(plan); err != nil {
+			return nil, err
+		}
+		h.SetAuditData(c, "创建", "备份计划", ref.Name+"/"+plan.Name)
+		if err := h.GetDB().WithContext(ctx).Create(plan).Error; err != nil {
+			return nil, err
+		}
+		return plan, nil
+	})
+}
+
+// @Tags        Application
+// @Summary     更新备份计划
+// @Description 更新备份计划
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                             true "tenaut id"
+// @Param       project_id     path     int                                             true "project id"
+// @Param       environment_id path     int                                             true "environment_id"
+// @Param       name           path     string                                          true "application name"
+// @Param       plan_id        path     int                                             true "plan id"
+// @Param       body           body     BackupPlanForm                                  true "备份计划"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.BackupPlan} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/backupplans/{plan_id} [put]
+// @Security    JWT
+func (h *ApplicationHandler) UpdateBackupPlan(c *gin.Context) {
+	body := &BackupPlanForm{}
+	h.NamedRefFunc(c, body, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		plan, err := h.getBackupPlan(c, ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		if err := body.applyTo(plan); err != nil {
+			return nil, err
+		}
+		h.SetAuditData(c, "更新", "备份计划", ref.Name+"/"+plan.Name)
+		if err := h.GetDB().WithContext(ctx).Omit("Environment").Save(plan).Error; err != nil {
+			return nil, err
+		}
+		return plan, nil
+	})
+}
+
+// @Tags        Application
+// @Summary     删除备份计划
+// @Description 删除备份计划,已有的备份不会被删除
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                  true "tenaut id"
+// @Param       project_id     path     int                                  true "project id"
+// @Param       environment_id path     int                                  true "environment_id"
+// @Param       name           path     string                               true "application name"
+// @Param       plan_id        path     int                                  true "plan id"
+// @Success     200            {object} handlers.ResponseStruct{Data=string} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/backupplans/{plan_id} [delete]
+// @Security    JWT
+func (h *ApplicationHandler) DeleteBackupPlan(c *gin.Context) {
+	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		plan, err := h.getBackupPlan(c, ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		h.SetAuditData(c, "删除", "备份计划", ref.Name+"/"+plan.Name)
+		if err := h.GetDB().WithContext(ctx).Delete(plan).Error; err != nil {
+			return nil, err
+		}
+		return "ok", nil
+	})
+}
+
+// @Tags        Application
+// @Summary     立即执行备份计划
+// @Description 提交异步任务立即执行一次备份
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                  true "tenaut id"
+// @Param       project_id     path     int                                  true "project id"
+// @Param       environment_id path     int                                  true "environment_id"
+// @Param       name           path     string                               true "application name"
+// @Param       plan_id        path     int                                  true "plan id"
+// @Success     200            {object} handlers.ResponseStruct{Data=string} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/backupplans/{plan_id}/backup [post]
+// @Security    JWT
+func (h *ApplicationHandler) RunBackupPlan(c *gin.Context) {
+	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		plan, err := h.getBackupPlan(c, ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		h.SetAuditData(c, "执行", "备份计划", ref.Name+"/"+plan.Name)
+		steps := []workflow.Step{
+			{
+				Name:     "backup",
+				Function: TaskFunction_Application_Backup,
+				Args:     workflow.ArgsOf(plan.ID),
+			},
+		}
+		if err := h.Task.Processor.SubmitTask(ctx, ref, "backup", steps); err != nil {
+			return nil, err
+		}
+		return "ok", nil
+	})
+}
+
+// @Tags        Application
+// @Summary     应用的备份
+// @Description 应用在当前环境中的备份
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                                                               true  "tenaut id"
+// @Param       project_id     path     int                                                                               true  "project id"
+// @Param       environment_id path     int                                                                               true  "environment_id"
+// @Param       name           path     string                                                                            true  "application name"
+// @Param       page           query    int                                                                               false "page"
+// @Param       size           query    int                                                                               false "page"
+// @Success     200            {object} handlers.ResponseStruct{Data=handlers.PageData{List=[]models.ApplicationBackup}} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/backups [get]
+// @Security    JWT
+func (h *ApplicationHandler) ListBackups(c *gin.Context) {
+	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		list := []models.ApplicationBackup{}
+		if err := h.GetDB().WithContext(ctx).
+			Where("environment_id = ? and application_name = ?", c.Param("environment_id"), ref.Name).
+			Order("id desc").Find(&list).Error; err != nil {
+			return nil, err
+		}
+		return handlers.NewPageDataFromContext(c, list, nil, nil), nil
+	})
+}
+
+// @Tags        Application
+// @Summary     备份详情
+// @Description 备份详情,包含备份时编排的 git 版本以及卷快照
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                                    true "tenaut id"
+// @Param       project_id     path     int                                                    true "project id"
+// @Param       environment_id path     int                                                    true "environment_id"
+// @Param       name           path     string                                                 true "application name"
+// @Param       backup_id      path     int                                                    true "backup id"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.ApplicationBackup} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/backups/{backup_id} [get]
+// @Security    JWT
+func (h *ApplicationHandler) GetBackup(c *gin.Context) {
+	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		return h.getBackup(c, ctx, ref)
+	})
+}
+
+// @Tags        Application
+// @Summary     删除备份
+// @Description 删除备份及其卷快照
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                  true "tenaut id"
+// @Param       project_id     path     int                                  true "project id"
+// @Param       environment_id path     int                                  true "environment_id"
+// @Param       name           path     string                               true "application name"
+// @Param       backup_id      path     int                                  true "backup id"
+// @Success     200            {object} handlers.ResponseStruct{Data=string} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/backups/{backup_id} [delete]
+// @Security    JWT
+func (h *ApplicationHandler) DeleteBackup(c *gin.Context) {
+	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		backup, err := h.getBackup(c, ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		env := &models.Environment{}
+		if err := h.GetDB().WithContext(ctx).Preload("Cluster").Take(env, backup.EnvironmentID).Error; err != nil {
+			return nil, err
+		}
+		h.SetAuditData(c, "删除", "应用备份", ref.Name+"/"+backup.Name)
+		if err := h.ApplicationProcessor.DeleteBackup(ctx, env, backup); err != nil {
+			return nil, err
+		}
+		return "ok", nil
+	})
+}
+
+// @Tags        Application
+// @Summary     从备份恢复应用
+// @Description 将同一项目下任意环境中该应用的备份恢复到当前环境: 从卷快照创建 PVC,将编排恢复为备份时的版本并同步. 卷快照只能恢复到同一集群中, 且目标环境中不能存在同名的 PVC.
+// @Description 环境处于冻结窗口时拒绝恢复, 配置了同步审批时在审批通过后恢复
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                                 true "tenaut id"
+// @Param       project_id     path     int                                                 true "project id"
+// @Param       environment_id path     int                                                 true "environment_id"
+// @Param       name           path     string                                              true "application name"
+// @Param       body           body     RestoreForm                                         true "恢复参数"
+// @Success     200            {object} handlers.ResponseStruct{Data=base.ApprovalResult} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/restore [post]
+// @Security    JWT
+func (h *ApplicationHandler) Restore(c *gin.Context) {
+	body := &RestoreForm{}
+	h.NamedRefFunc(c, body, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		backup := &models.ApplicationBackup{}
+		if err := h.GetDB().WithContext(ctx).
+			Joins("join environments on environments.id = application_backups.environment_id").
+			Where("application_backups.id = ? and environments.project_id = ?", body.BackupID, c.Param("project_id")).
+			Take(backup).Error; err != nil {
+			return nil, fmt.Errorf("backup %d not found in project: %w", body.BackupID, err)
+		}
+		if backup.Status != models.BackupStatusCompleted {
+			return nil, fmt.Errorf("backup %s is %s", backup.Name, backup.Status)
+		}
+		if backup.ApplicationName != ref.Name {
+			return nil, fmt.Errorf("backup %s belongs to application %s, not %s", backup.Name, backup.ApplicationName, ref.Name)
+		}
+		h.SetAuditData(c, "恢复", "应用", ref.Name+"/"+backup.Name)
+
+		envid, _ := strconv.Atoi(c.Param("environment_id"))
+		opts := RestoreOptions{BackupID: backup.ID, EnvironmentID: uint(envid)}
+		steps := []workflow.Step{
+			{
+				Name:     "restore",
+				Function: TaskFunction_Application_Restore,
+				Args:     workflow.ArgsOf(ref, opts),
+			},
+		}
+		// 恢复会同步应用, 与同步使用相同的审批策略
+		return h.submitTaskOrRequireApproval(c, ctx, ref, models.ApprovalActionSync, ref.Name, "restore", steps)
+	})
+}
+
+// @Tags        Application
+// @Summary     应用的恢复记录
+// @Description 应用在当前环境中的恢复记录
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                                                                true  "tenaut id"
+// @Param       project_id     path     int                                                                                true  "project id"
+// @Param       environment_id path     int                                                                                true  "environment_id"
+// @Param       name           path     string                                                                             true  "application name"
+// @Param       page           query    int                                                                                false "page"
+// @Param       size           query    int                                                                                false "page"
+// @Success     200            {object} handlers.ResponseStruct{Data=handlers.PageData{List=[]models.ApplicationRestore}} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/restores [get]
+// @Security    JWT
+func (h *ApplicationHandler) ListRestores(c *gin.Context) {
+	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		list := []models.ApplicationRestore{}
+		if err := h.GetDB().WithContext(ctx).
+			Where("environment_id = ? and application_name = ?", c.Param("environment_id"), ref.Name).
+			Order("id desc").Find(&list).Error; err != nil {
+			return nil, err
+		}
+		return handlers.NewPageDataFromContext(c, list, nil, nil), nil
+	})
+}
+
+func (f *BackupPlanForm) applyTo(plan *models.BackupPlan) error {
+	if f.Hooks == nil {
+		f.Hooks = []models.BackupHook{}
+	}
+	hooks, err := json.Marshal(f.Hooks)
+	if err != nil {
+		return err
+	}
+	plan.Name = f.Name
+	plan.Schedule = f.Schedule
+	plan.Retention = f.Retention
+	plan.SnapshotVolumes = f.SnapshotVolumes
+	plan.Hooks = hooks
+	plan.Enabled = f.Enabled
+	return plan.Validate()
+}
+
+func (h *ApplicationHandler) getBackupPlan(c *gin.Context, ctx context.Context, ref PathRef) (*models.BackupPlan, error) {
+	plan := &models.BackupPlan{}
+	if err := h.GetDB().WithContext(ctx).
+		Where("id = ? and environment_id = ? and application_name = ?", c.Param("plan_id"), c.Param("environment_id"), ref.Name).
+		Take(plan).Error; err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+func (h *ApplicationHandler) getBackup(c *gin.Context, ctx context.Context, ref PathRef) (*models.ApplicationBackup, error) {
+	backup := &models.ApplicationBackup{}
+	if err := h.GetDB().WithContext(ctx).
+		Where("id = ? and environment_id = ? and application_name = ?", c.Param("backup_id"), c.Param("environment_id"), ref.Name).
+		Take(backup).Error; err != nil {
+		return nil, err
+	}
+	return backup, nil
+}
@@ -0,0 +1,251 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/gin-gonic/gin"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/harbor"
+)
+
+type ImageUpdatePolicyForm struct {
+	// 镜像名称,包含 tag 时忽略 tag
+	Image   string `json:"image" binding:"required"`
+	Policy  string `json:"policy" binding:"required,oneof=semver regex latest"`
+	Range   string `json:"range"`
+	Pattern string `json:"pattern"`
+	Sync    bool   `json:"sync"`
+	DryRun  bool   `json:"dryRun"`
+	Enabled bool   `json:"enabled"`
+}
+
+func (f *ImageUpdatePolicyForm) validate() error {
+	switch f.Policy {
+	case models.ImagePolicySemver:
+		if f.Range != "" {
+			if _, err := semver.NewConstraint(f.Range); err != nil {
+				return fmt.Errorf("invalid semver range %s: %w", f.Range, err)
+			}
+		}
+	case models.ImagePolicyRegex:
+		if f.Pattern == "" {
+			return fmt.Errorf("pattern is required for policy regex")
+		}
+		if _, err := regexp.Compile(f.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern %s: %w", f.Pattern, err)
+		}
+	}
+	f.Image, _ = harbor.SplitImageNameTag(f.Image)
+	return nil
+}
+
+// @Tags        Application
+// @Summary     应用的镜像自动更新策略
+// @Description 应用的镜像自动更新策略
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                                      true "tenaut id"
+// @Param       project_id     path     int                                                      true "project id"
+// @Param       environment_id path     int                                                      true "environment_id"
+// @Param       name           path     string                                                   true "application name"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]models.ImageUpdatePolicy} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/imagepolicies [get]
+// @Security    JWT
+func (h *ApplicationHandler) ListImagePolicies(c *gin.Context) {
+	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		list := []models.ImageUpdatePolicy{}
+		if err := h.GetDB().WithContext(ctx).
+			Where("environment_id = ? and application_name = ?", c.Param("environment_id"), ref.Name).
+			Find(&list).Error; err != nil {
+			return nil, err
+		}
+		return list, nil
+	})
+}
+
+// @Tags        Application
+// @Summary     创建镜像自动更新策略
+// @Description 定期从镜像仓库中按照策略选择新的 tag 更新到编排. semver 选择满足范围的最高版本, regex 选择匹配正则的 tag 中最新推送的, latest 选择最新推送的 tag(仅支持 harbor)
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                                    true "tenaut id"
+// @Param       project_id     path     int                                                    true "project id"
+// @Param       environment_id path     int                                                    true "environment_id"
+// @Param       name           path     string                                                 true "application name"
+// @Param       body           body     ImageUpdatePolicyForm                                  true "策略"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.ImageUpdatePolicy} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/imagepolicies [post]
+// @Security    JWT
+func (h *ApplicationHandler) CreateImagePolicy(c *gin.Context) {
+	body := &ImageUpdatePolicyForm{}
+	h.NamedRefFunc(c, body, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		if err := body.validate(); err != nil {
+			return nil, err
+		}
+		envid, _ := strconv.Atoi(c.Param("environment_id"))
+		policy := &models.ImageUpdatePolicy{
+			EnvironmentID:   uint(envid),
+			ApplicationName: ref.Name,
+			Creator:         AuthorFromContext(ctx).Name,
+		}
+		body.applyTo(policy)
+		h.SetAuditData(c, "创建", "镜像更新策略", ref.Name+"/"+policy.Image)
+		if err := h.GetDB().WithContext(ctx).Create(policy).Error; err != nil {
+			return nil, err
+		}
+		return policy, nil
+	})
+}
+
+// @Tags        Application
+// @Summary     更新镜像自动更新策略
+// @Description 更新镜像自动更新策略
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                                    true "tenaut id"
+// @Param       project_id     path     int                                                    true "project id"
+// @Param       environment_id path     int                                                    true "environment_id"
+// @Param       name           path     string                                                 true "application name"
+// @Param       policy_id      path     int                                                    true "policy id"
+// @Param       body           body     ImageUpdatePolicyForm                                  true "策略"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.ImageUpdatePolicy} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/imagepolicies/{policy_id} [put]
+// @Security    JWT
+func (h *ApplicationHandler) UpdateImagePolicy(c *gin.Context) {
+	body := &ImageUpdatePolicyForm{}
+	h.NamedRefFunc(c, body, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		if err := body.validate(); err != nil {
+			return nil, err
+		}
+		policy, err := h.getImagePolicy(c, ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		body.applyTo(policy)
+		h.SetAuditData(c, "更新", "镜像更新策略", ref.Name+"/"+policy.Image)
+		if err := h.GetDB().WithContext(ctx).Omit("Environment").Save(policy).Error; err != nil {
+			return nil, err
+		}
+		return policy, nil
+	})
+}
+
+// @Tags        Application
+// @Summary     删除镜像自动更新策略
+// @Description 删除镜像自动更新策略
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                  true "tenaut id"
+// @Param       project_id     path     int                                  true "project id"
+// @Param       environment_id path     int                                  true "environment_id"
+// @Param       name           path     string                               true "application name"
+// @Param       policy_id      path     int                                  true "policy id"
+// @Success     200            {object} handlers.ResponseStruct{Data=string} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/imagepolicies/{policy_id} [delete]
+// @Security    JWT
+func (h *ApplicationHandler) DeleteImagePolicy(c *gin.Context) {
+	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		policy, err := h.getImagePolicy(c, ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		h.SetAuditData(c, "删除", "镜像更新策略", ref.Name+"/"+policy.Image)
+		if err := h.GetDB().WithContext(ctx).Delete(policy).Error; err != nil {
+			return nil, err
+		}
+		return "ok", nil
+	})
+}
+
+// @Tags        Application
+// @Summary     立即检查镜像自动更新策略
+// @Description 立即检查策略并按照策略更新, dryRun 为 true 时仅返回将要更新的镜像; 环境处于冻结窗口或者镜像更新(同步)需要审批时跳过更新
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                                     true  "tenaut id"
+// @Param       project_id     path     int                                                     true  "project id"
+// @Param       environment_id path     int                                                     true  "environment_id"
+// @Param       name           path     string                                                  true  "application name"
+// @Param       policy_id      path     int                                                     true  "policy id"
+// @Param       dryRun         query    bool                                                    false "dry run"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.ImageUpdateHistory} "本次更新记录,已是最新时为空"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/imagepolicies/{policy_id}/check [post]
+// @Security    JWT
+func (h *ApplicationHandler) CheckImagePolicy(c *gin.Context) {
+	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		policy, err := h.getImagePolicy(c, ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		if dryrun, _ := strconv.ParseBool(c.Query("dryRun")); dryrun {
+			policy.DryRun = true
+		}
+		h.SetAuditData(c, "检查", "镜像更新策略", ref.Name+"/"+policy.Image)
+		return h.ApplicationProcessor.CheckImagePolicy(ctx, policy)
+	})
+}
+
+// @Tags        Application
+// @Summary     镜像自动更新历史
+// @Description 镜像自动更新历史
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                                                                true  "tenaut id"
+// @Param       project_id     path     int                                                                                true  "project id"
+// @Param       environment_id path     int                                                                                true  "environment_id"
+// @Param       name           path     string                                                                             true  "application name"
+// @Param       page           query    int                                                                                false "page"
+// @Param       size           query    int                                                                                false "page"
+// @Success     200            {object} handlers.ResponseStruct{Data=handlers.PageData{List=[]models.ImageUpdateHistory}} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/imageupdatehistory [get]
+// @Security    JWT
+func (h *ApplicationHandler) ImageUpdateHistory(c *gin.Context) {
+	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		list := []models.ImageUpdateHistory{}
+		if err := h.GetDB().WithContext(ctx).
+			Where("environment_id = ? and application_name = ?", c.Param("environment_id"), ref.Name).
+			Order("id desc").Find(&list).Error; err != nil {
+			return nil, err
+		}
+		return handlers.NewPageDataFromContext(c, list, nil, nil), nil
+	})
+}
+
+func (f *ImageUpdatePolicyForm) applyTo(policy *models.ImageUpdatePolicy) {
+	policy.Image = f.Image
+	policy.Policy = f.Policy
+	policy.Range = f.Range
+	policy.Pattern = f.Pattern
+	policy.Sync = f.Sync
+	policy.DryRun = f.DryRun
+	policy.Enabled = f.Enabled
+}
+
+func (h *ApplicationHandler) getImagePolicy(c *gin.Context, ctx context.Context, ref PathRef) (*models.ImageUpdatePolicy, error) {
+	policy := &models.ImageUpdatePolicy{}
+	if err := h.GetDB().WithContext(ctx).Preload("Environment.Project.Tenant").
+		Where("id = ? and environment_id = ? and application_name = ?", c.Param("policy_id"), c.Param("environment_id"), ref.Name).
+		Take(policy).Error; err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
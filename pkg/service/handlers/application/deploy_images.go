@@ -21,6 +21,8 @@ import (
 
 	"github.com/gin-gonic/gin"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils"
 	"kubegems.io/kubegems/pkg/utils/harbor"
 	"kubegems.io/kubegems/pkg/utils/workflow"
 )
@@ -57,11 +59,11 @@ func (h *ApplicationHandler) ListImages(c *gin.Context) {
 // @Description 更新部署镜像
 // @Accept      json
 // @Produce     json
-// @Param       tenant_id      path     int                                            true "tenaut id"
-// @Param       project_id     path     int                                            true "project id"
-// @Param       environment_id path     int                                            true "environment_id"
-// @Param       name           path     string                                         true "应用名称，全部应用可设置为'_'"
-// @Success     200            {object} handlers.ResponseStruct{Data=DeploiedManifest} "Application"
+// @Param       tenant_id      path     int                                               true "tenaut id"
+// @Param       project_id     path     int                                               true "project id"
+// @Param       environment_id path     int                                               true "environment_id"
+// @Param       name           path     string                                            true "应用名称，全部应用可设置为'_'"
+// @Success     200            {object} handlers.ResponseStruct{Data=base.ApprovalResult} "需要审批时返回待审批的请求"
 // @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/_/images [put]
 // @Security    JWT
 func (h *ApplicationHandler) BatchUpdateImages(c *gin.Context) {
@@ -82,22 +84,19 @@ func (h *ApplicationHandler) BatchUpdateImages(c *gin.Context) {
 		}
 
 		h.SetAuditData(c, "更新", "应用镜像", strings.Join(updatednames, ","))
-		if err := h.asyncBatchUpdateImages(ctx, ref, args); err != nil {
-			return nil, err
-		}
-		return "ok", nil
+		steps := batchUpdateImagesSteps(ref, args)
+		return h.submitTaskOrRequireApproval(c, ctx, ref, models.ApprovalActionUpdateImage, strings.Join(updatednames, ","), "update-image-git(batch)", steps)
 	})
 }
 
-func (h *ApplicationHandler) asyncBatchUpdateImages(ctx context.Context, ref PathRef, args []UpdateImageArgs) error {
-	steps := []workflow.Step{
+func batchUpdateImagesSteps(ref PathRef, args []UpdateImageArgs) []workflow.Step {
+	return []workflow.Step{
 		{
 			Name:     "update-image-git-step",
 			Function: TaskFunction_Application_BatchUpdateImages,
 			Args:     workflow.ArgsOf(ref, args),
 		},
 	}
-	return h.Task.Processor.SubmitTask(ctx, ref, "update-image-git(batch)", steps)
 }
 
 // @Tags        Application
@@ -105,12 +104,12 @@ func (h *ApplicationHandler) asyncBatchUpdateImages(ctx context.Context, ref Pat
 // @Description 更新部署镜像
 // @Accept      json
 // @Produce     json
-// @Param       tenant_id      path     int                                            true "tenaut id"
-// @Param       project_id     path     int                                            true "project id"
-// @Param       environment_id path     int                                            true "environment_id"
-// @Param       name           path     string                                         true "应用名称"
-// @Param       body           body     DeployImages                                   true "更新参数"
-// @Success     200            {object} handlers.ResponseStruct{Data=DeploiedManifest} "Application"
+// @Param       tenant_id      path     int                                               true "tenaut id"
+// @Param       project_id     path     int                                               true "project id"
+// @Param       environment_id path     int                                               true "environment_id"
+// @Param       name           path     string                                            true "应用名称"
+// @Param       body           body     DeployImages                                      true "更新参数"
+// @Success     200            {object} handlers.ResponseStruct{Data=base.ApprovalResult} "需要审批时返回待审批的请求"
 // @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/images [put]
 // @Security    JWT
 func (h *ApplicationHandler) UpdateImages(c *gin.Context) {
@@ -127,15 +126,12 @@ func (h *ApplicationHandler) UpdateImages(c *gin.Context) {
 			Images:       images,
 			IstioVersion: item.IstioVersion,
 		}
-		if err := h.asyncUpdateImages(ctx, ref, arg); err != nil {
-			return nil, err
-		}
-		return "ok", nil
+		return h.submitTaskOrRequireApproval(c, ctx, ref, models.ApprovalActionUpdateImage, ref.Name, "update-image", updateImagesSteps(ref, arg))
 	})
 }
 
-func (h *ApplicationHandler) asyncUpdateImages(ctx context.Context, ref PathRef, arg UpdateImageArgs) error {
-	steps := []workflow.Step{
+func updateImagesSteps(ref PathRef, arg UpdateImageArgs) []workflow.Step {
+	return []workflow.Step{
 		{
 			Name:     "update-image",
 			Function: TaskFunction_Application_UpdateImages,
@@ -152,7 +148,6 @@ func (h *ApplicationHandler) asyncUpdateImages(ctx context.Context, ref PathRef,
 		// 	Args:     workflow.ArgsOf(ref),
 		// },
 	}
-	return h.Task.Processor.SubmitTask(ctx, ref, "update-image", steps)
 }
 
 type DeployImages struct {
@@ -248,6 +243,11 @@ func (h *ApplicationHandler) DirectUpdateImage(c *gin.Context) {
 		}
 		istioversion := c.Query("version")
 
+		// 封网窗口内禁止更新, environment_id 由 DirectRefNameFunc 根据环境名称解析
+		if err := h.CheckEnvironmentFreeze(c, utils.ToUint(c.Param("environment_id"))); err != nil {
+			return nil, err
+		}
+
 		// update
 		if err := h.ApplicationProcessor.UpdateImages(ctx, ref, []string{image}, istioversion); err != nil {
 			return nil, err
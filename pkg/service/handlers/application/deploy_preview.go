@@ -0,0 +1,518 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"kubegems.io/kubegems/pkg/apis/gems/v1beta1"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/handlers/environment"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"kubegems.io/kubegems/pkg/utils/resourcequota"
+	"kubegems.io/kubegems/pkg/utils/workflow"
+)
+
+type PreviewForm struct {
+	// 复制编排的源环境
+	SourceEnvironment string `json:"sourceEnvironment" binding:"required"`
+	Branch            string `json:"branch" binding:"required"`
+	PullRequest       int    `json:"pullRequest"`
+	// 源环境编排的 git commit,为空时使用最新的提交
+	Revision string `json:"revision"`
+	// 覆盖的镜像,例如分支构建出的镜像
+	Images []string `json:"images"`
+	// 存活时间,默认 24 小时,最长 7 天
+	TTLHours int `json:"ttlHours"`
+	// 租户网关名称,为空时使用租户下第一个配置了 baseDomain 的网关
+	Gateway string `json:"gateway"`
+}
+
+// PullRequestEvent github/gitea pull_request webhook 中使用到的字段
+type PullRequestEvent struct {
+	Action      string `json:"action"`
+	Number      int    `json:"number"`
+	PullRequest struct {
+		Head struct {
+			Ref string `json:"ref"`
+			Sha string `json:"sha"`
+		} `json:"head"`
+	} `json:"pull_request"`
+}
+
+// @Tags        Application
+// @Summary     项目下的预览环境
+// @Description 项目下的预览环境
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     int                                                       true "tenaut id"
+// @Param       project_id path     int                                                       true "project id"
+// @Success     200        {object} handlers.ResponseStruct{Data=[]models.PreviewEnvironment} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/previewenvironments [get]
+// @Security    JWT
+func (h *ApplicationHandler) ListPreviews(c *gin.Context) {
+	list := []models.PreviewEnvironment{}
+	if err := h.GetDB().WithContext(c.Request.Context()).Preload("Environment").
+		Where("project_id = ?", c.Param("project_id")).Order("id desc").Find(&list).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, list)
+}
+
+// @Tags        Application
+// @Summary     创建或更新应用的预览环境
+// @Description 在源环境的集群中以固定的配额创建分支或 PR 的临时环境, 复制源环境的应用编排并部署,同一分支已存在预览环境时更新版本并重新部署
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     int                                                     true "tenaut id"
+// @Param       project_id path     int                                                     true "project id"
+// @Param       name       path     string                                                  true "name"
+// @Param       body       body     PreviewForm                                             true "预览环境参数"
+// @Success     200        {object} handlers.ResponseStruct{Data=models.PreviewEnvironment} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/manifests/{name}/preview [post]
+// @Security    JWT
+func (h *ApplicationHandler) CreatePreview(c *gin.Context) {
+	body := &PreviewForm{}
+	h.NamedRefFunc(c, body, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		return h.createOrUpdatePreview(c, ctx, ref, body)
+	})
+}
+
+// @Tags        Application
+// @Summary     删除预览环境
+// @Description 删除预览环境中的应用以及环境
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     int                                  true "tenaut id"
+// @Param       project_id path     int                                  true "project id"
+// @Param       preview_id path     int                                  true "preview id"
+// @Success     200        {object} handlers.ResponseStruct{Data=string} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/previewenvironments/{preview_id} [delete]
+// @Security    JWT
+func (h *ApplicationHandler) DeletePreview(c *gin.Context) {
+	h.NoNameRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		preview := &models.PreviewEnvironment{}
+		if err := h.previewQuery(ctx).
+			Where("project_id = ? and id = ?", c.Param("project_id"), c.Param("preview_id")).
+			Take(preview).Error; err != nil {
+			return nil, err
+		}
+		h.SetAuditData(c, "删除", "预览环境", preview.Environment.EnvironmentName)
+		if err := h.teardownPreview(ctx, preview); err != nil {
+			return nil, err
+		}
+		return "ok", nil
+	})
+}
+
+// PreviewWebhookSecret 生成的 webhook 地址和密钥, 密钥仅在生成时返回
+type PreviewWebhookSecret struct {
+	// webhook 的地址路径, 不需要登录, 使用密钥校验签名
+	Path   string `json:"path"`
+	Secret string `json:"secret"`
+}
+
+// @Tags        Application
+// @Summary     生成 PR webhook 密钥
+// @Description 生成或重新生成应用的 PR webhook 密钥, 之前的密钥随即失效; 在 github/gitea 中将该密钥配置为 webhook 的 secret.
+// @Description webhook 以生成密钥的用户的身份创建和删除预览环境
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     int                                                true "tenaut id"
+// @Param       project_id path     int                                                true "project id"
+// @Param       name       path     string                                             true "name"
+// @Success     200        {object} handlers.ResponseStruct{Data=PreviewWebhookSecret} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/manifests/{name}/previewhook [post]
+// @Security    JWT
+func (h *ApplicationHandler) GeneratePreviewWebhookSecret(c *gin.Context) {
+	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		user, _ := h.GetContextUser(c)
+		projectid, _ := strconv.Atoi(c.Param("project_id"))
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		db := h.GetDB().WithContext(ctx)
+		hook := &models.PreviewWebhook{}
+		if err := db.Where("project_id = ? and application_name = ?", projectid, ref.Name).
+			Attrs(models.PreviewWebhook{ProjectID: uint(projectid), ApplicationName: ref.Name}).
+			FirstOrInit(hook).Error; err != nil {
+			return nil, err
+		}
+		hook.Secret = hex.EncodeToString(secret)
+		hook.CreatorID = user.GetID()
+		if err := db.Save(hook).Error; err != nil {
+			return nil, err
+		}
+		h.SetAuditData(c, "生成", "预览环境webhook密钥", ref.Name)
+		return PreviewWebhookSecret{
+			Path:   fmt.Sprintf("/v1/hooks/tenant/%s/project/%s/manifests/%s/preview", c.Param("tenant_id"), c.Param("project_id"), ref.Name),
+			Secret: hook.Secret,
+		}, nil
+	})
+}
+
+// @Tags        Application
+// @Summary     PR webhook
+// @Description 接收 github/gitea 的 pull_request 事件, PR 打开或更新时创建或更新预览环境, 关闭时删除预览环境.
+// @Description 使用应用的 webhook 密钥校验 X-Hub-Signature-256 或 X-Gitea-Signature 签名, 不需要登录.
+// @Description image 参数中可以使用 {branch},{number},{sha} 占位符
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     int                                       true  "tenaut id"
+// @Param       project_id path     int                                       true  "project id"
+// @Param       name       path     string                                    true  "name"
+// @Param       source     query    string                                    true  "源环境"
+// @Param       ttl        query    int                                       false "存活小时数"
+// @Param       gateway    query    string                                    false "租户网关"
+// @Param       image      query    []string                                  false "覆盖的镜像"
+// @Param       body       body     PullRequestEvent                          true  "pull_request 事件"
+// @Success     200        {object} handlers.ResponseStruct{Data=interface{}} "ok"
+// @Router      /v1/hooks/tenant/{tenant_id}/project/{project_id}/manifests/{name}/preview [post]
+func (h *ApplicationHandler) PreviewWebhook(c *gin.Context) {
+	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		hook := &models.PreviewWebhook{}
+		if err := h.GetDB().WithContext(ctx).
+			Where("project_id = ? and application_name = ?", c.Param("project_id"), ref.Name).
+			Take(hook).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				handlers.Unauthorized(c, fmt.Errorf("preview webhook of %s is not configured", ref.Name))
+				return nil, nil
+			}
+			return nil, err
+		}
+		return servePreviewHook(c, hook.Secret, previewHookFuncs{
+			update: func(form *PreviewForm) (interface{}, error) {
+				ctx, err := h.previewHookContext(c, ctx, hook)
+				if err != nil {
+					return nil, err
+				}
+				return h.createOrUpdatePreview(c, ctx, ref, form)
+			},
+			teardown: func(branch string) (interface{}, error) {
+				ctx, err := h.previewHookContext(c, ctx, hook)
+				if err != nil {
+					return nil, err
+				}
+				preview := &models.PreviewEnvironment{}
+				if err := h.previewQuery(ctx).
+					Where("project_id = ? and application_name = ? and branch = ?", c.Param("project_id"), ref.Name, branch).
+					Take(preview).Error; err != nil {
+					if errors.Is(err, gorm.ErrRecordNotFound) {
+						return "ignored", nil
+					}
+					return nil, err
+				}
+				h.SetAuditData(c, "删除", "预览环境", preview.Environment.EnvironmentName)
+				if err := h.teardownPreview(ctx, preview); err != nil {
+					return nil, err
+				}
+				return "ok", nil
+			},
+		})
+	})
+}
+
+// previewHookContext webhook 请求没有登录用户, 使用生成密钥的用户作为操作人
+func (h *ApplicationHandler) previewHookContext(c *gin.Context, ctx context.Context, hook *models.PreviewWebhook) (context.Context, error) {
+	user := &models.User{}
+	if err := h.GetDB().WithContext(ctx).Take(user, hook.CreatorID).Error; err != nil {
+		return nil, fmt.Errorf("creator of preview webhook: %w", err)
+	}
+	h.SetContextUser(c, user)
+	return context.WithValue(ctx, contextAuthorKey{}, &object.Signature{Name: user.GetUsername(), Email: user.GetEmail()}), nil
+}
+
+// previewHookFuncs webhook 中创建或更新以及删除预览环境的方法
+type previewHookFuncs struct {
+	update   func(form *PreviewForm) (interface{}, error)
+	teardown func(branch string) (interface{}, error)
+}
+
+// servePreviewHook 校验签名后按 pull_request 事件创建,更新或删除预览环境, 签名错误时响应 401
+func servePreviewHook(c *gin.Context, secret string, funcs previewHookFuncs) (interface{}, error) {
+	body, err := c.GetRawData()
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyWebhookSignature(c.Request.Header, body, secret); err != nil {
+		handlers.Unauthorized(c, err)
+		return nil, nil
+	}
+	event := &PullRequestEvent{}
+	if err := json.Unmarshal(body, event); err != nil {
+		return nil, err
+	}
+	branch := event.PullRequest.Head.Ref
+	switch event.Action {
+	case "opened", "reopened", "synchronize", "synchronized":
+		if branch == "" {
+			return nil, fmt.Errorf("no head branch in pull_request event")
+		}
+		ttl, _ := strconv.Atoi(c.Query("ttl"))
+		replacer := strings.NewReplacer(
+			"{branch}", dnsLabel(branch, 128),
+			"{number}", strconv.Itoa(event.Number),
+			"{sha}", event.PullRequest.Head.Sha,
+		)
+		images := []string{}
+		for _, image := range c.QueryArray("image") {
+			images = append(images, replacer.Replace(image))
+		}
+		form := &PreviewForm{
+			SourceEnvironment: c.Query("source"),
+			Branch:            branch,
+			PullRequest:       event.Number,
+			Images:            images,
+			TTLHours:          ttl,
+			Gateway:           c.Query("gateway"),
+		}
+		if form.SourceEnvironment == "" {
+			return nil, fmt.Errorf("query parameter source is required")
+		}
+		return funcs.update(form)
+	case "closed":
+		if branch == "" {
+			return nil, fmt.Errorf("no head branch in pull_request event")
+		}
+		return funcs.teardown(branch)
+	default:
+		return "ignored", nil
+	}
+}
+
+// verifyWebhookSignature 校验 github 的 X-Hub-Signature-256(sha256=<hex>) 或 gitea 的 X-Gitea-Signature(<hex>) 签名
+func verifyWebhookSignature(header http.Header, body []byte, secret string) error {
+	var signature string
+	if val := header.Get("X-Hub-Signature-256"); val != "" {
+		if !strings.HasPrefix(val, "sha256=") {
+			return fmt.Errorf("invalid X-Hub-Signature-256 header")
+		}
+		signature = strings.TrimPrefix(val, "sha256=")
+	} else {
+		signature = header.Get("X-Gitea-Signature")
+	}
+	if signature == "" {
+		return fmt.Errorf("webhook signature is required")
+	}
+	sum, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid webhook signature: %w", err)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if secret == "" || !hmac.Equal(sum, mac.Sum(nil)) {
+		return fmt.Errorf("webhook signature mismatch")
+	}
+	return nil
+}
+
+func (h *ApplicationHandler) createOrUpdatePreview(c *gin.Context, ctx context.Context, ref PathRef, form *PreviewForm) (*models.PreviewEnvironment, error) {
+	projectid, _ := strconv.Atoi(c.Param("project_id"))
+	ttl := form.TTLHours
+	if ttl <= 0 {
+		ttl = models.DefaultPreviewTTLHours
+	}
+	if ttl > models.MaxPreviewTTLHours {
+		return nil, fmt.Errorf("ttl of preview environment can't be longer than %d hours", models.MaxPreviewTTLHours)
+	}
+	srcenv, err := h.getProjectEnvironment(ctx, uint(projectid), form.SourceEnvironment)
+	if err != nil {
+		return nil, err
+	}
+	images, _ := json.Marshal(form.Images)
+	expiredAt := time.Now().Add(time.Duration(ttl) * time.Hour)
+	db := h.GetDB().WithContext(ctx)
+
+	preview := &models.PreviewEnvironment{}
+	err = h.previewQuery(ctx).
+		Where("project_id = ? and application_name = ? and branch = ?", projectid, ref.Name, form.Branch).
+		Take(preview).Error
+	switch {
+	case err == nil:
+		// 已存在时更新版本并延长过期时间
+		preview.SourceEnvironment = form.SourceEnvironment
+		preview.Revision = form.Revision
+		preview.Images = images
+		preview.PullRequest = form.PullRequest
+		preview.ExpiredAt = expiredAt
+		if err := db.Omit("Environment").Save(preview).Error; err != nil {
+			return nil, err
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		preview = &models.PreviewEnvironment{
+			ProjectID:         srcenv.ProjectID,
+			ApplicationName:   ref.Name,
+			Branch:            form.Branch,
+			PullRequest:       form.PullRequest,
+			SourceEnvironment: form.SourceEnvironment,
+			Revision:          form.Revision,
+			Images:            images,
+			ExpiredAt:         expiredAt,
+		}
+		if err := h.createPreviewEnvironment(c, ctx, ref, srcenv, preview); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	env := preview.Environment
+	h.SetAuditData(c, "部署", "预览环境", env.EnvironmentName)
+	h.SetExtraAuditData(c, models.ResEnvironment, env.ID)
+
+	opts := PreviewOptions{From: form.SourceEnvironment, Revision: form.Revision, Images: form.Images}
+	if gateway, err := h.previewGateway(ctx, env.Cluster.ClusterName, ref.Tenant, form.Gateway); err != nil {
+		log.FromContextOrDiscard(ctx).Error(err, "find tenant gateway for preview", "environment", env.EnvironmentName)
+	} else if gateway != nil {
+		opts.IngressClass = gateway.Spec.IngressClass
+		opts.Host = strings.Replace(gateway.Spec.BaseDomain, "*", env.EnvironmentName, 1)
+		if preview.Host != opts.Host {
+			preview.Host = opts.Host
+			_ = db.Model(preview).Update("host", opts.Host).Error
+		}
+	}
+
+	// 任务在预览环境下执行
+	previewref := PathRef{Tenant: ref.Tenant, Project: ref.Project, Env: env.EnvironmentName, Name: ref.Name}
+	ctx = context.WithValue(ctx, contextClusterNamespaceKey{}, ClusterNamespace{Cluster: env.Cluster.ClusterName, Namespace: env.Namespace})
+	steps := []workflow.Step{
+		{
+			Name:     "deploy-preview",
+			Function: TaskFunction_Application_DeployPreview,
+			Args:     workflow.ArgsOf(previewref, opts),
+		},
+	}
+	if err := h.Task.Processor.SubmitTask(ctx, previewref, "preview", steps); err != nil {
+		return nil, err
+	}
+	return preview, nil
+}
+
+// previewResourceQuota 预览环境使用固定的较小配额, 不复制源环境的配额, LimitRange 使用默认值
+var previewResourceQuota = func() datatypes.JSON {
+	quota := resourcequota.GetDefaultEnvironmentResourceQuota()
+	for name, value := range map[corev1.ResourceName]string{
+		corev1.ResourceRequestsCPU:     "2",
+		corev1.ResourceLimitsCPU:       "4",
+		corev1.ResourceRequestsMemory:  "4Gi",
+		corev1.ResourceLimitsMemory:    "8Gi",
+		corev1.ResourceRequestsStorage: "20Gi",
+		corev1.ResourcePods:            "50",
+	} {
+		quota[name] = resource.MustParse(value)
+	}
+	content, _ := json.Marshal(quota)
+	return content
+}()
+
+// createPreviewEnvironment 在源环境的集群中使用固定的配额创建新的环境
+func (h *ApplicationHandler) createPreviewEnvironment(c *gin.Context, ctx context.Context, ref PathRef, srcenv *models.Environment, preview *models.PreviewEnvironment) error {
+	user, ok := h.GetContextUser(c)
+	if !ok {
+		return fmt.Errorf("no user to create preview environment")
+	}
+	preview.Creator = user.GetUsername()
+	name := PreviewEnvironmentName(ref.Project, preview.ApplicationName, preview.Branch)
+	env := &models.Environment{
+		EnvironmentName: name,
+		Namespace:       name,
+		Remark:          fmt.Sprintf("preview of %s branch %s", preview.ApplicationName, preview.Branch),
+		MetaType:        srcenv.MetaType,
+		DeletePolicy:    "delNamespace",
+		ResourceQuota:   previewResourceQuota,
+		ProjectID:       srcenv.ProjectID,
+		ClusterID:       srcenv.ClusterID,
+		CreatorID:       user.GetID(),
+	}
+	env.LimitRange = models.FillDefaultLimigrange(env)
+	if err := environment.ValidateEnvironmentNamespace(ctx, h.BaseHandler.BaseHandler, h.GetDB().WithContext(ctx), env.Namespace, env.EnvironmentName, srcenv.Cluster.ClusterName); err != nil {
+		return err
+	}
+	err := h.GetDB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(env).Error; err != nil {
+			return err
+		}
+		if err := environment.AfterEnvironmentSave(ctx, h.BaseHandler.BaseHandler, tx, env); err != nil {
+			return err
+		}
+		preview.EnvironmentID = env.ID
+		return tx.Create(preview).Error
+	})
+	if err != nil {
+		return err
+	}
+	h.ModelCache().UpsertEnvironment(env.ProjectID, env.ID, env.EnvironmentName, srcenv.Cluster.ClusterName, env.Namespace)
+
+	env.Cluster = srcenv.Cluster
+	preview.Environment = env
+	return nil
+}
+
+// previewGateway 查找租户在集群中用于暴露预览环境的网关
+func (h *ApplicationHandler) previewGateway(ctx context.Context, cluster, tenant, name string) (*v1beta1.TenantGateway, error) {
+	gatewaylist := &v1beta1.TenantGatewayList{}
+	if err := h.Execute(ctx, cluster, func(ctx context.Context, cli agents.Client) error {
+		return cli.List(ctx, gatewaylist)
+	}); err != nil {
+		return nil, err
+	}
+	for i, gateway := range gatewaylist.Items {
+		if gateway.Spec.Tenant != tenant || gateway.Spec.BaseDomain == "" {
+			continue
+		}
+		if name == "" || gateway.Name == name {
+			return &gatewaylist.Items[i], nil
+		}
+	}
+	if name != "" {
+		return nil, fmt.Errorf("tenant gateway %s not found in cluster %s", name, cluster)
+	}
+	return nil, nil
+}
+
+func (h *ApplicationHandler) previewQuery(ctx context.Context) *gorm.DB {
+	return h.GetDB().WithContext(ctx).
+		Preload("Environment.Cluster").
+		Preload("Environment.Project.Tenant")
+}
+
+func (h *ApplicationHandler) teardownPreview(ctx context.Context, preview *models.PreviewEnvironment) error {
+	if err := h.ApplicationProcessor.TeardownPreview(ctx, preview); err != nil {
+		return err
+	}
+	env := preview.Environment
+	h.ModelCache().DelEnvironment(env.ProjectID, env.ID, env.Cluster.ClusterName, env.Namespace)
+	return nil
+}
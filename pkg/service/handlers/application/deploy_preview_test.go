@@ -0,0 +1,152 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestServePreviewHook(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const secret = "s3cret"
+	sign := func(body string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	event := func(action string) string {
+		return `{"action":"` + action + `","number":12,"pull_request":{"head":{"ref":"feature/login","sha":"abc123"}}}`
+	}
+	tests := []struct {
+		name         string
+		body         string
+		header       map[string]string
+		wantCode     int
+		wantForm     *PreviewForm
+		wantTeardown string
+		wantData     interface{}
+	}{
+		{
+			name:   "opened",
+			body:   event("opened"),
+			header: map[string]string{"X-Hub-Signature-256": "sha256=" + sign(event("opened"))},
+			wantForm: &PreviewForm{
+				SourceEnvironment: "dev",
+				Branch:            "feature/login",
+				PullRequest:       12,
+				Images:            []string{"registry/web:feature-login-abc123"},
+				TTLHours:          2,
+			},
+			wantData: "updated",
+		},
+		{
+			name:   "synchronize with gitea signature",
+			body:   event("synchronized"),
+			header: map[string]string{"X-Gitea-Signature": sign(event("synchronized"))},
+			wantForm: &PreviewForm{
+				SourceEnvironment: "dev",
+				Branch:            "feature/login",
+				PullRequest:       12,
+				Images:            []string{"registry/web:feature-login-abc123"},
+				TTLHours:          2,
+			},
+			wantData: "updated",
+		},
+		{
+			name:         "closed",
+			body:         event("closed"),
+			header:       map[string]string{"X-Hub-Signature-256": "sha256=" + sign(event("closed"))},
+			wantTeardown: "feature/login",
+			wantData:     "teardown",
+		},
+		{
+			name:     "other action",
+			body:     event("labeled"),
+			header:   map[string]string{"X-Hub-Signature-256": "sha256=" + sign(event("labeled"))},
+			wantData: "ignored",
+		},
+		{
+			name:     "bad signature",
+			body:     event("opened"),
+			header:   map[string]string{"X-Hub-Signature-256": "sha256=" + sign(event("closed"))},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "signature without prefix",
+			body:     event("opened"),
+			header:   map[string]string{"X-Hub-Signature-256": sign(event("opened"))},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "no signature",
+			body:     event("closed"),
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(http.MethodPost, "/?source=dev&ttl=2&image=registry/web:{branch}-{sha}", strings.NewReader(tt.body))
+			for k, v := range tt.header {
+				c.Request.Header.Set(k, v)
+			}
+			var (
+				gotForm     *PreviewForm
+				gotTeardown string
+			)
+			data, err := servePreviewHook(c, secret, previewHookFuncs{
+				update: func(form *PreviewForm) (interface{}, error) {
+					gotForm = form
+					return "updated", nil
+				},
+				teardown: func(branch string) (interface{}, error) {
+					gotTeardown = branch
+					return "teardown", nil
+				},
+			})
+			if err != nil {
+				t.Fatalf("servePreviewHook() error = %v", err)
+			}
+			if tt.wantCode != 0 {
+				if recorder.Code != tt.wantCode || data != nil {
+					t.Errorf("servePreviewHook() code = %v, data = %v, want code %v", recorder.Code, data, tt.wantCode)
+				}
+				if gotForm != nil || gotTeardown != "" {
+					t.Errorf("servePreviewHook() called preview funcs with a bad signature")
+				}
+				return
+			}
+			if data != tt.wantData {
+				t.Errorf("servePreviewHook() = %v, want %v", data, tt.wantData)
+			}
+			if !reflect.DeepEqual(gotForm, tt.wantForm) {
+				t.Errorf("servePreviewHook() form = %+v, want %+v", gotForm, tt.wantForm)
+			}
+			if gotTeardown != tt.wantTeardown {
+				t.Errorf("servePreviewHook() teardown = %v, want %v", gotTeardown, tt.wantTeardown)
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/handlers/base"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/workflow"
+)
+
+const argoHealthStatusHealthy = "Healthy"
+
+type PromotionPipelineForm struct {
+	// 按提升顺序排列的环境名称
+	Stages         []string `json:"stages" binding:"required,min=2"`
+	RequireHealthy bool     `json:"requireHealthy"`
+}
+
+type PromoteForm struct {
+	From string `json:"from" binding:"required"`
+	// 为空时使用流水线中的下一个环境
+	To string `json:"to"`
+	// 源环境编排的 git commit,为空时使用最新的提交
+	Revision string `json:"revision"`
+}
+
+type StagePromotionStatus struct {
+	Environment string   `json:"environment"`
+	Revision    string   `json:"revision,omitempty"`
+	Message     string   `json:"message,omitempty"`
+	Author      string   `json:"author,omitempty"`
+	Timestamp   string   `json:"timestamp,omitempty"`
+	Images      []string `json:"images,omitempty"`
+	Health      string   `json:"health,omitempty"`
+	Sync        string   `json:"sync,omitempty"`
+}
+
+// @Tags        Application
+// @Summary     获取项目的环境提升流水线
+// @Description 获取项目的环境提升流水线,未配置时环境列表为空
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     int                                                     true "tenaut id"
+// @Param       project_id path     int                                                     true "project id"
+// @Success     200        {object} handlers.ResponseStruct{Data=models.PromotionPipeline} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/promotionpipeline [get]
+// @Security    JWT
+func (h *ApplicationHandler) GetPromotionPipeline(c *gin.Context) {
+	pipeline, err := h.getPromotionPipeline(c.Request.Context(), c.Param("project_id"))
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, pipeline)
+}
+
+// @Tags        Application
+// @Summary     设置项目的环境提升流水线
+// @Description 设置项目的环境提升流水线,仅项目管理员可以设置
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     int                                                     true "tenaut id"
+// @Param       project_id path     int                                                     true "project id"
+// @Param       body       body     PromotionPipelineForm                                   true "流水线"
+// @Success     200        {object} handlers.ResponseStruct{Data=models.PromotionPipeline} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/promotionpipeline [put]
+// @Security    JWT
+func (h *ApplicationHandler) PutPromotionPipeline(c *gin.Context) {
+	body := &PromotionPipelineForm{}
+	if err := c.ShouldBindJSON(body); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	projectid, _ := strconv.Atoi(c.Param("project_id"))
+	u, _ := h.GetContextUser(c)
+	if auth := h.ModelCache().GetUserAuthority(u); !auth.IsSystemAdmin() && !auth.IsProjectAdmin(uint(projectid)) {
+		handlers.NotOK(c, fmt.Errorf("only project admin can modify promotion pipeline"))
+		return
+	}
+	ctx := c.Request.Context()
+	seen := map[string]bool{}
+	for _, stage := range body.Stages {
+		if seen[stage] {
+			handlers.NotOK(c, fmt.Errorf("duplicated environment %s in stages", stage))
+			return
+		}
+		seen[stage] = true
+		if _, err := h.getProjectEnvironment(ctx, uint(projectid), stage); err != nil {
+			handlers.NotOK(c, err)
+			return
+		}
+	}
+	stages, _ := json.Marshal(body.Stages)
+	pipeline := &models.PromotionPipeline{
+		ProjectID:      uint(projectid),
+		Stages:         stages,
+		RequireHealthy: body.RequireHealthy,
+	}
+	if err := h.GetDB().WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "project_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"stages", "require_healthy", "updated_at"}),
+	}).Create(pipeline).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.SetAuditData(c, "更新", "提升流水线", string(stages))
+	h.SetExtraAuditData(c, models.ResProject, uint(projectid))
+	handlers.OK(c, pipeline)
+}
+
+// @Tags        Application
+// @Summary     应用在流水线各环境中的版本
+// @Description 应用在流水线各环境中的 git 版本,镜像以及运行状态
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     int                                                  true "tenaut id"
+// @Param       project_id path     int                                                  true "project id"
+// @Param       name       path     string                                               true "name"
+// @Success     200        {object} handlers.ResponseStruct{Data=[]StagePromotionStatus} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/manifests/{name}/promotion [get]
+// @Security    JWT
+func (h *ApplicationHandler) GetPromotionStatus(c *gin.Context) {
+	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		pipeline, err := h.getPromotionPipeline(ctx, c.Param("project_id"))
+		if err != nil {
+			return nil, err
+		}
+		ret := []StagePromotionStatus{}
+		for _, stage := range PromotionStages(pipeline) {
+			stageref := PathRef{Tenant: ref.Tenant, Project: ref.Project, Env: stage, Name: ref.Name}
+			status := StagePromotionStatus{Environment: stage}
+			if commit, err := h.ApplicationProcessor.Manifest.LatestRevision(ctx, stageref); err != nil {
+				status.Message = err.Error()
+			} else {
+				status.Revision = commit.Hash
+				status.Message = commit.Message
+				status.Author = commit.Author.Name
+				status.Timestamp = commit.Author.When.Format(time.RFC3339)
+			}
+			if app, err := h.ApplicationProcessor.Argo.GetArgoApp(ctx, stageref.FullName()); err == nil {
+				status.Images = app.Status.Summary.Images
+				status.Health = string(app.Status.Health.Status)
+				status.Sync = string(app.Status.Sync.Status)
+			} else {
+				status.Health = StatusNoArgoApp
+			}
+			ret = append(ret, status)
+		}
+		return ret, nil
+	})
+}
+
+// @Tags        Application
+// @Summary     提升应用到下一个环境
+// @Description 将源环境中应用编排的指定版本复制到目标环境并同步,目标环境的 overlay 目录保持不变
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     int                                               true "tenaut id"
+// @Param       project_id path     int                                               true "project id"
+// @Param       name       path     string                                            true "name"
+// @Param       body       body     PromoteForm                                       true "提升参数"
+// @Success     200        {object} handlers.ResponseStruct{Data=base.ApprovalResult} "需要审批时返回待审批的请求"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/manifests/{name}/promote [post]
+// @Security    JWT
+func (h *ApplicationHandler) Promote(c *gin.Context) {
+	body := &PromoteForm{}
+	h.NamedRefFunc(c, body, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		pipeline, err := h.getPromotionPipeline(ctx, c.Param("project_id"))
+		if err != nil {
+			return nil, err
+		}
+		// 只能提升到流水线中的下一个环境
+		next := NextStage(PromotionStages(pipeline), body.From)
+		if next == "" {
+			return nil, fmt.Errorf("no next stage of environment %s in promotion pipeline", body.From)
+		}
+		if body.To == "" {
+			body.To = next
+		}
+		if body.To != next {
+			return nil, fmt.Errorf("environment %s is not the next stage of %s in promotion pipeline, expect %s", body.To, body.From, next)
+		}
+		if _, err := h.getProjectEnvironment(ctx, pipeline.ProjectID, body.From); err != nil {
+			return nil, err
+		}
+		toenv, err := h.getProjectEnvironment(ctx, pipeline.ProjectID, body.To)
+		if err != nil {
+			return nil, err
+		}
+		if !h.canDeployEnvironment(c, toenv) {
+			return nil, fmt.Errorf("you have no permission to deploy in environment %s", toenv.EnvironmentName)
+		}
+		if err := h.CheckEnvironmentFreeze(c, toenv.ID); err != nil {
+			return nil, err
+		}
+
+		h.SetAuditData(c, "提升", "应用", fmt.Sprintf("%s(%s->%s)", ref.Name, body.From, body.To))
+		h.SetExtraAuditData(c, models.ResEnvironment, toenv.ID)
+
+		srcref := PathRef{Tenant: ref.Tenant, Project: ref.Project, Env: body.From, Name: ref.Name}
+		if pipeline.RequireHealthy {
+			app, err := h.ApplicationProcessor.Argo.GetArgoApp(ctx, srcref.FullName())
+			if err != nil {
+				return nil, fmt.Errorf("get application %s in environment %s: %w", ref.Name, body.From, err)
+			}
+			if app.Status.Health.Status != argoHealthStatusHealthy || app.Status.Sync.Status != v1alpha1.SyncStatusCodeSynced {
+				return nil, fmt.Errorf("application %s in environment %s is %s/%s, require Healthy/Synced before promotion",
+					ref.Name, body.From, app.Status.Health.Status, app.Status.Sync.Status)
+			}
+		}
+
+		// 任务在目标环境下执行
+		dstref := PathRef{Tenant: ref.Tenant, Project: ref.Project, Env: body.To, Name: ref.Name}
+		ctx = context.WithValue(ctx, contextClusterNamespaceKey{}, ClusterNamespace{Cluster: toenv.Cluster.ClusterName, Namespace: toenv.Namespace})
+		opts := PromotionOptions{ProjectID: pipeline.ProjectID, From: body.From, To: body.To, Revision: body.Revision}
+		steps := []workflow.Step{
+			{
+				Name:     "promote",
+				Function: TaskFunction_Application_Promote,
+				Args:     workflow.ArgsOf(dstref, opts),
+			},
+			{
+				Name:     "sync",
+				Function: TaskFunction_Application_Sync,
+				Args:     workflow.ArgsOf(dstref),
+			},
+		}
+		approval, err := h.requireEnvironmentApproval(c, ctx, dstref, toenv.ID, models.ApprovalActionPromoteEnvironment, ref.Name, "promote", steps)
+		if err != nil {
+			return nil, err
+		}
+		if approval != nil {
+			return base.ApprovalResult{Approval: approval}, nil
+		}
+		if err := h.Task.Processor.SubmitTask(ctx, dstref, "promote", steps); err != nil {
+			return nil, err
+		}
+		return base.ApprovalResult{Submitted: true}, nil
+	})
+}
+
+// @Tags        Application
+// @Summary     应用的环境提升历史
+// @Description 应用的环境提升历史
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     int                                                                                 true  "tenaut id"
+// @Param       project_id path     int                                                                                 true  "project id"
+// @Param       name       path     string                                                                              true  "name"
+// @Param       page       query    int                                                                                 false "page"
+// @Param       size       query    int                                                                                 false "page"
+// @Success     200        {object} handlers.ResponseStruct{Data=handlers.PageData{List=[]models.ApplicationPromotion}} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/manifests/{name}/promotionhistory [get]
+// @Security    JWT
+func (h *ApplicationHandler) PromotionHistory(c *gin.Context) {
+	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		list := []models.ApplicationPromotion{}
+		if err := h.GetDB().WithContext(ctx).
+			Where("project_id = ? and application_name = ?", c.Param("project_id"), ref.Name).
+			Order("id desc").Find(&list).Error; err != nil {
+			return nil, err
+		}
+		return handlers.NewPageDataFromContext(c, list, nil, nil), nil
+	})
+}
+
+// 未配置流水线时返回空的流水线
+func (h *ApplicationHandler) getPromotionPipeline(ctx context.Context, projectid string) (*models.PromotionPipeline, error) {
+	id, err := strconv.Atoi(projectid)
+	if err != nil {
+		return nil, err
+	}
+	pipeline := &models.PromotionPipeline{ProjectID: uint(id)}
+	if err := h.GetDB().WithContext(ctx).Where("project_id = ?", id).Take(pipeline).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		pipeline.Stages = []byte("[]")
+	}
+	return pipeline, nil
+}
+
+// 与 CheckCanDeployEnvironment 相同,目标环境不在路径中时使用
+func (h *ApplicationHandler) canDeployEnvironment(c *gin.Context, env *models.Environment) bool {
+	u, exist := h.GetContextUser(c)
+	if !exist {
+		return false
+	}
+	tenantid, _ := strconv.Atoi(c.Param("tenant_id"))
+	auth := h.ModelCache().GetUserAuthority(u)
+	return auth.IsSystemAdmin() ||
+		auth.IsTenantAdmin(uint(tenantid)) ||
+		auth.IsProjectAdmin(env.ProjectID) || auth.IsProjectOps(env.ProjectID) ||
+		auth.IsEnvironmentOperator(env.ID)
+}
+
+func (h *ApplicationHandler) getProjectEnvironment(ctx context.Context, projectid uint, name string) (*models.Environment, error) {
+	env := &models.Environment{}
+	if err := h.GetDB().WithContext(ctx).Preload("Cluster", func(tx *gorm.DB) *gorm.DB { return tx.Select("id, cluster_name") }).
+		Where("project_id = ? and environment_name = ?", projectid, name).Take(env).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("environment %s not found in project", name)
+		}
+		return nil, err
+	}
+	return env, nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"kubegems.io/kubegems/pkg/service/handlers/base"
+	"kubegems.io/kubegems/pkg/utils"
+)
+
+type RightSizingApply struct {
+	Kind string `json:"kind" binding:"required"`
+	Name string `json:"name" binding:"required"`
+	// 需要应用建议的容器, 为空时应用全部容器
+	Containers []string `json:"containers"`
+}
+
+// RightSizingApplyResult 应用的资源建议以及同步任务是否提交或者等待的审批
+type RightSizingApplyResult struct {
+	Workload            WorkloadRightSizing `json:"workload"`
+	base.ApprovalResult `json:",inline"`
+}
+
+// @Tags        Application
+// @Summary     环境中工作负载的资源建议
+// @Description 根据窗口内容器 cpu/内存的百分位用量计算 request/limit 建议, 标记资源过量和不足的工作负载并估算每月节省的费用
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                             true  "tenaut id"
+// @Param       project_id     path     int                                             true  "project id"
+// @Param       environment_id path     int                                             true  "environment_id"
+// @Param       window         query    string                                          false "统计窗口, 默认 7d"
+// @Param       percentile     query    number                                          false "百分位, 默认 0.95"
+// @Param       headroom       query    number                                          false "余量比例, 默认 0.2"
+// @Param       status         query    string                                          false "over-provisioned, under-provisioned, optimal"
+// @Success     200            {object} handlers.ResponseStruct{Data=RightSizingReport} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/rightsizing [get]
+// @Security    JWT
+func (h *ApplicationHandler) RightSizing(c *gin.Context) {
+	h.NoNameRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		opts := RightSizingOptions{}
+		if err := c.ShouldBindQuery(&opts); err != nil {
+			return nil, err
+		}
+		report, err := h.ApplicationProcessor.RightSizing(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		if status := c.Query("status"); status != "" {
+			workloads := []WorkloadRightSizing{}
+			report.MonthlySavings = 0
+			for _, workload := range report.Workloads {
+				if workload.Status == status {
+					workloads = append(workloads, workload)
+					report.MonthlySavings += workload.MonthlySavings
+				}
+			}
+			report.Workloads = workloads
+		}
+		return report, nil
+	})
+}
+
+// @Tags        Application
+// @Summary     应用工作负载的资源建议
+// @Description 按照相同的计算参数重新计算资源建议, 更新至 gitrepo 并同步, 同步需要审批时在审批通过后执行
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                                  true  "tenaut id"
+// @Param       project_id     path     int                                                  true  "project id"
+// @Param       environment_id path     int                                                  true  "environment_id"
+// @Param       window         query    string                                               false "统计窗口, 默认 7d"
+// @Param       percentile     query    number                                               false "百分位, 默认 0.95"
+// @Param       headroom       query    number                                               false "余量比例, 默认 0.2"
+// @Param       body           body     RightSizingApply                                     true  "工作负载"
+// @Success     200            {object} handlers.ResponseStruct{Data=RightSizingApplyResult} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/rightsizing/apply [post]
+// @Security    JWT
+func (h *ApplicationHandler) ApplyRightSizing(c *gin.Context) {
+	body := &RightSizingApply{}
+	h.NoNameRefFunc(c, body, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		h.SetAuditData(c, "更新", "资源建议", body.Kind+"/"+body.Name)
+		opts := RightSizingOptions{}
+		if err := c.ShouldBindQuery(&opts); err != nil {
+			return nil, err
+		}
+		report, err := h.ApplicationProcessor.RightSizing(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, workload := range report.Workloads {
+			if workload.Kind != body.Kind || workload.Name != body.Name {
+				continue
+			}
+			if !workload.Applicable {
+				return nil, fmt.Errorf("%s %s is not managed by application", body.Kind, body.Name)
+			}
+			suggestion := RightSizingSuggestion(workload, body.Containers)
+			if len(suggestion.Spec.Template.Spec.Containers) == 0 {
+				return nil, fmt.Errorf("no suggestion for containers %v", body.Containers)
+			}
+			result, err := h.applyResourceSuggestion(c, ctx, ref, utils.ToUint(c.Param("environment_id")), suggestion)
+			if err != nil {
+				return nil, err
+			}
+			return RightSizingApplyResult{Workload: workload, ApprovalResult: *result}, nil
+		}
+		return nil, fmt.Errorf("no suggestion for %s %s", body.Kind, body.Name)
+	})
+}
@@ -218,12 +218,12 @@ func (h *ApplicationHandler) ListAnalysisTemplate(c *gin.Context) {
 // @Description 更新中的实时状态
 // @Accept      json
 // @Produce     json
-// @Param       tenant_id      path     int                                               true  "tenaut id"
-// @Param       project_id     path     int                                               true  "project id"
-// @param       environment_id path     int                                               true  "environment id"
-// @Param       name           path     string                                            true  "applicationname"
-// @param       watch          query    bool                                              false "watch 则返回 ssevent"
-// @Success     200            {object} handlers.ResponseStruct{Data=rollout.RolloutInfo} "-"
+// @Param       tenant_id      path     int                                                                true  "tenaut id"
+// @Param       project_id     path     int                                                                true  "project id"
+// @param       environment_id path     int                                                                true  "environment id"
+// @Param       name           path     string                                                             true  "applicationname"
+// @param       watch          query    bool                                                               false "watch 则返回 ssevent"
+// @Success     200            {object} handlers.ResponseStruct{Data=StrategyDeploymentStatusWithAnalysis} "非 watch 时包含灰度分析结果"
 // @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/application/{name}/strategydeploystatus [get]
 // @Security    JWT
 func (h *ApplicationHandler) StrategyDeploymentStatus(c *gin.Context) {
@@ -283,11 +283,24 @@ func (h *ApplicationHandler) StrategyDeploymentStatus(c *gin.Context) {
 			if err := cli.DoRequest(ctx, req); err != nil {
 				return nil, err
 			}
-			return item, nil
+			if rolloutresource == nil {
+				return item, nil
+			}
+			reports, err := AnalysisRunReports(ctx, cli, namespace, name)
+			if err != nil {
+				return nil, err
+			}
+			return StrategyDeploymentStatusWithAnalysis{RolloutInfo: item, AnalysisReports: reports}, nil
 		}
 	}, "")
 }
 
+// StrategyDeploymentStatusWithAnalysis rollout 状态以及每次灰度分析的结果
+type StrategyDeploymentStatusWithAnalysis struct {
+	*rollout.RolloutInfo
+	AnalysisReports []AnalysisRunReport `json:"analysisReports,omitempty"`
+}
+
 type (
 	LocalAndRemoteStoreFunc func(ctx context.Context, local GitStore, remote agents.Client, namespace string, ref PathRef) (interface{}, error)
 	RemoteStoreFunc         func(ctx context.Context, remote agents.Client, namespace string, ref PathRef) (interface{}, error)
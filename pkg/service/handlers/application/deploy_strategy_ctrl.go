@@ -28,13 +28,14 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/models"
 	"kubegems.io/kubegems/pkg/utils/agents"
 	"kubegems.io/kubegems/pkg/utils/workflow"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 type StrategyDeploymentControl struct {
-	Command string                 `json:"command,omitempty"` // 指令名称 in(pause,restart,retry,promote,terminate,undo)
+	Command string                 `json:"command,omitempty"` // 指令名称 in(pause,restart,retry,promote,terminate,undo,routes)
 	Args    map[string]interface{} `json:"args,omitempty"`    // 一些指令可能会携带的参数,比如 undo {reversion=1} ; promote {full=true}
 }
 
@@ -97,7 +98,32 @@ func (h *ApplicationHandler) StrategyDeploymentControl(c *gin.Context) {
 			case string:
 				full, _ = strconv.ParseBool(v)
 			}
+			// 需要审批时,审批通过后异步推进
+			steps := []workflow.Step{
+				{
+					Name:     "promote",
+					Function: TaskFunction_Application_PromoteRollout,
+					Args:     workflow.ArgsOf(ref, full),
+				},
+			}
+			approval, err := h.requireApproval(c, ctx, ref, models.ApprovalActionPromote, ref.Name, "promote", steps)
+			if err != nil {
+				return nil, err
+			}
+			if approval != nil {
+				return approval, nil
+			}
 			return PromoteRollout(ctx, cli, namespace, name, full)
+		case "routes":
+			// 查看集群中灰度相关的 istio 路由, 包括 header 路由
+			strategy, err := ParseUpdateStrategyAndDeployment(ctx, store)
+			if err != nil {
+				return nil, err
+			}
+			if strategy.Strategy.Canary == nil {
+				return nil, fmt.Errorf("application %s is not using canary strategy", ref.Name)
+			}
+			return LiveCanaryRoutes(ctx, cli, namespace, &strategy.Strategy.Canary.ExtendCanaryStrategy)
 		case "terminate":
 			// TODO:
 		case "undo":
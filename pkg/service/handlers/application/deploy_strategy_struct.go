@@ -26,6 +26,10 @@ type ExtendCanaryStrategy struct {
 	rolloutsv1alpha1.CanaryStrategy
 	// TrafficRouting hosts all the supported service meshes supported to enable more fine-grained traffic routing
 	TrafficRouting *RolloutTrafficRouting `json:"trafficRouting,omitempty" protobuf:"bytes,4,opt,name=trafficRouting"`
+	// StepAnalyses 步骤上的内置分析,根据监控模板或者日志生成 AnalysisTemplate
+	StepAnalyses []CanaryStepAnalysis `json:"stepAnalyses,omitempty"`
+	// HeaderRoutes 按照 header,cookie 或者来源标签直接路由到新版本,仅 istio 支持
+	HeaderRoutes []CanaryHeaderRoute `json:"headerRoutes,omitempty"`
 }
 
 func (s *ExtendCanaryStrategy) ToCanaryStrategy() *rolloutsv1alpha1.CanaryStrategy {
@@ -68,3 +72,18 @@ type IstioVirtualService struct {
 	Headers       map[string]*istionetworkingv1alpha3.StringMatch `json:"headers,omitempty"`
 	IgnoreUriCase bool                                            `json:"ignoreUriCase,omitempty"`
 }
+
+// CanaryHeaderRoute 匹配的请求全部路由到新版本,例如内部测试人员优先访问灰度版本.
+// argo rollouts 1.2 不支持 setHeaderRoute, 由平台在 VirtualService 中灰度路由之前维护
+type CanaryHeaderRoute struct {
+	Name    string                                          `json:"name"`
+	Headers map[string]*istionetworkingv1alpha3.StringMatch `json:"headers,omitempty"`
+	Cookie  *CanaryCookieMatch                              `json:"cookie,omitempty"`
+	// 来源工作负载的标签,仅对网格内的调用生效
+	SourceLabels map[string]string `json:"sourceLabels,omitempty"`
+}
+
+type CanaryCookieMatch struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
@@ -0,0 +1,269 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/handlers/base"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/workflow"
+)
+
+type DependencyGraphForm struct {
+	// 应用及其依赖的应用,例如 {"api":["mysql"],"web":["api"]}
+	Dependencies map[string][]string `json:"dependencies"`
+	// 每个批次等待应用健康的超时时间,单位秒,为 0 时使用默认的 10 分钟
+	HealthTimeout int `json:"healthTimeout"`
+}
+
+type CloneForm struct {
+	// 源环境名称
+	SourceEnvironment string `json:"sourceEnvironment" binding:"required"`
+	// 需要克隆的应用,为空时克隆源环境中的全部应用
+	Applications []string `json:"applications"`
+}
+
+type SyncWaveApplicationStatus struct {
+	Name   string `json:"name"`
+	Health string `json:"health"`
+	Sync   string `json:"sync"`
+}
+
+type SyncWaveStatus struct {
+	Wave         int                         `json:"wave"`
+	Healthy      bool                        `json:"healthy"`
+	Applications []SyncWaveApplicationStatus `json:"applications"`
+}
+
+type SyncWavesStatus struct {
+	Waves []SyncWaveStatus `json:"waves"`
+	// 最近一次批量部署或者环境克隆的任务,每个步骤对应一个批次
+	Task *workflow.Task `json:"task,omitempty"`
+}
+
+// @Tags        Application
+// @Summary     获取项目下应用的部署依赖
+// @Description 获取项目下应用的部署依赖,未配置时依赖为空
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     int                                                              true "tenaut id"
+// @Param       project_id path     int                                                              true "project id"
+// @Success     200        {object} handlers.ResponseStruct{Data=models.ApplicationDependencyGraph} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/appdependencies [get]
+// @Security    JWT
+func (h *ApplicationHandler) GetDependencyGraph(c *gin.Context) {
+	graph, err := h.getDependencyGraph(c.Request.Context(), c.Param("project_id"))
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, graph)
+}
+
+// @Tags        Application
+// @Summary     设置项目下应用的部署依赖
+// @Description 设置项目下应用的部署依赖,批量部署和环境克隆时按照依赖分批次部署,仅项目管理员可以设置
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     int                                                              true "tenaut id"
+// @Param       project_id path     int                                                              true "project id"
+// @Param       body       body     DependencyGraphForm                                              true "依赖"
+// @Success     200        {object} handlers.ResponseStruct{Data=models.ApplicationDependencyGraph} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/appdependencies [put]
+// @Security    JWT
+func (h *ApplicationHandler) PutDependencyGraph(c *gin.Context) {
+	body := &DependencyGraphForm{}
+	if err := c.ShouldBindJSON(body); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	projectid, _ := strconv.Atoi(c.Param("project_id"))
+	u, _ := h.GetContextUser(c)
+	if auth := h.ModelCache().GetUserAuthority(u); !auth.IsSystemAdmin() && !auth.IsProjectAdmin(uint(projectid)) {
+		handlers.NotOK(c, fmt.Errorf("only project admin can modify application dependencies"))
+		return
+	}
+	if body.HealthTimeout < 0 {
+		handlers.NotOK(c, fmt.Errorf("invalid health timeout %d", body.HealthTimeout))
+		return
+	}
+	if err := ValidateDependencies(body.Dependencies); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if body.Dependencies == nil {
+		body.Dependencies = map[string][]string{}
+	}
+	dependencies, _ := json.Marshal(body.Dependencies)
+	graph := &models.ApplicationDependencyGraph{
+		ProjectID:     uint(projectid),
+		Dependencies:  dependencies,
+		HealthTimeout: body.HealthTimeout,
+	}
+	if err := h.GetDB().WithContext(c.Request.Context()).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "project_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"dependencies", "health_timeout", "updated_at"}),
+	}).Create(graph).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.SetAuditData(c, "更新", "应用部署依赖", string(dependencies))
+	h.SetExtraAuditData(c, models.ResProject, uint(projectid))
+	handlers.OK(c, graph)
+}
+
+// @Tags        Application
+// @Summary     环境中应用的部署批次
+// @Description 按照部署依赖将环境中的应用分为多个批次,返回每个批次中应用的状态以及最近一次批量部署或者环境克隆的任务
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                           true "tenaut id"
+// @Param       project_id     path     int                                           true "project id"
+// @Param       environment_id path     int                                           true "environment_id"
+// @Success     200            {object} handlers.ResponseStruct{Data=SyncWavesStatus} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/syncwaves [get]
+// @Security    JWT
+func (h *ApplicationHandler) SyncWavesStatus(c *gin.Context) {
+	h.NoNameRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		graph, err := h.getDependencyGraph(ctx, c.Param("project_id"))
+		if err != nil {
+			return nil, err
+		}
+		deploied, err := h.ApplicationProcessor.List(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		statuses := map[string]SyncWaveApplicationStatus{}
+		names := make([]string, 0, len(deploied))
+		for _, item := range deploied {
+			status := SyncWaveApplicationStatus{Name: item.Name, Health: item.Runtime.Status}
+			if app, ok := item.Runtime.Raw.(*v1alpha1.Application); ok && app != nil {
+				status.Sync = string(app.Status.Sync.Status)
+			}
+			statuses[item.Name] = status
+			names = append(names, item.Name)
+		}
+		waves, err := SyncWaves(names, ApplicationDependencies(graph))
+		if err != nil {
+			return nil, err
+		}
+		ret := &SyncWavesStatus{Waves: make([]SyncWaveStatus, 0, len(waves))}
+		for i, wave := range waves {
+			wavestatus := SyncWaveStatus{Wave: i, Healthy: true}
+			for _, name := range wave {
+				status := statuses[name]
+				if status.Health != argoHealthStatusHealthy {
+					wavestatus.Healthy = false
+				}
+				wavestatus.Applications = append(wavestatus.Applications, status)
+			}
+			ret.Waves = append(ret.Waves, wavestatus)
+		}
+		if task, err := h.Task.Processor.GetTaskLatest(ctx, ref, TaskTypeSyncWaves); err == nil {
+			ret.Task = task
+		}
+		return ret, nil
+	})
+}
+
+// @Tags        Application
+// @Summary     从其他环境克隆应用
+// @Description 将源环境中应用最新的编排复制到当前环境,并按照部署依赖分批次部署,前一批次的应用健康后再部署下一批次.
+// @Description 环境配置了同步审批时, 复制和部署都在审批通过后执行
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                                 true "tenaut id"
+// @Param       project_id     path     int                                                 true "project id"
+// @Param       environment_id path     int                                                 true "environment_id"
+// @Param       body           body     CloneForm                                           true "克隆参数"
+// @Success     200            {object} handlers.ResponseStruct{Data=base.ApprovalResult} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications-clone [post]
+// @Security    JWT
+func (h *ApplicationHandler) CloneFrom(c *gin.Context) {
+	body := &CloneForm{}
+	h.NoNameRefFunc(c, body, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		if body.SourceEnvironment == ref.Env {
+			return nil, fmt.Errorf("can't clone from the same environment %s", ref.Env)
+		}
+		projectid, _ := strconv.Atoi(c.Param("project_id"))
+		if _, err := h.getProjectEnvironment(ctx, uint(projectid), body.SourceEnvironment); err != nil {
+			return nil, err
+		}
+		names := body.Applications
+		if len(names) == 0 {
+			srcref := PathRef{Tenant: ref.Tenant, Project: ref.Project, Env: body.SourceEnvironment}
+			manifests, err := h.ApplicationProcessor.Manifest.List(ctx, srcref)
+			if err != nil {
+				return nil, err
+			}
+			for _, manifest := range manifests {
+				names = append(names, manifest.Name)
+			}
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("no applications found in environment %s", body.SourceEnvironment)
+		}
+		h.SetAuditData(c, "克隆", "应用", fmt.Sprintf("%s(%s->%s)", strings.Join(names, ","), body.SourceEnvironment, ref.Env))
+
+		clone := workflow.Step{
+			Name:     "clone",
+			Function: TaskFunction_Application_CloneFrom,
+			Args:     workflow.ArgsOf(ref, body.SourceEnvironment, names),
+		}
+		return h.submitSyncWaves(c, ctx, ref, names, clone)
+	})
+}
+
+// submitSyncWaves 按照项目下的部署依赖提交分批次部署的任务, prepare 在部署前执行.
+// 环境配置了同步审批时整个任务在审批通过后提交
+func (h *ApplicationHandler) submitSyncWaves(c *gin.Context, ctx context.Context, ref PathRef, names []string, prepare ...workflow.Step) (*base.ApprovalResult, error) {
+	graph, err := h.getDependencyGraph(ctx, c.Param("project_id"))
+	if err != nil {
+		return nil, err
+	}
+	waves, err := SyncWaves(names, ApplicationDependencies(graph))
+	if err != nil {
+		return nil, err
+	}
+	steps := append(prepare, SyncWavesSteps(ref, waves, SyncWaveHealthTimeout(graph))...)
+	return h.submitTaskOrRequireApproval(c, ctx, ref, models.ApprovalActionSync, strings.Join(names, ","), TaskTypeSyncWaves, steps)
+}
+
+// 未配置依赖时返回空的依赖
+func (h *ApplicationHandler) getDependencyGraph(ctx context.Context, projectid string) (*models.ApplicationDependencyGraph, error) {
+	id, err := strconv.Atoi(projectid)
+	if err != nil {
+		return nil, err
+	}
+	graph := &models.ApplicationDependencyGraph{ProjectID: uint(id)}
+	if err := h.GetDB().WithContext(ctx).Where("project_id = ?", id).Take(graph).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		graph.Dependencies = []byte("{}")
+	}
+	return graph, nil
+}
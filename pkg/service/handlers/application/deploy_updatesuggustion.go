@@ -18,18 +18,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
 
 	"github.com/gin-gonic/gin"
-	"github.com/go-git/go-billy/v5"
-	"github.com/go-git/go-billy/v5/util"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/handlers/base"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/workflow"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/yaml"
 )
 
 type ResourceSuggestion struct {
@@ -63,83 +61,109 @@ type Container struct {
 
 // @Tags        Application
 // @Summary     更新资源建议至 gitrepo
-// @Description 更新资源建议至 gitrepo
+// @Description 更新资源建议至 gitrepo, 同步需要审批时返回待审批的请求
 // @Accept      json
 // @Produce     json
-// @Param       cluster   path     string                               true "-"
-// @Param       group     path     string                               true "-"
-// @Param       version   path     string                               true "-"
-// @param       namespace path     string                               true "-"
-// @Param       resource  path     string                               true "-"
-// @Param       name      path     string                               true "-"
-// @Success     200       {object} handlers.ResponseStruct{Data=object} "-"
+// @Param       cluster   path     string                                            true "-"
+// @Param       group     path     string                                            true "-"
+// @Param       version   path     string                                            true "-"
+// @param       namespace path     string                                            true "-"
+// @Param       resource  path     string                                            true "-"
+// @Param       name      path     string                                            true "-"
+// @Success     200       {object} handlers.ResponseStruct{Data=base.ApprovalResult} "-"
 // @Router      /v1/cluster/{cluster}/{group}/{version}/namespaces/{namespace}/{resource}/{name} [patch]
 // @Security    JWT
 func (h *ApplicationHandler) UpdateWorkloadResources(c *gin.Context) {
 	// audit
 	h.SetAuditData(c, "更新", "编排建议资源", c.Param("resource"))
 	h.SetExtraAuditDataByClusterNamespace(c, c.Param("cluster"), c.Param("namespace"))
-	process := func() error {
+	process := func() (*base.ApprovalResult, error) {
 		suggestion := ResourceSuggestion{}
 		if err := c.ShouldBind(&suggestion); err != nil {
-			return err
+			return nil, err
 		}
 		if suggestion.TypeMeta.GroupVersionKind().Empty() || suggestion.Name == "" {
-			return errors.New("empty resource kind or name")
+			return nil, errors.New("empty resource kind or name")
 		}
-
-		if suggestion.Annotations == nil {
-			suggestion.Annotations = map[string]string{}
+		entity := h.ModelCache().FindEnvironment(c.Param("cluster"), c.Param("namespace"))
+		if entity == nil {
+			return nil, errors.New("namespace not belongs to any environment")
 		}
-		ref := &PathRef{}
-		ref.FromJsonBase64(suggestion.Annotations[AnnotationRef])
-		if ref.IsEmpty() {
-			return errors.New("not a argo managed resource")
+		env := &models.Environment{}
+		if err := h.GetDB().WithContext(c.Request.Context()).Preload("Project.Tenant").First(env, entity.GetID()).Error; err != nil {
+			return nil, err
 		}
-
-		updatefunc := func(_ context.Context, fs billy.Filesystem) error {
-			return ForFileContentFunc(fs, "", func(filename string, content []byte) error {
-				if filepath.Ext(filename) != ".yaml" {
-					return nil
-				}
-				obj, err := DecodeResource(content)
-				if err != nil {
-					return nil
-				}
-				// check Kind Name
-				if (obj.GetObjectKind().GroupVersionKind() != suggestion.TypeMeta.GroupVersionKind()) || obj.GetName() != suggestion.Name {
-					return nil
-				}
-				// update resource
-				updated := UpdatedReourcesLimits(obj, suggestion)
-				if updated {
-					content, err := yaml.Marshal(obj)
-					if err != nil {
-						return err
-					}
-					return util.WriteFile(fs, filename, content, os.ModePerm)
-				}
-				return nil
-			})
+		// 封网窗口内禁止更新
+		if err := h.CheckEnvironmentFreeze(c, env.ID); err != nil {
+			return nil, err
 		}
+		envref := PathRef{Tenant: env.Project.Tenant.TenantName, Project: env.Project.ProjectName, Env: env.EnvironmentName}
+		return h.applyResourceSuggestion(c, c.Request.Context(), envref, env.ID, suggestion)
+	}
+
+	if ret, err := process(); err != nil {
+		handlers.NotOK(c, err)
+	} else {
+		handlers.OK(c, ret)
+	}
+}
 
-		// update git
-		msg := fmt.Sprintf("update resource suggestion for %s name=%s", suggestion.GroupVersionKind().String(), suggestion.ObjectMeta.Name)
-		if err := h.Manifest.UpdateContentFunc(c.Request.Context(), *ref, updatefunc, msg); err != nil {
+// suggestionRef 资源注解中的应用, 注解可以被修改, 需要属于 envref 所在的环境
+func suggestionRef(suggestion ResourceSuggestion, envref PathRef) (PathRef, error) {
+	ref := PathRef{}
+	ref.FromJsonBase64(suggestion.Annotations[AnnotationRef])
+	if ref.IsEmpty() || ref.Name == "" {
+		return ref, errors.New("not a argo managed resource")
+	}
+	if ref.Tenant != envref.Tenant || ref.Project != envref.Project || ref.Env != envref.Env {
+		return ref, fmt.Errorf("resource %s is managed by application of another environment", suggestion.Name)
+	}
+	return ref, nil
+}
+
+// applyResourceSuggestion 将资源建议更新至 gitrepo 中对应的资源, 同步需要审批时在审批通过后执行
+func (h *ApplicationHandler) applyResourceSuggestion(c *gin.Context, ctx context.Context, envref PathRef, envid uint,
+	suggestion ResourceSuggestion,
+) (*base.ApprovalResult, error) {
+	ref, err := suggestionRef(suggestion, envref)
+	if err != nil {
+		return nil, err
+	}
+
+	updatefunc := func(ctx context.Context, store GitStore) error {
+		objects, err := store.ListAll(ctx)
+		if err != nil {
 			return err
 		}
-		// sync
-		if err := h.ApplicationProcessor.Sync(c.Request.Context(), *ref); err != nil {
-			return err
+		for _, obj := range objects {
+			// check Kind Name
+			if (obj.GetObjectKind().GroupVersionKind() != suggestion.TypeMeta.GroupVersionKind()) || obj.GetName() != suggestion.Name {
+				continue
+			}
+			// update resource
+			if UpdatedReourcesLimits(obj, suggestion) {
+				if err := store.Update(ctx, obj); err != nil {
+					return err
+				}
+			}
 		}
 		return nil
 	}
 
-	if err := process(); err != nil {
-		handlers.NotOK(c, err)
-	} else {
-		handlers.OK(c, "ok")
+	// update git
+	msg := fmt.Sprintf("update resource suggestion for %s name=%s", suggestion.GroupVersionKind().String(), suggestion.ObjectMeta.Name)
+	if err := h.Manifest.StoreUpdateFunc(ctx, ref, updatefunc, msg); err != nil {
+		return nil, err
+	}
+	// sync
+	steps := []workflow.Step{
+		{
+			Name:     "sync",
+			Function: TaskFunction_Application_Sync,
+			Args:     workflow.ArgsOf(ref),
+		},
 	}
+	return h.submitEnvironmentTaskOrRequireApproval(c, ctx, ref, envid, models.ApprovalActionSync, ref.Name, "sync", steps)
 }
 
 func UpdatedReourcesLimits(obj client.Object, suggestion ResourceSuggestion) bool {
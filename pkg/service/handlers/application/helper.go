@@ -56,7 +56,7 @@ func InitOrUpdateKustomization(fs billy.Filesystem) error {
 		return nil
 	}
 
-	resourcefiles := []string{}
+	resourcefiles, patchfiles := []string{}, []string{}
 	kustomization := &types.Kustomization{}
 
 	_ = ForFileContentFunc(fs, "", func(filename string, content []byte) error {
@@ -70,6 +70,11 @@ func InitOrUpdateKustomization(fs billy.Filesystem) error {
 			}
 			return nil
 		}
+		// overlay 目录下的文件作为环境的 patch
+		if isOverlayFile(filename) {
+			patchfiles = append(patchfiles, filename)
+			return nil
+		}
 		// 其余的均视为资源文件
 		// fullpath 是文件系统上的路径，作为 resources 时需要去除base路径
 		resourcefiles = append(resourcefiles, filename)
@@ -78,7 +83,15 @@ func InitOrUpdateKustomization(fs billy.Filesystem) error {
 
 	// 写入/更新 kustomization.yaml
 	kustomization.FixKustomizationPostUnmarshalling()
-	kustomization.Resources = resourcefiles
+	// 保留引用的基础编排等远程资源
+	remotes := []string{}
+	for _, resource := range kustomization.Resources {
+		if isRemoteResource(resource) {
+			remotes = append(remotes, resource)
+		}
+	}
+	kustomization.Resources = append(remotes, resourcefiles...)
+	kustomization.Patches = overlayPatches(kustomization.Patches, patchfiles)
 	// 禁止使用 commonLabels 以防止覆盖编排中使用到的 label 造成不必要麻烦
 	kustomization.CommonAnnotations = nil
 	kustomization.CommonLabels = nil
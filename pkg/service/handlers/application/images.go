@@ -25,6 +25,7 @@ import (
 	"github.com/containerd/containerd/reference"
 	"github.com/gin-gonic/gin"
 	"github.com/goharbor/harbor/src/pkg/scan/vuln"
+	"gorm.io/gorm"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"kubegems.io/kubegems/pkg/log"
 	"kubegems.io/kubegems/pkg/service/handlers"
@@ -300,16 +301,7 @@ func (h *ImageHandler) OnHarborFunc(c *gin.Context, harborfun HarborFunc, ocifun
 			return nil, fmt.Errorf("empty image name")
 		}
 
-		u, _, _, _, _ := harbor.ParseImag(image)
-		if u == "docker.io" {
-			u = "index.docker.io"
-		}
-		u = "https://" + u
-
-		options := &RegistryOptions{
-			URL: u,
-		}
-		_ = h.completeRegistryOption(options, image, params.ProjectID) // ignore
+		options := RegistryOptionsOf(h.GetDataBase().DB(), image, params.ProjectID)
 
 		// try harbor
 		cli := harbor.NewClient(options.URL, options.Username, options.Password)
@@ -330,7 +322,18 @@ func (h *ImageHandler) OnHarborFunc(c *gin.Context, harborfun HarborFunc, ocifun
 	}
 }
 
-func (h *ImageHandler) completeRegistryOption(options *RegistryOptions, imgname string, projectid uint) error {
+// RegistryOptionsOf 返回镜像所在仓库的地址,如果是项目中的镜像仓库则同时设置认证信息
+func RegistryOptionsOf(db *gorm.DB, image string, projectid uint) *RegistryOptions {
+	u, _, _, _, _ := harbor.ParseImag(image)
+	if u == "docker.io" {
+		u = "index.docker.io"
+	}
+	options := &RegistryOptions{URL: "https://" + u}
+	_ = completeRegistryOption(db, options, image, projectid) // ignore
+	return options
+}
+
+func completeRegistryOption(db *gorm.DB, options *RegistryOptions, imgname string, projectid uint) error {
 	spec, err := reference.Parse(imgname)
 	if err != nil {
 		return err
@@ -338,7 +341,7 @@ func (h *ImageHandler) completeRegistryOption(options *RegistryOptions, imgname
 	hostname := spec.Hostname()
 	registries := []models.Registry{}
 
-	if err := h.GetDataBase().DB().Where(&models.Registry{ProjectID: projectid}).Find(&registries).Error; err != nil {
+	if err := db.Where(&models.Registry{ProjectID: projectid}).Find(&registries).Error; err != nil {
 		return err
 	}
 	for _, registry := range registries {
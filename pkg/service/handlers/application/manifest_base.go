@@ -0,0 +1,143 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"kubegems.io/kubegems/pkg/utils/git"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/yaml"
+)
+
+// 环境中的编排不再复制基础编排, 而是在 kustomization.yaml 中以 kustomize remote resource 的方式
+// 引用基础编排分支中指定提交的应用目录, 环境中只保存 overlay 目录下的 patch 以及环境特有的资源.
+// 引用的格式为 {cloneurl}//{path}?ref={commit}, argo 使用项目仓库的凭据拉取.
+
+// BaseResource 返回引用基础编排中 path 目录在 revision 时的 kustomize resource
+func BaseResource(cloneurl, path, revision string) string {
+	return fmt.Sprintf("%s//%s?ref=%s", cloneurl, path, revision)
+}
+
+// ParseBaseResource 解析引用了 cloneurl 仓库中基础编排的 resource, 其他 resource 返回 false
+func ParseBaseResource(cloneurl, resource string) (string, string, bool) {
+	rest := strings.TrimPrefix(resource, cloneurl+"//")
+	if rest == resource {
+		return "", "", false
+	}
+	path, revision, found := strings.Cut(rest, "?ref=")
+	if !found || path == "" || revision == "" {
+		return "", "", false
+	}
+	return path, revision, true
+}
+
+// isRemoteResource kustomization 中的远程 resource, 生成 kustomization 时需要保留
+func isRemoteResource(resource string) bool {
+	return strings.Contains(resource, "://")
+}
+
+// BaseFilesFunc 读取基础编排中 path 目录在 revision 时的文件
+type BaseFilesFunc func(ctx context.Context, path, revision string) ([]git.CommitFile, error)
+
+type baseLoader struct {
+	cloneurl string
+	files    BaseFilesFunc
+}
+
+type contextBaseLoaderKey struct{}
+
+// WithBaseLoader 设置读取 cloneurl 仓库中基础编排的方法, 环境中的编排需要通过它得到完整的资源
+func WithBaseLoader(ctx context.Context, cloneurl string, files BaseFilesFunc) context.Context {
+	return context.WithValue(ctx, contextBaseLoaderKey{}, baseLoader{cloneurl: cloneurl, files: files})
+}
+
+// BaseFiles 读取 fs 中的编排引用的基础编排, 没有引用基础编排时返回空
+func BaseFiles(ctx context.Context, fs billy.Filesystem) (*BaseReference, []git.CommitFile, error) {
+	loader, ok := ctx.Value(contextBaseLoaderKey{}).(baseLoader)
+	if !ok {
+		return nil, nil, nil
+	}
+	baseref := FindBaseReference(fs, loader.cloneurl)
+	if baseref == nil {
+		return nil, nil, nil
+	}
+	files, err := loader.files(ctx, baseref.Path, baseref.Revision)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read base manifest %s@%s: %w", baseref.Path, shortHash(baseref.Revision), err)
+	}
+	if len(files) == 0 {
+		return nil, nil, fmt.Errorf("base manifest %s@%s is empty", baseref.Path, shortHash(baseref.Revision))
+	}
+	return baseref, files, nil
+}
+
+// BaseReference 环境中的编排所引用的基础编排
+type BaseReference struct {
+	Resource string
+	Path     string
+	Revision string
+}
+
+// FindBaseReference 在 kustomization.yaml 中查找引用的基础编排
+func FindBaseReference(fs billy.Filesystem, cloneurl string) *BaseReference {
+	content, err := util.ReadFile(fs, KustimizationFilename)
+	if err != nil {
+		return nil
+	}
+	kustomization := &types.Kustomization{}
+	if err := yaml.Unmarshal(content, kustomization); err != nil {
+		return nil
+	}
+	for _, resource := range kustomization.Resources {
+		if path, revision, ok := ParseBaseResource(cloneurl, resource); ok {
+			return &BaseReference{Resource: resource, Path: path, Revision: revision}
+		}
+	}
+	return nil
+}
+
+// SetBaseReference 将 fs 中的编排改为引用基础编排, 移除之前复制或者引用的基础编排, 保留 overlay 目录以及 .meta
+func SetBaseReference(fs billy.Filesystem, resource string) error {
+	if err := removeBaseFiles(fs); err != nil {
+		return err
+	}
+	kustomization := &types.Kustomization{Resources: []string{resource}}
+	content, err := yaml.Marshal(kustomization)
+	if err != nil {
+		return err
+	}
+	if err := util.WriteFile(fs, KustimizationFilename, content, os.ModePerm); err != nil {
+		return err
+	}
+	return InitOrUpdateKustomization(fs)
+}
+
+// FilesFs 将提交中的文件写入内存中的文件系统
+func FilesFs(files []git.CommitFile) (billy.Filesystem, error) {
+	fs := memfs.New()
+	for _, file := range files {
+		if err := util.WriteFile(fs, file.Name, []byte(file.Content), os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+	return fs, nil
+}
@@ -32,6 +32,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
+	"kubegems.io/kubegems/pkg/utils/git"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 	"sigs.k8s.io/yaml"
@@ -41,6 +42,9 @@ type rawObject struct {
 	filename string
 	content  []byte
 	object   client.Object
+	// 非空时为引用的基础编排中的资源, content 为应用了 overlay patch 之后的内容, 修改时写入 overlay patch
+	base    client.Object
+	patches []string
 }
 
 type FsStore struct {
@@ -57,7 +61,8 @@ func NewGitFsStore(fs billy.Filesystem) *FsStore {
 		scheme:    scheme.Scheme,
 	}
 	ForFileContentFunc(fs, "", func(filename string, content []byte) error {
-		if filepath.Ext(filename) != ".yaml" {
+		// overlay 中的 patch 不是完整的资源
+		if filepath.Ext(filename) != ".yaml" || isOverlayFile(filename) {
 			return nil
 		}
 		obj, _ := DecodeResource(content)
@@ -68,6 +73,91 @@ func NewGitFsStore(fs billy.Filesystem) *FsStore {
 	return contents
 }
 
+// NewGitFsStoreFromContext 编排引用了基础编排时, 基础编排中的资源应用 overlay 中的 patch 后也作为编排中的资源
+func NewGitFsStoreFromContext(ctx context.Context, fs billy.Filesystem) (*FsStore, error) {
+	store := NewGitFsStore(fs)
+	_, basefiles, err := BaseFiles(ctx, fs)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.loadBase(basefiles); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// NewGitFsStoreFromFiles 使用某次提交中的文件构造 store
+func NewGitFsStoreFromFiles(ctx context.Context, files []git.CommitFile) (*FsStore, error) {
+	fs, err := FilesFs(files)
+	if err != nil {
+		return nil, err
+	}
+	return NewGitFsStoreFromContext(ctx, fs)
+}
+
+func (c *FsStore) loadBase(files []git.CommitFile) error {
+	if len(files) == 0 {
+		return nil
+	}
+	patches, err := readOverlayPatches(c.fs)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if filepath.Ext(file.Name) != ".yaml" || file.Name == KustimizationFilename {
+			continue
+		}
+		base, err := DecodeResource([]byte(file.Content))
+		if err != nil || base == nil {
+			continue
+		}
+		item := &rawObject{base: base}
+		content, deleted := []byte(file.Content), false
+		for _, patch := range patches {
+			if !patch.target(base) {
+				continue
+			}
+			item.patches = append(item.patches, patch.filename)
+			if patch.delete {
+				deleted = true
+				continue
+			}
+			if content, err = applyOverlayPatch(base, content, patch.content); err != nil {
+				return fmt.Errorf("apply patch %s: %w", patch.filename, err)
+			}
+		}
+		// 被删除的资源 object 为空, 重新创建时依然写入 patch
+		if !deleted {
+			if item.object, err = DecodeResource(content); err != nil {
+				return err
+			}
+			item.content = content
+		}
+		c.resources[overlayPatchFilename(base)] = item
+	}
+	return nil
+}
+
+// updateBase 基础编排中的资源与修改后的资源的差异写入 overlay 中的 patch, 并移除其他针对该资源的 patch
+func (c *FsStore) updateBase(filename string, item *rawObject, obj client.Object) error {
+	patch, err := createOverlayPatch(item.base, obj)
+	if err != nil {
+		return err
+	}
+	for _, f := range item.patches {
+		_ = c.fs.Remove(filepath.Join(OverlayDirname, f))
+	}
+	item.patches = nil
+	if patch == nil {
+		return nil
+	}
+	if err := util.WriteFile(c.fs, filename, patch, os.ModePerm); err != nil {
+		return err
+	}
+	item.patches = []string{strings.TrimPrefix(filename, OverlayDirname+"/")}
+	return nil
+}
+
 func (c *FsStore) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
 	if obj == nil || obj.GetName() == "" {
 		return errors.NewBadRequest("empty name")
@@ -76,6 +166,12 @@ func (c *FsStore) Create(ctx context.Context, obj client.Object, opts ...client.
 	if filename, _ := c.find(obj); filename != "" {
 		return errors.NewAlreadyExists(schema.GroupResource{}, obj.GetName())
 	}
+	for filename, item := range c.resources {
+		if item.object == nil && item.base != nil && sameObject(item.base, obj) {
+			item.object = obj
+			return c.updateBase(filename, item, obj)
+		}
+	}
 
 	filename := strings.ToLower(obj.GetObjectKind().GroupVersionKind().Kind) + "-" + obj.GetName() + ".yaml"
 	// create
@@ -92,7 +188,14 @@ func (c *FsStore) Create(ctx context.Context, obj client.Object, opts ...client.
 }
 
 func (c *FsStore) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
-	if filename, _ := c.find(obj); filename != "" {
+	if filename, found := c.find(obj); filename != "" {
+		if found.base != nil {
+			for _, f := range found.patches {
+				_ = c.fs.Remove(filepath.Join(OverlayDirname, f))
+			}
+			found.object, found.content, found.patches = nil, nil, []string{strings.TrimPrefix(filename, OverlayDirname+"/")}
+			return util.WriteFile(c.fs, filename, deleteOverlayPatch(found.base), os.ModePerm)
+		}
 		_ = c.fs.Remove(filename)
 		return nil
 	}
@@ -195,6 +298,9 @@ func (c *FsStore) Patch(ctx context.Context, obj client.Object, patch client.Pat
 	if err := json.Unmarshal(patchedjson, obj); err != nil {
 		return err
 	}
+	if found.base != nil {
+		return c.updateBase(filename, found, obj)
+	}
 	patchedyaml, err := yaml.JSONToYAML(patchedjson)
 	if err != nil {
 		return err
@@ -208,7 +314,10 @@ func (c *FsStore) Patch(ctx context.Context, obj client.Object, patch client.Pat
 }
 
 func (c *FsStore) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
-	if filename, _ := c.find(obj); filename != "" {
+	if filename, found := c.find(obj); filename != "" {
+		if found.base != nil {
+			return c.updateBase(filename, found, obj)
+		}
 		// updated
 		content, err := yaml.Marshal(obj)
 		if err != nil {
@@ -248,10 +357,7 @@ func (c *FsStore) find(find client.Object) (string, *rawObject) {
 		if item.object == nil {
 			continue // 这是非资源文件
 		}
-		if item.object.GetObjectKind().GroupVersionKind() != find.GetObjectKind().GroupVersionKind() {
-			continue
-		}
-		if item.object.GetName() != find.GetName() {
+		if !sameObject(item.object, find) {
 			continue
 		}
 		return filename, item
@@ -259,6 +365,10 @@ func (c *FsStore) find(find client.Object) (string, *rawObject) {
 	return "", nil
 }
 
+func sameObject(a, b client.Object) bool {
+	return a.GetObjectKind().GroupVersionKind() == b.GetObjectKind().GroupVersionKind() && a.GetName() == b.GetName()
+}
+
 func removeStatusField(origin []byte) []byte {
 	tmp := map[string]interface{}{}
 	if err := yaml.Unmarshal(origin, &tmp); err != nil {
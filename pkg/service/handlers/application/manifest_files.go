@@ -393,22 +393,10 @@ func (h *ManifestHandler) parseCommitImagesFunc(ctx context.Context, ref PathRef
 		if err != nil {
 			return err
 		}
-		images := []string{}
-		for _, f := range commit.Files {
-			if !strings.HasSuffix(f.Name, ".yaml") {
-				continue
-			}
-			obj, err := DecodeResource([]byte(f.Content))
-			if err != nil {
-				// ignore
-				continue
-			}
-			images = append(images, ParseImagesFrom(obj)...)
-		}
 		details = &CommitImageDetails{
 			CreatedAt: metav1.NewTime(commit.Author.When),
 			Creator:   commit.Author.Name,
-			Images:    images,
+			Images:    parseFilesImages(ctx, commit.Files),
 		}
 		return nil
 	})
@@ -0,0 +1,429 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/gin-gonic/gin"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"kubegems.io/kubegems/pkg/utils/git"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/yaml"
+)
+
+// OverlayDirname 编排中环境特有的 patch 目录.
+// 环境中的编排由基础编排和该目录下的 patch 组成, 目录下的每个 yaml 文件都作为 kustomize patch,
+// 从基础编排部署或者在环境间提升时保留目标环境中的内容, 在环境中修改基础编排中的资源(镜像,副本数等)也保存为该目录下的 patch.
+const OverlayDirname = "overlay"
+
+func isOverlayFile(filename string) bool {
+	parts := strings.SplitN(strings.TrimPrefix(filepath.ToSlash(filename), "/"), "/", 2)
+	return len(parts) == 2 && parts[0] == OverlayDirname
+}
+
+// validateOverlayFilename patch 文件名只能是 overlay 目录下的一个 yaml 文件, 避免操作目录之外的文件
+func validateOverlayFilename(filename string) error {
+	if filename == "" || filename == "." || filename == ".." || strings.ContainsAny(filename, `/\`) ||
+		filepath.Base(filename) != filename || filepath.Ext(filename) != ".yaml" {
+		return fmt.Errorf("patch file %s must be a .yaml file name without path", filename)
+	}
+	return nil
+}
+
+// overlayPatches 移除之前由 overlay 目录生成的 patch,并按照当前的 overlay 文件重新生成
+func overlayPatches(patches []types.Patch, files []string) []types.Patch {
+	ret := []types.Patch{}
+	for _, patch := range patches {
+		if patch.Path != "" && isOverlayFile(patch.Path) {
+			continue
+		}
+		ret = append(ret, patch)
+	}
+	for _, file := range files {
+		ret = append(ret, types.Patch{Path: filepath.ToSlash(file)})
+	}
+	if len(ret) == 0 {
+		return nil
+	}
+	return ret
+}
+
+// removeBaseFiles 清空编排中基础编排的文件,保留 overlay 目录以及 .meta
+func removeBaseFiles(fs billy.Filesystem) error {
+	fis, err := fs.ReadDir(".")
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if fi.Name() == OverlayDirname || fi.Name() == MetaFilename {
+			continue
+		}
+		if err := util.RemoveAll(fs, fi.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// KustomizeBuild 在内存中对编排执行 kustomize build, 引用的基础编排使用 basefiles 代替远程仓库中的内容
+func KustomizeBuild(fs billy.Filesystem, baseref *BaseReference, basefiles []git.CommitFile) ([]byte, error) {
+	const root, baseroot = "/manifest", "/base"
+	memfs := filesys.MakeFsInMemory()
+	if err := ForFileContentFunc(fs, "", func(filename string, content []byte) error {
+		if baseref != nil && filename == KustimizationFilename {
+			content = []byte(strings.Replace(string(content), baseref.Resource, "../base", 1))
+		}
+		return memfs.WriteFile(path.Join(root, filepath.ToSlash(filename)), content)
+	}); err != nil {
+		return nil, err
+	}
+	if baseref != nil {
+		for _, file := range basefiles {
+			if err := memfs.WriteFile(path.Join(baseroot, filepath.ToSlash(file.Name)), []byte(file.Content)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	resmap, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(memfs, root)
+	if err != nil {
+		return nil, err
+	}
+	return resmap.AsYaml()
+}
+
+// overlayPatchFilename 修改基础编排中的资源时生成的 patch 文件
+func overlayPatchFilename(obj client.Object) string {
+	return path.Join(OverlayDirname, strings.ToLower(obj.GetObjectKind().GroupVersionKind().Kind)+"-"+obj.GetName()+".yaml")
+}
+
+type overlayPatch struct {
+	filename string
+	gvk      schema.GroupVersionKind
+	name     string
+	content  []byte
+	delete   bool
+}
+
+func (p overlayPatch) target(obj client.Object) bool {
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	return p.gvk.GroupKind() == gvk.GroupKind() && p.name == obj.GetName()
+}
+
+// readOverlayPatches 按文件名顺序读取 overlay 目录下的 patch
+func readOverlayPatches(fs billy.Filesystem) ([]overlayPatch, error) {
+	patches := []overlayPatch{}
+	if _, err := fs.Stat(OverlayDirname); err != nil {
+		return patches, nil
+	}
+	err := ForFileContentFunc(fs, OverlayDirname, func(filename string, content []byte) error {
+		if filepath.Ext(filename) != ".yaml" {
+			return nil
+		}
+		patchjson, err := yaml.YAMLToJSON(content)
+		if err != nil {
+			return fmt.Errorf("invalid patch %s: %w", filename, err)
+		}
+		patch := &unstructured.Unstructured{}
+		if err := patch.UnmarshalJSON(patchjson); err != nil {
+			return fmt.Errorf("invalid patch %s: %w", filename, err)
+		}
+		directive, _, _ := unstructured.NestedString(patch.Object, "$patch")
+		patches = append(patches, overlayPatch{
+			filename: filename,
+			gvk:      patch.GroupVersionKind(),
+			name:     patch.GetName(),
+			content:  patchjson,
+			delete:   directive == "delete",
+		})
+		return nil
+	})
+	sort.Slice(patches, func(i, j int) bool { return patches[i].filename < patches[j].filename })
+	return patches, err
+}
+
+// applyOverlayPatch 与 kustomize 相同, 已知类型的资源使用 strategic merge patch, 其余使用 json merge patch
+func applyOverlayPatch(obj client.Object, content, patch []byte) ([]byte, error) {
+	original, err := yaml.YAMLToJSON(content)
+	if err != nil {
+		return nil, err
+	}
+	var patched []byte
+	if typed, err := scheme.Scheme.New(obj.GetObjectKind().GroupVersionKind()); err == nil {
+		patched, err = strategicpatch.StrategicMergePatch(original, patch, typed)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if patched, err = jsonpatch.MergePatch(original, patch); err != nil {
+			return nil, err
+		}
+	}
+	return yaml.JSONToYAML(patched)
+}
+
+// createOverlayPatch 生成 base 修改为 modified 的 patch, 没有差异时返回空
+func createOverlayPatch(base, modified client.Object) ([]byte, error) {
+	original, err := objectJSON(base)
+	if err != nil {
+		return nil, err
+	}
+	target, err := objectJSON(modified)
+	if err != nil {
+		return nil, err
+	}
+	gvk := base.GetObjectKind().GroupVersionKind()
+	var patch []byte
+	if typed, err := scheme.Scheme.New(gvk); err == nil {
+		patch, err = strategicpatch.CreateTwoWayMergePatch(original, target, typed)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if patch, err = jsonpatch.CreateMergePatch(original, target); err != nil {
+			return nil, err
+		}
+	}
+	patchmap := map[string]interface{}{}
+	if err := json.Unmarshal(patch, &patchmap); err != nil {
+		return nil, err
+	}
+	// kustomize 不支持调整列表顺序的指令, 顺序不影响结果
+	removePatchDirectives(patchmap)
+	// 对 apiVersion kind 的修改不能作为 patch
+	delete(patchmap, "apiVersion")
+	delete(patchmap, "kind")
+	if len(patchmap) == 0 {
+		return nil, nil
+	}
+	// patch 需要指定目标资源
+	patchmap["apiVersion"], patchmap["kind"] = gvk.GroupVersion().String(), gvk.Kind
+	if err := unstructured.SetNestedField(patchmap, base.GetName(), "metadata", "name"); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(patchmap)
+}
+
+// deleteOverlayPatch 从环境中删除基础编排中的资源
+func deleteOverlayPatch(base client.Object) []byte {
+	gvk := base.GetObjectKind().GroupVersionKind()
+	content, _ := yaml.Marshal(map[string]interface{}{
+		"apiVersion": gvk.GroupVersion().String(),
+		"kind":       gvk.Kind,
+		"metadata":   map[string]interface{}{"name": base.GetName()},
+		"$patch":     "delete",
+	})
+	return content
+}
+
+func objectJSON(obj client.Object) ([]byte, error) {
+	content, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	objmap := map[string]interface{}{}
+	if err := json.Unmarshal(content, &objmap); err != nil {
+		return nil, err
+	}
+	unstructured.RemoveNestedField(objmap, "status")
+	return json.Marshal(objmap)
+}
+
+func removePatchDirectives(patch map[string]interface{}) {
+	for k, v := range patch {
+		if strings.HasPrefix(k, "$setElementOrder/") || k == "$retainKeys" {
+			delete(patch, k)
+			continue
+		}
+		switch val := v.(type) {
+		case map[string]interface{}:
+			removePatchDirectives(val)
+		case []interface{}:
+			for _, item := range val {
+				if m, ok := item.(map[string]interface{}); ok {
+					removePatchDirectives(m)
+				}
+			}
+		}
+	}
+}
+
+// @Tags        Application
+// @Summary     列举环境的 overlay patch
+// @Description 列举应用在环境中的 patch 文件
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                         true "tenaut id"
+// @Param       project_id     path     int                                         true "project id"
+// @Param       environment_id path     int                                         true "environment_id"
+// @Param       name           path     string                                      true "application name"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]FileContent} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/overlays [get]
+// @Security    JWT
+func (h *ManifestHandler) ListOverlays(c *gin.Context) {
+	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		files := []FileContent{}
+		fun := func(ctx context.Context, fs billy.Filesystem) error {
+			if _, err := fs.Stat(OverlayDirname); err != nil {
+				return nil
+			}
+			return ForFileContentFunc(fs, OverlayDirname, func(filename string, content []byte) error {
+				files = append(files, FileContent{Name: filename, Content: string(content)})
+				return nil
+			})
+		}
+		if err := h.ContentFunc(ctx, ref, fun); err != nil {
+			return nil, err
+		}
+		return files, nil
+	})
+}
+
+// @Tags        Application
+// @Summary     写入环境的 overlay patch
+// @Description patch 使用 strategic merge patch 格式, 需要指定基础编排中已存在资源的 apiVersion,kind 以及 metadata.name, 例如修改副本数,资源限制,环境变量,ingress host 等
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                  true "tenaut id"
+// @Param       project_id     path     int                                  true "project id"
+// @Param       environment_id path     int                                  true "environment_id"
+// @Param       name           path     string                               true "application name"
+// @Param       filename       path     string                               true "patch file name"
+// @Param       body           body     FileContent                          true "filecontent"
+// @Success     200            {object} handlers.ResponseStruct{Data=string} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/overlays/{filename} [put]
+// @Security    JWT
+func (h *ManifestHandler) PutOverlay(c *gin.Context) {
+	body := &FileContent{}
+	h.NamedRefFunc(c, body, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		filename := c.Param("filename")
+		if err := validateOverlayFilename(filename); err != nil {
+			return nil, err
+		}
+		h.SetAuditData(c, "修改", "环境patch", ref.Name+"/"+filename)
+
+		content := []byte(body.Content)
+		patch, err := DecodeResource(content)
+		if err != nil {
+			return nil, err
+		}
+		if patch == nil || patch.GetName() == "" {
+			return nil, fmt.Errorf("patch must contain apiVersion,kind and metadata.name")
+		}
+		updatefunc := func(ctx context.Context, fs billy.Filesystem) error {
+			// patch 的目标需要在基础编排中存在
+			store, err := NewGitFsStoreFromContext(ctx, fs)
+			if err != nil {
+				return err
+			}
+			objects, err := store.ListAll(ctx)
+			if err != nil {
+				return err
+			}
+			gvk := patch.GetObjectKind().GroupVersionKind()
+			for _, obj := range objects {
+				if obj.GetObjectKind().GroupVersionKind().GroupKind() == gvk.GroupKind() && obj.GetName() == patch.GetName() {
+					return util.WriteFile(fs, filepath.Join(OverlayDirname, filename), content, os.ModePerm)
+				}
+			}
+			return fmt.Errorf("patch target %s %s not found in manifest", gvk.Kind, patch.GetName())
+		}
+		if err := h.UpdateContentFunc(ctx, ref, updatefunc, fmt.Sprintf("put patch %s", filename)); err != nil {
+			return nil, err
+		}
+		return "ok", nil
+	})
+}
+
+// @Tags        Application
+// @Summary     删除环境的 overlay patch
+// @Description 删除环境的 overlay patch
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                  true "tenaut id"
+// @Param       project_id     path     int                                  true "project id"
+// @Param       environment_id path     int                                  true "environment_id"
+// @Param       name           path     string                               true "application name"
+// @Param       filename       path     string                               true "patch file name"
+// @Success     200            {object} handlers.ResponseStruct{Data=string} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/overlays/{filename} [delete]
+// @Security    JWT
+func (h *ManifestHandler) RemoveOverlay(c *gin.Context) {
+	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		filename := c.Param("filename")
+		if err := validateOverlayFilename(filename); err != nil {
+			return nil, err
+		}
+		h.SetAuditData(c, "删除", "环境patch", ref.Name+"/"+filename)
+
+		updatefunc := func(ctx context.Context, fs billy.Filesystem) error {
+			return util.RemoveAll(fs, filepath.Join(OverlayDirname, filename))
+		}
+		if err := h.UpdateContentFunc(ctx, ref, updatefunc, fmt.Sprintf("remove patch %s", filename)); err != nil {
+			return nil, err
+		}
+		return "ok", nil
+	})
+}
+
+// @Tags        Application
+// @Summary     预览环境中最终部署的资源
+// @Description 对基础编排和环境 patch 执行 kustomize build
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                  true "tenaut id"
+// @Param       project_id     path     int                                  true "project id"
+// @Param       environment_id path     int                                  true "environment_id"
+// @Param       name           path     string                               true "application name"
+// @Success     200            {object} handlers.ResponseStruct{Data=string} "rendered yaml"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/rendered [get]
+// @Security    JWT
+func (h *ManifestHandler) Rendered(c *gin.Context) {
+	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		var rendered []byte
+		fun := func(ctx context.Context, fs billy.Filesystem) error {
+			baseref, basefiles, err := BaseFiles(ctx, fs)
+			if err != nil {
+				return err
+			}
+			content, err := KustomizeBuild(fs, baseref, basefiles)
+			if err != nil {
+				return fmt.Errorf("kustomize build: %w", err)
+			}
+			rendered = content
+			return nil
+		}
+		if err := h.Func(ctx, ref, Pull(), FsFunc(fun)); err != nil {
+			return nil, err
+		}
+		return string(rendered), nil
+	})
+}
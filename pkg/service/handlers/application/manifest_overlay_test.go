@@ -0,0 +1,225 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"kubegems.io/kubegems/pkg/utils/git"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/yaml"
+)
+
+const testDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  replicas: 1
+  selector:
+    matchLabels:
+      app: app
+  template:
+    metadata:
+      labels:
+        app: app
+    spec:
+      containers:
+      - name: app
+        image: nginx:1.0
+`
+
+const testReplicasPatch = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  replicas: 3
+`
+
+func Test_isOverlayFile(t *testing.T) {
+	tests := map[string]bool{
+		"overlay/replicas.yaml":  true,
+		"/overlay/replicas.yaml": true,
+		"overlay":                false,
+		"overlay.yaml":           false,
+		"configs/overlay/a.yaml": false,
+	}
+	for filename, want := range tests {
+		if got := isOverlayFile(filename); got != want {
+			t.Errorf("isOverlayFile(%s) = %v, want %v", filename, got, want)
+		}
+	}
+}
+
+func Test_validateOverlayFilename(t *testing.T) {
+	tests := map[string]bool{
+		"replicas.yaml":          true,
+		"..":                     false,
+		"../x.yaml":              false,
+		"a/../../x.yaml":         false,
+		"sub/x.yaml":             false,
+		`..\x.yaml`:              false,
+		".":                      false,
+		"":                       false,
+		"replicas.yml":           false,
+		"/overlay/replicas.yaml": false,
+	}
+	for filename, valid := range tests {
+		if err := validateOverlayFilename(filename); (err == nil) != valid {
+			t.Errorf("validateOverlayFilename(%q) error = %v, want valid %v", filename, err, valid)
+		}
+	}
+}
+
+func Test_overlayPatches(t *testing.T) {
+	userpatch := types.Patch{Path: "patches/custom.yaml"}
+	patches := []types.Patch{userpatch, {Path: "overlay/removed.yaml"}}
+	got := overlayPatches(patches, []string{"overlay/replicas.yaml"})
+	want := []types.Patch{userpatch, {Path: "overlay/replicas.yaml"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("overlayPatches() = %v, want %v", got, want)
+	}
+	if got := overlayPatches(nil, nil); got != nil {
+		t.Errorf("overlayPatches() = %v, want nil", got)
+	}
+}
+
+func TestKustomizeBuildWithOverlay(t *testing.T) {
+	fs := memfs.New()
+	_ = util.WriteFile(fs, "deployment.yaml", []byte(testDeployment), os.ModePerm)
+	_ = util.WriteFile(fs, "overlay/replicas.yaml", []byte(testReplicasPatch), os.ModePerm)
+	if err := InitOrUpdateKustomization(fs); err != nil {
+		t.Fatal(err)
+	}
+
+	kustomization := &types.Kustomization{}
+	content, _ := util.ReadFile(fs, KustimizationFilename)
+	if err := yaml.Unmarshal(content, kustomization); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(kustomization.Resources, []string{"deployment.yaml"}) {
+		t.Errorf("resources = %v, want only deployment.yaml", kustomization.Resources)
+	}
+
+	rendered, err := KustomizeBuild(fs, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rendered), "replicas: 3") {
+		t.Errorf("rendered manifest not patched:\n%s", rendered)
+	}
+
+	store := NewGitFsStore(fs)
+	if _, err := ParseMainDeployment(context.Background(), store); err != nil {
+		t.Errorf("overlay patch should not be treated as resource: %v", err)
+	}
+}
+
+func TestParseBaseResource(t *testing.T) {
+	const cloneurl = "http://git.example.com/tenant/project.git"
+	resource := BaseResource(cloneurl, "app", "abc123")
+	path, revision, ok := ParseBaseResource(cloneurl, resource)
+	if !ok || path != "app" || revision != "abc123" {
+		t.Errorf("ParseBaseResource(%s) = %s, %s, %v", resource, path, revision, ok)
+	}
+	for _, other := range []string{"deployment.yaml", "http://other.example.com/repo.git//app?ref=abc123", cloneurl + "//app"} {
+		if _, _, ok := ParseBaseResource(cloneurl, other); ok {
+			t.Errorf("ParseBaseResource(%s) should not be a base resource", other)
+		}
+	}
+}
+
+func TestFsStoreWithBase(t *testing.T) {
+	const cloneurl = "http://git.example.com/tenant/project.git"
+	basefiles := []git.CommitFile{
+		{Name: "deployment.yaml", Content: testDeployment},
+		{Name: KustimizationFilename, Content: "resources:\n- deployment.yaml\n"},
+	}
+	ctx := WithBaseLoader(context.Background(), cloneurl, func(_ context.Context, path, revision string) ([]git.CommitFile, error) {
+		if path != "app" || revision != "abc123" {
+			t.Errorf("unexpected base %s@%s", path, revision)
+		}
+		return basefiles, nil
+	})
+
+	fs := memfs.New()
+	if err := SetBaseReference(fs, BaseResource(cloneurl, "app", "abc123")); err != nil {
+		t.Fatal(err)
+	}
+	_ = util.WriteFile(fs, "overlay/replicas.yaml", []byte(testReplicasPatch), os.ModePerm)
+
+	store, err := NewGitFsStoreFromContext(ctx, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployment, err := ParseMainDeployment(ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *deployment.Spec.Replicas != 3 {
+		t.Errorf("replicas = %d, want patched replicas 3", *deployment.Spec.Replicas)
+	}
+
+	// 修改写入 overlay patch, 不复制基础编排
+	deployment.Spec.Template.Spec.Containers[0].Image = "nginx:2.0"
+	if err := store.Update(ctx, deployment); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("deployment.yaml"); err == nil {
+		t.Errorf("base resource should not be copied into environment")
+	}
+	if _, err := fs.Stat("overlay/replicas.yaml"); err == nil {
+		t.Errorf("patch merged into generated patch should be removed")
+	}
+	patch, _ := util.ReadFile(fs, "overlay/deployment-app.yaml")
+	if strings.Contains(string(patch), "$setElementOrder") || !strings.Contains(string(patch), "nginx:2.0") {
+		t.Errorf("unexpected generated patch:\n%s", patch)
+	}
+	if err := InitOrUpdateKustomization(fs); err != nil {
+		t.Fatal(err)
+	}
+	baseref, files, err := BaseFiles(ctx, fs)
+	if err != nil || baseref == nil {
+		t.Fatalf("base reference lost after updating kustomization: %v", err)
+	}
+	rendered, err := KustomizeBuild(fs, baseref, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"replicas: 3", "image: nginx:2.0"} {
+		if !strings.Contains(string(rendered), want) {
+			t.Errorf("rendered manifest missing %q:\n%s", want, rendered)
+		}
+	}
+
+	// 删除基础编排中的资源
+	if err := store.Delete(ctx, deployment); err != nil {
+		t.Fatal(err)
+	}
+	store, err = NewGitFsStoreFromContext(ctx, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objects, _ := store.ListAll(ctx); len(objects) != 0 {
+		t.Errorf("deleted base resource still listed: %v", objects)
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"fmt"
+
+	"kubegems.io/kubegems/pkg/apis/gems"
+)
+
+// AnalysisOptions 灰度分析时 argo rollouts 在集群内访问的 prometheus 和 loki 地址, 需要与 agent 的配置一致
+type AnalysisOptions struct {
+	PrometheusServer string `json:"prometheusServer,omitempty"`
+	LokiServer       string `json:"lokiServer,omitempty"`
+}
+
+func NewDefaultAnalysisOptions() *AnalysisOptions {
+	return &AnalysisOptions{
+		PrometheusServer: fmt.Sprintf("http://prometheus.%s:9090", gems.NamespaceMonitor),
+		LokiServer:       fmt.Sprintf("http://loki-gateway.%s:3100", gems.NamespaceLogging),
+	}
+}
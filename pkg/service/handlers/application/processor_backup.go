@@ -0,0 +1,281 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/models"
+)
+
+const (
+	TaskFunction_Application_Backup         = "application_backup"
+	TaskFunction_Application_RunBackupPlans = "application_run_backup_plans"
+	TaskFunction_Application_Restore        = "application_restore"
+)
+
+type RestoreOptions struct {
+	BackupID uint `json:"backupID,omitempty"`
+	// 恢复到的环境
+	EnvironmentID uint `json:"environmentID,omitempty"`
+}
+
+// BackupByPlan 按照备份计划执行一次备份
+func (p *ApplicationProcessor) BackupByPlan(ctx context.Context, planid uint) error {
+	plan := &models.BackupPlan{}
+	if err := p.backupPlanQuery(ctx).Take(plan, planid).Error; err != nil {
+		return err
+	}
+	_, err := p.Backup(ctx, plan)
+	return err
+}
+
+// RunBackupPlans 执行所有到期的定时备份计划
+func (p *ApplicationProcessor) RunBackupPlans(ctx context.Context) error {
+	log := log.FromContextOrDiscard(ctx)
+	plans := []models.BackupPlan{}
+	if err := p.backupPlanQuery(ctx).Where("enabled = ? and schedule <> ''", true).Find(&plans).Error; err != nil {
+		return err
+	}
+	now := time.Now()
+	for i := range plans {
+		plan := &plans[i]
+		if !plan.ScheduledAt(now) {
+			continue
+		}
+		// 单个计划失败不影响其他计划
+		if _, err := p.Backup(ctx, plan); err != nil {
+			log.Error(err, "run backup plan", "plan", plan.ID, "application", plan.ApplicationName)
+		}
+	}
+	return nil
+}
+
+// Backup 备份应用编排的 git 版本以及 PVC 的卷快照,成功后按照保留数量清理旧的备份.
+// plan 需要预加载 Environment.Project.Tenant 以及 Environment.Cluster
+func (p *ApplicationProcessor) Backup(ctx context.Context, plan *models.BackupPlan) (*models.ApplicationBackup, error) {
+	now := time.Now()
+	backup := &models.ApplicationBackup{
+		PlanID:          plan.ID,
+		EnvironmentID:   plan.EnvironmentID,
+		ApplicationName: plan.ApplicationName,
+		Name:            fmt.Sprintf("%s-%s", plan.ApplicationName, now.Format("20060102150405")),
+		Status:          models.BackupStatusRunning,
+		Creator:         AuthorFromContext(ctx).Name,
+	}
+	if err := p.DataBase.DB.WithContext(ctx).Create(backup).Error; err != nil {
+		return nil, err
+	}
+	err := p.backup(ctx, plan, backup)
+
+	completed := time.Now()
+	backup.CompletedAt = &completed
+	backup.Status, backup.Message = models.BackupStatusCompleted, ""
+	if err != nil {
+		backup.Status, backup.Message = models.BackupStatusFailed, err.Error()
+	}
+	if dberr := p.DataBase.DB.WithContext(ctx).Save(backup).Error; dberr != nil {
+		log.FromContextOrDiscard(ctx).Error(dberr, "save backup", "backup", backup.Name)
+	}
+	if dberr := p.DataBase.DB.WithContext(ctx).Model(plan).
+		Updates(map[string]interface{}{"last_backup_at": now, "last_message": backup.Status + ": " + backup.Message}).Error; dberr != nil {
+		log.FromContextOrDiscard(ctx).Error(dberr, "update backup plan status", "plan", plan.ID)
+	}
+	if err != nil {
+		return backup, err
+	}
+	if err := p.cleanupBackups(ctx, plan); err != nil {
+		log.FromContextOrDiscard(ctx).Error(err, "cleanup backups", "plan", plan.ID)
+	}
+	return backup, nil
+}
+
+func (p *ApplicationProcessor) backup(ctx context.Context, plan *models.BackupPlan, backup *models.ApplicationBackup) error {
+	env := plan.Environment
+	if env == nil || env.Cluster == nil || env.Project == nil || env.Project.Tenant == nil {
+		return fmt.Errorf("environment of backup plan %d not loaded", plan.ID)
+	}
+	ref := PathRef{
+		Tenant:  env.Project.Tenant.TenantName,
+		Project: env.Project.ProjectName,
+		Env:     env.EnvironmentName,
+		Name:    plan.ApplicationName,
+	}
+	commit, err := p.Manifest.LatestRevision(ctx, ref)
+	if err != nil {
+		return err
+	}
+	backup.Revision = commit.Hash
+
+	app, err := p.Argo.GetArgoApp(ctx, ref.FullName())
+	if err != nil {
+		return fmt.Errorf("get argo application %s: %w", ref.Name, err)
+	}
+	cli, err := p.Agents.ClientOf(ctx, env.Cluster.ClusterName)
+	if err != nil {
+		return err
+	}
+	if !plan.SnapshotVolumes {
+		return nil
+	}
+	claims, err := CollectBackupClaims(ctx, cli, env.Namespace, app.Status.Resources)
+	if err != nil {
+		return err
+	}
+	if len(claims) == 0 {
+		return nil
+	}
+	hooks, err := plan.BackupHooks()
+	if err != nil {
+		return err
+	}
+	volumebackup := &VolumeBackup{
+		Client:    cli,
+		Executor:  cli.Extend(),
+		Namespace: env.Namespace,
+		Name:      backup.Name,
+	}
+	volumes, err := volumebackup.Run(ctx, hooks, claims)
+	// 部分快照创建失败时也记录,以便清理
+	backup.Volumes, _ = json.Marshal(volumes)
+	return err
+}
+
+// cleanupBackups 保留最近的 Retention 个备份,删除更早的备份及其卷快照
+func (p *ApplicationProcessor) cleanupBackups(ctx context.Context, plan *models.BackupPlan) error {
+	if plan.Retention <= 0 {
+		return nil
+	}
+	expired := []models.ApplicationBackup{}
+	if err := p.DataBase.DB.WithContext(ctx).
+		Where("plan_id = ?", plan.ID).
+		Order("id desc").Offset(plan.Retention).
+		Find(&expired).Error; err != nil {
+		return err
+	}
+	for i := range expired {
+		if err := p.DeleteBackup(ctx, plan.Environment, &expired[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteBackup 删除备份记录及其卷快照, env 需要预加载 Cluster
+func (p *ApplicationProcessor) DeleteBackup(ctx context.Context, env *models.Environment, backup *models.ApplicationBackup) error {
+	volumes := []models.BackupVolume{}
+	if len(backup.Volumes) > 0 {
+		if err := json.Unmarshal(backup.Volumes, &volumes); err != nil {
+			return err
+		}
+	}
+	if len(volumes) > 0 {
+		cli, err := p.Agents.ClientOf(ctx, env.Cluster.ClusterName)
+		if err != nil {
+			return err
+		}
+		if err := DeleteBackupSnapshots(ctx, cli, env.Namespace, volumes); err != nil {
+			return err
+		}
+	}
+	return p.DataBase.DB.WithContext(ctx).Delete(backup).Error
+}
+
+// Restore 将备份恢复到 ref 所在的环境: 先从卷快照恢复 PVC,再将编排恢复为备份时的 git 版本并同步
+func (p *ApplicationProcessor) Restore(ctx context.Context, ref PathRef, opts RestoreOptions) error {
+	record := &models.ApplicationRestore{
+		BackupID:        opts.BackupID,
+		EnvironmentID:   opts.EnvironmentID,
+		ApplicationName: ref.Name,
+		Status:          models.BackupStatusRunning,
+		Creator:         AuthorFromContext(ctx).Name,
+	}
+	err := p.restore(ctx, ref, opts, record)
+
+	completed := time.Now()
+	record.CompletedAt = &completed
+	record.Status = models.BackupStatusCompleted
+	if err != nil {
+		record.Status, record.Message = models.BackupStatusFailed, err.Error()
+	}
+	if dberr := p.DataBase.DB.WithContext(ctx).Create(record).Error; dberr != nil {
+		log.FromContextOrDiscard(ctx).Error(dberr, "save restore record")
+	}
+	return err
+}
+
+func (p *ApplicationProcessor) restore(ctx context.Context, ref PathRef, opts RestoreOptions, record *models.ApplicationRestore) error {
+	backup := &models.ApplicationBackup{}
+	if err := p.DataBase.DB.WithContext(ctx).Take(backup, opts.BackupID).Error; err != nil {
+		return err
+	}
+	if backup.Status != models.BackupStatusCompleted {
+		return fmt.Errorf("backup %s is %s", backup.Name, backup.Status)
+	}
+	if backup.ApplicationName != ref.Name {
+		return fmt.Errorf("backup %s belongs to application %s, not %s", backup.Name, backup.ApplicationName, ref.Name)
+	}
+	srcenv := &models.Environment{}
+	if err := p.DataBase.DB.WithContext(ctx).Preload("Cluster").Take(srcenv, backup.EnvironmentID).Error; err != nil {
+		return err
+	}
+	record.FromEnvironment = srcenv.EnvironmentName
+	dstenv, err := p.DataBase.GetEnvironmentWithCluster(ref)
+	if err != nil {
+		return err
+	}
+
+	volumes := []models.BackupVolume{}
+	if len(backup.Volumes) > 0 {
+		if err := json.Unmarshal(backup.Volumes, &volumes); err != nil {
+			return err
+		}
+	}
+	if len(volumes) > 0 {
+		if srcenv.Cluster.ClusterName != dstenv.ClusterName {
+			return fmt.Errorf("volume snapshots in cluster %s can't be restored to cluster %s", srcenv.Cluster.ClusterName, dstenv.ClusterName)
+		}
+		cli, err := p.Agents.ClientOf(ctx, dstenv.ClusterName)
+		if err != nil {
+			return err
+		}
+		if err := RestoreVolumes(ctx, cli, srcenv.Namespace, dstenv.Namespace, volumes); err != nil {
+			return err
+		}
+	}
+
+	srcref := PathRef{Tenant: ref.Tenant, Project: ref.Project, Env: srcenv.EnvironmentName, Name: backup.ApplicationName}
+	commit, err := p.Manifest.RevisionFiles(ctx, srcref, backup.Revision)
+	if err != nil {
+		return err
+	}
+	if err := p.Manifest.Func(ctx, ref,
+		Pull(),
+		FsFunc(PromoteFilesFunc(commit.Files)),
+		UpdateKustomizeCommit(fmt.Sprintf("restore from backup %s@%s", backup.Name, shortHash(commit.Hash))),
+	); err != nil {
+		return err
+	}
+	return p.Sync(ctx, ref)
+}
+
+func (p *ApplicationProcessor) backupPlanQuery(ctx context.Context) *gorm.DB {
+	return p.DataBase.DB.WithContext(ctx).Preload("Environment.Project.Tenant").Preload("Environment.Cluster")
+}
@@ -0,0 +1,395 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/utils/pointer"
+	"kubegems.io/kubegems/pkg/apis/storage"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	defaultSnapshotTimeout  = 5 * time.Minute
+	defaultSnapshotInterval = 2 * time.Second
+)
+
+// PodCommandExecutor 在容器中执行命令, agents.ExtendClient 实现了该接口
+type PodCommandExecutor interface {
+	ExecPod(ctx context.Context, namespace, name, container string, command []string) (*agents.ExecResult, error)
+}
+
+// VolumeBackup 对命名空间中的 PVC 创建卷快照,快照前后在 pod 中执行钩子以保证数据的应用一致性
+type VolumeBackup struct {
+	Client    client.Client
+	Executor  PodCommandExecutor
+	Namespace string
+	// 备份名称,作为卷快照名称的前缀以及 label
+	Name string
+	// 等待快照创建完成的超时时间以及检查间隔
+	Timeout  time.Duration
+	Interval time.Duration
+}
+
+// Run 执行 pre 钩子,创建卷快照并等待快照创建完成后执行 post 钩子. pre 钩子或者快照失败时同样执行 post 钩子
+func (b *VolumeBackup) Run(ctx context.Context, hooks []models.BackupHook, claims []string) ([]models.BackupVolume, error) {
+	var volumes []models.BackupVolume
+	err := b.runHooks(ctx, hooks, models.BackupHookPre)
+	if err == nil {
+		volumes, err = b.snapshot(ctx, claims)
+	}
+	if posterr := b.runHooks(ctx, hooks, models.BackupHookPost); posterr != nil && err == nil {
+		err = posterr
+	}
+	return volumes, err
+}
+
+func (b *VolumeBackup) runHooks(ctx context.Context, hooks []models.BackupHook, phase string) error {
+	for _, hook := range hooks {
+		if hook.Phase != phase {
+			continue
+		}
+		if err := b.runHook(ctx, hook); err != nil {
+			if !hook.ContinueOnError {
+				return err
+			}
+			log.FromContextOrDiscard(ctx).Error(err, "backup hook failed", "hook", hook.Name)
+		}
+	}
+	return nil
+}
+
+func (b *VolumeBackup) runHook(ctx context.Context, hook models.BackupHook) error {
+	pods := &corev1.PodList{}
+	if err := b.Client.List(ctx, pods, client.InNamespace(b.Namespace), client.MatchingLabels(hook.Selector)); err != nil {
+		return err
+	}
+	executed := 0
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
+			continue
+		}
+		executed++
+		if _, err := b.Executor.ExecPod(ctx, b.Namespace, pod.Name, hook.Container, hook.Command); err != nil {
+			return fmt.Errorf("hook %s in pod %s: %w", hook.Name, pod.Name, err)
+		}
+	}
+	if executed == 0 {
+		return fmt.Errorf("no running pod matched hook %s", hook.Name)
+	}
+	return nil
+}
+
+func (b *VolumeBackup) snapshot(ctx context.Context, claims []string) ([]models.BackupVolume, error) {
+	volumes := []models.BackupVolume{}
+	for _, claim := range claims {
+		pvc := &corev1.PersistentVolumeClaim{}
+		if err := b.Client.Get(ctx, client.ObjectKey{Namespace: b.Namespace, Name: claim}, pvc); err != nil {
+			return volumes, err
+		}
+		class, err := VolumeSnapshotClassOf(ctx, b.Client, pvc)
+		if err != nil {
+			return volumes, err
+		}
+		pvcbytes, err := json.Marshal(pvc)
+		if err != nil {
+			return volumes, err
+		}
+		snapshot := &snapshotv1.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      b.Name + "-" + claim,
+				Namespace: b.Namespace,
+				Labels:    map[string]string{storage.LabelBackup: b.Name},
+				Annotations: map[string]string{
+					storage.AnnotationVolumeSnapshotAnnotationKeyPersistentVolumeClaim: string(pvcbytes),
+				},
+			},
+			Spec: snapshotv1.VolumeSnapshotSpec{
+				Source:                  snapshotv1.VolumeSnapshotSource{PersistentVolumeClaimName: pointer.String(claim)},
+				VolumeSnapshotClassName: pointer.String(class),
+			},
+		}
+		if err := b.Client.Create(ctx, snapshot); err != nil {
+			return volumes, err
+		}
+		volumes = append(volumes, models.BackupVolume{PersistentVolumeClaim: claim, VolumeSnapshot: snapshot.Name})
+	}
+	return volumes, b.waitSnapshotsTaken(ctx, volumes)
+}
+
+// waitSnapshotsTaken 等待快照已经在存储中创建, 此时即可执行 post 钩子, 无需等待快照可用
+func (b *VolumeBackup) waitSnapshotsTaken(ctx context.Context, volumes []models.BackupVolume) error {
+	timeout, interval := b.Timeout, b.Interval
+	if timeout == 0 {
+		timeout = defaultSnapshotTimeout
+	}
+	if interval == 0 {
+		interval = defaultSnapshotInterval
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pending := volumes
+	for {
+		notready := []models.BackupVolume{}
+		for _, volume := range pending {
+			snapshot := &snapshotv1.VolumeSnapshot{}
+			if err := b.Client.Get(ctx, client.ObjectKey{Namespace: b.Namespace, Name: volume.VolumeSnapshot}, snapshot); err != nil {
+				return err
+			}
+			if status := snapshot.Status; status != nil {
+				if status.Error != nil && status.Error.Message != nil {
+					return fmt.Errorf("snapshot %s: %s", snapshot.Name, *status.Error.Message)
+				}
+				if status.CreationTime != nil || (status.ReadyToUse != nil && *status.ReadyToUse) {
+					continue
+				}
+			}
+			notready = append(notready, volume)
+		}
+		if len(notready) == 0 {
+			return nil
+		}
+		pending = notready
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("wait volume snapshots created timeout after %s", timeout)
+		case <-time.After(interval):
+		}
+	}
+}
+
+// VolumeSnapshotClassOf 选择与 PVC 的 storageclass 使用相同驱动的 VolumeSnapshotClass
+func VolumeSnapshotClassOf(ctx context.Context, cli client.Client, pvc *corev1.PersistentVolumeClaim) (string, error) {
+	if pvc.Spec.StorageClassName == nil {
+		return "", fmt.Errorf("pvc %s has no storageclass", pvc.Name)
+	}
+	storageclass := &storagev1.StorageClass{}
+	if err := cli.Get(ctx, client.ObjectKey{Name: *pvc.Spec.StorageClassName}, storageclass); err != nil {
+		return "", err
+	}
+	snapshotclasses := &snapshotv1.VolumeSnapshotClassList{}
+	if err := cli.List(ctx, snapshotclasses); err != nil {
+		return "", err
+	}
+	for _, snapshotclass := range snapshotclasses.Items {
+		if snapshotclass.Driver == storageclass.Provisioner {
+			return snapshotclass.Name, nil
+		}
+	}
+	return "", fmt.Errorf("unable to find VolumeSnapshotClass of pvc %s provisioner=%s", pvc.Name, storageclass.Provisioner)
+}
+
+// CollectBackupClaims 找出 argo 应用中的资源以及工作负载使用的 PVC
+func CollectBackupClaims(ctx context.Context, cli client.Client, namespace string, resources []v1alpha1.ResourceStatus) ([]string, error) {
+	claims := map[string]bool{}
+	var claimtemplates []string
+	for _, res := range resources {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(schema.GroupVersionKind{Group: res.Group, Version: res.Version, Kind: res.Kind})
+		if err := cli.Get(ctx, client.ObjectKey{Namespace: res.Namespace, Name: res.Name}, obj); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("get %s %s: %w", res.Kind, res.Name, err)
+		}
+		if res.Group == "" && res.Kind == "PersistentVolumeClaim" {
+			claims[res.Name] = true
+		}
+		for _, claim := range podTemplateClaims(obj) {
+			claims[claim] = true
+		}
+		if res.Group == "apps" && res.Kind == "StatefulSet" {
+			templates, _, _ := unstructured.NestedSlice(obj.Object, "spec", "volumeClaimTemplates")
+			for _, template := range templates {
+				if m, ok := template.(map[string]interface{}); ok {
+					name, _, _ := unstructured.NestedString(m, "metadata", "name")
+					claimtemplates = append(claimtemplates, regexp.QuoteMeta(name+"-"+res.Name+"-"))
+				}
+			}
+		}
+	}
+	// statefulset 的 volumeClaimTemplates 创建的 PVC 名称为 <template>-<statefulset>-<序号>
+	if len(claimtemplates) > 0 {
+		pvcs := &corev1.PersistentVolumeClaimList{}
+		if err := cli.List(ctx, pvcs, client.InNamespace(namespace)); err != nil {
+			return nil, err
+		}
+		for _, template := range claimtemplates {
+			re := regexp.MustCompile("^" + template + `\d+$`)
+			for _, pvc := range pvcs.Items {
+				if re.MatchString(pvc.Name) {
+					claims[pvc.Name] = true
+				}
+			}
+		}
+	}
+	names := make([]string, 0, len(claims))
+	for name := range claims {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func podTemplateClaims(obj *unstructured.Unstructured) []string {
+	var volumes []interface{}
+	for _, path := range [][]string{
+		{"spec", "template", "spec", "volumes"},
+		{"spec", "jobTemplate", "spec", "template", "spec", "volumes"},
+	} {
+		if found, ok, _ := unstructured.NestedSlice(obj.Object, path...); ok {
+			volumes = found
+			break
+		}
+	}
+	claims := []string{}
+	for _, volume := range volumes {
+		if m, ok := volume.(map[string]interface{}); ok {
+			if name, ok, _ := unstructured.NestedString(m, "persistentVolumeClaim", "claimName"); ok && name != "" {
+				claims = append(claims, name)
+			}
+		}
+	}
+	return claims
+}
+
+// RestoreVolumes 从卷快照创建 PVC. 目标命名空间与备份的命名空间不同时, 先在目标命名空间中创建引用同一存储快照的 VolumeSnapshot
+func RestoreVolumes(ctx context.Context, cli client.Client, from, to string, volumes []models.BackupVolume) error {
+	for _, volume := range volumes {
+		snapshot := &snapshotv1.VolumeSnapshot{}
+		if err := cli.Get(ctx, client.ObjectKey{Namespace: from, Name: volume.VolumeSnapshot}, snapshot); err != nil {
+			return err
+		}
+		if snapshot.Status == nil || snapshot.Status.ReadyToUse == nil || !*snapshot.Status.ReadyToUse {
+			return fmt.Errorf("volume snapshot %s is not ready to use", snapshot.Name)
+		}
+		existing := &corev1.PersistentVolumeClaim{}
+		if err := cli.Get(ctx, client.ObjectKey{Namespace: to, Name: volume.PersistentVolumeClaim}, existing); err == nil {
+			return fmt.Errorf("pvc %s already exists in namespace %s, remove the application and the pvc before restore", existing.Name, to)
+		} else if !errors.IsNotFound(err) {
+			return err
+		}
+		if from != to {
+			copied, err := copyVolumeSnapshot(ctx, cli, snapshot, to)
+			if err != nil {
+				return err
+			}
+			snapshot = copied
+		}
+
+		pvc := &corev1.PersistentVolumeClaim{}
+		if err := json.Unmarshal([]byte(snapshot.Annotations[storage.AnnotationVolumeSnapshotAnnotationKeyPersistentVolumeClaim]), pvc); err != nil {
+			return fmt.Errorf("decode pvc of snapshot %s: %w", snapshot.Name, err)
+		}
+		restored := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      volume.PersistentVolumeClaim,
+				Namespace: to,
+				Labels:    pvc.Labels,
+			},
+			Spec: pvc.Spec,
+		}
+		group := snapshotv1.GroupName
+		restored.Spec.DataSource = &corev1.TypedLocalObjectReference{
+			APIGroup: &group,
+			Kind:     "VolumeSnapshot",
+			Name:     snapshot.Name,
+		}
+		restored.Spec.DataSourceRef = nil
+		// reset bind volume
+		restored.Spec.VolumeName = ""
+		if err := cli.Create(ctx, restored); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyVolumeSnapshot 静态创建引用同一存储快照的 VolumeSnapshotContent 及目标命名空间中的 VolumeSnapshot.
+// 删除策略为 Retain,删除恢复出的快照不会删除原备份的存储快照
+func copyVolumeSnapshot(ctx context.Context, cli client.Client, snapshot *snapshotv1.VolumeSnapshot, namespace string) (*snapshotv1.VolumeSnapshot, error) {
+	if snapshot.Status.BoundVolumeSnapshotContentName == nil {
+		return nil, fmt.Errorf("volume snapshot %s is not bound", snapshot.Name)
+	}
+	content := &snapshotv1.VolumeSnapshotContent{}
+	if err := cli.Get(ctx, client.ObjectKey{Name: *snapshot.Status.BoundVolumeSnapshotContentName}, content); err != nil {
+		return nil, err
+	}
+	if content.Status == nil || content.Status.SnapshotHandle == nil {
+		return nil, fmt.Errorf("volume snapshot content %s has no snapshot handle", content.Name)
+	}
+	copiedcontent := &snapshotv1.VolumeSnapshotContent{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "restore-" + namespace + "-" + snapshot.Name,
+			Labels: snapshot.Labels,
+		},
+		Spec: snapshotv1.VolumeSnapshotContentSpec{
+			DeletionPolicy:          snapshotv1.VolumeSnapshotContentRetain,
+			Driver:                  content.Spec.Driver,
+			VolumeSnapshotClassName: content.Spec.VolumeSnapshotClassName,
+			Source:                  snapshotv1.VolumeSnapshotContentSource{SnapshotHandle: content.Status.SnapshotHandle},
+			VolumeSnapshotRef:       corev1.ObjectReference{Namespace: namespace, Name: snapshot.Name},
+		},
+	}
+	if err := cli.Create(ctx, copiedcontent); err != nil && !errors.IsAlreadyExists(err) {
+		return nil, err
+	}
+	copied := &snapshotv1.VolumeSnapshot{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        snapshot.Name,
+			Namespace:   namespace,
+			Labels:      snapshot.Labels,
+			Annotations: snapshot.Annotations,
+		},
+		Spec: snapshotv1.VolumeSnapshotSpec{
+			Source: snapshotv1.VolumeSnapshotSource{VolumeSnapshotContentName: pointer.String(copiedcontent.Name)},
+		},
+	}
+	if err := cli.Create(ctx, copied); err != nil && !errors.IsAlreadyExists(err) {
+		return nil, err
+	}
+	return copied, nil
+}
+
+// DeleteBackupSnapshots 删除备份创建的卷快照
+func DeleteBackupSnapshots(ctx context.Context, cli client.Client, namespace string, volumes []models.BackupVolume) error {
+	for _, volume := range volumes {
+		snapshot := &snapshotv1.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Name: volume.VolumeSnapshot, Namespace: namespace},
+		}
+		if err := cli.Delete(ctx, snapshot); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,343 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	"kubegems.io/kubegems/pkg/apis/storage"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"kubegems.io/kubegems/pkg/utils/kube"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const testCSIDriver = "csi.test.io"
+
+// csiSnapshotter 模拟 CSI snapshotter, 创建 VolumeSnapshot 时立即完成快照并绑定 VolumeSnapshotContent
+type csiSnapshotter struct {
+	client.Client
+}
+
+func (c *csiSnapshotter) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	snapshot, ok := obj.(*snapshotv1.VolumeSnapshot)
+	if !ok {
+		return c.Client.Create(ctx, obj, opts...)
+	}
+	contentname := "snapcontent-" + snapshot.Namespace + "-" + snapshot.Name
+	handle := "handle-" + snapshot.Name
+	if snapshot.Spec.Source.VolumeSnapshotContentName != nil {
+		// 静态创建的快照引用已有的 VolumeSnapshotContent
+		contentname = *snapshot.Spec.Source.VolumeSnapshotContentName
+	} else {
+		content := &snapshotv1.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{Name: contentname},
+			Spec: snapshotv1.VolumeSnapshotContentSpec{
+				DeletionPolicy: snapshotv1.VolumeSnapshotContentDelete,
+				Driver:         testCSIDriver,
+				Source:         snapshotv1.VolumeSnapshotContentSource{VolumeHandle: snapshot.Spec.Source.PersistentVolumeClaimName},
+			},
+			Status: &snapshotv1.VolumeSnapshotContentStatus{SnapshotHandle: pointer.String(handle)},
+		}
+		if err := c.Client.Create(ctx, content); err != nil {
+			return err
+		}
+	}
+	now := metav1.Now()
+	snapshot.Status = &snapshotv1.VolumeSnapshotStatus{
+		BoundVolumeSnapshotContentName: pointer.String(contentname),
+		CreationTime:                   &now,
+		ReadyToUse:                     pointer.Bool(true),
+	}
+	return c.Client.Create(ctx, snapshot, opts...)
+}
+
+type recordExecutor struct {
+	calls []string
+	fails map[string]bool
+}
+
+func (e *recordExecutor) ExecPod(ctx context.Context, namespace, name, container string, command []string) (*agents.ExecResult, error) {
+	call := fmt.Sprintf("%s/%s:%v", namespace, name, command)
+	e.calls = append(e.calls, call)
+	if e.fails[command[0]] {
+		return nil, fmt.Errorf("exec %s failed", command[0])
+	}
+	return &agents.ExecResult{}, nil
+}
+
+func newBackupTestClient(objs ...client.Object) client.Client {
+	base := []client.Object{
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "local"}, Provisioner: testCSIDriver},
+		&snapshotv1.VolumeSnapshotClass{ObjectMeta: metav1.ObjectMeta{Name: "local-snapshot"}, Driver: testCSIDriver},
+	}
+	cli := fake.NewClientBuilder().WithScheme(kube.GetScheme()).WithObjects(append(base, objs...)...).Build()
+	return &csiSnapshotter{Client: cli}
+}
+
+func testPVC(namespace, name string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{"app": "mysql"}},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: pointer.String("local"),
+			VolumeName:       "pv-" + name,
+		},
+	}
+}
+
+func testPod(namespace, name string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{"app": "mysql"}},
+		Status:     corev1.PodStatus{Phase: phase},
+	}
+}
+
+func TestVolumeBackupRun(t *testing.T) {
+	hooks := []models.BackupHook{
+		{Name: "unfreeze", Phase: models.BackupHookPost, Selector: map[string]string{"app": "mysql"}, Command: []string{"unlock"}},
+		{Name: "freeze", Phase: models.BackupHookPre, Selector: map[string]string{"app": "mysql"}, Command: []string{"lock"}},
+	}
+	tests := []struct {
+		name        string
+		hooks       []models.BackupHook
+		fails       map[string]bool
+		claims      []string
+		wantVolumes []models.BackupVolume
+		wantCalls   []string
+		wantErr     bool
+	}{
+		{
+			name:   "snapshot with hooks",
+			hooks:  hooks,
+			claims: []string{"data"},
+			wantVolumes: []models.BackupVolume{
+				{PersistentVolumeClaim: "data", VolumeSnapshot: "mysql-1-data"},
+			},
+			wantCalls: []string{"prod/mysql-0:[lock]", "prod/mysql-0:[unlock]"},
+		},
+		{
+			name:      "post hook runs when pre hook failed",
+			hooks:     hooks,
+			fails:     map[string]bool{"lock": true},
+			claims:    []string{"data"},
+			wantCalls: []string{"prod/mysql-0:[lock]", "prod/mysql-0:[unlock]"},
+			wantErr:   true,
+		},
+		{
+			name: "continue on error",
+			hooks: []models.BackupHook{
+				{Name: "freeze", Phase: models.BackupHookPre, Selector: map[string]string{"app": "mysql"}, Command: []string{"lock"}, ContinueOnError: true},
+			},
+			fails:  map[string]bool{"lock": true},
+			claims: []string{"data"},
+			wantVolumes: []models.BackupVolume{
+				{PersistentVolumeClaim: "data", VolumeSnapshot: "mysql-1-data"},
+			},
+			wantCalls: []string{"prod/mysql-0:[lock]"},
+		},
+		{
+			name:      "post hook runs when snapshot failed",
+			hooks:     hooks,
+			claims:    []string{"notfound"},
+			wantCalls: []string{"prod/mysql-0:[lock]", "prod/mysql-0:[unlock]"},
+			wantErr:   true,
+		},
+		{
+			name: "no running pod matched",
+			hooks: []models.BackupHook{
+				{Name: "freeze", Phase: models.BackupHookPre, Selector: map[string]string{"app": "redis"}, Command: []string{"lock"}},
+			},
+			claims:  []string{"data"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := newBackupTestClient(
+				testPVC("prod", "data"),
+				testPod("prod", "mysql-0", corev1.PodRunning),
+				testPod("prod", "mysql-1", corev1.PodPending),
+			)
+			executor := &recordExecutor{fails: tt.fails}
+			b := &VolumeBackup{
+				Client:    cli,
+				Executor:  executor,
+				Namespace: "prod",
+				Name:      "mysql-1",
+				Timeout:   time.Second,
+				Interval:  10 * time.Millisecond,
+			}
+			volumes, err := b.Run(context.Background(), tt.hooks, tt.claims)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VolumeBackup.Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(volumes, tt.wantVolumes) {
+				t.Errorf("VolumeBackup.Run() = %v, want %v", volumes, tt.wantVolumes)
+			}
+			if !reflect.DeepEqual(executor.calls, tt.wantCalls) {
+				t.Errorf("VolumeBackup.Run() exec calls = %v, want %v", executor.calls, tt.wantCalls)
+			}
+			for _, volume := range tt.wantVolumes {
+				snapshot := &snapshotv1.VolumeSnapshot{}
+				if err := cli.Get(context.Background(), client.ObjectKey{Namespace: "prod", Name: volume.VolumeSnapshot}, snapshot); err != nil {
+					t.Fatalf("get snapshot %s: %v", volume.VolumeSnapshot, err)
+				}
+				if class := snapshot.Spec.VolumeSnapshotClassName; class == nil || *class != "local-snapshot" {
+					t.Errorf("snapshot class = %v, want local-snapshot", class)
+				}
+				if snapshot.Labels[storage.LabelBackup] != "mysql-1" {
+					t.Errorf("snapshot labels = %v", snapshot.Labels)
+				}
+			}
+		})
+	}
+}
+
+func TestCollectBackupClaims(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "prod"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Volumes: []corev1.Volume{
+						{Name: "data", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "web-data"}}},
+						{Name: "tmp", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+					},
+				},
+			},
+		},
+	}
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysql", Namespace: "prod"},
+		Spec: appsv1.StatefulSetSpec{
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{{ObjectMeta: metav1.ObjectMeta{Name: "data"}}},
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysql", Namespace: "prod"},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	}
+	cli := newBackupTestClient(
+		deployment, statefulset, secret,
+		testPVC("prod", "web-data"),
+		testPVC("prod", "data-mysql-0"),
+		testPVC("prod", "data-mysql-1"),
+		testPVC("prod", "data-mysql-backup-0"),
+	)
+	resources := []v1alpha1.ResourceStatus{
+		{Group: "apps", Version: "v1", Kind: "Deployment", Namespace: "prod", Name: "web"},
+		{Group: "apps", Version: "v1", Kind: "StatefulSet", Namespace: "prod", Name: "mysql"},
+		{Version: "v1", Kind: "Secret", Namespace: "prod", Name: "mysql"},
+		{Version: "v1", Kind: "ConfigMap", Namespace: "prod", Name: "deleted"},
+	}
+	claims, err := CollectBackupClaims(context.Background(), cli, "prod", resources)
+	if err != nil {
+		t.Fatalf("CollectBackupClaims() error = %v", err)
+	}
+	if want := []string{"data-mysql-0", "data-mysql-1", "web-data"}; !reflect.DeepEqual(claims, want) {
+		t.Errorf("CollectBackupClaims() claims = %v, want %v", claims, want)
+	}
+}
+
+func TestRestoreVolumes(t *testing.T) {
+	ctx := context.Background()
+	backup := func() client.Client {
+		cli := newBackupTestClient(testPVC("prod", "data"))
+		b := &VolumeBackup{Client: cli, Executor: &recordExecutor{}, Namespace: "prod", Name: "mysql-1"}
+		if _, err := b.Run(ctx, nil, []string{"data"}); err != nil {
+			t.Fatalf("backup: %v", err)
+		}
+		return cli
+	}
+	volumes := []models.BackupVolume{{PersistentVolumeClaim: "data", VolumeSnapshot: "mysql-1-data"}}
+
+	t.Run("same namespace", func(t *testing.T) {
+		cli := backup()
+		if err := RestoreVolumes(ctx, cli, "prod", "prod", volumes); err == nil {
+			t.Fatalf("RestoreVolumes() expect error when pvc exists")
+		}
+		if err := cli.Delete(ctx, testPVC("prod", "data")); err != nil {
+			t.Fatal(err)
+		}
+		if err := RestoreVolumes(ctx, cli, "prod", "prod", volumes); err != nil {
+			t.Fatalf("RestoreVolumes() error = %v", err)
+		}
+		pvc := &corev1.PersistentVolumeClaim{}
+		if err := cli.Get(ctx, client.ObjectKey{Namespace: "prod", Name: "data"}, pvc); err != nil {
+			t.Fatal(err)
+		}
+		if ds := pvc.Spec.DataSource; ds == nil || ds.Kind != "VolumeSnapshot" || ds.Name != "mysql-1-data" {
+			t.Errorf("restored pvc datasource = %v", ds)
+		}
+		if pvc.Spec.VolumeName != "" {
+			t.Errorf("restored pvc volumeName = %s, want empty", pvc.Spec.VolumeName)
+		}
+		if pvc.Labels["app"] != "mysql" {
+			t.Errorf("restored pvc labels = %v", pvc.Labels)
+		}
+	})
+
+	t.Run("new namespace", func(t *testing.T) {
+		cli := backup()
+		if err := RestoreVolumes(ctx, cli, "prod", "staging", volumes); err != nil {
+			t.Fatalf("RestoreVolumes() error = %v", err)
+		}
+		content := &snapshotv1.VolumeSnapshotContent{}
+		if err := cli.Get(ctx, client.ObjectKey{Name: "restore-staging-mysql-1-data"}, content); err != nil {
+			t.Fatal(err)
+		}
+		if content.Spec.DeletionPolicy != snapshotv1.VolumeSnapshotContentRetain {
+			t.Errorf("copied content deletionPolicy = %s", content.Spec.DeletionPolicy)
+		}
+		if handle := content.Spec.Source.SnapshotHandle; handle == nil || *handle != "handle-mysql-1-data" {
+			t.Errorf("copied content snapshotHandle = %v", handle)
+		}
+		snapshot := &snapshotv1.VolumeSnapshot{}
+		if err := cli.Get(ctx, client.ObjectKey{Namespace: "staging", Name: "mysql-1-data"}, snapshot); err != nil {
+			t.Fatal(err)
+		}
+		pvc := &corev1.PersistentVolumeClaim{}
+		if err := cli.Get(ctx, client.ObjectKey{Namespace: "staging", Name: "data"}, pvc); err != nil {
+			t.Fatal(err)
+		}
+		if ds := pvc.Spec.DataSource; ds == nil || ds.Name != "mysql-1-data" {
+			t.Errorf("restored pvc datasource = %v", ds)
+		}
+	})
+
+	t.Run("delete snapshots", func(t *testing.T) {
+		cli := backup()
+		if err := DeleteBackupSnapshots(ctx, cli, "prod", volumes); err != nil {
+			t.Fatalf("DeleteBackupSnapshots() error = %v", err)
+		}
+		err := cli.Get(ctx, client.ObjectKey{Namespace: "prod", Name: "mysql-1-data"}, &snapshotv1.VolumeSnapshot{})
+		if !errors.IsNotFound(err) {
+			t.Errorf("snapshot not deleted: %v", err)
+		}
+	})
+}
@@ -0,0 +1,391 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	rolloutsv1alpha1 "github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"kubegems.io/kubegems/pkg/apis/application"
+	"kubegems.io/kubegems/pkg/service/handlers/application/options"
+	"kubegems.io/kubegems/pkg/utils/database"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+	"kubegems.io/kubegems/pkg/utils/prometheus/templates"
+	"kubegems.io/kubegems/pkg/utils/slice"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	AnalysisMetricTypePromql = "promql"
+	AnalysisMetricTypeLoki   = "loki"
+
+	// 新版本 pod 的 pod-template-hash, 用于只对灰度的 pod 做分析
+	AnalysisArgCanaryHash = "canary-hash"
+
+	// 生成的 AnalysisTemplate 上记录原始的分析配置,用于回显
+	AnnotationCanaryAnalysis = application.GroupName + "/canary-analysis"
+
+	defaultAnalysisInterval = "1m"
+	defaultAnalysisCount    = 5
+	defaultLogErrorMatch    = "(?i)(error|exception)"
+)
+
+// CanaryStepAnalysis 灰度步骤上的内置分析,生成 AnalysisTemplate 并设置到对应的步骤上
+type CanaryStepAnalysis struct {
+	// 步骤序号,从0开始,该步骤需要是空步骤或者分析步骤
+	Step    int                    `json:"step"`
+	Metrics []CanaryAnalysisMetric `json:"metrics"`
+}
+
+// CanaryAnalysisMetric 分析指标,指标值满足 Operator Threshold 时认为成功
+type CanaryAnalysisMetric struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // promql,loki
+	// promql 监控模板,为空时使用 Expr. 模板中有 pod 标签时仅查询新版本的 pod
+	PromqlGenerator *prometheus.PromqlGenerator `json:"promqlGenerator,omitempty"`
+	// 原生 promql,可以使用 {{args.namespace}} 和 {{args.canary-hash}}
+	Expr string `json:"expr,omitempty"`
+	// loki 错误日志匹配的正则,指标值为新版本 pod 匹配的日志行数占总行数的比例
+	Match     string  `json:"match,omitempty"`
+	Operator  string  `json:"operator"` // <,<=,>,>=
+	Threshold float64 `json:"threshold"`
+	// 分析间隔,次数以及允许失败的次数
+	Interval     string `json:"interval,omitempty"`
+	Count        int    `json:"count,omitempty"`
+	FailureLimit int    `json:"failureLimit,omitempty"`
+}
+
+// AnalysisRunReport 灰度分析的执行结果
+type AnalysisRunReport struct {
+	Name      string                 `json:"name"`
+	Revision  string                 `json:"revision,omitempty"`
+	Phase     string                 `json:"phase"`
+	Message   string                 `json:"message,omitempty"`
+	StartedAt *metav1.Time           `json:"startedAt,omitempty"`
+	Metrics   []AnalysisMetricReport `json:"metrics"`
+}
+
+type AnalysisMetricReport struct {
+	Name         string `json:"name"`
+	Phase        string `json:"phase"`
+	Message      string `json:"message,omitempty"`
+	Count        int32  `json:"count"`
+	Successful   int32  `json:"successful"`
+	Failed       int32  `json:"failed"`
+	Inconclusive int32  `json:"inconclusive"`
+	Error        int32  `json:"error"`
+	LastValue    string `json:"lastValue,omitempty"`
+}
+
+func canaryAnalysisTemplateName(deployment string, step int) string {
+	return fmt.Sprintf("%s-canary-step-%d", deployment, step)
+}
+
+// prepareCanaryAnalysis 根据步骤上的内置分析生成 AnalysisTemplate, 并移除不再使用的
+func (p *ApplicationProcessor) prepareCanaryAnalysis(ctx context.Context, cli GitStore, dep *appsv1.Deployment, canary *CanaryDeploymentStrategy) error {
+	tplGetter := (&database.DatabaseHelper{DB: p.DataBase.DB}).FindPromqlTpl
+	steps := canary.ExtendCanaryStrategy.CanaryStrategy.Steps
+
+	keep := map[string]bool{}
+	for _, analysis := range canary.StepAnalyses {
+		if analysis.Step < 0 || analysis.Step >= len(steps) {
+			return fmt.Errorf("canary analysis step %d out of range", analysis.Step)
+		}
+		step := &steps[analysis.Step]
+		if step.SetWeight != nil || step.Pause != nil || step.Experiment != nil || step.SetCanaryScale != nil {
+			return fmt.Errorf("canary step %d is not an analysis step", analysis.Step)
+		}
+		name := canaryAnalysisTemplateName(dep.Name, analysis.Step)
+		template, err := GenerateAnalysisTemplate(name, dep.Name, analysis, p.Analysis, tplGetter)
+		if err != nil {
+			return err
+		}
+		template.Namespace = dep.Namespace
+		if err := createOrUpdateInStore(ctx, cli, template); err != nil {
+			return err
+		}
+		keep[name] = true
+
+		step.Analysis = &rolloutsv1alpha1.RolloutAnalysis{
+			Templates: []rolloutsv1alpha1.RolloutAnalysisTemplate{{TemplateName: name}},
+			Args: []rolloutsv1alpha1.AnalysisRunArgument{
+				{Name: AnalysisArgNamespace},
+				{
+					Name: AnalysisArgCanaryHash,
+					ValueFrom: &rolloutsv1alpha1.ArgumentValueFrom{
+						PodTemplateHashValue: func() *rolloutsv1alpha1.ValueFromPodTemplateHash {
+							v := rolloutsv1alpha1.Latest
+							return &v
+						}(),
+					},
+				},
+			},
+		}
+		defaultAnalysisArgs(step.Analysis, canary.CanaryService)
+	}
+
+	list := &rolloutsv1alpha1.AnalysisTemplateList{}
+	if err := cli.List(ctx, list); err != nil {
+		return err
+	}
+	for i := range list.Items {
+		template := &list.Items[i]
+		if _, ok := template.Annotations[AnnotationCanaryAnalysis]; !ok || keep[template.Name] {
+			continue
+		}
+		if !strings.HasPrefix(template.Name, dep.Name+"-canary-step-") {
+			continue
+		}
+		for j, step := range steps {
+			if step.Analysis == nil {
+				continue
+			}
+			for _, ref := range step.Analysis.Templates {
+				if ref.TemplateName == template.Name {
+					return fmt.Errorf("canary step %d references removed analysis %s", j, template.Name)
+				}
+			}
+		}
+		if err := cli.Delete(ctx, template); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreCanaryAnalysis 从生成的 AnalysisTemplate 中恢复步骤上的内置分析配置
+func restoreCanaryAnalysis(ctx context.Context, store GitStore, dep *appsv1.Deployment, canary *ExtendCanaryStrategy) error {
+	list := &rolloutsv1alpha1.AnalysisTemplateList{}
+	if err := store.List(ctx, list); err != nil {
+		return err
+	}
+	for _, template := range list.Items {
+		content, ok := template.Annotations[AnnotationCanaryAnalysis]
+		if !ok || !strings.HasPrefix(template.Name, dep.Name+"-canary-step-") {
+			continue
+		}
+		analysis := CanaryStepAnalysis{}
+		if err := json.Unmarshal([]byte(content), &analysis); err != nil {
+			return fmt.Errorf("parse canary analysis of %s: %w", template.Name, err)
+		}
+		canary.StepAnalyses = append(canary.StepAnalyses, analysis)
+	}
+	sort.Slice(canary.StepAnalyses, func(i, j int) bool {
+		return canary.StepAnalyses[i].Step < canary.StepAnalyses[j].Step
+	})
+	return nil
+}
+
+// GenerateAnalysisTemplate 将内置分析转换为 argo rollouts 的 AnalysisTemplate, 使用 opts 中配置的 prometheus 和 loki 地址
+func GenerateAnalysisTemplate(name, deployment string, analysis CanaryStepAnalysis, opts *options.AnalysisOptions, tplGetter templates.TplGetter) (*rolloutsv1alpha1.AnalysisTemplate, error) {
+	if len(analysis.Metrics) == 0 {
+		return nil, fmt.Errorf("no metrics in canary analysis of step %d", analysis.Step)
+	}
+	metrics := make([]rolloutsv1alpha1.Metric, 0, len(analysis.Metrics))
+	for _, m := range analysis.Metrics {
+		metric, err := generateAnalysisMetric(deployment, m, opts, tplGetter)
+		if err != nil {
+			return nil, fmt.Errorf("metric %s: %w", m.Name, err)
+		}
+		metrics = append(metrics, *metric)
+	}
+	content, err := json.Marshal(analysis)
+	if err != nil {
+		return nil, err
+	}
+	return &rolloutsv1alpha1.AnalysisTemplate{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rolloutsv1alpha1.SchemeGroupVersion.String(),
+			Kind:       "AnalysisTemplate",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Annotations: map[string]string{
+				AnnotationGeneratedByPlatformKey: AnnotationGeneratedByPlatformValueRollouts,
+				AnnotationCanaryAnalysis:         string(content),
+			},
+		},
+		Spec: rolloutsv1alpha1.AnalysisTemplateSpec{
+			Args: []rolloutsv1alpha1.Argument{
+				{Name: AnalysisArgNamespace},
+				{Name: AnalysisArgCanaryHash},
+			},
+			Metrics: metrics,
+		},
+	}, nil
+}
+
+func generateAnalysisMetric(deployment string, m CanaryAnalysisMetric, opts *options.AnalysisOptions, tplGetter templates.TplGetter) (*rolloutsv1alpha1.Metric, error) {
+	if m.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if !slice.ContainStr([]string{"<", "<=", ">", ">="}, m.Operator) {
+		return nil, fmt.Errorf("invalid operator %s", m.Operator)
+	}
+	threshold := strconv.FormatFloat(m.Threshold, 'f', -1, 64)
+	podselector := deployment + "-{{args." + AnalysisArgCanaryHash + "}}-.*"
+
+	metric := &rolloutsv1alpha1.Metric{
+		Name:     m.Name,
+		Interval: rolloutsv1alpha1.DurationString(m.Interval),
+	}
+	if metric.Interval == "" {
+		metric.Interval = defaultAnalysisInterval
+	}
+	count := m.Count
+	if count <= 0 {
+		count = defaultAnalysisCount
+	}
+	metric.Count = func() *intstr.IntOrString { v := intstr.FromInt(count); return &v }()
+	metric.FailureLimit = func() *intstr.IntOrString { v := intstr.FromInt(m.FailureLimit); return &v }()
+
+	switch m.Type {
+	case AnalysisMetricTypePromql:
+		query := m.Expr
+		if !m.PromqlGenerator.Notpl() {
+			generator := *m.PromqlGenerator
+			if err := generator.SetTpl(tplGetter); err != nil {
+				return nil, err
+			}
+			if !generator.Tpl.Namespaced {
+				return nil, fmt.Errorf("promql template %s is not namespaced", generator.TplString())
+			}
+			labels := map[string]string{}
+			for k, v := range generator.LabelPairs {
+				labels[k] = v
+			}
+			if _, ok := labels["pod"]; !ok && slice.ContainStr(generator.Tpl.Labels, "pod") {
+				labels["pod"] = podselector
+			}
+			generator.LabelPairs = labels
+			expr, err := generator.ToPromql("{{args." + AnalysisArgNamespace + "}}")
+			if err != nil {
+				return nil, err
+			}
+			query = expr
+		}
+		if query == "" {
+			return nil, fmt.Errorf("promql template or expr is required")
+		}
+		metric.Provider.Prometheus = &rolloutsv1alpha1.PrometheusMetric{
+			Address: opts.PrometheusServer,
+			Query:   query,
+		}
+		// 没有数据时既不成功也不失败, 分析结果为 inconclusive
+		metric.SuccessCondition = fmt.Sprintf("len(result) > 0 && all(result, {# %s %s})", m.Operator, threshold)
+		metric.FailureCondition = fmt.Sprintf("len(result) > 0 && !all(result, {# %s %s})", m.Operator, threshold)
+	case AnalysisMetricTypeLoki:
+		match := m.Match
+		if match == "" {
+			match = defaultLogErrorMatch
+		}
+		selector := fmt.Sprintf(`{namespace="{{args.%s}}", pod=~"%s"}`, AnalysisArgNamespace, podselector)
+		// 没有匹配的日志时比例为 0, 没有任何日志时 loki 返回空的结果
+		logql := fmt.Sprintf("(sum(count_over_time(%[1]s |~ `%[2]s` [%[3]s])) or vector(0)) / sum(count_over_time(%[1]s [%[3]s]))",
+			selector, match, metric.Interval)
+		metric.Provider.Web = &rolloutsv1alpha1.WebMetric{
+			URL:      lokiQueryURL(opts.LokiServer, logql),
+			JSONPath: "{$.data.result}",
+		}
+		// 空的结果既不成功也不失败, 分析结果为 inconclusive
+		metric.SuccessCondition = fmt.Sprintf("len(result) > 0 && asFloat(result[0].value[1]) %s %s", m.Operator, threshold)
+		metric.FailureCondition = fmt.Sprintf("len(result) > 0 && !(asFloat(result[0].value[1]) %s %s)", m.Operator, threshold)
+	default:
+		return nil, fmt.Errorf("unsupported analysis metric type %s", m.Type)
+	}
+	return metric, nil
+}
+
+// lokiQueryURL 对查询语句编码,但保留 argo rollouts 的参数占位符以便在分析时替换
+func lokiQueryURL(address, logql string) string {
+	encoded := url.QueryEscape(logql)
+	for _, arg := range []string{AnalysisArgNamespace, AnalysisArgCanaryHash} {
+		placeholder := "{{args." + arg + "}}"
+		encoded = strings.ReplaceAll(encoded, url.QueryEscape(placeholder), placeholder)
+	}
+	return strings.TrimSuffix(address, "/") + "/loki/api/v1/query?query=" + encoded
+}
+
+// AnalysisRunReports 获取 rollout 的灰度分析结果, 按照创建时间倒序
+func AnalysisRunReports(ctx context.Context, cli client.Client, namespace, rollout string) ([]AnalysisRunReport, error) {
+	list := &rolloutsv1alpha1.AnalysisRunList{}
+	if err := cli.List(ctx, list, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	runs := []rolloutsv1alpha1.AnalysisRun{}
+	for _, run := range list.Items {
+		for _, owner := range run.OwnerReferences {
+			if owner.Kind == "Rollout" && owner.Name == rollout {
+				runs = append(runs, run)
+				break
+			}
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[j].CreationTimestamp.Before(&runs[i].CreationTimestamp)
+	})
+
+	reports := make([]AnalysisRunReport, 0, len(runs))
+	for _, run := range runs {
+		report := AnalysisRunReport{
+			Name:      run.Name,
+			Revision:  run.Annotations["rollout.argoproj.io/revision"],
+			Phase:     string(run.Status.Phase),
+			Message:   run.Status.Message,
+			StartedAt: run.Status.StartedAt,
+			Metrics:   []AnalysisMetricReport{},
+		}
+		for _, result := range run.Status.MetricResults {
+			metric := AnalysisMetricReport{
+				Name:         result.Name,
+				Phase:        string(result.Phase),
+				Message:      result.Message,
+				Count:        result.Count,
+				Successful:   result.Successful,
+				Failed:       result.Failed,
+				Inconclusive: result.Inconclusive,
+				Error:        result.Error,
+			}
+			if n := len(result.Measurements); n > 0 {
+				metric.LastValue = result.Measurements[n-1].Value
+			}
+			report.Metrics = append(report.Metrics, metric)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+func createOrUpdateInStore(ctx context.Context, cli GitStore, obj client.Object) error {
+	exist := obj.DeepCopyObject().(client.Object)
+	if err := cli.Get(ctx, client.ObjectKeyFromObject(obj), exist); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		return cli.Create(ctx, obj)
+	}
+	obj.SetResourceVersion(exist.GetResourceVersion())
+	return cli.Update(ctx, obj)
+}
@@ -0,0 +1,134 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"kubegems.io/kubegems/pkg/service/handlers/application/options"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+	"kubegems.io/kubegems/pkg/utils/prometheus/templates"
+)
+
+func TestGenerateAnalysisTemplate(t *testing.T) {
+	tplGetter := func(scope, resource, rule string) (*templates.PromqlTpl, error) {
+		if scope == "containers" && resource == "container" && rule == "cpuUsage" {
+			return &templates.PromqlTpl{
+				ScopeName:    scope,
+				ResourceName: resource,
+				RuleName:     rule,
+				Namespaced:   true,
+				Expr:         "sum(rate(container_cpu_usage_seconds_total[5m]))by(namespace,pod,container)",
+				Unit:         "short",
+				Labels:       []string{"namespace", "pod", "container"},
+			}, nil
+		}
+		return nil, fmt.Errorf("not found")
+	}
+	analysis := CanaryStepAnalysis{
+		Step: 1,
+		Metrics: []CanaryAnalysisMetric{
+			{
+				Name:            "cpu",
+				Type:            AnalysisMetricTypePromql,
+				PromqlGenerator: &prometheus.PromqlGenerator{Scope: "containers", Resource: "container", Rule: "cpuUsage"},
+				Operator:        "<",
+				Threshold:       0.5,
+			},
+			{
+				Name:      "errors",
+				Type:      AnalysisMetricTypeLoki,
+				Operator:  "<=",
+				Threshold: 0.01,
+				Interval:  "2m",
+			},
+		},
+	}
+	opts := &options.AnalysisOptions{PrometheusServer: "http://prometheus.example:9090", LokiServer: "http://loki.example:3100/"}
+	template, err := GenerateAnalysisTemplate("app-canary-step-1", "app", analysis, opts, tplGetter)
+	if err != nil {
+		t.Fatalf("GenerateAnalysisTemplate() error = %v", err)
+	}
+	if len(template.Spec.Metrics) != 2 || len(template.Spec.Args) != 2 {
+		t.Fatalf("unexpected template spec: %+v", template.Spec)
+	}
+
+	cpu := template.Spec.Metrics[0]
+	query := cpu.Provider.Prometheus.Query
+	for _, want := range []string{`pod=~"app-{{args.canary-hash}}-.*"`, `namespace=~"{{args.namespace}}"`} {
+		if !strings.Contains(query, want) {
+			t.Errorf("promql %s should contains %s", query, want)
+		}
+	}
+	if cpu.Provider.Prometheus.Address != opts.PrometheusServer {
+		t.Errorf("unexpected prometheus address %s", cpu.Provider.Prometheus.Address)
+	}
+	// 没有数据时既不成功也不失败
+	if cpu.SuccessCondition != "len(result) > 0 && all(result, {# < 0.5})" {
+		t.Errorf("unexpected success condition %s", cpu.SuccessCondition)
+	}
+	if cpu.FailureCondition != "len(result) > 0 && !all(result, {# < 0.5})" {
+		t.Errorf("unexpected failure condition %s", cpu.FailureCondition)
+	}
+	if cpu.Interval != defaultAnalysisInterval || cpu.Count.IntValue() != defaultAnalysisCount {
+		t.Errorf("unexpected interval %s or count %v", cpu.Interval, cpu.Count)
+	}
+
+	logs := template.Spec.Metrics[1]
+	// 占位符需要保留以便 argo rollouts 替换
+	if !strings.HasPrefix(logs.Provider.Web.URL, "http://loki.example:3100/loki/api/v1/query?") {
+		t.Errorf("unexpected loki url %s", logs.Provider.Web.URL)
+	}
+	if !strings.Contains(logs.Provider.Web.URL, "{{args.namespace}}") || !strings.Contains(logs.Provider.Web.URL, "{{args.canary-hash}}") {
+		t.Errorf("placeholders should be kept in url %s", logs.Provider.Web.URL)
+	}
+	u, err := url.Parse(strings.NewReplacer("{{args.namespace}}", "default", "{{args.canary-hash}}", "abc").Replace(logs.Provider.Web.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantlogql := "(sum(count_over_time({namespace=\"default\", pod=~\"app-abc-.*\"} |~ `" + defaultLogErrorMatch + "` [2m])) or vector(0)) / " +
+		"sum(count_over_time({namespace=\"default\", pod=~\"app-abc-.*\"} [2m]))"
+	if got := u.Query().Get("query"); got != wantlogql {
+		t.Errorf("logql = %s, want %s", got, wantlogql)
+	}
+	if logs.SuccessCondition != "len(result) > 0 && asFloat(result[0].value[1]) <= 0.01" {
+		t.Errorf("unexpected success condition %s", logs.SuccessCondition)
+	}
+	if logs.FailureCondition != "len(result) > 0 && !(asFloat(result[0].value[1]) <= 0.01)" {
+		t.Errorf("unexpected failure condition %s", logs.FailureCondition)
+	}
+}
+
+func TestGenerateAnalysisTemplateInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric CanaryAnalysisMetric
+	}{
+		{name: "invalid operator", metric: CanaryAnalysisMetric{Name: "a", Type: AnalysisMetricTypePromql, Expr: "up", Operator: "=="}},
+		{name: "empty promql", metric: CanaryAnalysisMetric{Name: "a", Type: AnalysisMetricTypePromql, Operator: "<"}},
+		{name: "unknown type", metric: CanaryAnalysisMetric{Name: "a", Type: "datadog", Operator: "<"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analysis := CanaryStepAnalysis{Metrics: []CanaryAnalysisMetric{tt.metric}}
+			if _, err := GenerateAnalysisTemplate("a", "app", analysis, options.NewDefaultAnalysisOptions(), nil); err == nil {
+				t.Errorf("GenerateAnalysisTemplate() should return error")
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	istionetworkingv1alpha3 "istio.io/api/networking/v1alpha3"
+	istioclinetworkingv1alpha3 "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"kubegems.io/kubegems/pkg/apis/application"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// 生成的 VirtualService 上记录原始的 header 路由配置,用于回显
+const AnnotationCanaryHeaderRoutes = application.GroupName + "/canary-header-routes"
+
+func canaryHeaderRouteName(vsroute, name string) string {
+	return vsroute + "-header-" + name
+}
+
+func isCanaryHeaderRoute(vsroute, name string) bool {
+	return strings.HasPrefix(name, vsroute+"-header-")
+}
+
+// buildCanaryHeaderRoutes 生成 header 路由, 匹配的请求全部转发到新版本的 service
+func buildCanaryHeaderRoutes(vsroute, canarysvc string, routes []CanaryHeaderRoute) ([]*istionetworkingv1alpha3.HTTPRoute, error) {
+	httproutes := make([]*istionetworkingv1alpha3.HTTPRoute, 0, len(routes))
+	names := map[string]bool{}
+	for _, route := range routes {
+		if errs := validation.IsDNS1123Label(route.Name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid header route name %s: %s", route.Name, strings.Join(errs, ","))
+		}
+		if names[route.Name] {
+			return nil, fmt.Errorf("duplicated header route %s", route.Name)
+		}
+		names[route.Name] = true
+
+		match := &istionetworkingv1alpha3.HTTPMatchRequest{
+			Headers:      map[string]*istionetworkingv1alpha3.StringMatch{},
+			SourceLabels: route.SourceLabels,
+		}
+		for k, v := range route.Headers {
+			match.Headers[strings.ToLower(k)] = v
+		}
+		if route.Cookie != nil {
+			if route.Cookie.Name == "" {
+				return nil, fmt.Errorf("cookie name of header route %s is required", route.Name)
+			}
+			if _, ok := match.Headers["cookie"]; ok {
+				return nil, fmt.Errorf("header route %s can't match both cookie header and cookie", route.Name)
+			}
+			match.Headers["cookie"] = &istionetworkingv1alpha3.StringMatch{
+				MatchType: &istionetworkingv1alpha3.StringMatch_Regex{Regex: cookieRegex(route.Cookie.Name, route.Cookie.Value)},
+			}
+		}
+		if len(match.Headers) == 0 && len(match.SourceLabels) == 0 {
+			return nil, fmt.Errorf("header route %s must match headers, cookie or source labels", route.Name)
+		}
+		if len(match.Headers) == 0 {
+			match.Headers = nil
+		}
+		httproutes = append(httproutes, &istionetworkingv1alpha3.HTTPRoute{
+			Name:  canaryHeaderRouteName(vsroute, route.Name),
+			Match: []*istionetworkingv1alpha3.HTTPMatchRequest{match},
+			Route: []*istionetworkingv1alpha3.HTTPRouteDestination{
+				{
+					Destination: &istionetworkingv1alpha3.Destination{Host: canarysvc},
+					Weight:      100,
+				},
+			},
+		})
+	}
+	return httproutes, nil
+}
+
+// cookieRegex 匹配 cookie header 中任意位置的 name=value
+func cookieRegex(name, value string) string {
+	return fmt.Sprintf(`^(.*;\s*)?%s=%s(;.*)?$`, regexp.QuoteMeta(name), regexp.QuoteMeta(value))
+}
+
+func setCanaryHeaderRoutesAnnotation(vs *istioclinetworkingv1alpha3.VirtualService, routes []CanaryHeaderRoute) error {
+	annotations := vs.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	if len(routes) == 0 {
+		delete(annotations, AnnotationCanaryHeaderRoutes)
+	} else {
+		content, err := json.Marshal(routes)
+		if err != nil {
+			return err
+		}
+		annotations[AnnotationCanaryHeaderRoutes] = string(content)
+	}
+	vs.SetAnnotations(annotations)
+	return nil
+}
+
+// restoreCanaryHeaderRoutes 从编排中的 VirtualService 恢复 header 路由配置
+func restoreCanaryHeaderRoutes(ctx context.Context, store GitStore, namespace string, canary *ExtendCanaryStrategy) error {
+	if canary.TrafficRouting == nil || canary.TrafficRouting.Istio == nil || canary.TrafficRouting.Istio.VirtualService.Name == "" {
+		return nil
+	}
+	vs := &istioclinetworkingv1alpha3.VirtualService{}
+	key := client.ObjectKey{Namespace: namespace, Name: canary.TrafficRouting.Istio.VirtualService.Name}
+	if err := store.Get(ctx, key, vs); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	content, ok := vs.Annotations[AnnotationCanaryHeaderRoutes]
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal([]byte(content), &canary.HeaderRoutes)
+}
+
+// LiveCanaryRoutes 集群中 VirtualService 上灰度相关的路由, 包括 header 路由和按照权重的灰度路由
+func LiveCanaryRoutes(ctx context.Context, cli client.Client, namespace string, canary *ExtendCanaryStrategy) ([]*istionetworkingv1alpha3.HTTPRoute, error) {
+	if canary == nil || canary.TrafficRouting == nil || canary.TrafficRouting.Istio == nil {
+		return nil, fmt.Errorf("canary strategy has no istio traffic routing")
+	}
+	istio := canary.TrafficRouting.Istio
+	vs := &istioclinetworkingv1alpha3.VirtualService{}
+	if err := cli.Get(ctx, client.ObjectKey{Namespace: namespace, Name: istio.VirtualService.Name}, vs); err != nil {
+		return nil, err
+	}
+	vsroute := "canary"
+	if len(istio.VirtualService.Routes) != 0 {
+		vsroute = istio.VirtualService.Routes[0]
+	}
+	routes := []*istionetworkingv1alpha3.HTTPRoute{}
+	for _, route := range vs.Spec.Http {
+		if route.Name == vsroute || isCanaryHeaderRoute(vsroute, route.Name) {
+			routes = append(routes, route)
+		}
+	}
+	return routes, nil
+}
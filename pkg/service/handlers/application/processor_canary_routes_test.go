@@ -0,0 +1,96 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"regexp"
+	"testing"
+
+	istionetworkingv1alpha3 "istio.io/api/networking/v1alpha3"
+)
+
+func TestBuildCanaryHeaderRoutes(t *testing.T) {
+	routes, err := buildCanaryHeaderRoutes("canary", "app-canary", []CanaryHeaderRoute{
+		{
+			Name: "testers",
+			Headers: map[string]*istionetworkingv1alpha3.StringMatch{
+				"X-Canary": {MatchType: &istionetworkingv1alpha3.StringMatch_Exact{Exact: "always"}},
+			},
+		},
+		{Name: "cookie", Cookie: &CanaryCookieMatch{Name: "user.group", Value: "internal"}},
+		{Name: "mesh", SourceLabels: map[string]string{"app": "gateway-internal"}},
+	})
+	if err != nil {
+		t.Fatalf("buildCanaryHeaderRoutes() error = %v", err)
+	}
+	if len(routes) != 3 {
+		t.Fatalf("buildCanaryHeaderRoutes() got %d routes, want 3", len(routes))
+	}
+	for _, route := range routes {
+		if !isCanaryHeaderRoute("canary", route.Name) {
+			t.Errorf("route %s should be a canary header route", route.Name)
+		}
+		if len(route.Route) != 1 || route.Route[0].Destination.Host != "app-canary" || route.Route[0].Weight != 100 {
+			t.Errorf("route %s should route all traffic to canary service", route.Name)
+		}
+	}
+	if _, ok := routes[0].Match[0].Headers["x-canary"]; !ok {
+		t.Errorf("header names should be lower case")
+	}
+	if routes[2].Match[0].Headers != nil || routes[2].Match[0].SourceLabels["app"] != "gateway-internal" {
+		t.Errorf("unexpected source labels match %v", routes[2].Match[0])
+	}
+
+	re := regexp.MustCompile(routes[1].Match[0].Headers["cookie"].GetRegex())
+	for cookie, want := range map[string]bool{
+		"user.group=internal":           true,
+		"a=b; user.group=internal; c=d": true,
+		"a=b;user.group=internal":       true,
+		"user.group=internalx":          false,
+		"userxgroup=internal":           false,
+		"xuser.group=internal":          false,
+		"a=b; user.group=external; c=d": false,
+	} {
+		if got := re.MatchString(cookie); got != want {
+			t.Errorf("cookie %q match = %v, want %v", cookie, got, want)
+		}
+	}
+}
+
+func TestBuildCanaryHeaderRoutesInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []CanaryHeaderRoute
+	}{
+		{name: "empty match", routes: []CanaryHeaderRoute{{Name: "a"}}},
+		{name: "invalid name", routes: []CanaryHeaderRoute{{Name: "A_B", SourceLabels: map[string]string{"a": "b"}}}},
+		{name: "duplicated", routes: []CanaryHeaderRoute{
+			{Name: "a", SourceLabels: map[string]string{"a": "b"}},
+			{Name: "a", SourceLabels: map[string]string{"a": "c"}},
+		}},
+		{name: "cookie conflict", routes: []CanaryHeaderRoute{{
+			Name:    "a",
+			Headers: map[string]*istionetworkingv1alpha3.StringMatch{"Cookie": {}},
+			Cookie:  &CanaryCookieMatch{Name: "a", Value: "b"},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := buildCanaryHeaderRoutes("canary", "app-canary", tt.routes); err == nil {
+				t.Errorf("buildCanaryHeaderRoutes() should return error")
+			}
+		})
+	}
+}
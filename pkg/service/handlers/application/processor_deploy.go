@@ -19,7 +19,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
@@ -43,6 +42,7 @@ import (
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/handlers/application/options"
 	"kubegems.io/kubegems/pkg/utils/agents"
 	"kubegems.io/kubegems/pkg/utils/argo"
 	"kubegems.io/kubegems/pkg/utils/database"
@@ -62,6 +62,8 @@ const (
 	TaskFunction_Application_PrepareDeploymentStrategy = "application_preparedeploymentstrategy"
 	TaskFunction_Application_WaitRollouts              = "application_wait_rollouts"
 	TaskFunction_Application_Undo                      = "application_undo"
+	TaskFunction_Application_PromoteRollout            = "application_promote_rollout"
+	TaskFunction_Application_Promote                   = "application_promote"
 )
 
 // ProvideFuntions 用于对异步任务框架指出所使用的方法
@@ -73,6 +75,16 @@ func (p *ApplicationProcessor) ProvideFuntions() map[string]interface{} {
 		TaskFunction_Application_PrepareDeploymentStrategy: p.PrepareDeploymentStrategyWithImages,
 		TaskFunction_Application_WaitRollouts:              p.WaitRollouts,
 		TaskFunction_Application_Undo:                      p.Undo,
+		TaskFunction_Application_PromoteRollout:            p.PromoteRollout,
+		TaskFunction_Application_Promote:                   p.Promote,
+		TaskFunction_Application_DeployPreview:             p.DeployPreview,
+		TaskFunction_Application_CheckImagePolicies:        p.CheckImagePolicies,
+		TaskFunction_Application_SyncWave:                  p.SyncWave,
+		TaskFunction_Application_CloneFrom:                 p.CloneFrom,
+		TaskFunction_Application_CreateBatch:               p.CreateBatch,
+		TaskFunction_Application_Backup:                    p.BackupByPlan,
+		TaskFunction_Application_RunBackupPlans:            p.RunBackupPlans,
+		TaskFunction_Application_Restore:                   p.Restore,
 	}
 }
 
@@ -82,18 +94,21 @@ type ApplicationProcessor struct {
 	DataBase *DatabseProcessor
 	Manifest *ManifestProcessor
 	Task     *TaskProcessor
+	// 灰度分析使用的 prometheus 和 loki 地址
+	Analysis *options.AnalysisOptions
 
 	// 缓存已经创建的 cluster,project,repo
 	argostatuscache *sync.Map
 }
 
-func NewApplicationProcessor(db *database.Database, gitp *git.SimpleLocalProvider, argo *argo.Client, redis *redis.Client, agents *agents.ClientSet) *ApplicationProcessor {
+func NewApplicationProcessor(db *database.Database, gitp *git.SimpleLocalProvider, argo *argo.Client, redis *redis.Client, agents *agents.ClientSet, analysis *options.AnalysisOptions) *ApplicationProcessor {
 	p := &ApplicationProcessor{
 		Agents:   agents,
 		Argo:     argo,
 		DataBase: &DatabseProcessor{DB: db.DB()},
 		Manifest: &ManifestProcessor{GitProvider: gitp},
 		Task:     &TaskProcessor{Workflowcli: workflow.NewClientFromRedisClient(redis.Client)},
+		Analysis: analysis,
 
 		argostatuscache: &sync.Map{},
 	}
@@ -243,23 +258,23 @@ func CompleteDeploiedManifestRuntime(app *v1alpha1.Application, status *Deploied
 }
 
 func (h *ApplicationProcessor) CreateBatch(ctx context.Context, baseref PathRef, names []string) error {
-	var srcfs billy.Filesystem
-	srcref := PathRef{Tenant: baseref.Tenant, Project: baseref.Project, Env: ""}
-	err := h.Manifest.ContentFunc(ctx, srcref, func(ctx context.Context, fs billy.Filesystem) error {
-		srcfs = fs
-		return nil
-	})
-	if err != nil {
-		return err
+	commits := map[string]*git.Commit{}
+	for _, name := range names {
+		commit, err := h.Manifest.RevisionFiles(ctx, PathRef{Tenant: baseref.Tenant, Project: baseref.Project, Name: name}, "")
+		if err != nil {
+			return err
+		}
+		commits[name] = commit
 	}
 
-	copyfilefunc := func(ctx context.Context, fs billy.Filesystem) error {
+	refbasefunc := func(ctx context.Context, repository Repository) error {
+		fs, err := repository.FS(ctx)
+		if err != nil {
+			return err
+		}
 		for _, name := range names {
-			err := ForFileContentFunc(srcfs, name, func(filename string, content []byte) error {
-				destfile := filepath.Join(name, filename)
-				return util.WriteFile(fs, destfile, content, os.ModePerm)
-			})
-			if err != nil {
+			_ = fs.MkdirAll(name, os.ModePerm)
+			if err := writeBaseReference(chroot.New(fs, name), repository.repo.CloneURL(), name, commits[name]); err != nil {
 				return err
 			}
 		}
@@ -268,47 +283,48 @@ func (h *ApplicationProcessor) CreateBatch(ctx context.Context, baseref PathRef,
 
 	return h.Manifest.Func(ctx, baseref,
 		Pull(),
-		FsFunc(copyfilefunc),
+		refbasefunc,
 		Commit("batch create"),
 	)
 }
 
-func (h *ApplicationProcessor) Create(ctx context.Context, ref PathRef) error {
-	// 先git copy
-	files := []FileContent{}
+// writeBaseReference 环境中的编排引用基础编排中的提交, 保留环境中已有的 patch
+func writeBaseReference(fs billy.Filesystem, cloneurl, name string, commit *git.Commit) error {
+	if err := SetBaseReference(fs, BaseResource(cloneurl, name, commit.Hash)); err != nil {
+		return err
+	}
+	// 编排的描述
+	for _, file := range commit.Files {
+		if file.Name == ReadmeFilename {
+			return util.WriteFile(fs, ReadmeFilename, []byte(file.Content), os.ModePerm)
+		}
+	}
+	return nil
+}
 
+func (h *ApplicationProcessor) Create(ctx context.Context, ref PathRef) error {
 	manifest, err := h.Manifest.Get(ctx, ref)
 	if err != nil {
 		return err
 	}
-	// copy from src
+	// 引用基础编排中最新的提交
 	srcref := PathRef{Tenant: ref.Tenant, Project: ref.Project, Env: "", Name: ref.Name} // base env
-	if err := h.Manifest.ContentFunc(ctx, srcref, func(ctx context.Context, fs billy.Filesystem) error {
-		return ForFileContentFunc(fs, "", func(filename string, content []byte) error {
-			files = append(files, FileContent{Name: filename, Content: string(content)})
-			return nil
-		})
-	}); err != nil {
+	commit, err := h.Manifest.RevisionFiles(ctx, srcref, "")
+	if err != nil {
 		return err
 	}
-	// write to dest
-	writetoenvmanifestfunc := func(_ context.Context, fs billy.Filesystem) error {
-		if err := util.RemoveAll(fs, "."); err != nil {
+	refbasefunc := func(ctx context.Context, repository Repository) error {
+		fs, err := repository.FS(ctx)
+		if err != nil {
 			return err
 		}
-		// copy files
-		for _, f := range files {
-			if err := util.WriteFile(fs, f.Name, []byte(f.Content), os.ModePerm); err != nil {
-				return err
-			}
-		}
-		// set meta
-		if err := setManifestMeta(fs, manifestmeta{Creator: AuthorFromContext(ctx).Name, CreateAt: metav1.Now()}); err != nil {
+		if err := writeBaseReference(fs, repository.repo.CloneURL(), ref.Name, commit); err != nil {
 			return err
 		}
-		return nil
+		// set meta
+		return setManifestMeta(fs, manifestmeta{Creator: AuthorFromContext(ctx).Name, CreateAt: metav1.Now()})
 	}
-	if err := h.Manifest.UpdateContentFunc(ctx, ref, writetoenvmanifestfunc, "manifest from base"); err != nil {
+	if err := h.Manifest.Func(ctx, ref, Pull(), refbasefunc, UpdateKustomizeCommit("manifest from base")); err != nil {
 		return err
 	}
 
@@ -387,9 +403,10 @@ func (h *ApplicationProcessor) deployKustomizeApplication(ctx context.Context, r
 		// https://argoproj.github.io/argo-rollouts/features/traffic-management/istio/#integrating-with-gitops
 		app.Spec.IgnoreDifferences = []v1alpha1.ResourceIgnoreDifferences{
 			{
-				Group:        istioclinetworkingv1alpha3.SchemeGroupVersion.Group,
-				Kind:         "VirtualService",
-				JSONPointers: []string{"/spec/http/0"}, // 忽略 argo rollouts
+				Group: istioclinetworkingv1alpha3.SchemeGroupVersion.Group,
+				Kind:  "VirtualService",
+				// 忽略 argo rollouts 修改的权重, header 路由在灰度路由之前,所以不能只忽略 http/0
+				JQPathExpressions: []string{".spec.http[].route[].weight"},
 			},
 			{
 				Group: appsv1.SchemeGroupVersion.Group,
@@ -730,8 +747,15 @@ func (p *ApplicationProcessor) BatchUpdateImages(ctx context.Context, ref PathRe
 					_ = fs.MkdirAll(item.Name, os.ModePerm)
 					basedfs := chroot.New(fs, item.Name)
 
-					store := NewGitFsStore(basedfs)
+					store, err := NewGitFsStoreFromContext(ctx, basedfs)
+					if err != nil {
+						return err
+					}
 					UpdateContentImages(ctx, store, item.Images, item.IstioVersion)
+					// 基础编排中资源的修改写入了 overlay patch
+					if err := InitOrUpdateKustomization(basedfs); err != nil {
+						return err
+					}
 				}
 				return nil
 			},
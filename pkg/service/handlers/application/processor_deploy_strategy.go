@@ -80,6 +80,31 @@ type DeploymentStrategy struct {
 	Rolling   *appsv1.RollingUpdateDeployment `json:"rolling,omitempty"`
 }
 
+// PromoteRollout 推进应用主 deployment 对应的 rollout, 用于审批通过后的异步推进
+func (p *ApplicationProcessor) PromoteRollout(ctx context.Context, ref PathRef, full bool) error {
+	envinfo, err := p.DataBase.GetEnvironmentWithCluster(ref)
+	if err != nil {
+		return err
+	}
+	cli, err := p.Agents.ClientOf(ctx, envinfo.ClusterName)
+	if err != nil {
+		return err
+	}
+	name := ""
+	if err := p.Manifest.StoreFunc(ctx, ref, func(ctx context.Context, store GitStore) error {
+		deployment, err := ParseMainDeployment(ctx, store)
+		if err != nil {
+			return err
+		}
+		name = deployment.Name
+		return nil
+	}); err != nil {
+		return err
+	}
+	_, err = PromoteRollout(ctx, cli, envinfo.Namespace, name, full)
+	return err
+}
+
 func (p *ApplicationProcessor) WaitRollouts(ctx context.Context, ref PathRef) error {
 	// 从编排中找到 rollouts
 	var rollout *rolloutsv1alpha1.Rollout
@@ -246,6 +271,12 @@ func ParseUpdateStrategyAndDeployment(ctx context.Context, store GitStore) (*Dep
 			ret.Strategy.Canary = &CanaryDeploymentStrategy{
 				ExtendCanaryStrategy: *ExtendCanaryStrategyFromCanaryStrategy(canary),
 			}
+			if err := restoreCanaryAnalysis(ctx, store, deployment, &ret.Strategy.Canary.ExtendCanaryStrategy); err != nil {
+				return nil, err
+			}
+			if err := restoreCanaryHeaderRoutes(ctx, store, deployment.Namespace, &ret.Strategy.Canary.ExtendCanaryStrategy); err != nil {
+				return nil, err
+			}
 		} else if bg := rolloutresource.Spec.Strategy.BlueGreen; bg != nil {
 			ret.Strategy.Type = BlueGreenDeploymentStrategyType
 			ret.Strategy.BlueGreen = &BlueGreenDeploymentStrategy{
@@ -371,6 +402,10 @@ func (p *ApplicationProcessor) prepareCanaryRollout(ctx context.Context, cli Git
 	if err := p.ensureDeploymentServices(ctx, cli, dep, canary.StableService, canary.CanaryService); err != nil {
 		return err
 	}
+	// 内置的步骤分析
+	if err := p.prepareCanaryAnalysis(ctx, cli, dep, canary); err != nil {
+		return err
+	}
 	// 分析参数默认
 	if canary.Analysis != nil {
 		defaultAnalysisArgs(&canary.Analysis.RolloutAnalysis, canary.CanaryService) // 默认对新版本服务做分析
@@ -384,7 +419,7 @@ func (p *ApplicationProcessor) prepareCanaryRollout(ctx context.Context, cli Git
 	switch {
 	// istio 需要创建 virtual service
 	case tr.Istio != nil:
-		return p.prepareCanaryIstioRollout(ctx, cli, dep, canary.StableService, canary.CanaryService, tr.Istio, isinit)
+		return p.prepareCanaryIstioRollout(ctx, cli, dep, canary.StableService, canary.CanaryService, tr.Istio, canary.HeaderRoutes, isinit)
 	default:
 		// 其他策略暂时不做操作
 		return nil
@@ -407,7 +442,7 @@ func completeCanarySteps(_ context.Context, canary *rolloutsv1alpha1.CanaryStrat
 
 // istio 策略需要创建一个virtualservice
 func (p *ApplicationProcessor) prepareCanaryIstioRollout(ctx context.Context, cli GitStore,
-	dep *appsv1.Deployment, stablesvcname, nextsvcname string, istio *IstioTrafficRouting, isinit bool,
+	dep *appsv1.Deployment, stablesvcname, nextsvcname string, istio *IstioTrafficRouting, headers []CanaryHeaderRoute, isinit bool,
 ) error {
 	// 虚拟服务是要和主service同名的
 	if istio.VirtualService.Name == "" {
@@ -475,6 +510,17 @@ func (p *ApplicationProcessor) prepareCanaryIstioRollout(ctx context.Context, cl
 			}(),
 		},
 	}
+	// header 路由需要在灰度路由之前匹配
+	headerRoutes, err := buildCanaryHeaderRoutes(vsroute, nextsvcname, headers)
+	if err != nil {
+		return err
+	}
+	weighted := vs.Spec.Http[0]
+	vs.Spec.Http = append(append([]*istionetworkingv1alpha3.HTTPRoute{}, headerRoutes...), vs.Spec.Http...)
+	if err := setCanaryHeaderRoutesAnnotation(vs, headers); err != nil {
+		return err
+	}
+
 	existvs := &istioclinetworkingv1alpha3.VirtualService{}
 	if err := cli.Get(ctx, client.ObjectKeyFromObject(vs), existvs); err != nil {
 		if !errors.IsNotFound(err) {
@@ -484,42 +530,25 @@ func (p *ApplicationProcessor) prepareCanaryIstioRollout(ctx context.Context, cl
 		return cli.Create(ctx, vs)
 	}
 
-	updated := false
 	// update existvs
-	var targetFound *istionetworkingv1alpha3.HTTPRoute
-	for i, v := range existvs.Spec.Http {
-		if v.Name == vsroute {
-			if !equality.Semantic.DeepEqual(v, vs.Spec.Http[0]) {
-				existvs.Spec.Http[i] = vs.Spec.Http[0]
-				updated = true
-			}
-			// 由于编排中忽略的是 http/0 ;所以这里需要把对应的条目移动到 0 位置
-			if i != 0 {
-				// [0,1,2,T,4,5,6]
-				//
-				// [T]
-				// [T]+[0,1,2]
-				// [T]+[0,1,2]+[4,5,6]
-				existvs.Spec.Http = append(
-					append(
-						[]*istionetworkingv1alpha3.HTTPRoute{v},
-						existvs.Spec.Http[:i]...),
-					existvs.Spec.Http[i+1:]...)
-				updated = true
-			}
-			targetFound = v
+	// 按照 header 路由,灰度路由,其他路由的顺序重新排列
+	httpRoutes := append([]*istionetworkingv1alpha3.HTTPRoute{}, headerRoutes...)
+	httpRoutes = append(httpRoutes, weighted)
+	for _, v := range existvs.Spec.Http {
+		if v.Name == vsroute || isCanaryHeaderRoute(vsroute, v.Name) {
+			continue
 		}
+		httpRoutes = append(httpRoutes, v)
 	}
-	if targetFound == nil {
-		// 添加 0 条目
-		existvs.Spec.Http = append([]*istionetworkingv1alpha3.HTTPRoute{vs.Spec.Http[0]}, existvs.Spec.Http...)
-		updated = true
+	existannotation := existvs.Annotations[AnnotationCanaryHeaderRoutes]
+	if err := setCanaryHeaderRoutesAnnotation(existvs, headers); err != nil {
+		return err
 	}
-
-	if updated {
-		return cli.Update(ctx, existvs)
+	if equality.Semantic.DeepEqual(existvs.Spec.Http, httpRoutes) && existannotation == existvs.Annotations[AnnotationCanaryHeaderRoutes] {
+		return nil
 	}
-	return nil
+	existvs.Spec.Http = httpRoutes
+	return cli.Update(ctx, existvs)
 }
 
 func (p *ApplicationProcessor) ensureDeploymentServices(ctx context.Context, cli GitStore, dep *appsv1.Deployment, svcnames ...string) error {
@@ -0,0 +1,365 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"gorm.io/gorm"
+	corev1 "k8s.io/api/core/v1"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/harbor"
+)
+
+const (
+	TaskFunction_Application_CheckImagePolicies = "application_check_image_policies"
+
+	imageAutomationAuthor = "image-automation"
+)
+
+// SelectImageTag 按照策略从 tags 中选择需要更新到的 tag, 当前 tag 已经是最新时返回空
+func SelectImageTag(policy *models.ImageUpdatePolicy, current string, tags []harbor.ImageTag) (string, error) {
+	switch policy.Policy {
+	case models.ImagePolicySemver:
+		return selectSemverTag(policy.Range, current, tags)
+	case models.ImagePolicyRegex:
+		re, err := regexp.Compile(policy.Pattern)
+		if err != nil {
+			return "", fmt.Errorf("invalid pattern %s: %w", policy.Pattern, err)
+		}
+		matched := []harbor.ImageTag{}
+		for _, tag := range tags {
+			if re.MatchString(tag.Name) {
+				matched = append(matched, tag)
+			}
+		}
+		return selectLatestTag(current, matched), nil
+	case models.ImagePolicyLatest:
+		candidates, nopushtime := []harbor.ImageTag{}, false
+		for _, tag := range tags {
+			if tag.Name == "latest" {
+				continue
+			}
+			nopushtime = nopushtime || tag.PushTime.IsZero()
+			candidates = append(candidates, tag)
+		}
+		// 没有推送时间时只能按照 semver 版本排序
+		if nopushtime && !allSemverTags(candidates) {
+			return "", fmt.Errorf("policy latest requires push time of tags or semver tags, push time is only supported by harbor")
+		}
+		return selectLatestTag(current, candidates), nil
+	default:
+		return "", fmt.Errorf("unsupported image update policy %s", policy.Policy)
+	}
+}
+
+func selectSemverTag(constraint string, current string, tags []harbor.ImageTag) (string, error) {
+	if constraint == "" {
+		constraint = "*"
+	}
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return "", fmt.Errorf("invalid semver range %s: %w", constraint, err)
+	}
+	var (
+		latest    *semver.Version
+		latesttag string
+	)
+	for _, tag := range tags {
+		v, err := semver.NewVersion(tag.Name)
+		if err != nil || !c.Check(v) {
+			continue
+		}
+		if latest == nil || v.GreaterThan(latest) {
+			latest, latesttag = v, tag.Name
+		}
+	}
+	if latest == nil {
+		return "", nil
+	}
+	if currentv, err := semver.NewVersion(current); err == nil && !latest.GreaterThan(currentv) {
+		return "", nil
+	}
+	return latesttag, nil
+}
+
+// selectLatestTag 选择最新推送的 tag, 没有推送时间时 tag 都是 semver 版本则选择最高的版本, 否则按照名称倒序
+func selectLatestTag(current string, tags []harbor.ImageTag) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	sorted := append([]harbor.ImageTag{}, tags...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return newerTag(sorted[i], sorted[j])
+	})
+	latest := sorted[0]
+	if latest.Name == current {
+		return ""
+	}
+	// 当前的 tag 比选出的更新时不回退
+	for _, tag := range sorted {
+		if tag.Name == current && !tag.PushTime.IsZero() && !tag.PushTime.Before(latest.PushTime) {
+			return ""
+		}
+	}
+	if latest.PushTime.IsZero() {
+		if currentv, err := semver.NewVersion(current); err == nil {
+			if latestv, err := semver.NewVersion(latest.Name); err == nil && !latestv.GreaterThan(currentv) {
+				return ""
+			}
+		}
+	}
+	return latest.Name
+}
+
+// newerTag a 是否比 b 更新, 都有推送时间时比较推送时间, 否则都是 semver 版本时比较版本, 最后按照名称倒序
+func newerTag(a, b harbor.ImageTag) bool {
+	if !a.PushTime.IsZero() && !b.PushTime.IsZero() && !a.PushTime.Equal(b.PushTime) {
+		return a.PushTime.After(b.PushTime)
+	}
+	av, aerr := semver.NewVersion(a.Name)
+	bv, berr := semver.NewVersion(b.Name)
+	if aerr == nil && berr == nil && !av.Equal(bv) {
+		return av.GreaterThan(bv)
+	}
+	return a.Name > b.Name
+}
+
+// allSemverTags tag 是否都是 semver 版本
+func allSemverTags(tags []harbor.ImageTag) bool {
+	for _, tag := range tags {
+		if _, err := semver.NewVersion(tag.Name); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckImagePolicy 检查镜像仓库中是否有符合策略的新 tag,有则更新编排并按照配置同步,返回本次更新的记录.
+// policy 需要预加载 Environment.Project.Tenant
+func (p *ApplicationProcessor) CheckImagePolicy(ctx context.Context, policy *models.ImageUpdatePolicy) (*models.ImageUpdateHistory, error) {
+	history, err := p.checkImagePolicy(ctx, policy)
+	now := time.Now()
+	message := "image is up to date"
+	switch {
+	case err != nil:
+		message = err.Error()
+	case history != nil:
+		message = fmt.Sprintf("%s: %s -> %s", history.Status, history.FromImage, history.ToImage)
+	}
+	if dberr := p.DataBase.DB.WithContext(ctx).Model(policy).
+		Updates(map[string]interface{}{"last_checked_at": now, "last_message": message}).Error; dberr != nil {
+		log.FromContextOrDiscard(ctx).Error(dberr, "update image policy status", "policy", policy.ID)
+	}
+	return history, err
+}
+
+func (p *ApplicationProcessor) checkImagePolicy(ctx context.Context, policy *models.ImageUpdatePolicy) (*models.ImageUpdateHistory, error) {
+	env := policy.Environment
+	if env == nil || env.Project == nil || env.Project.Tenant == nil {
+		return nil, fmt.Errorf("environment of image policy %d not loaded", policy.ID)
+	}
+	ref := PathRef{
+		Tenant:  env.Project.Tenant.TenantName,
+		Project: env.Project.ProjectName,
+		Env:     env.EnvironmentName,
+		Name:    policy.ApplicationName,
+	}
+	current := ""
+	if err := p.Manifest.StoreFunc(ctx, ref, func(ctx context.Context, store GitStore) error {
+		objects, err := store.ListAll(ctx)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			for _, image := range ParseImagesFrom(obj) {
+				if name, _ := harbor.SplitImageNameTag(image); name == policy.Image {
+					current = image
+					return nil
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if current == "" {
+		return nil, fmt.Errorf("image %s not found in application %s", policy.Image, ref.Name)
+	}
+
+	options := RegistryOptionsOf(p.DataBase.DB, current, env.ProjectID)
+	tags, err := harbor.ListImageTags(ctx, options.URL, options.Username, options.Password, current)
+	if err != nil {
+		return nil, fmt.Errorf("list tags of %s: %w", policy.Image, err)
+	}
+	_, currenttag := harbor.SplitImageNameTag(current)
+	tag, err := SelectImageTag(policy, currenttag, tags)
+	if err != nil || tag == "" {
+		return nil, err
+	}
+
+	history := &models.ImageUpdateHistory{
+		PolicyID:        policy.ID,
+		EnvironmentID:   policy.EnvironmentID,
+		ApplicationName: policy.ApplicationName,
+		FromImage:       current,
+		ToImage:         policy.Image + ":" + tag,
+		Status:          models.ImageUpdateStatusDryRun,
+	}
+	if !policy.DryRun {
+		reason, err := p.imagePolicyGate(ctx, policy)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			// 冻结窗口或者审批策略生效时不自动更新, 之后的检查中再更新
+			history.Status, history.Message = models.ImageUpdateStatusSkipped, reason
+		} else {
+			history.Status = models.ImageUpdateStatusSuccess
+			if err := p.updateImageByPolicy(ctx, ref, policy.Image, tag, policy.Sync); err != nil {
+				history.Status, history.Message = models.ImageUpdateStatusFailed, err.Error()
+			}
+		}
+	}
+	if err := p.saveImageUpdateHistory(ctx, history); err != nil {
+		log.FromContextOrDiscard(ctx).Error(err, "save image update history", "policy", policy.ID)
+	}
+	if history.Status == models.ImageUpdateStatusFailed {
+		return history, fmt.Errorf("update image: %s", history.Message)
+	}
+	return history, nil
+}
+
+// imagePolicyGate 检查环境的冻结窗口和审批策略, 自动更新无法发起审批, 因此需要审批的环境中跳过更新, 返回跳过的原因
+func (p *ApplicationProcessor) imagePolicyGate(ctx context.Context, policy *models.ImageUpdatePolicy) (string, error) {
+	db := p.DataBase.DB.WithContext(ctx)
+	env := policy.Environment
+	window, err := models.ActiveFreezeWindow(db, env, time.Now())
+	if err != nil {
+		return "", err
+	}
+	if window != nil {
+		return fmt.Sprintf("environment %s is frozen by freeze window %s", env.EnvironmentName, window.Name), nil
+	}
+	actions := []string{models.ApprovalActionUpdateImage}
+	if policy.Sync {
+		actions = append(actions, models.ApprovalActionSync)
+	}
+	for _, action := range actions {
+		approval, err := models.FindApprovalPolicy(db, env.ID, action)
+		if err != nil {
+			return "", err
+		}
+		if approval != nil {
+			return fmt.Sprintf("%s in environment %s requires approval", action, env.EnvironmentName), nil
+		}
+	}
+	return "", nil
+}
+
+// saveImageUpdateHistory 保存更新历史,与上一条记录相同时(例如 dry-run 或持续失败)不重复保存
+func (p *ApplicationProcessor) saveImageUpdateHistory(ctx context.Context, history *models.ImageUpdateHistory) error {
+	db := p.DataBase.DB.WithContext(ctx)
+	last := &models.ImageUpdateHistory{}
+	if err := db.Where("policy_id = ?", history.PolicyID).Order("id desc").Take(last).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		last = nil
+	}
+	if isSameImageUpdateHistory(last, history) {
+		return nil
+	}
+	return db.Create(history).Error
+}
+
+func isSameImageUpdateHistory(last, history *models.ImageUpdateHistory) bool {
+	return last != nil &&
+		last.FromImage == history.FromImage &&
+		last.ToImage == history.ToImage &&
+		last.Status == history.Status
+}
+
+func (p *ApplicationProcessor) updateImageByPolicy(ctx context.Context, ref PathRef, name, tag string, sync bool) error {
+	ctx = context.WithValue(ctx, contextAuthorKey{}, &object.Signature{
+		Name:  imageAutomationAuthor,
+		Email: imageAutomationAuthor,
+		When:  time.Now(),
+	})
+	updatefunc := func(ctx context.Context, store GitStore) error {
+		objects, err := store.ListAll(ctx)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			updated := false
+			ObjectPodTemplateFunc(obj, func(template *corev1.PodTemplateSpec) {
+				// 仅更新镜像名称完全相同的容器, 避免前缀相同的其他镜像被修改
+				for i, c := range template.Spec.Containers {
+					if imagename, _ := harbor.SplitImageNameTag(c.Image); imagename == name {
+						template.Spec.Containers[i].Image = name + ":" + tag
+						updated = true
+					}
+				}
+			})
+			if updated {
+				if err := store.Update(ctx, obj); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := p.Manifest.StoreUpdateFunc(ctx, ref, updatefunc, fmt.Sprintf("image policy: set image %s:%s", name, tag)); err != nil {
+		return err
+	}
+	if sync {
+		return p.Sync(ctx, ref)
+	}
+	return nil
+}
+
+// CheckImagePolicies 检查所有启用的镜像更新策略
+func (p *ApplicationProcessor) CheckImagePolicies(ctx context.Context) error {
+	log := log.FromContextOrDiscard(ctx)
+	policies := []models.ImageUpdatePolicy{}
+	if err := p.DataBase.DB.WithContext(ctx).
+		Preload("Environment.Project.Tenant").
+		Where("enabled = ?", true).
+		Find(&policies).Error; err != nil {
+		return err
+	}
+	for i := range policies {
+		policy := &policies[i]
+		history, err := p.CheckImagePolicy(ctx, policy)
+		if err != nil {
+			// 单个策略失败不影响其他策略
+			log.Error(err, "check image policy", "policy", policy.ID, "image", policy.Image)
+			continue
+		}
+		if history != nil {
+			log.Info("image updated by policy", "policy", policy.ID, "from", history.FromImage, "to", history.ToImage, "status", history.Status)
+		}
+	}
+	return nil
+}
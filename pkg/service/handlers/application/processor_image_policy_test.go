@@ -0,0 +1,113 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"testing"
+	"time"
+
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/harbor"
+)
+
+func TestSelectImageTag(t *testing.T) {
+	now := time.Now()
+	tags := []harbor.ImageTag{
+		{Name: "v1.0.0", PushTime: now.Add(-5 * time.Hour)},
+		{Name: "v1.2.0", PushTime: now.Add(-4 * time.Hour)},
+		{Name: "v2.0.0", PushTime: now.Add(-3 * time.Hour)},
+		{Name: "main-abc123", PushTime: now.Add(-2 * time.Hour)},
+		{Name: "main-def456", PushTime: now.Add(-1 * time.Hour)},
+		{Name: "latest", PushTime: now},
+	}
+	tests := []struct {
+		name    string
+		policy  models.ImageUpdatePolicy
+		current string
+		want    string
+		wantErr bool
+	}{
+		{name: "semver in range", policy: models.ImageUpdatePolicy{Policy: models.ImagePolicySemver, Range: "^1.0.0"}, current: "v1.0.0", want: "v1.2.0"},
+		{name: "semver any", policy: models.ImageUpdatePolicy{Policy: models.ImagePolicySemver}, current: "v1.0.0", want: "v2.0.0"},
+		{name: "semver up to date", policy: models.ImageUpdatePolicy{Policy: models.ImagePolicySemver, Range: "^1.0.0"}, current: "v1.2.0", want: ""},
+		{name: "semver no downgrade", policy: models.ImageUpdatePolicy{Policy: models.ImagePolicySemver, Range: "^1.0.0"}, current: "v1.5.0", want: ""},
+		{name: "semver invalid range", policy: models.ImageUpdatePolicy{Policy: models.ImagePolicySemver, Range: "!!"}, wantErr: true},
+		{name: "regex", policy: models.ImageUpdatePolicy{Policy: models.ImagePolicyRegex, Pattern: "^main-"}, current: "main-abc123", want: "main-def456"},
+		{name: "regex up to date", policy: models.ImageUpdatePolicy{Policy: models.ImagePolicyRegex, Pattern: "^main-"}, current: "main-def456", want: ""},
+		{name: "latest", policy: models.ImageUpdatePolicy{Policy: models.ImagePolicyLatest}, current: "v1.0.0", want: "main-def456"},
+		{name: "unknown", policy: models.ImageUpdatePolicy{Policy: "unknown"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SelectImageTag(&tt.policy, tt.current, tags)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SelectImageTag() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("SelectImageTag() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// 非 harbor 仓库没有推送时间
+	if _, err := SelectImageTag(&models.ImageUpdatePolicy{Policy: models.ImagePolicyLatest}, "", []harbor.ImageTag{{Name: "v1"}, {Name: "main-abc123"}}); err == nil {
+		t.Errorf("SelectImageTag() with policy latest should fail without push time")
+	}
+	// 没有推送时间时按照 semver 排序
+	nopushtime := []harbor.ImageTag{{Name: "v1.10.0"}, {Name: "v1.9.0"}, {Name: "v1.2.0"}, {Name: "latest"}}
+	notimetests := []struct {
+		name    string
+		policy  models.ImageUpdatePolicy
+		current string
+		want    string
+	}{
+		{name: "latest by semver", policy: models.ImageUpdatePolicy{Policy: models.ImagePolicyLatest}, current: "v1.2.0", want: "v1.10.0"},
+		{name: "regex by semver", policy: models.ImageUpdatePolicy{Policy: models.ImagePolicyRegex, Pattern: `^v1\.`}, current: "v1.2.0", want: "v1.10.0"},
+		{name: "regex up to date", policy: models.ImageUpdatePolicy{Policy: models.ImagePolicyRegex, Pattern: `^v1\.`}, current: "v1.10.0", want: ""},
+		{name: "regex no downgrade", policy: models.ImageUpdatePolicy{Policy: models.ImagePolicyRegex, Pattern: `^v1\.`}, current: "v1.11.0", want: ""},
+	}
+	for _, tt := range notimetests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SelectImageTag(&tt.policy, tt.current, nopushtime)
+			if err != nil {
+				t.Fatalf("SelectImageTag() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("SelectImageTag() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_isSameImageUpdateHistory(t *testing.T) {
+	history := &models.ImageUpdateHistory{FromImage: "app:v1.0.0", ToImage: "app:v1.2.0", Status: models.ImageUpdateStatusDryRun}
+	tests := []struct {
+		name string
+		last *models.ImageUpdateHistory
+		want bool
+	}{
+		{name: "no history", last: nil, want: false},
+		{name: "same dry-run", last: &models.ImageUpdateHistory{FromImage: "app:v1.0.0", ToImage: "app:v1.2.0", Status: models.ImageUpdateStatusDryRun}, want: true},
+		{name: "new tag", last: &models.ImageUpdateHistory{FromImage: "app:v1.0.0", ToImage: "app:v1.1.0", Status: models.ImageUpdateStatusDryRun}, want: false},
+		{name: "status changed", last: &models.ImageUpdateHistory{FromImage: "app:v1.0.0", ToImage: "app:v1.2.0", Status: models.ImageUpdateStatusFailed}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSameImageUpdateHistory(tt.last, history); got != tt.want {
+				t.Errorf("isSameImageUpdateHistory() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -303,7 +303,11 @@ func DecodeManifest(ctx context.Context, fs billy.Filesystem, manifest *Manifest
 		images := []string{}
 		istioVersion := ""
 
-		workload, _ := ParseMainWorkload(ctx, NewGitFsStore(fs))
+		store, err := NewGitFsStoreFromContext(ctx, fs)
+		if err != nil {
+			return err
+		}
+		workload, _ := ParseMainWorkload(ctx, store)
 		ObjectPodTemplateFunc(workload, func(template *corev1.PodTemplateSpec) {
 			for _, c := range template.Spec.Containers {
 				if v, ok := template.Labels[LabelIstioVersion]; ok {
@@ -320,7 +324,15 @@ func DecodeManifest(ctx context.Context, fs billy.Filesystem, manifest *Manifest
 		}
 	} else {
 		// kind
-		for _, res := range kustomization.Resources {
+		resources := kustomization.Resources
+		// 引用基础编排时使用基础编排中的文件名
+		if _, basefiles, _ := BaseFiles(ctx, fs); basefiles != nil {
+			resources = []string{}
+			for _, file := range basefiles {
+				resources = append(resources, file.Name)
+			}
+		}
+		for _, res := range resources {
 			for _, kind := range detectKinds {
 				// 因为约定，文件名中一般包含 deployment statefulset 等字样，如果存在这些则可以直接判断
 				if strings.Contains(res, kind) {
@@ -0,0 +1,238 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/handlers/environment"
+	"kubegems.io/kubegems/pkg/service/models"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	TaskFunction_Application_DeployPreview = "application_deploy_preview"
+
+	LabelPreviewEnvironment = "kubegems.io/preview"
+)
+
+type PreviewOptions struct {
+	// 复制编排的源环境
+	From string `json:"from,omitempty"`
+	// 源环境编排的 git commit,为空时使用最新的提交
+	Revision string `json:"revision,omitempty"`
+	// 覆盖的镜像,例如分支构建出的镜像
+	Images []string `json:"images,omitempty"`
+	// 通过租户网关暴露服务,为空时不创建 ingress
+	IngressClass string `json:"ingressClass,omitempty"`
+	Host         string `json:"host,omitempty"`
+}
+
+var invalidDNSLabelChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// dnsLabel 将 s 转换为合法的 dns label,超出长度时截断并追加 hash 避免冲突
+func dnsLabel(s string, max int) string {
+	label := strings.Trim(invalidDNSLabelChars.ReplaceAllString(strings.ToLower(s), "-"), "-")
+	if len(label) <= max {
+		return label
+	}
+	sum := sha256.Sum256([]byte(s))
+	suffix := hex.EncodeToString(sum[:])[:6]
+	return strings.TrimRight(label[:max-len(suffix)-1], "-") + "-" + suffix
+}
+
+// PreviewEnvironmentName 生成预览环境的名称,同时作为预览环境的 namespace.
+// 环境名称全局唯一,因此包含项目名称
+func PreviewEnvironmentName(project, app, branch string) string {
+	return dnsLabel(strings.Join([]string{"preview", project, app, branch}, "-"), 50)
+}
+
+// DeployPreview 将源环境中指定版本的编排复制到预览环境,覆盖镜像后通过 argo 部署, 并在指定 host 时创建 ingress
+func (p *ApplicationProcessor) DeployPreview(ctx context.Context, ref PathRef, opts PreviewOptions) error {
+	srcref := PathRef{Tenant: ref.Tenant, Project: ref.Project, Env: opts.From, Name: ref.Name}
+	commit, err := p.Manifest.RevisionFiles(ctx, srcref, opts.Revision)
+	if err != nil {
+		return err
+	}
+	updateimages := func(ctx context.Context, store GitStore) error {
+		if len(opts.Images) == 0 {
+			return nil
+		}
+		objects, err := store.ListAll(ctx)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			updated := false
+			ObjectPodTemplateFunc(obj, func(template *corev1.PodTemplateSpec) {
+				UpdateImage(template, opts.Images)
+				updated = true
+			})
+			if updated {
+				if err := store.Update(ctx, obj); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := p.Manifest.Func(ctx, ref,
+		Pull(),
+		FsFunc(PromoteFilesFunc(commit.Files), FSStoreFunc(updateimages)),
+		UpdateKustomizeCommit(fmt.Sprintf("preview from %s@%s", opts.From, shortHash(commit.Hash))),
+	); err != nil {
+		return err
+	}
+	if _, err := p.deployKustomizeApplication(ctx, ref, true); err != nil {
+		return err
+	}
+	if opts.Host == "" {
+		return nil
+	}
+	return p.exposePreview(ctx, ref, opts)
+}
+
+// exposePreview 为编排中的第一个 service 创建 ingress
+func (p *ApplicationProcessor) exposePreview(ctx context.Context, ref PathRef, opts PreviewOptions) error {
+	var svc *corev1.Service
+	if err := p.Manifest.StoreFunc(ctx, ref, func(ctx context.Context, store GitStore) error {
+		svcs := &corev1.ServiceList{}
+		if err := store.List(ctx, svcs); err != nil {
+			return err
+		}
+		for i := range svcs.Items {
+			if len(svcs.Items[i].Spec.Ports) > 0 {
+				svc = &svcs.Items[i]
+				return nil
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if svc == nil {
+		log.FromContextOrDiscard(ctx).Info("no service found in manifest, skip expose preview", "app", ref.Name)
+		return nil
+	}
+
+	envinfo, err := p.DataBase.GetEnvironmentWithCluster(ref)
+	if err != nil {
+		return err
+	}
+	cli, err := p.Agents.ClientOf(ctx, envinfo.ClusterName)
+	if err != nil {
+		return err
+	}
+	// 等待环境控制器创建 namespace
+	if err := wait.PollImmediateWithContext(ctx, 2*time.Second, time.Minute, func(ctx context.Context) (bool, error) {
+		ns := &corev1.Namespace{}
+		if err := cli.Get(ctx, client.ObjectKey{Name: envinfo.Namespace}, ns); err != nil {
+			if errors.IsNotFound(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	}); err != nil {
+		return fmt.Errorf("wait namespace %s: %w", envinfo.Namespace, err)
+	}
+
+	pathtype := networkingv1.PathTypePrefix
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: ref.Name + "-preview", Namespace: envinfo.Namespace},
+	}
+	_, err = controllerutil.CreateOrUpdate(ctx, cli, ingress, func() error {
+		if ingress.Labels == nil {
+			ingress.Labels = map[string]string{}
+		}
+		ingress.Labels[LabelPreviewEnvironment] = ref.Env
+		ingress.Spec = networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{{
+				Host: opts.Host,
+				IngressRuleValue: networkingv1.IngressRuleValue{
+					HTTP: &networkingv1.HTTPIngressRuleValue{
+						Paths: []networkingv1.HTTPIngressPath{{
+							Path:     "/",
+							PathType: &pathtype,
+							Backend: networkingv1.IngressBackend{
+								Service: &networkingv1.IngressServiceBackend{
+									Name: svc.Name,
+									Port: networkingv1.ServiceBackendPort{Number: svc.Spec.Ports[0].Port},
+								},
+							},
+						}},
+					},
+				},
+			}},
+		}
+		if opts.IngressClass != "" {
+			ingress.Spec.IngressClassName = &opts.IngressClass
+		}
+		return nil
+	})
+	return err
+}
+
+// ExpiredPreviews 列举已经过期的预览环境
+func (p *ApplicationProcessor) ExpiredPreviews(ctx context.Context, now time.Time) ([]models.PreviewEnvironment, error) {
+	previews := []models.PreviewEnvironment{}
+	err := p.DataBase.DB.WithContext(ctx).
+		Preload("Environment.Cluster").
+		Preload("Environment.Project.Tenant").
+		Where("expired_at < ?", now).
+		Find(&previews).Error
+	return previews, err
+}
+
+// TeardownPreview 删除预览环境中的应用,集群中的环境以及数据库记录. preview 需要预加载 Environment.Cluster 和 Environment.Project.Tenant
+func (p *ApplicationProcessor) TeardownPreview(ctx context.Context, preview *models.PreviewEnvironment) error {
+	env := preview.Environment
+	if env == nil || env.Cluster == nil || env.Project == nil || env.Project.Tenant == nil {
+		return fmt.Errorf("environment of preview %d not loaded", preview.ID)
+	}
+	ref := PathRef{
+		Tenant:  env.Project.Tenant.TenantName,
+		Project: env.Project.ProjectName,
+		Env:     env.EnvironmentName,
+		Name:    preview.ApplicationName,
+	}
+	if err := p.Remove(ctx, ref); err != nil {
+		// 编排可能还未创建成功,继续删除环境
+		log.FromContextOrDiscard(ctx).Error(err, "remove preview application", "ref", ref)
+	}
+	cli, err := p.Agents.ClientOf(ctx, env.Cluster.ClusterName)
+	if err != nil {
+		return err
+	}
+	// 预览环境的删除策略为 delNamespace, 删除环境时同时删除 namespace 以及其中的 ingress
+	if err := environment.DeleteEnvironmentCR(ctx, cli, env.EnvironmentName); err != nil {
+		return err
+	}
+	// 级联删除预览环境记录
+	return p.DataBase.DB.WithContext(ctx).Delete(env).Error
+}
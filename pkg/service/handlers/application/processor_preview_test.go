@@ -0,0 +1,47 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestPreviewEnvironmentName(t *testing.T) {
+	valid := regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	tests := []struct {
+		project, app, branch string
+		want                 string
+	}{
+		{project: "demo", app: "web", branch: "feature/Login_Page", want: "preview-demo-web-feature-login-page"},
+		{project: "demo", app: "web", branch: "fix--", want: "preview-demo-web-fix"},
+	}
+	for _, tt := range tests {
+		if got := PreviewEnvironmentName(tt.project, tt.app, tt.branch); got != tt.want {
+			t.Errorf("PreviewEnvironmentName(%s) = %v, want %v", tt.branch, got, tt.want)
+		}
+	}
+
+	long := strings.Repeat("feature-", 10)
+	a := PreviewEnvironmentName("demo", "web", long+"a")
+	b := PreviewEnvironmentName("demo", "web", long+"b")
+	if len(a) > 50 || !valid.MatchString(a) {
+		t.Errorf("PreviewEnvironmentName() = %v, not a valid environment name", a)
+	}
+	if a == b {
+		t.Errorf("truncated names of different branches conflict: %v", a)
+	}
+}
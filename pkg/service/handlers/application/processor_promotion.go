@@ -0,0 +1,242 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	corev1 "k8s.io/api/core/v1"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/git"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type PromotionOptions struct {
+	ProjectID uint   `json:"projectID,omitempty"`
+	From      string `json:"from,omitempty"`
+	To        string `json:"to,omitempty"`
+	// 源环境编排的 git commit,为空时使用最新的提交
+	Revision string `json:"revision,omitempty"`
+}
+
+// NextStage 返回流水线中 from 的下一个环境,不存在时返回空
+func NextStage(stages []string, from string) string {
+	for i, stage := range stages {
+		if stage == from && i+1 < len(stages) {
+			return stages[i+1]
+		}
+	}
+	return ""
+}
+
+// PromotionStages 解析流水线中的环境顺序
+func PromotionStages(pipeline *models.PromotionPipeline) []string {
+	stages := []string{}
+	if pipeline == nil || len(pipeline.Stages) == 0 {
+		return stages
+	}
+	_ = json.Unmarshal(pipeline.Stages, &stages)
+	return stages
+}
+
+// isEnvironmentSpecificFile 环境特有的文件不随提升复制
+func isEnvironmentSpecificFile(filename string) bool {
+	return strings.TrimPrefix(filename, "/") == MetaFilename || isOverlayFile(filename)
+}
+
+// PromoteFilesFunc 使用源环境的编排文件替换目标环境的编排,保留目标环境的 overlay 目录及 .meta.
+// 源环境中对镜像的修改保存在 overlay 中,因此还需要将目标环境中工作负载的镜像设置为源环境中的镜像.
+func PromoteFilesFunc(files []git.CommitFile) RepositoryFileSystemFunc {
+	return func(ctx context.Context, fs billy.Filesystem) error {
+		images, err := workloadImages(ctx, files)
+		if err != nil {
+			return err
+		}
+		if err := removeBaseFiles(fs); err != nil {
+			return err
+		}
+		for _, file := range files {
+			if isEnvironmentSpecificFile(file.Name) {
+				continue
+			}
+			if err := util.WriteFile(fs, file.Name, []byte(file.Content), os.ModePerm); err != nil {
+				return err
+			}
+		}
+		store, err := NewGitFsStoreFromContext(ctx, fs)
+		if err != nil {
+			return err
+		}
+		objects, err := store.ListAll(ctx)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			want, ok := images[workloadKey(obj)]
+			if !ok {
+				continue
+			}
+			updated := false
+			ObjectPodTemplateFunc(obj, func(template *corev1.PodTemplateSpec) {
+				for i, c := range template.Spec.Containers {
+					if image, ok := want[c.Name]; ok && image != c.Image {
+						template.Spec.Containers[i].Image, updated = image, true
+					}
+				}
+			})
+			if !updated {
+				continue
+			}
+			if err := store.Update(ctx, obj); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func workloadKey(obj client.Object) string {
+	return obj.GetObjectKind().GroupVersionKind().Kind + "/" + obj.GetName()
+}
+
+// workloadImages 编排中各工作负载的容器最终使用的镜像
+func workloadImages(ctx context.Context, files []git.CommitFile) (map[string]map[string]string, error) {
+	store, err := NewGitFsStoreFromFiles(ctx, files)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := store.ListAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	images := map[string]map[string]string{}
+	for _, obj := range objects {
+		ObjectPodTemplateFunc(obj, func(template *corev1.PodTemplateSpec) {
+			containers := map[string]string{}
+			for _, c := range template.Spec.Containers {
+				containers[c.Name] = c.Image
+			}
+			images[workloadKey(obj)] = containers
+		})
+	}
+	return images, nil
+}
+
+// LatestRevision 返回编排最新的 git commit
+func (h *ManifestProcessor) LatestRevision(ctx context.Context, ref PathRef) (*git.Commit, error) {
+	var latest *git.Commit
+	err := h.Func(ctx, ref, Pull(), func(ctx context.Context, repository Repository) error {
+		return repository.HistoryFunc(ctx, func(_ context.Context, commit git.Commit) error {
+			latest = &commit
+			return storer.ErrStop
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("no revision of %s found in environment %s", ref.Name, ref.Env)
+	}
+	return latest, nil
+}
+
+// Promote 将源环境中指定版本的编排复制到 ref 所在的环境,并记录提升历史
+func (p *ApplicationProcessor) Promote(ctx context.Context, ref PathRef, opts PromotionOptions) error {
+	record := &models.ApplicationPromotion{
+		ProjectID:       opts.ProjectID,
+		ApplicationName: ref.Name,
+		FromEnvironment: opts.From,
+		ToEnvironment:   ref.Env,
+		Revision:        opts.Revision,
+		Creator:         AuthorFromContext(ctx).Name,
+	}
+	err := p.promote(ctx, ref, opts, record)
+	if err != nil {
+		record.Status, record.Message = models.PromotionStatusFailed, err.Error()
+	} else {
+		record.Status = models.PromotionStatusSuccess
+	}
+	if dberr := p.DataBase.DB.WithContext(ctx).Create(record).Error; dberr != nil {
+		log.FromContextOrDiscard(ctx).Error(dberr, "save promotion record")
+	}
+	return err
+}
+
+func (p *ApplicationProcessor) promote(ctx context.Context, ref PathRef, opts PromotionOptions, record *models.ApplicationPromotion) error {
+	srcref := PathRef{Tenant: ref.Tenant, Project: ref.Project, Env: opts.From, Name: ref.Name}
+	commit, err := p.Manifest.RevisionFiles(ctx, srcref, opts.Revision)
+	if err != nil {
+		return err
+	}
+	record.Revision = commit.Hash
+	record.Images, _ = json.Marshal(parseFilesImages(ctx, commit.Files))
+
+	return p.Manifest.Func(ctx, ref,
+		Pull(),
+		FsFunc(PromoteFilesFunc(commit.Files)),
+		UpdateKustomizeCommit(fmt.Sprintf("promote from %s@%s", opts.From, shortHash(commit.Hash))),
+	)
+}
+
+// RevisionFiles 读取编排在指定 git commit 时的文件,revision 为空时使用最新的提交
+func (h *ManifestProcessor) RevisionFiles(ctx context.Context, ref PathRef, revision string) (*git.Commit, error) {
+	if revision == "" {
+		latest, err := h.LatestRevision(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		revision = latest.Hash
+	}
+	var commit *git.Commit
+	if err := h.Func(ctx, ref, Pull(), func(ctx context.Context, repository Repository) error {
+		c, err := repository.HistoryFiles(ctx, revision)
+		commit = c
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("read %s at %s: %w", ref.Name, revision, err)
+	}
+	if len(commit.Files) == 0 {
+		return nil, fmt.Errorf("no manifest files of %s found in environment %s", ref.Name, ref.Env)
+	}
+	return commit, nil
+}
+
+func parseFilesImages(ctx context.Context, files []git.CommitFile) []string {
+	images := []string{}
+	store, err := NewGitFsStoreFromFiles(ctx, files)
+	if err != nil {
+		return images
+	}
+	objects, _ := store.ListAll(ctx)
+	for _, obj := range objects {
+		images = append(images, ParseImagesFrom(obj)...)
+	}
+	return images
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 8 {
+		return hash[:8]
+	}
+	return hash
+}
@@ -0,0 +1,139 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"kubegems.io/kubegems/pkg/utils/git"
+)
+
+func TestNextStage(t *testing.T) {
+	stages := []string{"dev", "staging", "prod"}
+	tests := []struct {
+		from string
+		want string
+	}{
+		{from: "dev", want: "staging"},
+		{from: "staging", want: "prod"},
+		{from: "prod", want: ""},
+		{from: "unknown", want: ""},
+	}
+	for _, tt := range tests {
+		if got := NextStage(stages, tt.from); got != tt.want {
+			t.Errorf("NextStage(%s) = %v, want %v", tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestPromoteFilesFunc(t *testing.T) {
+	fs := memfs.New()
+	for name, content := range map[string]string{
+		"deployment.yaml":         "old",
+		"removed.yaml":            "old",
+		MetaFilename:              "dst-meta",
+		"overlay/replicas.yaml":   "dst-overlay",
+		"kustomization.yaml":      "old",
+		"configs/configmap.yaml":  "old",
+		"configs/deprecated.yaml": "old",
+	} {
+		if err := util.WriteFile(fs, name, []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := []git.CommitFile{
+		{Name: "deployment.yaml", Content: "new"},
+		{Name: "kustomization.yaml", Content: "new"},
+		{Name: "configs/configmap.yaml", Content: "new"},
+		{Name: MetaFilename, Content: "src-meta"},
+		{Name: "overlay/replicas.yaml", Content: "src-overlay"},
+	}
+	if err := PromoteFilesFunc(files)(context.Background(), fs); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"deployment.yaml":        "new",
+		"kustomization.yaml":     "new",
+		"configs/configmap.yaml": "new",
+		MetaFilename:             "dst-meta",
+		"overlay/replicas.yaml":  "dst-overlay",
+	}
+	got := map[string]string{}
+	if err := ForFileContentFunc(fs, "", func(filename string, content []byte) error {
+		got[filename] = string(content)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		names := []string{}
+		for name := range got {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		t.Fatalf("files after promotion = %v, want %d files", names, len(want))
+	}
+	for name, content := range want {
+		if got[name] != content {
+			t.Errorf("file %s = %q, want %q", name, got[name], content)
+		}
+	}
+}
+
+func TestPromoteFilesFuncWithBase(t *testing.T) {
+	const cloneurl = "http://git.example.com/tenant/project.git"
+	ctx := WithBaseLoader(context.Background(), cloneurl, func(_ context.Context, _, _ string) ([]git.CommitFile, error) {
+		return []git.CommitFile{{Name: "deployment.yaml", Content: testDeployment}}, nil
+	})
+	srcpatch := strings.Replace(testReplicasPatch, "replicas: 3", "template:\n    spec:\n      containers:\n      - name: app\n        image: nginx:2.0", 1)
+	files := []git.CommitFile{
+		{Name: KustimizationFilename, Content: "resources:\n- " + BaseResource(cloneurl, "app", "new") + "\n"},
+		{Name: "overlay/deployment-app.yaml", Content: srcpatch},
+	}
+
+	fs := memfs.New()
+	if err := SetBaseReference(fs, BaseResource(cloneurl, "app", "old")); err != nil {
+		t.Fatal(err)
+	}
+	_ = util.WriteFile(fs, "overlay/replicas.yaml", []byte(testReplicasPatch), os.ModePerm)
+	if err := PromoteFilesFunc(files)(ctx, fs); err != nil {
+		t.Fatal(err)
+	}
+
+	if baseref := FindBaseReference(fs, cloneurl); baseref == nil || baseref.Revision != "new" {
+		t.Errorf("base reference = %v, want revision new", baseref)
+	}
+	store, err := NewGitFsStoreFromContext(ctx, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployment, err := ParseMainDeployment(ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image := deployment.Spec.Template.Spec.Containers[0].Image; image != "nginx:2.0" {
+		t.Errorf("image = %s, want image of source environment nginx:2.0", image)
+	}
+	if *deployment.Spec.Replicas != 3 {
+		t.Errorf("replicas = %d, want replicas of target environment 3", *deployment.Spec.Replicas)
+	}
+}
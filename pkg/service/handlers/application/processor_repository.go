@@ -54,6 +54,10 @@ func (h *ManifestProcessor) Func(ctx context.Context, ref PathRef, funcs ...Repo
 		return err
 	}
 	repo := &Repository{path: gitref.Path, repo: gitrepo}
+	// 环境中的编排引用基础编排分支中的文件
+	if gitref.Branch != BaseEnv {
+		ctx = WithBaseLoader(ctx, gitrepo.CloneURL(), h.baseFilesFunc(ref))
+	}
 
 	for _, f := range funcs {
 		if err := f(ctx, *repo); err != nil {
@@ -63,6 +67,27 @@ func (h *ManifestProcessor) Func(ctx context.Context, ref PathRef, funcs ...Repo
 	return nil
 }
 
+func (h *ManifestProcessor) baseFilesFunc(ref PathRef) BaseFilesFunc {
+	baseref := PathRef{Tenant: ref.Tenant, Project: ref.Project}
+	return func(ctx context.Context, path, revision string) ([]git.CommitFile, error) {
+		gitrepo, err := h.GitProvider.Get(ctx, baseref.GitRef())
+		if err != nil {
+			return nil, err
+		}
+		commit, err := gitrepo.HistoryFiles(ctx, path, revision)
+		if err != nil {
+			// 本地缓存的基础编排分支可能还没有该提交
+			if pullerr := gitrepo.Pull(ctx); pullerr != nil {
+				return nil, err
+			}
+			if commit, err = gitrepo.HistoryFiles(ctx, path, revision); err != nil {
+				return nil, err
+			}
+		}
+		return commit.Files, nil
+	}
+}
+
 type RepositoryFileSystemFunc func(ctx context.Context, fs billy.Filesystem) error
 
 func FsFunc(funcs ...RepositoryFileSystemFunc) RepositoryFunc {
@@ -113,7 +138,10 @@ type GitStore = *FsStore
 
 func FSStoreFunc(funcs ...func(ctx context.Context, store GitStore) error) RepositoryFileSystemFunc {
 	return (func(ctx context.Context, fs billy.Filesystem) error {
-		store := NewGitFsStore(fs)
+		store, err := NewGitFsStoreFromContext(ctx, fs)
+		if err != nil {
+			return err
+		}
 		for _, f := range funcs {
 			if err := f(ctx, store); err != nil {
 				return err
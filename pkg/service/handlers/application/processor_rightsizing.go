@@ -0,0 +1,355 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	prommodel "github.com/prometheus/common/model"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/slice"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	RightSizingOverProvisioned  = "over-provisioned"
+	RightSizingUnderProvisioned = "under-provisioned"
+	RightSizingOptimal          = "optimal"
+
+	defaultRightSizingWindow     = "7d"
+	defaultRightSizingPercentile = 0.95
+	defaultRightSizingHeadroom   = 0.2
+
+	// 建议值低于当前 request 的该比例时认为资源过量
+	rightSizingOverRatio = 0.7
+	// 每月按 730 小时估算节省的费用
+	rightSizingHoursPerMonth = 730
+
+	// 每个 pod 中容器在窗口内的百分位用量, 取同一工作负载所有 pod 中的最大值
+	rightSizingCPUUsage    = `max(quantile_over_time(%[2]v, gems_container_cpu_usage_cores{namespace="%[1]s", owner_kind=~"Deployment|StatefulSet|DaemonSet", container!~"istio-proxy|"}[%[3]s:5m]))by(owner_kind, workload, container)`
+	rightSizingMemoryUsage = `max(quantile_over_time(%[2]v, gems_container_memory_usage_bytes{namespace="%[1]s", owner_kind=~"Deployment|StatefulSet|DaemonSet", container!~"istio-proxy|"}[%[3]s:5m]))by(owner_kind, workload, container)`
+)
+
+var (
+	minRightSizingCPU    = resource.MustParse("10m")
+	minRightSizingMemory = resource.MustParse("32Mi")
+)
+
+// RightSizingOptions 资源建议的计算参数
+type RightSizingOptions struct {
+	// 统计窗口, 如 7d
+	Window string `form:"window" json:"window"`
+	// 使用量的百分位, 0-1
+	Percentile float64 `form:"percentile" json:"percentile"`
+	// 在百分位用量上预留的余量比例
+	Headroom float64 `form:"headroom" json:"headroom"`
+}
+
+func (o *RightSizingOptions) Default() error {
+	if o.Window == "" {
+		o.Window = defaultRightSizingWindow
+	}
+	if _, err := prommodel.ParseDuration(o.Window); err != nil {
+		return fmt.Errorf("invalid window %s: %w", o.Window, err)
+	}
+	if o.Percentile == 0 {
+		o.Percentile = defaultRightSizingPercentile
+	}
+	if o.Percentile <= 0 || o.Percentile > 1 {
+		return fmt.Errorf("percentile must be in (0, 1]")
+	}
+	if o.Headroom == 0 {
+		o.Headroom = defaultRightSizingHeadroom
+	}
+	if o.Headroom < 0 {
+		return fmt.Errorf("headroom can't be negative")
+	}
+	return nil
+}
+
+type RightSizingReport struct {
+	RightSizingOptions
+	Workloads []WorkloadRightSizing `json:"workloads"`
+	// 全部采用建议后每月预计节省的费用, 负数表示需要增加的费用
+	MonthlySavings float64 `json:"monthlySavings"`
+}
+
+type WorkloadRightSizing struct {
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+	Replicas int32  `json:"replicas"`
+	Status   string `json:"status"`
+	// 由应用部署管理的工作负载才能一键应用建议
+	Applicable     bool                   `json:"applicable"`
+	MonthlySavings float64                `json:"monthlySavings"`
+	Containers     []ContainerRightSizing `json:"containers"`
+
+	ref string
+}
+
+type ContainerRightSizing struct {
+	Name string `json:"name"`
+	// 百分位 cpu 用量(核)和内存用量(字节)
+	CPUUsage     float64                     `json:"cpuUsage"`
+	MemoryUsage  float64                     `json:"memoryUsage"`
+	CPUStatus    string                      `json:"cpuStatus"`
+	MemoryStatus string                      `json:"memoryStatus"`
+	Current      corev1.ResourceRequirements `json:"current"`
+	Recommended  corev1.ResourceRequirements `json:"recommended"`
+}
+
+type rightSizingTarget struct {
+	Kind       string
+	Name       string
+	Replicas   int32
+	Ref        string
+	Containers []corev1.Container
+}
+
+type containerUsage struct {
+	CPU    *float64
+	Memory *float64
+}
+
+func containerUsageKey(kind, name, container string) string {
+	return kind + "/" + name + "/" + container
+}
+
+// RightSizing 根据环境中工作负载的历史用量计算资源建议
+func (p *ApplicationProcessor) RightSizing(ctx context.Context, opts RightSizingOptions) (*RightSizingReport, error) {
+	if err := opts.Default(); err != nil {
+		return nil, err
+	}
+	cluster, namespace := ClusterNamespaceFromCtx(ctx)
+	if cluster == "" || namespace == "" {
+		return nil, fmt.Errorf("empty cluster or namespace")
+	}
+	cli, err := p.Agents.ClientOf(ctx, cluster)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := listRightSizingTargets(ctx, cli, namespace)
+	if err != nil {
+		return nil, err
+	}
+	usages := map[string]*containerUsage{}
+	for _, q := range []struct {
+		expr string
+		set  func(u *containerUsage, v float64)
+	}{
+		{expr: rightSizingCPUUsage, set: func(u *containerUsage, v float64) { u.CPU = &v }},
+		{expr: rightSizingMemoryUsage, set: func(u *containerUsage, v float64) { u.Memory = &v }},
+	} {
+		vector, err := cli.Extend().PrometheusVector(ctx, fmt.Sprintf(q.expr, namespace, opts.Percentile, opts.Window))
+		if err != nil {
+			return nil, err
+		}
+		for _, sample := range vector {
+			value := float64(sample.Value)
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+			// workload 标签为 Deployment:nginx
+			kind, name, ok := strings.Cut(string(sample.Metric["workload"]), ":")
+			if !ok {
+				continue
+			}
+			key := containerUsageKey(kind, name, string(sample.Metric["container"]))
+			if usages[key] == nil {
+				usages[key] = &containerUsage{}
+			}
+			q.set(usages[key], value)
+		}
+	}
+	prices := []models.CostPrice{}
+	if err := p.DataBase.DB.WithContext(ctx).Find(&prices).Error; err != nil {
+		return nil, err
+	}
+	return calculateRightSizing(targets, usages, opts, models.MatchNodePrice(prices, cluster, nil)), nil
+}
+
+func listRightSizingTargets(ctx context.Context, cli client.Client, namespace string) ([]rightSizingTarget, error) {
+	targets := []rightSizingTarget{}
+	deployments := &appsv1.DeploymentList{}
+	if err := cli.List(ctx, deployments, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for _, item := range deployments.Items {
+		targets = append(targets, rightSizingTarget{
+			Kind: "Deployment", Name: item.Name, Replicas: item.Status.Replicas,
+			Ref: item.Annotations[AnnotationRef], Containers: item.Spec.Template.Spec.Containers,
+		})
+	}
+	statefulsets := &appsv1.StatefulSetList{}
+	if err := cli.List(ctx, statefulsets, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for _, item := range statefulsets.Items {
+		targets = append(targets, rightSizingTarget{
+			Kind: "StatefulSet", Name: item.Name, Replicas: item.Status.Replicas,
+			Ref: item.Annotations[AnnotationRef], Containers: item.Spec.Template.Spec.Containers,
+		})
+	}
+	daemonsets := &appsv1.DaemonSetList{}
+	if err := cli.List(ctx, daemonsets, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for _, item := range daemonsets.Items {
+		targets = append(targets, rightSizingTarget{
+			Kind: "DaemonSet", Name: item.Name, Replicas: item.Status.DesiredNumberScheduled,
+			Ref: item.Annotations[AnnotationRef], Containers: item.Spec.Template.Spec.Containers,
+		})
+	}
+	return targets, nil
+}
+
+func calculateRightSizing(targets []rightSizingTarget, usages map[string]*containerUsage, opts RightSizingOptions, price *models.CostPrice) *RightSizingReport {
+	report := &RightSizingReport{RightSizingOptions: opts, Workloads: []WorkloadRightSizing{}}
+	for _, target := range targets {
+		workload := WorkloadRightSizing{
+			Kind:       target.Kind,
+			Name:       target.Name,
+			Replicas:   target.Replicas,
+			Status:     RightSizingOptimal,
+			Applicable: target.Ref != "",
+			ref:        target.Ref,
+		}
+		for _, container := range target.Containers {
+			usage, ok := usages[containerUsageKey(target.Kind, target.Name, container.Name)]
+			// 没有完整用量数据的容器不做建议
+			if !ok || usage.CPU == nil || usage.Memory == nil {
+				continue
+			}
+			cs := ContainerRightSizing{
+				Name:        container.Name,
+				CPUUsage:    *usage.CPU,
+				MemoryUsage: *usage.Memory,
+				Current:     container.Resources,
+				Recommended: *container.Resources.DeepCopy(),
+			}
+			cpu := recommendQuantity(*usage.CPU, opts.Headroom, minRightSizingCPU, true)
+			memory := recommendQuantity(*usage.Memory, opts.Headroom, minRightSizingMemory, false)
+			cs.CPUStatus = recommendResource(&cs.Recommended, corev1.ResourceCPU, cpu, *usage.CPU)
+			cs.MemoryStatus = recommendResource(&cs.Recommended, corev1.ResourceMemory, memory, *usage.Memory)
+
+			if price != nil {
+				cpuDelta := cs.Current.Requests.Cpu().AsApproximateFloat64() - cs.Recommended.Requests.Cpu().AsApproximateFloat64()
+				memDelta := (cs.Current.Requests.Memory().AsApproximateFloat64() - cs.Recommended.Requests.Memory().AsApproximateFloat64()) / (1 << 30)
+				workload.MonthlySavings += (cpuDelta*price.CPUCoreHour + memDelta*price.MemoryGiBHour) * float64(target.Replicas) * rightSizingHoursPerMonth
+			}
+			workload.Status = mergeRightSizingStatus(workload.Status, cs.CPUStatus, cs.MemoryStatus)
+			workload.Containers = append(workload.Containers, cs)
+		}
+		if len(workload.Containers) == 0 {
+			continue
+		}
+		report.MonthlySavings += workload.MonthlySavings
+		report.Workloads = append(report.Workloads, workload)
+	}
+	sort.SliceStable(report.Workloads, func(i, j int) bool {
+		return report.Workloads[i].MonthlySavings > report.Workloads[j].MonthlySavings
+	})
+	return report
+}
+
+// recommendQuantity 在用量上加上余量, cpu 向上取整到 1m, 内存向上取整到 1Mi
+func recommendQuantity(usage, headroom float64, min resource.Quantity, cpu bool) resource.Quantity {
+	value := usage * (1 + headroom)
+	var q resource.Quantity
+	if cpu {
+		q = *resource.NewMilliQuantity(int64(math.Ceil(value*1000)), resource.DecimalSI)
+	} else {
+		q = *resource.NewQuantity(int64(math.Ceil(value/(1<<20)))<<20, resource.BinarySI)
+	}
+	if q.Cmp(min) < 0 {
+		return min.DeepCopy()
+	}
+	return q
+}
+
+// recommendResource 设置建议的 request, 有 limit 时按原来的 limit/request 比例调整, 返回当前 request 的状态
+func recommendResource(resources *corev1.ResourceRequirements, name corev1.ResourceName, recommended resource.Quantity, usage float64) string {
+	current, hasRequest := resources.Requests[name]
+	status := RightSizingOptimal
+	switch {
+	case !hasRequest || current.IsZero() || usage > current.AsApproximateFloat64():
+		status = RightSizingUnderProvisioned
+	case recommended.AsApproximateFloat64() < current.AsApproximateFloat64()*rightSizingOverRatio:
+		status = RightSizingOverProvisioned
+	}
+	if status == RightSizingOptimal {
+		return status
+	}
+	if resources.Requests == nil {
+		resources.Requests = corev1.ResourceList{}
+	}
+	resources.Requests[name] = recommended
+	if limit, ok := resources.Limits[name]; ok && !limit.IsZero() {
+		newlimit := recommended.DeepCopy()
+		if hasRequest && !current.IsZero() {
+			ratio := limit.AsApproximateFloat64() / current.AsApproximateFloat64()
+			if name == corev1.ResourceCPU {
+				newlimit = *resource.NewMilliQuantity(int64(math.Ceil(float64(recommended.MilliValue())*ratio)), resource.DecimalSI)
+			} else {
+				newlimit = *resource.NewQuantity(int64(math.Ceil(float64(recommended.Value())*ratio/(1<<20)))<<20, resource.BinarySI)
+			}
+		}
+		if newlimit.Cmp(recommended) < 0 {
+			newlimit = recommended.DeepCopy()
+		}
+		resources.Limits[name] = newlimit
+	}
+	return status
+}
+
+// mergeRightSizingStatus 只要有资源不足即为不足, 其次为过量
+func mergeRightSizingStatus(statuses ...string) string {
+	ret := RightSizingOptimal
+	for _, status := range statuses {
+		switch status {
+		case RightSizingUnderProvisioned:
+			return RightSizingUnderProvisioned
+		case RightSizingOverProvisioned:
+			ret = RightSizingOverProvisioned
+		}
+	}
+	return ret
+}
+
+// RightSizingSuggestion 由工作负载的资源建议生成 ResourceSuggestion, containers 为空时应用全部容器
+func RightSizingSuggestion(workload WorkloadRightSizing, containers []string) ResourceSuggestion {
+	suggestion := ResourceSuggestion{}
+	suggestion.APIVersion = appsv1.SchemeGroupVersion.String()
+	suggestion.Kind = workload.Kind
+	suggestion.Name = workload.Name
+	suggestion.Annotations = map[string]string{AnnotationRef: workload.ref}
+	for _, container := range workload.Containers {
+		if len(containers) > 0 && !slice.ContainStr(containers, container.Name) {
+			continue
+		}
+		suggestion.Spec.Template.Spec.Containers = append(suggestion.Spec.Template.Spec.Containers, Container{
+			Name:      container.Name,
+			Resources: container.Recommended,
+		})
+	}
+	return suggestion
+}
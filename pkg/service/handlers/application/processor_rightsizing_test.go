@@ -0,0 +1,154 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"math"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"kubegems.io/kubegems/pkg/service/models"
+)
+
+func TestCalculateRightSizing(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	resources := func(cpuReq, memReq, cpuLimit, memLimit string) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpuReq), corev1.ResourceMemory: resource.MustParse(memReq)},
+			Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpuLimit), corev1.ResourceMemory: resource.MustParse(memLimit)},
+		}
+	}
+	targets := []rightSizingTarget{
+		{
+			Kind: "Deployment", Name: "big", Replicas: 2, Ref: "ref",
+			Containers: []corev1.Container{{Name: "app", Resources: resources("2", "4Gi", "4", "8Gi")}},
+		},
+		{
+			Kind: "Deployment", Name: "small", Replicas: 1,
+			Containers: []corev1.Container{{Name: "app", Resources: resources("100m", "128Mi", "200m", "256Mi")}},
+		},
+		{
+			Kind: "StatefulSet", Name: "fit", Replicas: 1,
+			Containers: []corev1.Container{{Name: "db", Resources: resources("1", "1Gi", "1", "1Gi")}},
+		},
+		{
+			Kind: "DaemonSet", Name: "nodata", Replicas: 3,
+			Containers: []corev1.Container{{Name: "agent"}},
+		},
+	}
+	usages := map[string]*containerUsage{
+		containerUsageKey("Deployment", "big", "app"):     {CPU: f(0.5), Memory: f(1 << 30)},
+		containerUsageKey("Deployment", "small", "app"):   {CPU: f(0.3), Memory: f(100 << 20)},
+		containerUsageKey("StatefulSet", "fit", "db"):     {CPU: f(0.8), Memory: f(800 << 20)},
+		containerUsageKey("DaemonSet", "nodata", "agent"): {CPU: f(0.1)},
+	}
+	opts := RightSizingOptions{}
+	if err := opts.Default(); err != nil {
+		t.Fatal(err)
+	}
+	price := &models.CostPrice{CPUCoreHour: 0.1, MemoryGiBHour: 0.01}
+	report := calculateRightSizing(targets, usages, opts, price)
+	if len(report.Workloads) != 3 {
+		t.Fatalf("expect 3 workloads, got %d", len(report.Workloads))
+	}
+
+	big := report.Workloads[0]
+	if big.Name != "big" || big.Status != RightSizingOverProvisioned || !big.Applicable {
+		t.Errorf("unexpected workload %+v", big)
+	}
+	container := big.Containers[0]
+	if got := container.Recommended.Requests.Cpu().String(); got != "600m" {
+		t.Errorf("cpu request = %s, want 600m", got)
+	}
+	if got := container.Recommended.Limits.Cpu().String(); got != "1200m" {
+		t.Errorf("cpu limit = %s, want 1200m", got)
+	}
+	// 1Gi * 1.2 向上取整到 Mi
+	if got := container.Recommended.Requests.Memory().Value(); got != 1229<<20 {
+		t.Errorf("memory request = %d, want %d", got, 1229<<20)
+	}
+	// (2 - 0.6) * 0.1 + (4 - 1229/1024) * 0.01, 2 副本, 730 小时
+	want := (1.4*0.1 + (4-1229.0/1024)*0.01) * 2 * 730
+	if math.Abs(big.MonthlySavings-want) > 0.01 {
+		t.Errorf("savings = %v, want %v", big.MonthlySavings, want)
+	}
+
+	statuses := map[string]string{}
+	for _, w := range report.Workloads {
+		statuses[w.Name] = w.Status
+	}
+	if statuses["small"] != RightSizingUnderProvisioned {
+		t.Errorf("small status = %s", statuses["small"])
+	}
+	if statuses["fit"] != RightSizingOptimal {
+		t.Errorf("fit status = %s", statuses["fit"])
+	}
+	if report.Workloads[2].MonthlySavings >= 0 {
+		t.Errorf("under-provisioned workload should cost more, got %v", report.Workloads[2].MonthlySavings)
+	}
+
+	suggestion := RightSizingSuggestion(big, nil)
+	if suggestion.Kind != "Deployment" || suggestion.Annotations[AnnotationRef] != "ref" || len(suggestion.Spec.Template.Spec.Containers) != 1 {
+		t.Errorf("unexpected suggestion %+v", suggestion)
+	}
+	if got := RightSizingSuggestion(big, []string{"sidecar"}); len(got.Spec.Template.Spec.Containers) != 0 {
+		t.Errorf("unexpected containers %v", got.Spec.Template.Spec.Containers)
+	}
+}
+
+func TestRightSizingOptionsDefault(t *testing.T) {
+	for _, opts := range []RightSizingOptions{
+		{Window: "7days"},
+		{Percentile: 1.5},
+		{Headroom: -1},
+	} {
+		if err := opts.Default(); err == nil {
+			t.Errorf("expect error for %+v", opts)
+		}
+	}
+}
+
+func TestSuggestionRef(t *testing.T) {
+	envref := PathRef{Tenant: "t", Project: "p", Env: "dev"}
+	suggestion := func(ref PathRef) ResourceSuggestion {
+		s := ResourceSuggestion{}
+		s.Name = "web"
+		s.Annotations = map[string]string{AnnotationRef: ref.JsonStringBase64()}
+		return s
+	}
+	tests := []struct {
+		name    string
+		ref     PathRef
+		wantErr bool
+	}{
+		{name: "same environment", ref: PathRef{Tenant: "t", Project: "p", Env: "dev", Name: "web"}},
+		{name: "other environment", ref: PathRef{Tenant: "t", Project: "p", Env: "prod", Name: "web"}, wantErr: true},
+		{name: "other project", ref: PathRef{Tenant: "t", Project: "other", Env: "dev", Name: "web"}, wantErr: true},
+		{name: "no application", ref: PathRef{Tenant: "t", Project: "p", Env: "dev"}, wantErr: true},
+		{name: "no ref", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := suggestionRef(suggestion(tt.ref), envref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("suggestionRef() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.ref {
+				t.Errorf("suggestionRef() = %v, want %v", got, tt.ref)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/workflow"
+)
+
+const (
+	TaskFunction_Application_SyncWave    = "application_sync_wave"
+	TaskFunction_Application_CloneFrom   = "application_clone_from"
+	TaskFunction_Application_CreateBatch = "application_create_batch"
+
+	// 批量部署和环境克隆的任务类型
+	TaskTypeSyncWaves = "sync-waves"
+
+	DefaultSyncWaveHealthTimeout = 10 * time.Minute
+	syncWaveCheckInterval        = 5 * time.Second
+)
+
+// SyncWaves 按照应用之间的依赖将 names 分为多个批次,同一批次内的应用互不依赖可以同时部署.
+// 不在 names 中的依赖认为已经部署,不参与排序
+func SyncWaves(names []string, dependencies map[string][]string) ([][]string, error) {
+	indegree := map[string]int{}
+	for _, name := range names {
+		indegree[name] = 0
+	}
+	dependents := map[string][]string{}
+	for name := range indegree {
+		seen := map[string]bool{}
+		for _, dep := range dependencies[name] {
+			if dep == name {
+				return nil, fmt.Errorf("application %s depends on itself", name)
+			}
+			if _, ok := indegree[dep]; !ok || seen[dep] {
+				continue
+			}
+			seen[dep] = true
+			indegree[name]++
+			dependents[dep] = append(dependents[dep], name)
+		}
+	}
+
+	waves := [][]string{}
+	current := []string{}
+	for name, degree := range indegree {
+		if degree == 0 {
+			current = append(current, name)
+		}
+	}
+	done := 0
+	for len(current) > 0 {
+		sort.Strings(current)
+		waves = append(waves, current)
+		done += len(current)
+		next := []string{}
+		for _, name := range current {
+			for _, dependent := range dependents[name] {
+				if indegree[dependent]--; indegree[dependent] == 0 {
+					next = append(next, dependent)
+				}
+			}
+		}
+		current = next
+	}
+	if done != len(indegree) {
+		cycle := []string{}
+		for name, degree := range indegree {
+			if degree > 0 {
+				cycle = append(cycle, name)
+			}
+		}
+		sort.Strings(cycle)
+		return nil, fmt.Errorf("circular dependency between applications %s", strings.Join(cycle, ","))
+	}
+	return waves, nil
+}
+
+// ValidateDependencies 检查依赖中是否存在循环
+func ValidateDependencies(dependencies map[string][]string) error {
+	names := []string{}
+	for name, deps := range dependencies {
+		names = append(names, name)
+		names = append(names, deps...)
+	}
+	_, err := SyncWaves(names, dependencies)
+	return err
+}
+
+// ApplicationDependencies 解析项目下应用的依赖
+func ApplicationDependencies(graph *models.ApplicationDependencyGraph) map[string][]string {
+	dependencies := map[string][]string{}
+	if graph == nil || len(graph.Dependencies) == 0 {
+		return dependencies
+	}
+	_ = json.Unmarshal(graph.Dependencies, &dependencies)
+	return dependencies
+}
+
+// SyncWaveHealthTimeout 每个批次等待应用健康的超时时间
+func SyncWaveHealthTimeout(graph *models.ApplicationDependencyGraph) time.Duration {
+	if graph == nil || graph.HealthTimeout <= 0 {
+		return DefaultSyncWaveHealthTimeout
+	}
+	return time.Duration(graph.HealthTimeout) * time.Second
+}
+
+// SyncWavesSteps 每个批次对应任务中的一个步骤,任务的步骤状态即批次的进度
+func SyncWavesSteps(ref PathRef, waves [][]string, timeout time.Duration) []workflow.Step {
+	steps := make([]workflow.Step, 0, len(waves))
+	for i, wave := range waves {
+		steps = append(steps, workflow.Step{
+			Name:     fmt.Sprintf("wave-%d(%s)", i, strings.Join(wave, ",")),
+			Function: TaskFunction_Application_SyncWave,
+			Args:     workflow.ArgsOf(ref, wave, int(timeout.Seconds())),
+		})
+	}
+	return steps
+}
+
+// SyncWave 同步一个批次中的应用,并等待批次中的应用全部 Healthy 且 Synced,未就绪时不进入下一个批次
+func (p *ApplicationProcessor) SyncWave(ctx context.Context, ref PathRef, names []string, timeoutSeconds int) error {
+	for _, name := range names {
+		appref := PathRef{Tenant: ref.Tenant, Project: ref.Project, Env: ref.Env, Name: name}
+		if err := p.Sync(ctx, appref); err != nil {
+			return err
+		}
+	}
+	timeout := DefaultSyncWaveHealthTimeout
+	if timeoutSeconds > 0 {
+		timeout = time.Duration(timeoutSeconds) * time.Second
+	}
+	return p.WaitApplicationsHealthy(ctx, ref, names, timeout)
+}
+
+// WaitApplicationsHealthy 等待环境中的应用同步完成且健康,同步失败时立即返回
+func (p *ApplicationProcessor) WaitApplicationsHealthy(ctx context.Context, ref PathRef, names []string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pending := names
+	for {
+		notready := []string{}
+		messages := []string{}
+		for _, name := range pending {
+			appref := PathRef{Tenant: ref.Tenant, Project: ref.Project, Env: ref.Env, Name: name}
+			app, err := p.Argo.GetArgoApp(ctx, appref.FullName())
+			if err != nil {
+				notready, messages = append(notready, name), append(messages, fmt.Sprintf("%s: %v", name, err))
+				continue
+			}
+			ready, err := ArgoAppReady(app)
+			if err != nil {
+				return fmt.Errorf("application %s: %w", name, err)
+			}
+			if !ready {
+				notready = append(notready, name)
+				messages = append(messages, fmt.Sprintf("%s: %s/%s", name, app.Status.Health.Status, app.Status.Sync.Status))
+			}
+		}
+		if len(notready) == 0 {
+			return nil
+		}
+		pending = notready
+		log.FromContextOrDiscard(ctx).Info("waiting applications healthy", "environment", ref.Env, "applications", messages)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("wait applications healthy timeout after %s: %s", timeout, strings.Join(messages, "; "))
+		case <-time.After(syncWaveCheckInterval):
+		}
+	}
+}
+
+// ArgoAppReady 应用的同步操作已经完成且状态为 Healthy/Synced,同步操作失败时返回错误
+func ArgoAppReady(app *v1alpha1.Application) (bool, error) {
+	if app.Operation != nil {
+		return false, nil
+	}
+	if state := app.Status.OperationState; state != nil {
+		if !state.Phase.Completed() {
+			return false, nil
+		}
+		if !state.Phase.Successful() {
+			return false, fmt.Errorf("sync %s: %s", state.Phase, state.Message)
+		}
+	}
+	return app.Status.Health.Status == argoHealthStatusHealthy && app.Status.Sync.Status == v1alpha1.SyncStatusCodeSynced, nil
+}
+
+// CloneFrom 将源环境中应用最新的编排复制到 ref 所在的环境,保留目标环境中已有的 overlay 目录
+func (p *ApplicationProcessor) CloneFrom(ctx context.Context, ref PathRef, from string, names []string) error {
+	for _, name := range names {
+		srcref := PathRef{Tenant: ref.Tenant, Project: ref.Project, Env: from, Name: name}
+		dstref := PathRef{Tenant: ref.Tenant, Project: ref.Project, Env: ref.Env, Name: name}
+		commit, err := p.Manifest.RevisionFiles(ctx, srcref, "")
+		if err != nil {
+			return err
+		}
+		if err := p.Manifest.Func(ctx, dstref,
+			Pull(),
+			FsFunc(PromoteFilesFunc(commit.Files)),
+			UpdateKustomizeCommit(fmt.Sprintf("clone from %s@%s", from, shortHash(commit.Hash))),
+		); err != nil {
+			return fmt.Errorf("clone %s: %w", name, err)
+		}
+	}
+	return nil
+}
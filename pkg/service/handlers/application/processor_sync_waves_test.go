@@ -0,0 +1,127 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	health "github.com/argoproj/gitops-engine/pkg/health"
+	synccommon "github.com/argoproj/gitops-engine/pkg/sync/common"
+)
+
+func TestSyncWaves(t *testing.T) {
+	dependencies := map[string][]string{
+		"api":    {"mysql", "redis"},
+		"web":    {"api"},
+		"worker": {"mysql", "mysql"},
+	}
+	tests := []struct {
+		name         string
+		names        []string
+		dependencies map[string][]string
+		want         [][]string
+		wantErr      bool
+	}{
+		{
+			name:         "layered",
+			names:        []string{"web", "worker", "api", "redis", "mysql", "docs"},
+			dependencies: dependencies,
+			want:         [][]string{{"docs", "mysql", "redis"}, {"api", "worker"}, {"web"}},
+		},
+		{
+			name:         "dependencies not in batch are ignored",
+			names:        []string{"web", "api"},
+			dependencies: dependencies,
+			want:         [][]string{{"api"}, {"web"}},
+		},
+		{
+			name:  "no dependencies",
+			names: []string{"b", "a"},
+			want:  [][]string{{"a", "b"}},
+		},
+		{
+			name:         "cycle",
+			names:        []string{"a", "b", "c"},
+			dependencies: map[string][]string{"a": {"b"}, "b": {"a"}, "c": {"a"}},
+			wantErr:      true,
+		},
+		{
+			name:         "self dependency",
+			names:        []string{"a"},
+			dependencies: map[string][]string{"a": {"a"}},
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SyncWaves(tt.names, tt.dependencies)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SyncWaves() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SyncWaves() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateDependencies(t *testing.T) {
+	if err := ValidateDependencies(map[string][]string{"api": {"mysql"}, "web": {"api"}}); err != nil {
+		t.Errorf("ValidateDependencies() unexpected error = %v", err)
+	}
+	if err := ValidateDependencies(map[string][]string{"api": {"web"}, "web": {"api"}}); err == nil {
+		t.Errorf("ValidateDependencies() expected error of circular dependency")
+	}
+}
+
+func TestArgoAppReady(t *testing.T) {
+	app := func(op bool, phase synccommon.OperationPhase, healthstatus health.HealthStatusCode, sync v1alpha1.SyncStatusCode) *v1alpha1.Application {
+		a := &v1alpha1.Application{}
+		if op {
+			a.Operation = &v1alpha1.Operation{}
+		}
+		if phase != "" {
+			a.Status.OperationState = &v1alpha1.OperationState{Phase: phase}
+		}
+		a.Status.Health.Status = healthstatus
+		a.Status.Sync.Status = sync
+		return a
+	}
+	tests := []struct {
+		name      string
+		app       *v1alpha1.Application
+		wantReady bool
+		wantErr   bool
+	}{
+		{name: "ready", app: app(false, synccommon.OperationSucceeded, health.HealthStatusHealthy, v1alpha1.SyncStatusCodeSynced), wantReady: true},
+		{name: "operation pending", app: app(true, synccommon.OperationSucceeded, health.HealthStatusHealthy, v1alpha1.SyncStatusCodeSynced)},
+		{name: "syncing", app: app(false, synccommon.OperationRunning, health.HealthStatusHealthy, v1alpha1.SyncStatusCodeSynced)},
+		{name: "progressing", app: app(false, synccommon.OperationSucceeded, health.HealthStatusProgressing, v1alpha1.SyncStatusCodeSynced)},
+		{name: "sync failed", app: app(false, synccommon.OperationFailed, health.HealthStatusHealthy, v1alpha1.SyncStatusCodeOutOfSync), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ready, err := ArgoAppReady(tt.app)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ArgoAppReady() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if ready != tt.wantReady {
+				t.Errorf("ArgoAppReady() = %v, want %v", ready, tt.wantReady)
+			}
+		})
+	}
+}
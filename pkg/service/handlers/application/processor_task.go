@@ -31,8 +31,13 @@ type TaskProcessor struct {
 }
 
 func (p *TaskProcessor) SubmitTask(ctx context.Context, ref PathRef, typ string, steps []workflow.Step) error {
+	return p.Workflowcli.SubmitTask(ctx, NewTask(ctx, ref, typ, steps))
+}
+
+// NewTask 构造应用的异步任务, 需要审批时任务在审批通过后再提交
+func NewTask(ctx context.Context, ref PathRef, typ string, steps []workflow.Step) workflow.Task {
 	cluster, namespace := ClusterNamespaceFromCtx(ctx)
-	task := workflow.Task{
+	return workflow.Task{
 		Name:  TaskNameOf(ref, typ),
 		Group: TaskGroupApplication,
 		Steps: steps,
@@ -45,7 +50,6 @@ func (p *TaskProcessor) SubmitTask(ctx context.Context, ref PathRef, typ string,
 			LabelApplication:         ref.Name,
 		},
 	}
-	return p.Workflowcli.SubmitTask(ctx, task)
 }
 
 func (p *TaskProcessor) ListTasks(ctx context.Context, ref PathRef, typ string) ([]workflow.Task, error) {
@@ -65,6 +65,25 @@ func (h *ApplicationHandler) RegistRouter(rg *gin.RouterGroup) error {
 	rg.GET("/tenant/:tenant_id/project/:project_id/manifests/:name/argohistory", h.CheckByProjectID, deploy.Argohistory)
 	rg.GET("/tenant/:tenant_id/project/:project_id/manifests/:name/imagehistory", h.CheckByProjectID, deploy.ImageHistory)
 
+	// 应用环境提升
+	rg.GET("/tenant/:tenant_id/project/:project_id/promotionpipeline", h.CheckByProjectID, deploy.GetPromotionPipeline)
+	rg.PUT("/tenant/:tenant_id/project/:project_id/promotionpipeline", h.CheckByProjectID, deploy.PutPromotionPipeline)
+	rg.GET("/tenant/:tenant_id/project/:project_id/manifests/:name/promotion", h.CheckByProjectID, deploy.GetPromotionStatus)
+	rg.POST("/tenant/:tenant_id/project/:project_id/manifests/:name/promote", h.CheckByProjectID, deploy.Promote)
+	rg.GET("/tenant/:tenant_id/project/:project_id/manifests/:name/promotionhistory", h.CheckByProjectID, deploy.PromotionHistory)
+
+	// 应用部署依赖及分批次部署
+	rg.GET("/tenant/:tenant_id/project/:project_id/appdependencies", h.CheckByProjectID, deploy.GetDependencyGraph)
+	rg.PUT("/tenant/:tenant_id/project/:project_id/appdependencies", h.CheckByProjectID, deploy.PutDependencyGraph)
+	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/syncwaves", h.CheckByEnvironmentID, deploy.SyncWavesStatus)
+	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications-clone", h.CheckByEnvironmentID, h.CheckFreezeWindow, deploy.CloneFrom)
+
+	// 应用预览环境
+	rg.GET("/tenant/:tenant_id/project/:project_id/previewenvironments", h.CheckByProjectID, deploy.ListPreviews)
+	rg.DELETE("/tenant/:tenant_id/project/:project_id/previewenvironments/:preview_id", h.CheckByProjectID, deploy.DeletePreview)
+	rg.POST("/tenant/:tenant_id/project/:project_id/manifests/:name/preview", h.CheckByProjectID, deploy.CreatePreview)
+	rg.POST("/tenant/:tenant_id/project/:project_id/manifests/:name/previewhook", h.CheckByProjectID, deploy.GeneratePreviewWebhookSecret)
+
 	// 应用商店部署
 	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/appstoreapplications", h.CheckByEnvironmentID, deploy.ListAppstoreApp)
 	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/appstoreapplications/:name", h.CheckByEnvironmentID, deploy.GetAppstoreApp)
@@ -79,9 +98,16 @@ func (h *ApplicationHandler) RegistRouter(rg *gin.RouterGroup) error {
 	rg.DELETE("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name", h.CheckByEnvironmentID, deploy.Remove)
 	// 应用部署镜像更新
 	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/_/images", h.CheckByEnvironmentID, deploy.ListImages)
-	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/_/images", h.CheckByEnvironmentID, deploy.BatchUpdateImages)
-	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/images", h.CheckByEnvironmentID, deploy.UpdateImages)
+	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/_/images", h.CheckByEnvironmentID, h.CheckFreezeWindow, deploy.BatchUpdateImages)
+	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/images", h.CheckByEnvironmentID, h.CheckFreezeWindow, deploy.UpdateImages)
 	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/images", h.CheckByEnvironmentID, deploy.GetImages)
+	// 镜像自动更新策略
+	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/imagepolicies", h.CheckByEnvironmentID, deploy.ListImagePolicies)
+	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/imagepolicies", h.CheckByEnvironmentID, deploy.CreateImagePolicy)
+	rg.PUT("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/imagepolicies/:policy_id", h.CheckByEnvironmentID, deploy.UpdateImagePolicy)
+	rg.DELETE("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/imagepolicies/:policy_id", h.CheckByEnvironmentID, deploy.DeleteImagePolicy)
+	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/imagepolicies/:policy_id/check", h.CheckByEnvironmentID, deploy.CheckImagePolicy)
+	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/imageupdatehistory", h.CheckByEnvironmentID, deploy.ImageUpdateHistory)
 
 	// 应用部署异步结果
 	task := deploy.Task
@@ -98,6 +124,11 @@ func (h *ApplicationHandler) RegistRouter(rg *gin.RouterGroup) error {
 	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/gitdiff", h.CheckByEnvironmentID, deploy.GitDiff)
 	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/gitrevert", h.CheckByEnvironmentID, deploy.GitRevert)
 	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/gitpull", h.CheckByEnvironmentID, deploy.GitPull)
+	// 应用部署环境 patch
+	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/overlays", h.CheckByEnvironmentID, manifest.ListOverlays)
+	rg.PUT("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/overlays/:filename", h.CheckByEnvironmentID, manifest.PutOverlay)
+	rg.DELETE("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/overlays/:filename", h.CheckByEnvironmentID, manifest.RemoveOverlay)
+	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/rendered", h.CheckByEnvironmentID, manifest.Rendered)
 	// 编排内的自动补全
 	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/metas", h.CheckByEnvironmentID, manifest.Metas)
 	// 编排作为store
@@ -109,13 +140,15 @@ func (h *ApplicationHandler) RegistRouter(rg *gin.RouterGroup) error {
 
 	// 应用部署编排更新-资源建议
 	rg.PATCH("/cluster/:cluster/:group/:version/namespaces/:namespace/:resource/:name", h.CheckByClusterNamespace, deploy.UpdateWorkloadResources)
+	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/rightsizing", h.CheckByEnvironmentID, deploy.RightSizing)
+	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/rightsizing/apply", h.CheckByEnvironmentID, h.CheckFreezeWindow, deploy.ApplyRightSizing)
 	// Argo CD相关操作
 	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/argohistory", h.CheckByEnvironmentID, deploy.Argohistory)
 	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/imagehistory", h.CheckByEnvironmentID, deploy.ImageHistory)
 	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/resourcetree", h.CheckByEnvironmentID, deploy.ResourceTree)
 	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/argoresource", h.CheckByEnvironmentID, deploy.GetArgoResource)
 	rg.DELETE("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/argoresource", h.CheckByEnvironmentID, deploy.DeleteArgoResource)
-	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/sync", h.CheckByEnvironmentID, deploy.Sync)
+	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/sync", h.CheckByEnvironmentID, h.CheckFreezeWindow, deploy.Sync)
 
 	// 镜像相关
 	image := ImageHandler{BaseHandler: manifest.BaseHandler}
@@ -127,11 +160,11 @@ func (h *ApplicationHandler) RegistRouter(rg *gin.RouterGroup) error {
 
 	// 策略化发布 灰度发布
 	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/strategydeploy", h.CheckByEnvironmentID, deploy.GetStrategyDeployment)
-	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/strategydeploy", h.CheckByEnvironmentID, deploy.EnableStrategyDeployment)
-	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/strategyswitch", h.CheckByEnvironmentID, deploy.SwitchStrategy)
+	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/strategydeploy", h.CheckByEnvironmentID, h.CheckFreezeWindow, deploy.EnableStrategyDeployment)
+	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/strategyswitch", h.CheckByEnvironmentID, h.CheckFreezeWindow, deploy.SwitchStrategy)
 	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/analysistemplate", h.CheckByEnvironmentID, deploy.ListAnalysisTemplate)
 	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/strategydeploystatus", h.CheckByEnvironmentID, deploy.StrategyDeploymentStatus)
-	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/strategydeploycontrol", h.CheckByEnvironmentID, deploy.StrategyDeploymentControl)
+	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/strategydeploycontrol", h.CheckByEnvironmentID, h.CheckFreezeWindow, deploy.StrategyDeploymentControl)
 
 	// 部署状态的附加信息
 	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/services", h.CheckByEnvironmentID, deploy.ListRelatedService)
@@ -141,8 +174,25 @@ func (h *ApplicationHandler) RegistRouter(rg *gin.RouterGroup) error {
 	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/hpa", h.CheckByEnvironmentID, deploy.SetHPA)
 	rg.DELETE("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/hpa", h.CheckByEnvironmentID, deploy.DeleteHPA)
 
+	// 备份与恢复
+	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/backupplans", h.CheckByEnvironmentID, deploy.ListBackupPlans)
+	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/backupplans", h.CheckByEnvironmentID, deploy.CreateBackupPlan)
+	rg.PUT("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/backupplans/:plan_id", h.CheckByEnvironmentID, deploy.UpdateBackupPlan)
+	rg.DELETE("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/backupplans/:plan_id", h.CheckByEnvironmentID, deploy.DeleteBackupPlan)
+	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/backupplans/:plan_id/backup", h.CheckByEnvironmentID, deploy.RunBackupPlan)
+	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/backups", h.CheckByEnvironmentID, deploy.ListBackups)
+	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/backups/:backup_id", h.CheckByEnvironmentID, deploy.GetBackup)
+	rg.DELETE("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/backups/:backup_id", h.CheckByEnvironmentID, deploy.DeleteBackup)
+	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/restore", h.CheckByEnvironmentID, h.CheckFreezeWindow, deploy.Restore)
+	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/restores", h.CheckByEnvironmentID, deploy.ListRestores)
+
 	// ⬇️ 直接使用名称时路由全部注册为复数
 	// 供外部集成使用,填充名称
 	rg.POST("/tenants/:tenant/projects/:project/environments/:environment/applications/:name/images", deploy.DirectUpdateImage)
 	return nil
 }
+
+// RegistHookRouter 注册不需要登录的 webhook 路由, 由各个 webhook 自行校验签名
+func (h *ApplicationHandler) RegistHookRouter(rg *gin.RouterGroup) {
+	rg.POST("/hooks/tenant/:tenant_id/project/:project_id/manifests/:name/preview", h.PreviewWebhook)
+}
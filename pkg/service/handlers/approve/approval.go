@@ -0,0 +1,394 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package approveHandler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/log"
+	msgclient "kubegems.io/kubegems/pkg/msgbus/client"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	environmenthandler "kubegems.io/kubegems/pkg/service/handlers/environment"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/msgbus"
+	"kubegems.io/kubegems/pkg/utils/workflow"
+)
+
+var clusterSensitiveFunc = func(tx *gorm.DB) *gorm.DB { return tx.Select("id, cluster_name") }
+
+type ApprovalDecision struct {
+	Comment string `json:"comment"`
+}
+
+// ListApprovalPolicies 获取环境的审批策略
+// @Tags        Approve
+// @Summary     获取环境的审批策略
+// @Description 获取环境的审批策略
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     uint                                                  true "environment_id"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]models.ApprovalPolicy} "ApprovalPolicy"
+// @Router      /v1/environment/{environment_id}/approvalpolicy [get]
+// @Security    JWT
+func (h *ApproveHandler) ListApprovalPolicies(c *gin.Context) {
+	policies := []models.ApprovalPolicy{}
+	if err := h.GetDB().WithContext(c.Request.Context()).
+		Find(&policies, "environment_id = ?", c.Param("environment_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, policies)
+}
+
+// PutApprovalPolicy 创建或更新环境下某个操作的审批策略
+// @Tags        Approve
+// @Summary     创建或更新环境下某个操作的审批策略
+// @Description 操作可选 application-sync,update-image,strategy-promote,delete-environment,environment-promote;审批人可选 project-admin,environment-operator,tenant-admin
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     uint                                                true "environment_id"
+// @Param       param          body     models.ApprovalPolicy                               true "审批策略"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.ApprovalPolicy} "ApprovalPolicy"
+// @Router      /v1/environment/{environment_id}/approvalpolicy [put]
+// @Security    JWT
+func (h *ApproveHandler) PutApprovalPolicy(c *gin.Context) {
+	env, err := h.getPolicyEnvironment(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	policy := &models.ApprovalPolicy{}
+	if err := c.BindJSON(policy); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	policy.ID = 0
+	policy.EnvironmentID = env.ID
+	policy.Environment = nil
+	if err := h.GetDB().WithContext(c.Request.Context()).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "environment_id"}, {Name: "action"}},
+		DoUpdates: clause.AssignmentColumns([]string{"approvers", "required_approvals", "expire_hours", "updated_at"}),
+	}).Create(policy).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	action := i18n.Sprintf(context.TODO(), "update")
+	module := i18n.Sprintf(context.TODO(), "approval policy")
+	h.SetAuditData(c, action, module, env.EnvironmentName+"/"+policy.Action)
+	h.SetExtraAuditData(c, models.ResEnvironment, env.ID)
+	handlers.OK(c, policy)
+}
+
+// DeleteApprovalPolicy 删除环境下某个操作的审批策略
+// @Tags        Approve
+// @Summary     删除环境下某个操作的审批策略
+// @Description 删除环境下某个操作的审批策略,已经创建的审批请求不受影响
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     uint                    true "environment_id"
+// @Param       action         path     string                  true "操作"
+// @Success     204            {object} handlers.ResponseStruct "resp"
+// @Router      /v1/environment/{environment_id}/approvalpolicy/{action} [delete]
+// @Security    JWT
+func (h *ApproveHandler) DeleteApprovalPolicy(c *gin.Context) {
+	env, err := h.getPolicyEnvironment(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if err := h.GetDB().WithContext(c.Request.Context()).
+		Delete(&models.ApprovalPolicy{}, "environment_id = ? and action = ?", env.ID, c.Param("action")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	action := i18n.Sprintf(context.TODO(), "delete")
+	module := i18n.Sprintf(context.TODO(), "approval policy")
+	h.SetAuditData(c, action, module, env.EnvironmentName+"/"+c.Param("action"))
+	h.SetExtraAuditData(c, models.ResEnvironment, env.ID)
+	handlers.NoContent(c, nil)
+}
+
+// 只有项目管理员和系统管理员可以修改审批策略
+func (h *ApproveHandler) getPolicyEnvironment(c *gin.Context) (*models.Environment, error) {
+	env := &models.Environment{}
+	if err := h.GetDB().WithContext(c.Request.Context()).First(env, c.Param("environment_id")).Error; err != nil {
+		return nil, err
+	}
+	u, _ := h.GetContextUser(c)
+	auth := h.ModelCache().GetUserAuthority(u)
+	if !auth.IsSystemAdmin() && !auth.IsProjectAdmin(env.ProjectID) {
+		return nil, i18n.Errorf(c, "only project admin can modify approval policies")
+	}
+	return env, nil
+}
+
+// ListApprovalRequests 获取环境下的审批请求
+// @Tags        Approve
+// @Summary     获取环境下的审批请求
+// @Description 获取环境下的审批请求,包含已经结束的请求
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     uint                                                   true  "environment_id"
+// @Param       status         query    string                                                 false "状态过滤(pending,approved,rejected,expired,failed)"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]models.ApprovalRequest} "ApprovalRequest"
+// @Router      /v1/environment/{environment_id}/approvalrequest [get]
+// @Security    JWT
+func (h *ApproveHandler) ListApprovalRequests(c *gin.Context) {
+	ctx := c.Request.Context()
+	h.expireApprovalRequests(ctx)
+
+	query := h.GetDB().WithContext(ctx).Preload("Approvals").Where("environment_id = ?", c.Param("environment_id"))
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	requests := []models.ApprovalRequest{}
+	if err := query.Order("id desc").Find(&requests).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, requests)
+}
+
+// PassApprovalRequest 批准审批请求
+// @Tags        Approve
+// @Summary     批准审批请求
+// @Description 批准人数满足策略时提交对应的操作, 环境处于冻结窗口时不执行并将请求置为失败, 管理员可以携带 X-Freeze-Override-Reason 强制执行
+// @Accept      json
+// @Produce     json
+// @Param       id    path     uint                                                 true "approval request id"
+// @Param       param body     ApprovalDecision                                     true "审批意见"
+// @Success     200   {object} handlers.ResponseStruct{Data=models.ApprovalRequest} "ApprovalRequest"
+// @Router      /v1/approvalrequest/{id}/pass [post]
+// @Security    JWT
+func (h *ApproveHandler) PassApprovalRequest(c *gin.Context) {
+	h.decideApprovalRequest(c, true)
+}
+
+// RejectApprovalRequest 拒绝审批请求
+// @Tags        Approve
+// @Summary     拒绝审批请求
+// @Description 任一审批人拒绝即结束该请求
+// @Accept      json
+// @Produce     json
+// @Param       id    path     uint                                                 true "approval request id"
+// @Param       param body     ApprovalDecision                                     true "审批意见"
+// @Success     200   {object} handlers.ResponseStruct{Data=models.ApprovalRequest} "ApprovalRequest"
+// @Router      /v1/approvalrequest/{id}/reject [post]
+// @Security    JWT
+func (h *ApproveHandler) RejectApprovalRequest(c *gin.Context) {
+	h.decideApprovalRequest(c, false)
+}
+
+func (h *ApproveHandler) decideApprovalRequest(c *gin.Context, passed bool) {
+	ctx := c.Request.Context()
+	id, _ := strconv.Atoi(c.Param("id"))
+	decision := ApprovalDecision{}
+	_ = c.ShouldBindJSON(&decision)
+
+	req, err := h.getApprovalRequest(ctx, uint(id))
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	u, _ := h.GetContextUser(c)
+	if !h.canApprove(u, req) {
+		handlers.NotOK(c, i18n.Errorf(c, "you are not an approver of this request"))
+		return
+	}
+	if req.Decide(time.Now()) != models.ApprovalStatusPending {
+		handlers.NotOK(c, i18n.Errorf(c, "the approval request is %s", req.Decide(time.Now())))
+		return
+	}
+	if req.ApprovedBy(u.GetUsername()) {
+		handlers.NotOK(c, i18n.Errorf(c, "you have already approved this request"))
+		return
+	}
+
+	record := &models.ApprovalRecord{
+		ApprovalRequestID: req.ID,
+		Username:          u.GetUsername(),
+		Passed:            passed,
+		Comment:           decision.Comment,
+	}
+	if err := h.GetDB().WithContext(ctx).Create(record).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	req.Approvals = append(req.Approvals, record)
+
+	action := i18n.Sprintf(context.TODO(), "rejected")
+	if passed {
+		action = i18n.Sprintf(context.TODO(), "passed")
+	}
+	module := i18n.Sprintf(context.TODO(), "approval request")
+	h.SetAuditData(c, action, module, req.Environment.EnvironmentName+"/"+req.Action+"/"+req.Target)
+	h.SetExtraAuditData(c, models.ResEnvironment, req.EnvironmentID)
+
+	status := req.Decide(time.Now())
+	if status == models.ApprovalStatusPending {
+		handlers.OK(c, req)
+		return
+	}
+	// 多个审批人同时审批时只有一个可以修改状态并执行
+	result := h.GetDB().WithContext(ctx).Model(req).
+		Where("status = ?", models.ApprovalStatusPending).Update("status", status)
+	if result.Error != nil {
+		handlers.NotOK(c, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		handlers.OK(c, req)
+		return
+	}
+	req.Status = status
+
+	if status == models.ApprovalStatusApproved {
+		if err := h.executeApprovalRequest(c, req); err != nil {
+			log.Error(err, "execute approval request", "id", req.ID, "action", req.Action)
+			req.Status, req.Message = models.ApprovalStatusFailed, err.Error()
+			h.GetDB().WithContext(ctx).Model(req).Updates(map[string]interface{}{"status": req.Status, "message": req.Message})
+		}
+	}
+
+	applicant := models.User{}
+	h.GetDB().WithContext(ctx).Where("username = ?", req.Username).First(&applicant)
+	h.SendToMsgbus(c, func(msg *msgclient.MsgRequest) {
+		msg.EventKind = msgbus.Update
+		msg.ResourceType = msgbus.ApprovalRequest
+		msg.ResourceID = req.ID
+		msg.Detail = i18n.Sprintf(context.TODO(), "finished the approval of %s of %s in environment %s, the result is %s",
+			req.Action, req.Target, req.Environment.EnvironmentName, req.Status)
+		msg.ToUsers.Append(applicant.ID)
+	})
+	handlers.OK(c, req)
+}
+
+// executeApprovalRequest 执行审批通过的操作, 异步任务直接提交, 删除环境同步执行.
+// 环境处于冻结窗口时不执行, 与直接操作一样管理员可以携带原因强制执行
+func (h *ApproveHandler) executeApprovalRequest(c *gin.Context, req *models.ApprovalRequest) error {
+	if err := h.CheckEnvironmentFreeze(c, req.EnvironmentID); err != nil {
+		return err
+	}
+	if len(req.Task) > 0 {
+		task := workflow.Task{}
+		if err := json.Unmarshal(req.Task, &task); err != nil {
+			return err
+		}
+		return h.Workflowcli.SubmitTask(c.Request.Context(), task)
+	}
+	switch req.Action {
+	case models.ApprovalActionDeleteEnvironment:
+		env := &models.Environment{}
+		if err := h.GetDB().WithContext(c.Request.Context()).
+			Preload("Cluster", clusterSensitiveFunc).Preload("Project.Tenant").
+			First(env, req.EnvironmentID).Error; err != nil {
+			return err
+		}
+		return environmenthandler.RemoveEnvironment(c, h.BaseHandler, env)
+	default:
+		return i18n.Errorf(c, "unsupported approval action %s", req.Action)
+	}
+}
+
+func (h *ApproveHandler) getApprovalRequest(ctx context.Context, id uint) (*models.ApprovalRequest, error) {
+	req := &models.ApprovalRequest{}
+	if err := h.GetDB().WithContext(ctx).
+		Preload("Approvals").
+		Preload("Environment.Project.Tenant").
+		Preload("Environment.Cluster", clusterSensitiveFunc).
+		First(req, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, i18n.Errorf(ctx, "the approval request does not exist")
+		}
+		return nil, err
+	}
+	return req, nil
+}
+
+// canApprove 申请人不能审批自己的请求, 系统管理员可以审批所有请求, 其余用户需在审批通知的用户列表中
+func (h *ApproveHandler) canApprove(u models.CommonUserIface, req *models.ApprovalRequest) bool {
+	if u.GetUsername() == req.Username || req.Environment == nil {
+		return false
+	}
+	if h.ModelCache().GetUserAuthority(u).IsSystemAdmin() {
+		return true
+	}
+	for _, id := range h.ApprovalApprovers(req.Environment, req.Approvers) {
+		if id == u.GetID() {
+			return true
+		}
+	}
+	return false
+}
+
+// pendingApprovalRequests 获取用户可以审批的请求
+func (h *ApproveHandler) pendingApprovalRequests(c *gin.Context) ([]Approve, error) {
+	ctx := c.Request.Context()
+	h.expireApprovalRequests(ctx)
+
+	requests := []models.ApprovalRequest{}
+	if err := h.GetDB().WithContext(ctx).
+		Preload("Approvals").
+		Preload("Environment.Project.Tenant").
+		Preload("Environment.Cluster", clusterSensitiveFunc).
+		Find(&requests, "status = ?", models.ApprovalStatusPending).Error; err != nil {
+		return nil, err
+	}
+	u, _ := h.GetContextUser(c)
+	ret := []Approve{}
+	for i := range requests {
+		req := &requests[i]
+		if !h.canApprove(u, req) || req.ApprovedBy(u.GetUsername()) {
+			continue
+		}
+		env := req.Environment
+		approve := Approve{
+			ResourceType: msgbus.ApprovalRequest,
+			ID:           req.ID,
+			Title: i18n.Sprintf(c, "user %s requested approval for %s of %s in environment %s",
+				req.Username, req.Action, req.Target, env.EnvironmentName),
+			Content:   req,
+			CreatedAt: req.CreatedAt,
+			Status:    req.Status,
+		}
+		if env.Project != nil && env.Project.Tenant != nil {
+			approve.TenantID, approve.TenantName = env.Project.TenantID, env.Project.Tenant.TenantName
+		}
+		if env.Cluster != nil {
+			approve.ClusterID, approve.ClusterName = env.Cluster.ID, env.Cluster.ClusterName
+		}
+		ret = append(ret, approve)
+	}
+	return ret, nil
+}
+
+// expireApprovalRequests 将已经过期的请求设置为过期
+func (h *ApproveHandler) expireApprovalRequests(ctx context.Context) {
+	if err := h.GetDB().WithContext(ctx).Model(&models.ApprovalRequest{}).
+		Where("status = ? and expired_at < ?", models.ApprovalStatusPending, time.Now()).
+		Update("status", models.ApprovalStatusExpired).Error; err != nil {
+		log.Error(err, "expire approval requests")
+	}
+}
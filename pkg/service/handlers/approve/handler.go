@@ -33,7 +33,7 @@ import (
 
 type Approve struct {
 	msgbus.ResourceType
-	ID          uint // 目前记录的是quota id
+	ID          uint // quota id 或者审批请求 id
 	Title       string
 	Content     interface{}
 	TenantID    uint   `json:",omitempty"`
@@ -72,7 +72,7 @@ func (h *ApproveHandler) ListApproves(c *gin.Context) {
 	}
 
 	ret := ApprovesList{}
-	// 目前只给admin看
+	// 集群资源申请只给admin看
 	u, _ := h.GetContextUser(c)
 	if h.ModelCache().GetUserAuthority(u).IsSystemAdmin() {
 		for _, v := range quotas {
@@ -91,8 +91,15 @@ func (h *ApproveHandler) ListApproves(c *gin.Context) {
 				})
 			}
 		}
-		sort.Sort(ret)
 	}
+	// 环境下需要当前用户审批的操作
+	requests, err := h.pendingApprovalRequests(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	ret = append(ret, requests...)
+	sort.Sort(ret)
 
 	handlers.OK(c, ret)
 }
@@ -17,14 +17,24 @@ package approveHandler
 import (
 	"github.com/gin-gonic/gin"
 	"kubegems.io/kubegems/pkg/service/handlers/base"
+	"kubegems.io/kubegems/pkg/utils/workflow"
 )
 
 type ApproveHandler struct {
 	base.BaseHandler
+	// 用于提交审批通过的异步任务
+	Workflowcli *workflow.Client
 }
 
 func (h *ApproveHandler) RegistRouter(rg *gin.RouterGroup) {
 	rg.GET("/approve", h.ListApproves)
 	rg.POST("/approve/:id/pass", h.CheckIsSysADMIN, h.Pass)
 	rg.POST("/approve/:id/reject", h.CheckIsSysADMIN, h.Reject)
+
+	rg.GET("/environment/:environment_id/approvalpolicy", h.CheckByEnvironmentID, h.ListApprovalPolicies)
+	rg.PUT("/environment/:environment_id/approvalpolicy", h.CheckByEnvironmentID, h.PutApprovalPolicy)
+	rg.DELETE("/environment/:environment_id/approvalpolicy/:action", h.CheckByEnvironmentID, h.DeleteApprovalPolicy)
+	rg.GET("/environment/:environment_id/approvalrequest", h.CheckByEnvironmentID, h.ListApprovalRequests)
+	rg.POST("/approvalrequest/:id/pass", h.PassApprovalRequest)
+	rg.POST("/approvalrequest/:id/reject", h.RejectApprovalRequest)
 }
@@ -0,0 +1,102 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package base
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"kubegems.io/kubegems/pkg/i18n"
+	msgclient "kubegems.io/kubegems/pkg/msgbus/client"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/msgbus"
+	"kubegems.io/kubegems/pkg/utils/workflow"
+)
+
+// ApprovalResult 需要审批的操作的返回结果, Submitted 为 false 时 Approval 为等待审批的请求
+type ApprovalResult struct {
+	Approval  *models.ApprovalRequest `json:"approval,omitempty"`
+	Submitted bool                    `json:"submitted"`
+}
+
+// RequireApproval 检查环境的审批策略, 操作需要审批时创建待审批的请求并通知审批人.
+// 返回的请求不为空时调用方不应再执行该操作, task 为审批通过后提交的异步任务, 可以为空.
+func (h BaseHandler) RequireApproval(c *gin.Context, envid uint, action, target string, task *workflow.Task) (*models.ApprovalRequest, error) {
+	ctx := c.Request.Context()
+	policy := &models.ApprovalPolicy{}
+	if err := h.GetDB().WithContext(ctx).Preload("Environment.Project").
+		First(policy, "environment_id = ? and action = ?", envid, action).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	u, exist := h.GetContextUser(c)
+	if !exist {
+		return nil, i18n.Errorf(c, "can't get current user")
+	}
+
+	req := &models.ApprovalRequest{
+		EnvironmentID:     envid,
+		Action:            action,
+		Target:            target,
+		Approvers:         policy.Approvers,
+		RequiredApprovals: policy.RequiredApprovals,
+		Username:          u.GetUsername(),
+		Status:            models.ApprovalStatusPending,
+		ExpiredAt:         time.Now().Add(policy.ExpireDuration()),
+	}
+	if task != nil {
+		content, err := json.Marshal(task)
+		if err != nil {
+			return nil, err
+		}
+		req.Task = content
+	}
+	if err := h.GetDB().WithContext(ctx).Create(req).Error; err != nil {
+		return nil, err
+	}
+
+	env := policy.Environment
+	h.SendToMsgbus(c, func(msg *msgclient.MsgRequest) {
+		msg.MessageType = msgbus.Approve
+		msg.EventKind = msgbus.Add
+		msg.ResourceType = msgbus.ApprovalRequest
+		msg.ResourceID = req.ID
+		msg.Detail = i18n.Sprintf(context.TODO(), "requested approval for %s of %s in environment %s", action, target, env.EnvironmentName)
+		msg.ToUsers.Append(h.ApprovalApprovers(env, policy.Approvers)...).Append(u.GetID())
+	})
+	return req, nil
+}
+
+// ApprovalApprovers 返回环境下对应审批人角色的用户, 审批通知和审批权限校验都使用该列表
+func (h BaseHandler) ApprovalApprovers(env *models.Environment, approvers string) []uint {
+	db := h.GetDataBase()
+	switch approvers {
+	case models.ApproverEnvironmentOperator:
+		return append(db.EnvAdmins(env.ID), db.ProjectAdmins(env.ProjectID)...)
+	case models.ApproverTenantAdmin:
+		if env.Project == nil {
+			return nil
+		}
+		return db.TenantAdmins(env.Project.TenantID)
+	default:
+		return db.ProjectAdmins(env.ProjectID)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package base
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/service/aaa/audit"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils"
+)
+
+const (
+	// FreezeOverrideHeader 在冻结窗口内强制变更时需要在请求中携带原因
+	FreezeOverrideHeader = "X-Freeze-Override-Reason"
+	FreezeOverrideQuery  = "freezeOverrideReason"
+)
+
+// CheckFreezeWindow 路径中环境处于冻结窗口时拒绝请求
+func (h BaseHandler) CheckFreezeWindow(c *gin.Context) {
+	if err := h.CheckEnvironmentFreeze(c, utils.ToUint(c.Param("environment_id"))); err != nil {
+		handlers.Forbidden(c, err)
+		c.Abort()
+		return
+	}
+}
+
+// CheckEnvironmentFreeze 检查环境是否处于租户,项目或者环境的冻结窗口内.
+// 冻结窗口内管理员携带原因时允许变更, 原因记录在审计日志中
+func (h BaseHandler) CheckEnvironmentFreeze(c *gin.Context, envid uint) error {
+	if envid == 0 {
+		return nil
+	}
+	ctx := c.Request.Context()
+	env := &models.Environment{}
+	if err := h.GetDB().WithContext(ctx).Preload("Project").First(env, envid).Error; err != nil {
+		return err
+	}
+	window, err := models.ActiveFreezeWindow(h.GetDB().WithContext(ctx), env, time.Now())
+	if err != nil || window == nil {
+		return err
+	}
+	_, until := window.ActiveAt(time.Now())
+
+	reason := c.GetHeader(FreezeOverrideHeader)
+	if reason == "" {
+		reason = c.Query(FreezeOverrideQuery)
+	}
+	if reason == "" {
+		return i18n.Errorf(c, "environment %s is frozen by freeze window %s until %s: %s",
+			env.EnvironmentName, window.Name, until.Format(time.RFC3339), window.Reason)
+	}
+	u, exist := h.GetContextUser(c)
+	if !exist {
+		return i18n.Errorf(c, "can't get current user")
+	}
+	auth := h.ModelCache().GetUserAuthority(u)
+	if !auth.IsSystemAdmin() && !auth.IsTenantAdmin(env.Project.TenantID) && !auth.IsProjectAdmin(env.ProjectID) {
+		return i18n.Errorf(c, "only admin can override freeze window %s", window.Name)
+	}
+	audit.SetAuditFreezeOverride(c, window.Name, reason)
+	return nil
+}
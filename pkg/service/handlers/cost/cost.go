@@ -0,0 +1,384 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package costhandler
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils"
+)
+
+const (
+	GroupByProject     = "project"
+	GroupByEnvironment = "environment"
+	GroupByWorkload    = "workload"
+
+	dateLayout = "2006-01-02"
+)
+
+var groupByColumns = map[string][]string{
+	GroupByProject:     {"tenant_name", "project_id", "project_name"},
+	GroupByEnvironment: {"tenant_name", "project_id", "project_name", "environment_id", "environment_name", "cluster_name", "namespace"},
+	GroupByWorkload:    {"tenant_name", "project_id", "project_name", "environment_id", "environment_name", "cluster_name", "namespace", "workload_kind", "workload_name"},
+}
+
+const sumColumns = `sum(cpu_request_core_hours) as cpu_request_core_hours, sum(cpu_usage_core_hours) as cpu_usage_core_hours,
+sum(memory_request_gi_b_hours) as memory_request_gi_b_hours, sum(memory_usage_gi_b_hours) as memory_usage_gi_b_hours,
+sum(gpu_hours) as gpu_hours, sum(storage_gi_b_hours) as storage_gi_b_hours,
+sum(cpu_cost) as cpu_cost, sum(memory_cost) as memory_cost, sum(gpu_cost) as gpu_cost, sum(storage_cost) as storage_cost, sum(total_cost) as total_cost`
+
+// CostSummary 按分组汇总的费用, 分组之外的字段为空
+type CostSummary struct {
+	Date            *time.Time `json:"date,omitempty"`
+	TenantName      string     `json:"tenantName,omitempty"`
+	ProjectID       uint       `json:"projectID,omitempty"`
+	ProjectName     string     `json:"projectName,omitempty"`
+	EnvironmentID   uint       `json:"environmentID,omitempty"`
+	EnvironmentName string     `json:"environmentName,omitempty"`
+	ClusterName     string     `json:"clusterName,omitempty"`
+	Namespace       string     `json:"namespace,omitempty"`
+	WorkloadKind    string     `json:"workloadKind,omitempty"`
+	WorkloadName    string     `json:"workloadName,omitempty"`
+
+	CPURequestCoreHours   float64 `json:"cpuRequestCoreHours"`
+	CPUUsageCoreHours     float64 `json:"cpuUsageCoreHours"`
+	MemoryRequestGiBHours float64 `json:"memoryRequestGiBHours"`
+	MemoryUsageGiBHours   float64 `json:"memoryUsageGiBHours"`
+	GPUHours              float64 `json:"gpuHours"`
+	StorageGiBHours       float64 `json:"storageGiBHours"`
+	CPUCost               float64 `json:"cpuCost"`
+	MemoryCost            float64 `json:"memoryCost"`
+	GPUCost               float64 `json:"gpuCost"`
+	StorageCost           float64 `json:"storageCost"`
+	TotalCost             float64 `json:"totalCost"`
+}
+
+// ListCostPrices 资源价格列表
+// @Tags        Cost
+// @Summary     资源价格列表
+// @Description 资源价格列表
+// @Accept      json
+// @Produce     json
+// @Success     200 {object} handlers.ResponseStruct{Data=[]models.CostPrice} "CostPrice"
+// @Router      /v1/cost/prices [get]
+// @Security    JWT
+func (h *CostHandler) ListCostPrices(c *gin.Context) {
+	prices := []models.CostPrice{}
+	if err := h.GetDB().WithContext(c.Request.Context()).Order("cluster_name, id").Find(&prices).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, prices)
+}
+
+// CreateCostPrice 创建资源价格
+// @Tags        Cost
+// @Summary     创建资源价格
+// @Description 创建资源价格, 集群为空时为默认价格, nodeSelector 不为空时为节点池价格
+// @Accept      json
+// @Produce     json
+// @Param       param body     models.CostPrice                               true "价格"
+// @Success     200   {object} handlers.ResponseStruct{Data=models.CostPrice} "CostPrice"
+// @Router      /v1/cost/prices [post]
+// @Security    JWT
+func (h *CostHandler) CreateCostPrice(c *gin.Context) {
+	price := &models.CostPrice{}
+	if err := c.BindJSON(price); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	price.ID = 0
+	if err := price.Validate(); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.auditCostPrice(c, "create", price)
+	if err := h.GetDB().WithContext(c.Request.Context()).Create(price).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, price)
+}
+
+// UpdateCostPrice 更新资源价格
+// @Tags        Cost
+// @Summary     更新资源价格
+// @Description 更新资源价格, 只影响之后统计的费用
+// @Accept      json
+// @Produce     json
+// @Param       id    path     uint                                           true "id"
+// @Param       param body     models.CostPrice                               true "价格"
+// @Success     200   {object} handlers.ResponseStruct{Data=models.CostPrice} "CostPrice"
+// @Router      /v1/cost/prices/{id} [put]
+// @Security    JWT
+func (h *CostHandler) UpdateCostPrice(c *gin.Context) {
+	price := &models.CostPrice{}
+	if err := c.BindJSON(price); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	old := &models.CostPrice{}
+	if err := h.GetDB().WithContext(c.Request.Context()).First(old, "id = ?", c.Param("id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	price.ID = old.ID
+	if err := price.Validate(); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.auditCostPrice(c, "update", price)
+	if err := h.GetDB().WithContext(c.Request.Context()).Save(price).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, price)
+}
+
+// DeleteCostPrice 删除资源价格
+// @Tags        Cost
+// @Summary     删除资源价格
+// @Description 删除资源价格
+// @Accept      json
+// @Produce     json
+// @Param       id  path     uint                                 true "id"
+// @Success     200 {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/cost/prices/{id} [delete]
+// @Security    JWT
+func (h *CostHandler) DeleteCostPrice(c *gin.Context) {
+	price := &models.CostPrice{}
+	if err := h.GetDB().WithContext(c.Request.Context()).First(price, "id = ?", c.Param("id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.auditCostPrice(c, "delete", price)
+	if err := h.GetDB().WithContext(c.Request.Context()).Delete(price).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, "ok")
+}
+
+// TenantCost 租户费用
+// @Tags        Cost
+// @Summary     租户费用
+// @Description 按项目, 环境或工作负载汇总租户在时间段内的费用, format=csv 时导出 csv
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     uint                                              true  "tenant_id"
+// @Param       start          query    string                                            false "开始日期 2006-01-02, 默认30天前"
+// @Param       end            query    string                                            false "结束日期 2006-01-02, 包含当天, 默认昨天"
+// @Param       project_id     query    uint                                              false "项目"
+// @Param       environment_id query    uint                                              false "环境"
+// @Param       groupby        query    string                                            false "project, environment(默认), workload"
+// @Param       format         query    string                                            false "csv"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]CostSummary} "CostSummary"
+// @Router      /v1/tenant/{tenant_id}/cost [get]
+// @Security    JWT
+func (h *CostHandler) TenantCost(c *gin.Context) {
+	h.costSummary(c, "tenant_id = ?", utils.ToUint(c.Param("tenant_id")))
+}
+
+// TenantCostTrend 租户费用趋势
+// @Tags        Cost
+// @Summary     租户费用趋势
+// @Description 租户每天的费用
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     uint                                              true  "tenant_id"
+// @Param       start          query    string                                            false "开始日期 2006-01-02, 默认30天前"
+// @Param       end            query    string                                            false "结束日期 2006-01-02, 包含当天, 默认昨天"
+// @Param       project_id     query    uint                                              false "项目"
+// @Param       environment_id query    uint                                              false "环境"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]CostSummary} "CostSummary"
+// @Router      /v1/tenant/{tenant_id}/cost/trend [get]
+// @Security    JWT
+func (h *CostHandler) TenantCostTrend(c *gin.Context) {
+	h.costTrend(c, "tenant_id = ?", utils.ToUint(c.Param("tenant_id")))
+}
+
+// ProjectCost 项目费用
+// @Tags        Cost
+// @Summary     项目费用
+// @Description 按环境或工作负载汇总项目在时间段内的费用, format=csv 时导出 csv
+// @Accept      json
+// @Produce     json
+// @Param       project_id     path     uint                                              true  "project_id"
+// @Param       start          query    string                                            false "开始日期 2006-01-02, 默认30天前"
+// @Param       end            query    string                                            false "结束日期 2006-01-02, 包含当天, 默认昨天"
+// @Param       environment_id query    uint                                              false "环境"
+// @Param       groupby        query    string                                            false "project, environment(默认), workload"
+// @Param       format         query    string                                            false "csv"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]CostSummary} "CostSummary"
+// @Router      /v1/project/{project_id}/cost [get]
+// @Security    JWT
+func (h *CostHandler) ProjectCost(c *gin.Context) {
+	h.costSummary(c, "project_id = ?", utils.ToUint(c.Param("project_id")))
+}
+
+// ProjectCostTrend 项目费用趋势
+// @Tags        Cost
+// @Summary     项目费用趋势
+// @Description 项目每天的费用
+// @Accept      json
+// @Produce     json
+// @Param       project_id     path     uint                                              true  "project_id"
+// @Param       start          query    string                                            false "开始日期 2006-01-02, 默认30天前"
+// @Param       end            query    string                                            false "结束日期 2006-01-02, 包含当天, 默认昨天"
+// @Param       environment_id query    uint                                              false "环境"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]CostSummary} "CostSummary"
+// @Router      /v1/project/{project_id}/cost/trend [get]
+// @Security    JWT
+func (h *CostHandler) ProjectCostTrend(c *gin.Context) {
+	h.costTrend(c, "project_id = ?", utils.ToUint(c.Param("project_id")))
+}
+
+func (h *CostHandler) costSummary(c *gin.Context, scope string, scopeid uint) {
+	groupby := c.DefaultQuery("groupby", GroupByEnvironment)
+	columns, ok := groupByColumns[groupby]
+	if !ok {
+		handlers.NotOK(c, fmt.Errorf("invalid groupby %s", groupby))
+		return
+	}
+	query, err := h.costQuery(c, scope, scopeid)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	group := strings.Join(columns, ", ")
+	ret := []CostSummary{}
+	if err := query.Select(group + ", " + sumColumns).Group(group).Order("total_cost desc").Scan(&ret).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if c.Query("format") == "csv" {
+		writeCostCSV(c, columns, ret)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+func (h *CostHandler) costTrend(c *gin.Context, scope string, scopeid uint) {
+	query, err := h.costQuery(c, scope, scopeid)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	ret := []CostSummary{}
+	if err := query.Select("date, " + sumColumns).Group("date").Order("date").Scan(&ret).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+func (h *CostHandler) costQuery(c *gin.Context, scope string, scopeid uint) (*gorm.DB, error) {
+	start, end, err := parseDateRange(c.Query("start"), c.Query("end"), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	query := h.GetDB().WithContext(c.Request.Context()).Model(&models.CostRecord{}).
+		Where(scope, scopeid).
+		Where("date >= ? and date <= ?", start, end)
+	if projectID := c.Query("project_id"); projectID != "" {
+		query = query.Where("project_id = ?", projectID)
+	}
+	if envID := c.Query("environment_id"); envID != "" {
+		query = query.Where("environment_id = ?", envID)
+	}
+	return query, nil
+}
+
+// parseDateRange 解析日期范围, 默认为截止到昨天的 30 天
+func parseDateRange(startStr, endStr string, now time.Time) (time.Time, time.Time, error) {
+	end := utils.DayStartTime(now).AddDate(0, 0, -1)
+	if endStr != "" {
+		t, err := time.ParseInLocation(dateLayout, endStr, now.Location())
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid end date: %w", err)
+		}
+		end = t
+	}
+	start := end.AddDate(0, 0, -29)
+	if startStr != "" {
+		t, err := time.ParseInLocation(dateLayout, startStr, now.Location())
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid start date: %w", err)
+		}
+		start = t
+	}
+	if start.After(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("start date %s after end date %s", start.Format(dateLayout), end.Format(dateLayout))
+	}
+	return start, end, nil
+}
+
+func writeCostCSV(c *gin.Context, columns []string, summaries []CostSummary) {
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=cost-%s.csv", time.Now().Format(dateLayout)))
+	w := csv.NewWriter(c.Writer)
+	_ = w.WriteAll(costCSVRows(columns, summaries))
+}
+
+func costCSVRows(columns []string, summaries []CostSummary) [][]string {
+	header := append([]string{}, columns...)
+	header = append(header,
+		"cpu_request_core_hours", "cpu_usage_core_hours", "memory_request_gib_hours", "memory_usage_gib_hours",
+		"gpu_hours", "storage_gib_hours", "cpu_cost", "memory_cost", "gpu_cost", "storage_cost", "total_cost")
+	rows := [][]string{header}
+	formatFloat := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', 4, 64)
+	}
+	for _, s := range summaries {
+		values := map[string]string{
+			"tenant_name":      s.TenantName,
+			"project_id":       strconv.Itoa(int(s.ProjectID)),
+			"project_name":     s.ProjectName,
+			"environment_id":   strconv.Itoa(int(s.EnvironmentID)),
+			"environment_name": s.EnvironmentName,
+			"cluster_name":     s.ClusterName,
+			"namespace":        s.Namespace,
+			"workload_kind":    s.WorkloadKind,
+			"workload_name":    s.WorkloadName,
+		}
+		row := []string{}
+		for _, col := range columns {
+			row = append(row, values[col])
+		}
+		for _, f := range []float64{
+			s.CPURequestCoreHours, s.CPUUsageCoreHours, s.MemoryRequestGiBHours, s.MemoryUsageGiBHours,
+			s.GPUHours, s.StorageGiBHours, s.CPUCost, s.MemoryCost, s.GPUCost, s.StorageCost, s.TotalCost,
+		} {
+			row = append(row, formatFloat(f))
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func (h *CostHandler) auditCostPrice(c *gin.Context, action string, price *models.CostPrice) {
+	module := i18n.Sprintf(context.TODO(), "cost price")
+	h.SetAuditData(c, i18n.Sprintf(context.TODO(), action), module, price.Name)
+}
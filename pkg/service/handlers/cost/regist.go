@@ -0,0 +1,36 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package costhandler
+
+import (
+	"github.com/gin-gonic/gin"
+	"kubegems.io/kubegems/pkg/service/handlers/base"
+)
+
+type CostHandler struct {
+	base.BaseHandler
+}
+
+func (h *CostHandler) RegistRouter(rg *gin.RouterGroup) {
+	rg.GET("/cost/prices", h.ListCostPrices)
+	rg.POST("/cost/prices", h.CheckIsSysADMIN, h.CreateCostPrice)
+	rg.PUT("/cost/prices/:id", h.CheckIsSysADMIN, h.UpdateCostPrice)
+	rg.DELETE("/cost/prices/:id", h.CheckIsSysADMIN, h.DeleteCostPrice)
+
+	rg.GET("/tenant/:tenant_id/cost", h.CheckByTenantID, h.TenantCost)
+	rg.GET("/tenant/:tenant_id/cost/trend", h.CheckByTenantID, h.TenantCostTrend)
+	rg.GET("/project/:project_id/cost", h.CheckByProjectID, h.ProjectCost)
+	rg.GET("/project/:project_id/cost/trend", h.CheckByProjectID, h.ProjectCostTrend)
+}
@@ -318,18 +318,38 @@ func (h *EnvironmentHandler) DeleteEnvironment(c *gin.Context) {
 	h.SetAuditData(c, action, module, obj.EnvironmentName)
 	h.SetExtraAuditData(c, models.ResEnvironment, obj.ID)
 
+	// 需要审批时,审批通过后再删除
+	approval, err := h.RequireApproval(c, obj.ID, models.ApprovalActionDeleteEnvironment, obj.EnvironmentName, nil)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if approval != nil {
+		handlers.OK(c, approval)
+		return
+	}
+	if err := RemoveEnvironment(c, h.BaseHandler, &obj); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.NoContent(c, nil)
+}
+
+// RemoveEnvironment 删除环境以及集群中的环境CRD, 并通知环境成员
+func RemoveEnvironment(c *gin.Context, h base.BaseHandler, obj *models.Environment) error {
+	ctx := c.Request.Context()
 	envUsers := h.GetDataBase().EnvUsers(obj.ID)
 	projAdmins := h.GetDataBase().ProjectAdmins(obj.ProjectID)
 
+	envh := &EnvironmentHandler{BaseHandler: h}
 	err := h.GetDB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		if err := tx.Delete(&obj).Error; err != nil {
+		if err := tx.Delete(obj).Error; err != nil {
 			return err
 		}
-		return h.afterEnvironmentDelete(ctx, tx, &obj)
+		return envh.afterEnvironmentDelete(ctx, tx, obj)
 	})
 	if err != nil {
-		handlers.NotOK(c, err)
-		return
+		return err
 	}
 	h.ModelCache().DelEnvironment(obj.ProjectID, obj.ID, obj.Cluster.ClusterName, obj.Namespace)
 
@@ -341,26 +361,30 @@ func (h *EnvironmentHandler) DeleteEnvironment(c *gin.Context) {
 		msg.ToUsers.Append(projAdmins...).Append(envUsers...)
 		msg.AffectedUsers.Append(envUsers...) // 环境所有用户刷新权限
 	})
-
-	handlers.NoContent(c, nil)
+	return nil
 }
 
 // 环境删除,同步删除CRD
 func (h *EnvironmentHandler) afterEnvironmentDelete(ctx context.Context, tx *gorm.DB, env *models.Environment) error {
 	return h.Execute(ctx, env.Cluster.ClusterName, func(ctx context.Context, cli agents.Client) error {
-		envobj := &v1beta1.Environment{}
-		err := cli.Get(ctx, client.ObjectKey{Name: env.EnvironmentName}, envobj)
-		if err != nil {
-			if errors.IsNotFound(err) {
-				return nil
-			} else {
-				return err
-			}
-		}
-		return cli.Delete(ctx, envobj)
+		return DeleteEnvironmentCR(ctx, cli, env.EnvironmentName)
 	})
 }
 
+// DeleteEnvironmentCR 删除集群中的环境CRD, 不存在时忽略
+func DeleteEnvironmentCR(ctx context.Context, cli agents.Client, name string) error {
+	envobj := &v1beta1.Environment{}
+	err := cli.Get(ctx, client.ObjectKey{Name: name}, envobj)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		} else {
+			return err
+		}
+	}
+	return cli.Delete(ctx, envobj)
+}
+
 // ListEnvironmentUser 获取属于Environment的 User 列表
 // @Tags        Environment
 // @Summary     获取属于 Environment 的 User 列表
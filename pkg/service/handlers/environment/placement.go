@@ -0,0 +1,380 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package environment
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	"kubegems.io/kubegems/pkg/apis/gems/v1beta1"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/handlers/base"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"kubegems.io/kubegems/pkg/utils/resourcequota"
+	"kubegems.io/kubegems/pkg/utils/statistics"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// 集群评分权重
+const (
+	placementWeightQuota    = 0.5 // 租户/项目剩余资源
+	placementWeightNode     = 0.3 // 可调度节点的资源余量
+	placementWeightAffinity = 0.2 // 项目在该集群已有环境
+)
+
+// PlacementRequest 环境放置请求
+type PlacementRequest struct {
+	// ResourceQuota 环境申请的资源
+	ResourceQuota corev1.ResourceList `json:"resourceQuota"`
+	// NodeSelector 环境的工作负载需要调度到的节点标签
+	NodeSelector map[string]string `json:"nodeSelector"`
+	// Tolerations 环境的工作负载可以容忍的污点
+	Tolerations []corev1.Toleration `json:"tolerations"`
+	// ClusterIDs 候选集群,为空时为租户有资源的全部集群
+	ClusterIDs []uint `json:"clusterIDs"`
+}
+
+// ClusterPlacement 集群的放置评估结果
+type ClusterPlacement struct {
+	ClusterID   uint   `json:"clusterID"`
+	ClusterName string `json:"clusterName"`
+	// Feasible 是否可以放置
+	Feasible bool `json:"feasible"`
+	// Score 评分(0-100),越高越合适
+	Score float64 `json:"score"`
+	// Reasons 不能放置的原因或者评分说明
+	Reasons []string `json:"reasons"`
+	// Headroom 租户(设置了项目资源限制时取项目)在该集群剩余可划分的资源
+	Headroom corev1.ResourceList `json:"headroom"`
+	// NodeAllocatable 可调度节点的可分配资源
+	NodeAllocatable corev1.ResourceList `json:"nodeAllocatable"`
+	// NodeRequested 可调度节点上已经被请求的资源
+	NodeRequested corev1.ResourceList `json:"nodeRequested"`
+	// SchedulableNodes 可调度的节点数量
+	SchedulableNodes int `json:"schedulableNodes"`
+	// ProjectEnvironments 项目在该集群已有的环境数量
+	ProjectEnvironments int `json:"projectEnvironments"`
+}
+
+// PlacementCandidate 放置评估需要的集群信息
+type PlacementCandidate struct {
+	ClusterID   uint
+	ClusterName string
+	// TenantHard,TenantAllocated 租户在集群的资源限制和已经划分的资源
+	TenantHard      corev1.ResourceList
+	TenantAllocated corev1.ResourceList
+	// ProjectHard,ProjectAllocated 项目在集群的资源限制和已经划分的资源,项目未设置资源限制时为nil
+	ProjectHard      corev1.ResourceList
+	ProjectAllocated corev1.ResourceList
+	Nodes            []corev1.Node
+	// NodeRequested 每个节点上已经被请求的资源
+	NodeRequested       map[string]corev1.ResourceList
+	ProjectEnvironments int
+	// Err 获取集群信息时出错
+	Err error
+}
+
+// EnvironmentPlacement 评估并排序可用于创建环境的集群
+// @Tags        Project
+// @Summary     评估并排序可用于创建环境的集群
+// @Description 根据租户/项目剩余资源,节点容量,标签与污点,以及项目已有环境对集群评分,结果按评分从高到低排序
+// @Accept      json
+// @Produce     json
+// @Param       project_id path     uint                                                 true "project_id"
+// @Param       param      body     PlacementRequest                                     true "表单"
+// @Success     200        {object} handlers.ResponseStruct{Data=[]ClusterPlacement} "placements"
+// @Router      /v1/project/{project_id}/environment/placement [post]
+// @Security    JWT
+func (h *EnvironmentHandler) EnvironmentPlacement(c *gin.Context) {
+	req := &PlacementRequest{}
+	if err := c.BindJSON(req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	project := &models.Project{}
+	if err := h.GetDB().WithContext(c.Request.Context()).Preload("Tenant").First(project, "id = ?", c.Param("project_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	placements, err := PlaceEnvironment(c.Request.Context(), h.BaseHandler, project, req)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, placements)
+}
+
+// PlaceEnvironment 评估项目所属租户有资源的集群,返回按评分排序的放置结果
+func PlaceEnvironment(ctx context.Context, h base.BaseHandler, project *models.Project, req *PlacementRequest) ([]ClusterPlacement, error) {
+	trqs := []models.TenantResourceQuota{}
+	query := h.GetDB().WithContext(ctx).Preload("Cluster", clusterSensitiveFunc).Where("tenant_id = ?", project.TenantID)
+	if len(req.ClusterIDs) > 0 {
+		query = query.Where("cluster_id in ?", req.ClusterIDs)
+	}
+	if err := query.Find(&trqs).Error; err != nil {
+		return nil, err
+	}
+	if len(trqs) == 0 {
+		return nil, i18n.Errorf(ctx, "tenant %s has no resource in any cluster", project.Tenant.TenantName)
+	}
+	envcounts := []struct {
+		ClusterID uint
+		Count     int
+	}{}
+	if err := h.GetDB().WithContext(ctx).Model(&models.Environment{}).
+		Select("cluster_id, count(*) as count").Where("project_id = ?", project.ID).
+		Group("cluster_id").Scan(&envcounts).Error; err != nil {
+		return nil, err
+	}
+
+	candidates := make([]PlacementCandidate, len(trqs))
+	eg := &errgroup.Group{}
+	for i, trq := range trqs {
+		i, trq := i, trq
+		candidates[i] = PlacementCandidate{ClusterID: trq.ClusterID, ClusterName: trq.Cluster.ClusterName}
+		for _, count := range envcounts {
+			if count.ClusterID == trq.ClusterID {
+				candidates[i].ProjectEnvironments = count.Count
+			}
+		}
+		eg.Go(func() error {
+			candidates[i].Err = h.Execute(ctx, trq.Cluster.ClusterName, func(ctx context.Context, cli agents.Client) error {
+				return collectPlacementCandidate(ctx, cli, project.Tenant.TenantName, project.ProjectName, &candidates[i])
+			})
+			if candidates[i].Err != nil {
+				log.FromContextOrDiscard(ctx).Error(candidates[i].Err, "collect placement candidate", "cluster", trq.Cluster.ClusterName)
+			}
+			return nil
+		})
+	}
+	_ = eg.Wait()
+
+	resourceQuota := req.ResourceQuota.DeepCopy()
+	resourcequota.SetSameRequestWithLimit(resourceQuota)
+	return RankPlacements(candidates, resourceQuota, req.NodeSelector, req.Tolerations), nil
+}
+
+func collectPlacementCandidate(ctx context.Context, cli agents.Client, tenant, project string, candidate *PlacementCandidate) error {
+	trq := &v1beta1.TenantResourceQuota{}
+	if err := cli.Get(ctx, client.ObjectKey{Name: tenant}, trq); err != nil {
+		return err
+	}
+	candidate.TenantHard, candidate.TenantAllocated = trq.Spec.Hard, trq.Status.Allocated
+
+	prqs := &v1beta1.ProjectResourceQuotaList{}
+	if err := cli.List(ctx, prqs); err != nil {
+		return err
+	}
+	for _, prq := range prqs.Items {
+		if prq.Spec.Tenant == tenant && prq.Spec.Project == project {
+			candidate.ProjectHard, candidate.ProjectAllocated = prq.Spec.Hard, prq.Status.Allocated
+		}
+	}
+
+	nodes := &corev1.NodeList{}
+	if err := cli.List(ctx, nodes); err != nil {
+		return err
+	}
+	candidate.Nodes = nodes.Items
+
+	pods := &corev1.PodList{}
+	if err := cli.List(ctx, pods); err != nil {
+		return err
+	}
+	candidate.NodeRequested = map[string]corev1.ResourceList{}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName == "" || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if _, ok := candidate.NodeRequested[pod.Spec.NodeName]; !ok {
+			candidate.NodeRequested[pod.Spec.NodeName] = corev1.ResourceList{}
+		}
+		for _, container := range pod.Spec.Containers {
+			statistics.AddResourceList(candidate.NodeRequested[pod.Spec.NodeName], container.Resources.Requests)
+		}
+	}
+	return nil
+}
+
+// RankPlacements 对候选集群评分并排序,可以放置的集群排在前面
+func RankPlacements(candidates []PlacementCandidate, request corev1.ResourceList, nodeSelector map[string]string, tolerations []corev1.Toleration) []ClusterPlacement {
+	placements := make([]ClusterPlacement, 0, len(candidates))
+	for _, candidate := range candidates {
+		placements = append(placements, evaluatePlacement(candidate, request, nodeSelector, tolerations))
+	}
+	sort.SliceStable(placements, func(i, j int) bool {
+		if placements[i].Feasible != placements[j].Feasible {
+			return placements[i].Feasible
+		}
+		return placements[i].Score > placements[j].Score
+	})
+	return placements
+}
+
+func evaluatePlacement(candidate PlacementCandidate, request corev1.ResourceList, nodeSelector map[string]string, tolerations []corev1.Toleration) ClusterPlacement {
+	placement := ClusterPlacement{
+		ClusterID:           candidate.ClusterID,
+		ClusterName:         candidate.ClusterName,
+		ProjectEnvironments: candidate.ProjectEnvironments,
+		Feasible:            true,
+	}
+	if candidate.Err != nil {
+		placement.Feasible = false
+		placement.Reasons = append(placement.Reasons, fmt.Sprintf("failed to get cluster status: %v", candidate.Err))
+		return placement
+	}
+
+	// 1. 租户/项目剩余资源
+	placement.Headroom = headroom(candidate.TenantHard, candidate.TenantAllocated)
+	if candidate.ProjectHard != nil {
+		projectHeadroom := headroom(candidate.ProjectHard, candidate.ProjectAllocated)
+		for name, quantity := range projectHeadroom {
+			if tenantQuantity, ok := placement.Headroom[name]; !ok || quantity.Cmp(tenantQuantity) < 0 {
+				placement.Headroom[name] = quantity
+			}
+		}
+	}
+	quotaScore := 1.0
+	for name, quantity := range request {
+		if quantity.IsZero() {
+			continue
+		}
+		left, ok := placement.Headroom[name]
+		if !ok || left.Cmp(quantity) < 0 {
+			placement.Feasible = false
+			placement.Reasons = append(placement.Reasons, fmt.Sprintf("%s not enough, left %s but request %s", name, left.String(), quantity.String()))
+			continue
+		}
+		quotaScore = minFloat(quotaScore, 1-ratio(quantity, left))
+	}
+
+	// 2. 可调度节点
+	selector := labels.SelectorFromSet(nodeSelector)
+	placement.NodeAllocatable, placement.NodeRequested = corev1.ResourceList{}, corev1.ResourceList{}
+	for _, node := range candidate.Nodes {
+		if node.Spec.Unschedulable || !selector.Matches(labels.Set(node.Labels)) || !toleratesNodeTaints(node.Spec.Taints, tolerations) {
+			continue
+		}
+		placement.SchedulableNodes++
+		statistics.AddResourceList(placement.NodeAllocatable, node.Status.Allocatable)
+		statistics.AddResourceList(placement.NodeRequested, candidate.NodeRequested[node.Name])
+	}
+	if placement.SchedulableNodes == 0 {
+		placement.Feasible = false
+		placement.Reasons = append(placement.Reasons, "no schedulable node matches the node selector and tolerations")
+	}
+	nodeScore := 1.0
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		quantity, ok := request[corev1.ResourceName("requests."+string(name))]
+		if !ok {
+			quantity, ok = request[name]
+		}
+		if !ok || quantity.IsZero() {
+			continue
+		}
+		allocatable := placement.NodeAllocatable[name]
+		free := allocatable.DeepCopy()
+		free.Sub(placement.NodeRequested[name])
+		if free.Cmp(quantity) < 0 {
+			// 节点资源不足不影响环境创建(ResourceQuota仅是限制),只降低评分
+			placement.Reasons = append(placement.Reasons, fmt.Sprintf("free %s of schedulable nodes %s is less than request %s", name, free.String(), quantity.String()))
+			nodeScore = 0
+			continue
+		}
+		nodeScore = minFloat(nodeScore, 1-ratio(quantity, free))
+	}
+
+	// 3. 项目已有环境的集群优先
+	affinityScore := 0.0
+	if candidate.ProjectEnvironments > 0 {
+		affinityScore = 1
+		placement.Reasons = append(placement.Reasons, fmt.Sprintf("project has %d environments in this cluster", candidate.ProjectEnvironments))
+	}
+
+	if placement.Feasible {
+		placement.Score = 100 * (placementWeightQuota*quotaScore + placementWeightNode*nodeScore + placementWeightAffinity*affinityScore)
+	}
+	return placement
+}
+
+func headroom(hard, allocated corev1.ResourceList) corev1.ResourceList {
+	left := hard.DeepCopy()
+	if left == nil {
+		left = corev1.ResourceList{}
+	}
+	statistics.SubResourceList(left, allocated)
+	for name, quantity := range left {
+		if quantity.Sign() < 0 {
+			left[name] = resource.MustParse("0")
+		}
+	}
+	return left
+}
+
+func toleratesNodeTaints(taints []corev1.Taint, tolerations []corev1.Toleration) bool {
+	for i := range taints {
+		taint := &taints[i]
+		if taint.Effect != corev1.TaintEffectNoSchedule && taint.Effect != corev1.TaintEffectNoExecute {
+			continue
+		}
+		tolerated := false
+		for _, toleration := range tolerations {
+			if toleration.ToleratesTaint(taint) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return false
+		}
+	}
+	return true
+}
+
+func ratio(a, b resource.Quantity) float64 {
+	if b.IsZero() {
+		return 1
+	}
+	return a.AsApproximateFloat64() / b.AsApproximateFloat64()
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// BestPlacement 返回评分最高的可放置集群
+func BestPlacement(ctx context.Context, placements []ClusterPlacement) (*ClusterPlacement, error) {
+	if len(placements) == 0 || !placements[0].Feasible {
+		reasons := []string{}
+		for _, p := range placements {
+			reasons = append(reasons, p.ClusterName+": "+strings.Join(p.Reasons, ","))
+		}
+		return nil, i18n.Errorf(ctx, "no cluster available for the environment: %s", strings.Join(reasons, "; "))
+	}
+	return &placements[0], nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package environment
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRankPlacements(t *testing.T) {
+	node := func(name string, cpu string, labels map[string]string, taints ...v1.Taint) v1.Node {
+		return v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+			Spec:       v1.NodeSpec{Taints: taints},
+			Status:     v1.NodeStatus{Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}},
+		}
+	}
+	quota := func(cpu string) v1.ResourceList {
+		return v1.ResourceList{"requests.cpu": resource.MustParse(cpu)}
+	}
+	gpuTaint := v1.Taint{Key: "gpu", Value: "true", Effect: v1.TaintEffectNoSchedule}
+
+	tests := []struct {
+		name         string
+		candidates   []PlacementCandidate
+		request      v1.ResourceList
+		nodeSelector map[string]string
+		tolerations  []v1.Toleration
+		want         []string // cluster names in order
+		feasible     []bool
+	}{
+		{
+			name: "more headroom first",
+			candidates: []PlacementCandidate{
+				{ClusterName: "a", TenantHard: quota("10"), TenantAllocated: quota("8"), Nodes: []v1.Node{node("n1", "16", nil)}},
+				{ClusterName: "b", TenantHard: quota("10"), TenantAllocated: quota("2"), Nodes: []v1.Node{node("n1", "16", nil)}},
+			},
+			request:  quota("1"),
+			want:     []string{"b", "a"},
+			feasible: []bool{true, true},
+		},
+		{
+			name: "not enough tenant quota",
+			candidates: []PlacementCandidate{
+				{ClusterName: "a", TenantHard: quota("10"), TenantAllocated: quota("8"), Nodes: []v1.Node{node("n1", "16", nil)}},
+				{ClusterName: "b", TenantHard: quota("10"), TenantAllocated: quota("9"), Nodes: []v1.Node{node("n1", "16", nil)}},
+			},
+			request:  quota("2"),
+			want:     []string{"a", "b"},
+			feasible: []bool{true, false},
+		},
+		{
+			name: "project quota limits headroom",
+			candidates: []PlacementCandidate{
+				{
+					ClusterName: "a", TenantHard: quota("10"), TenantAllocated: quota("0"),
+					ProjectHard: quota("2"), ProjectAllocated: quota("2"), Nodes: []v1.Node{node("n1", "16", nil)},
+				},
+				{ClusterName: "b", TenantHard: quota("10"), TenantAllocated: quota("5"), Nodes: []v1.Node{node("n1", "16", nil)}},
+			},
+			request:  quota("1"),
+			want:     []string{"b", "a"},
+			feasible: []bool{true, false},
+		},
+		{
+			name: "node selector and taints",
+			candidates: []PlacementCandidate{
+				{ClusterName: "a", TenantHard: quota("10"), TenantAllocated: quota("0"), Nodes: []v1.Node{node("n1", "16", map[string]string{"gpu": "true"}, gpuTaint)}},
+				{ClusterName: "b", TenantHard: quota("10"), TenantAllocated: quota("0"), Nodes: []v1.Node{node("n1", "16", nil)}},
+			},
+			request:      quota("1"),
+			nodeSelector: map[string]string{"gpu": "true"},
+			tolerations:  []v1.Toleration{{Key: "gpu", Operator: v1.TolerationOpExists}},
+			want:         []string{"a", "b"},
+			feasible:     []bool{true, false},
+		},
+		{
+			name: "project affinity",
+			candidates: []PlacementCandidate{
+				{ClusterName: "a", TenantHard: quota("10"), TenantAllocated: quota("0"), Nodes: []v1.Node{node("n1", "16", nil)}},
+				{ClusterName: "b", TenantHard: quota("10"), TenantAllocated: quota("0"), Nodes: []v1.Node{node("n1", "16", nil)}, ProjectEnvironments: 2},
+			},
+			request:  quota("1"),
+			want:     []string{"b", "a"},
+			feasible: []bool{true, true},
+		},
+		{
+			name: "unreachable cluster",
+			candidates: []PlacementCandidate{
+				{ClusterName: "a", Err: errors.New("timeout")},
+				{ClusterName: "b", TenantHard: quota("10"), TenantAllocated: quota("0"), Nodes: []v1.Node{node("n1", "16", nil)}},
+			},
+			request:  quota("1"),
+			want:     []string{"b", "a"},
+			feasible: []bool{true, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			placements := RankPlacements(tt.candidates, tt.request, tt.nodeSelector, tt.tolerations)
+			names, feasible := []string{}, []bool{}
+			for _, p := range placements {
+				names = append(names, p.ClusterName)
+				feasible = append(feasible, p.Feasible)
+			}
+			if !reflect.DeepEqual(names, tt.want) || !reflect.DeepEqual(feasible, tt.feasible) {
+				t.Errorf("RankPlacements() = %v %v, want %v %v", names, feasible, tt.want, tt.feasible)
+			}
+		})
+	}
+}
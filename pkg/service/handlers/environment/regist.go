@@ -39,4 +39,6 @@ func (h *EnvironmentHandler) RegistRouter(rg *gin.RouterGroup) {
 	rg.GET("/environment/:environment_id/resources", h.CheckByEnvironmentID, h.GetEnvironmentResource)
 
 	rg.GET("/environment/:environment_id/observability", h.CheckByEnvironmentID, h.EnvironmentObservabilityDetails)
+
+	rg.POST("/project/:project_id/environment/placement", h.CheckByProjectID, h.EnvironmentPlacement)
 }
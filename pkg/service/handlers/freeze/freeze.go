@@ -0,0 +1,306 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freezehandler
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils"
+)
+
+type FreezeStatus struct {
+	Frozen bool                 `json:"frozen"`
+	Window *models.FreezeWindow `json:"window,omitempty"`
+	Until  *time.Time           `json:"until,omitempty"`
+}
+
+// ListTenantFreezeWindows 获取租户的冻结窗口
+// @Tags        Freeze
+// @Summary     获取租户的冻结窗口
+// @Description 获取租户的冻结窗口
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id path     uint                                                true "tenant_id"
+// @Success     200       {object} handlers.ResponseStruct{Data=[]models.FreezeWindow} "FreezeWindow"
+// @Router      /v1/tenant/{tenant_id}/freezewindow [get]
+// @Security    JWT
+func (h *FreezeHandler) ListTenantFreezeWindows(c *gin.Context) {
+	h.listFreezeWindows(c, models.FreezeScopeTenant, utils.ToUint(c.Param("tenant_id")))
+}
+
+// CreateTenantFreezeWindow 创建租户的冻结窗口
+// @Tags        Freeze
+// @Summary     创建租户的冻结窗口
+// @Description 租户下所有环境在窗口内禁止应用同步,镜像更新,灰度控制以及通过代理修改资源
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id path     uint                                              true "tenant_id"
+// @Param       param     body     models.FreezeWindow                               true "冻结窗口"
+// @Success     200       {object} handlers.ResponseStruct{Data=models.FreezeWindow} "FreezeWindow"
+// @Router      /v1/tenant/{tenant_id}/freezewindow [post]
+// @Security    JWT
+func (h *FreezeHandler) CreateTenantFreezeWindow(c *gin.Context) {
+	h.createFreezeWindow(c, models.FreezeScopeTenant, utils.ToUint(c.Param("tenant_id")))
+}
+
+// ListProjectFreezeWindows 获取项目的冻结窗口
+// @Tags        Freeze
+// @Summary     获取项目的冻结窗口
+// @Description 获取项目的冻结窗口
+// @Accept      json
+// @Produce     json
+// @Param       project_id path     uint                                                true "project_id"
+// @Success     200        {object} handlers.ResponseStruct{Data=[]models.FreezeWindow} "FreezeWindow"
+// @Router      /v1/project/{project_id}/freezewindow [get]
+// @Security    JWT
+func (h *FreezeHandler) ListProjectFreezeWindows(c *gin.Context) {
+	h.listFreezeWindows(c, models.FreezeScopeProject, utils.ToUint(c.Param("project_id")))
+}
+
+// CreateProjectFreezeWindow 创建项目的冻结窗口
+// @Tags        Freeze
+// @Summary     创建项目的冻结窗口
+// @Description 项目下所有环境在窗口内禁止应用同步,镜像更新,灰度控制以及通过代理修改资源
+// @Accept      json
+// @Produce     json
+// @Param       project_id path     uint                                              true "project_id"
+// @Param       param      body     models.FreezeWindow                               true "冻结窗口"
+// @Success     200        {object} handlers.ResponseStruct{Data=models.FreezeWindow} "FreezeWindow"
+// @Router      /v1/project/{project_id}/freezewindow [post]
+// @Security    JWT
+func (h *FreezeHandler) CreateProjectFreezeWindow(c *gin.Context) {
+	h.createFreezeWindow(c, models.FreezeScopeProject, utils.ToUint(c.Param("project_id")))
+}
+
+// ListEnvironmentFreezeWindows 获取环境的冻结窗口
+// @Tags        Freeze
+// @Summary     获取环境的冻结窗口
+// @Description 获取环境自身的冻结窗口,不包含所属租户和项目的窗口
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     uint                                                true "environment_id"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]models.FreezeWindow} "FreezeWindow"
+// @Router      /v1/environment/{environment_id}/freezewindow [get]
+// @Security    JWT
+func (h *FreezeHandler) ListEnvironmentFreezeWindows(c *gin.Context) {
+	h.listFreezeWindows(c, models.FreezeScopeEnvironment, utils.ToUint(c.Param("environment_id")))
+}
+
+// CreateEnvironmentFreezeWindow 创建环境的冻结窗口
+// @Tags        Freeze
+// @Summary     创建环境的冻结窗口
+// @Description 环境在窗口内禁止应用同步,镜像更新,灰度控制以及通过代理修改资源
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     uint                                              true "environment_id"
+// @Param       param          body     models.FreezeWindow                               true "冻结窗口"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.FreezeWindow} "FreezeWindow"
+// @Router      /v1/environment/{environment_id}/freezewindow [post]
+// @Security    JWT
+func (h *FreezeHandler) CreateEnvironmentFreezeWindow(c *gin.Context) {
+	h.createFreezeWindow(c, models.FreezeScopeEnvironment, utils.ToUint(c.Param("environment_id")))
+}
+
+// EnvironmentFreezeStatus 获取环境当前的冻结状态
+// @Tags        Freeze
+// @Summary     获取环境当前的冻结状态
+// @Description 获取环境当前的冻结状态,包括所属租户和项目的冻结窗口
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     uint                                      true "environment_id"
+// @Success     200            {object} handlers.ResponseStruct{Data=FreezeStatus} "FreezeStatus"
+// @Router      /v1/environment/{environment_id}/freezestatus [get]
+// @Security    JWT
+func (h *FreezeHandler) EnvironmentFreezeStatus(c *gin.Context) {
+	ctx := c.Request.Context()
+	env := &models.Environment{}
+	if err := h.GetDB().WithContext(ctx).Preload("Project").First(env, c.Param("environment_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	now := time.Now()
+	window, err := models.ActiveFreezeWindow(h.GetDB().WithContext(ctx), env, now)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	status := FreezeStatus{}
+	if window != nil {
+		_, until := window.ActiveAt(now)
+		status = FreezeStatus{Frozen: true, Window: window, Until: &until}
+	}
+	handlers.OK(c, status)
+}
+
+// UpdateFreezeWindow 更新冻结窗口
+// @Tags        Freeze
+// @Summary     更新冻结窗口
+// @Description 更新冻结窗口
+// @Accept      json
+// @Produce     json
+// @Param       id    path     uint                                              true "freeze window id"
+// @Param       param body     models.FreezeWindow                               true "冻结窗口"
+// @Success     200   {object} handlers.ResponseStruct{Data=models.FreezeWindow} "FreezeWindow"
+// @Router      /v1/freezewindow/{id} [put]
+// @Security    JWT
+func (h *FreezeHandler) UpdateFreezeWindow(c *gin.Context) {
+	ctx := c.Request.Context()
+	window, err := h.getFreezeWindow(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	// 未指定 enabled 时保持原状态
+	body := &models.FreezeWindow{Enabled: window.Enabled}
+	if err := c.BindJSON(body); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	// 不允许修改窗口的作用范围
+	body.ID, body.Scope, body.ScopeID = window.ID, window.Scope, window.ScopeID
+	body.Creator, body.CreatedAt = window.Creator, window.CreatedAt
+	if err := body.Validate(); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if err := h.GetDB().WithContext(ctx).Save(body).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.auditFreezeWindow(c, "update", body)
+	handlers.OK(c, body)
+}
+
+// DeleteFreezeWindow 删除冻结窗口
+// @Tags        Freeze
+// @Summary     删除冻结窗口
+// @Description 删除冻结窗口
+// @Accept      json
+// @Produce     json
+// @Param       id  path     uint                    true "freeze window id"
+// @Success     204 {object} handlers.ResponseStruct "resp"
+// @Router      /v1/freezewindow/{id} [delete]
+// @Security    JWT
+func (h *FreezeHandler) DeleteFreezeWindow(c *gin.Context) {
+	window, err := h.getFreezeWindow(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if err := h.GetDB().WithContext(c.Request.Context()).Delete(window).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.auditFreezeWindow(c, "delete", window)
+	handlers.NoContent(c, nil)
+}
+
+func (h *FreezeHandler) listFreezeWindows(c *gin.Context, scope string, scopeid uint) {
+	windows := []models.FreezeWindow{}
+	if err := h.GetDB().WithContext(c.Request.Context()).
+		Order("id desc").
+		Find(&windows, "scope = ? and scope_id = ?", scope, scopeid).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, windows)
+}
+
+func (h *FreezeHandler) createFreezeWindow(c *gin.Context, scope string, scopeid uint) {
+	if err := h.checkScopeAdmin(c, scope, scopeid); err != nil {
+		handlers.Forbidden(c, err)
+		return
+	}
+	// 未指定 enabled 时默认启用,避免创建出不生效的窗口
+	window := &models.FreezeWindow{Enabled: true}
+	if err := c.BindJSON(window); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	window.ID = 0
+	window.Scope, window.ScopeID = scope, scopeid
+	if u, exist := h.GetContextUser(c); exist {
+		window.Creator = u.GetUsername()
+	}
+	if err := window.Validate(); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if err := h.GetDB().WithContext(c.Request.Context()).Create(window).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.auditFreezeWindow(c, "create", window)
+	handlers.OK(c, window)
+}
+
+// 只有对应范围的管理员可以修改冻结窗口
+func (h *FreezeHandler) getFreezeWindow(c *gin.Context) (*models.FreezeWindow, error) {
+	window := &models.FreezeWindow{}
+	if err := h.GetDB().WithContext(c.Request.Context()).First(window, c.Param("id")).Error; err != nil {
+		return nil, err
+	}
+	if err := h.checkScopeAdmin(c, window.Scope, window.ScopeID); err != nil {
+		return nil, err
+	}
+	return window, nil
+}
+
+func (h *FreezeHandler) checkScopeAdmin(c *gin.Context, scope string, scopeid uint) error {
+	u, exist := h.GetContextUser(c)
+	if !exist {
+		return i18n.Errorf(c, "can't get current user")
+	}
+	auth := h.ModelCache().GetUserAuthority(u)
+	if auth.IsSystemAdmin() {
+		return nil
+	}
+	db := h.GetDB().WithContext(c.Request.Context())
+	switch scope {
+	case models.FreezeScopeTenant:
+		if auth.IsTenantAdmin(scopeid) {
+			return nil
+		}
+	case models.FreezeScopeProject:
+		project := &models.Project{}
+		if err := db.First(project, scopeid).Error; err != nil {
+			return err
+		}
+		if auth.IsTenantAdmin(project.TenantID) || auth.IsProjectAdmin(project.ID) {
+			return nil
+		}
+	case models.FreezeScopeEnvironment:
+		env := &models.Environment{}
+		if err := db.Preload("Project").First(env, scopeid).Error; err != nil {
+			return err
+		}
+		if auth.IsTenantAdmin(env.Project.TenantID) || auth.IsProjectAdmin(env.ProjectID) {
+			return nil
+		}
+	}
+	return i18n.Errorf(c, "only %s admin can modify freeze windows", scope)
+}
+
+func (h *FreezeHandler) auditFreezeWindow(c *gin.Context, action string, window *models.FreezeWindow) {
+	module := i18n.Sprintf(context.TODO(), "freeze window")
+	h.SetAuditData(c, i18n.Sprintf(context.TODO(), action), module, window.Name)
+	// 冻结窗口的 scope 与审计的资源类型一致
+	h.SetExtraAuditData(c, window.Scope, window.ScopeID)
+}
@@ -0,0 +1,36 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freezehandler
+
+import (
+	"github.com/gin-gonic/gin"
+	"kubegems.io/kubegems/pkg/service/handlers/base"
+)
+
+type FreezeHandler struct {
+	base.BaseHandler
+}
+
+func (h *FreezeHandler) RegistRouter(rg *gin.RouterGroup) {
+	rg.GET("/tenant/:tenant_id/freezewindow", h.CheckByTenantID, h.ListTenantFreezeWindows)
+	rg.POST("/tenant/:tenant_id/freezewindow", h.CheckByTenantID, h.CreateTenantFreezeWindow)
+	rg.GET("/project/:project_id/freezewindow", h.CheckByProjectID, h.ListProjectFreezeWindows)
+	rg.POST("/project/:project_id/freezewindow", h.CheckByProjectID, h.CreateProjectFreezeWindow)
+	rg.GET("/environment/:environment_id/freezewindow", h.CheckByEnvironmentID, h.ListEnvironmentFreezeWindows)
+	rg.POST("/environment/:environment_id/freezewindow", h.CheckByEnvironmentID, h.CreateEnvironmentFreezeWindow)
+	rg.GET("/environment/:environment_id/freezestatus", h.CheckByEnvironmentID, h.EnvironmentFreezeStatus)
+	rg.PUT("/freezewindow/:id", h.UpdateFreezeWindow)
+	rg.DELETE("/freezewindow/:id", h.DeleteFreezeWindow)
+}
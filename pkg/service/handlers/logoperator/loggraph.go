@@ -16,36 +16,29 @@ package logoperatorhandler
 
 import (
 	"context"
+	"fmt"
 	"sort"
 	"strings"
 
-	// "fmt"
-	// "net/http"
-	// "net/url"
-	// "sync"
-
 	loggingv1beta1 "github.com/banzaicloud/logging-operator/pkg/sdk/logging/api/v1beta1"
 	"github.com/gin-gonic/gin"
+	promemodel "github.com/prometheus/common/model"
+	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-
-	// promemodel "github.com/prometheus/common/model"
-
-	// corev1 "k8s.io/api/core/v1"
 	gemlabels "kubegems.io/kubegems/pkg/apis/gems"
+	"kubegems.io/kubegems/pkg/log"
 	"kubegems.io/kubegems/pkg/service/handlers"
 	"kubegems.io/kubegems/pkg/service/models"
 	"kubegems.io/kubegems/pkg/utils/agents"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	// metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	// "src.kubegems.io/controller/gemlabels"
 )
 
 var PrimaryKeyName = "tenant_id"
 
 type AggrValue struct {
-	Min  float64
-	Hour float64
-	Day  float64
+	Min  float64 `json:"min"`
+	Hour float64 `json:"hour"`
+	Day  float64 `json:"day"`
 }
 
 func (h *LogOperatorHandler) GetTenantNamespaces(c *gin.Context) ([]string, error) {
@@ -118,277 +111,405 @@ func (h *LogOperatorHandler) Outputs(c *gin.Context) {
 	handlers.OK(c, allOutputs)
 }
 
-// TODO: uncomment when metrics api is stable
-// func (h *LogOperatorHandler) Metrics(c *gin.Context) {
-// 	cluster := c.Param("cluster")
-// 	namespace := c.Param("namespace")
-// 	flowid := c.Param("flowid")
-// 	flow, err := kubeclient.GetClient().GetFlowByName(cluster, namespace, flowid, nil)
-// 	if err != nil {
-// 		handlers.NotOK(c, err)
-// 		return
-// 	}
-// 	buildMetricTable := func(flow *loggingv1beta1.Flow, cluster, namespace string) (*models.MetricTable, error) {
-// 		table := &models.MetricTable{}
-// 		pods, err := kubeclient.GetClient().GetPodsOfNamespaces(cluster, namespace, nil)
-// 		if err != nil {
-// 			return nil, err
-// 		}
-// 		appSet := make(map[string][]corev1.Pod, 10)
-// 		for _, p := range pods {
-// 			if v, ok := p.Labels[gemlabels.LabelApplication]; ok {
-// 				appSet[v] = append(appSet[v], p)
-// 			}
-// 		}
-// 		if len(appSet) <= 0 {
-// 			return nil, fmt.Errorf("no metadata exist, so skip metrics")
-// 		}
-
-// 		ret := make([]promemodel.Vector, 3)
-// 		interval := []string{"1m", "1h", "24h"}
-// 		var wg sync.WaitGroup
-// 		for i := 0; i < 3; i++ {
-// 			wg.Add(1)
-// 			go func(index int) error {
-// 				defer wg.Done()
-// 				values := url.Values{}
-// 				promql := fmt.Sprintf("irate(logging_entry_count{namespace=\"%s\"}[%s])", namespace, interval[index])
-// 				values.Add("query", promql)
-// 				u := "/custom/prometheus/v1/vector?" + values.Encode()
-// 				err = kubeclient.DoRequest(http.MethodGet, cluster, u, nil, &ret[index])
-// 				if err != nil {
-// 					return err
-// 				}
-// 				return nil
-// 			}(i)
-
-// 		}
-// 		wg.Wait()
-
-// 		appAggrValue := make(map[string]map[string]float64, 10)
-
-// 		// appSet = map[string][]corev1.Pod{
-// 		// 	"test-app": {
-// 		// 		corev1.Pod{
-// 		// 			ObjectMeta: metav1.ObjectMeta{
-// 		// 				Name: "test_pod",
-// 		// 			},
-// 		// 		},
-// 		// 	},
-// 		// }
-// 		// ret = []promemodel.Vector{
-// 		// 	{
-// 		// 		&promemodel.Sample{
-// 		// 			Metric: promemodel.Metric{
-// 		// 				"pod": "test_pod",
-// 		// 			},
-// 		// 			Value:     123.1,
-// 		// 			Timestamp: 1234567,
-// 		// 		},
-// 		// 	},
-// 		// 	{
-// 		// 		&promemodel.Sample{
-// 		// 			Metric: promemodel.Metric{
-// 		// 				"pod": "test_pod",
-// 		// 			},
-// 		// 			Value:     124.1,
-// 		// 			Timestamp: 1234567,
-// 		// 		},
-// 		// 	},
-// 		// 	{
-// 		// 		&promemodel.Sample{
-// 		// 			Metric: promemodel.Metric{
-// 		// 				"pod": "test_pod",
-// 		// 			},
-// 		// 			Value:     125.1,
-// 		// 			Timestamp: 1234567,
-// 		// 		},
-// 		// 	},
-// 		// }
-// 		// aggr 1m
-// 		for k, v := range appSet {
-// 			for _, p := range v {
-// 				for _, sample := range ret[0] {
-// 					if sample.Metric[promemodel.LabelName("pod")] == promemodel.LabelValue(p.Name) {
-// 						if aggr, exist := appAggrValue[k]; exist {
-// 							aggr["min"] += float64(sample.Value)
-// 						} else {
-// 							appAggrValue[k] = make(map[string]float64, 3)
-// 							appAggrValue[k]["min"] += float64(sample.Value)
-// 						}
-
-// 					}
-// 				}
-// 				for _, sample := range ret[1] {
-// 					if sample.Metric[promemodel.LabelName("pod")] == promemodel.LabelValue(p.Name) {
-// 						if aggr, exist := appAggrValue[k]; exist {
-// 							aggr["hour"] += float64(sample.Value)
-// 						} else {
-// 							appAggrValue[k] = make(map[string]float64, 3)
-// 							appAggrValue[k]["hour"] += float64(sample.Value)
-// 						}
-
-// 					}
-// 				}
-// 				for _, sample := range ret[2] {
-// 					if sample.Metric[promemodel.LabelName("pod")] == promemodel.LabelValue(p.Name) {
-// 						if aggr, exist := appAggrValue[k]; exist {
-// 							aggr["day"] += float64(sample.Value)
-// 						} else {
-// 							appAggrValue[k] = make(map[string]float64, 3)
-// 							appAggrValue[k]["day"] += float64(sample.Value)
-// 						}
-
-// 					}
-// 				}
-// 			}
-// 		}
-// 		for k, v := range appAggrValue {
-// 			table.Rows = append(table.Rows, models.MetricRow{
-// 				AppName: k,
-// 				RealTimeRate: func() float64 {
-// 					if _, ok := v["min"]; ok {
-// 						return v["min"]
-// 					}
-// 					return 0.0
-// 				}(),
-// 				AvgOfHour: func() float64 {
-// 					if _, ok := v["hour"]; ok {
-// 						return v["hour"]
-// 					}
-// 					return 0.0
-// 				}(),
-// 				AvgOfDay: func() float64 {
-// 					if _, ok := v["day"]; ok {
-// 						return v["day"]
-// 					}
-// 					return 0.0
-// 				}(),
-// 			})
-// 		}
-// 		return table, nil
-
-// 	}
-// 	table, err := buildMetricTable(flow, cluster, namespace)
-// 	if err != nil {
-// 		handlers.NotOK(c, err)
-// 		return
-// 	}
-// 	handlers.OK(c, table)
-// }
-
-// func (h *LogOperatorHandler) Graph(c *gin.Context) {
-// 	cluster := c.Param("cluster")
-// 	namespace := c.Param("namespace")
-// 	flowid := c.Param("flowid")
-// 	flow, err := kubeclient.GetClient().GetFlowByName(cluster, namespace, flowid, nil)
-// 	if err != nil {
-// 		handlers.NotOK(c, err)
-// 		return
-// 	}
-// 	graph := buildFlowGraph(flow)
-// 	handlers.OK(c, graph)
-// }
-
-// const (
-// 	virtualbox         = "filterbox"
-// 	matchprefix        = "matcher-"
-// 	filterprefix       = "filter-"
-// 	outputprefix       = "output-"
-// 	globaloutputprefix = "globaloutput-"
-// 	inprefix           = "input-"
-// 	outprefix          = "output-"
-// 	clusteroutprefix   = "clusteroutput-"
-// )
-
-// func buildFlowGraph(flow *loggingv1beta1.Flow) *models.Graph {
-// 	var nodes []*models.NodeWrapper
-// 	var edges []*models.EdgeWrapper
-// 	nodes = append(nodes, &models.NodeWrapper{
-// 		Data: &models.NodeData{
-// 			ID: virtualbox,
-// 		},
-// 	})
-// 	for id, _ := range flow.Spec.Match {
-// 		nd := &models.NodeData{
-// 			ID:       fmt.Sprintf("%s%d", matchprefix, id),
-// 			NodeType: string(models.Matcher),
-// 		}
-// 		nw := &models.NodeWrapper{
-// 			Data: nd,
-// 		}
-// 		nodes = append(nodes, nw)
-// 	}
-// 	for id, _ := range flow.Spec.Filters {
-// 		nd := &models.NodeData{
-// 			ID:       fmt.Sprintf("%s%d", filterprefix, id),
-// 			Parent:   virtualbox,
-// 			NodeType: string(models.Filter),
-// 		}
-// 		nw := &models.NodeWrapper{
-// 			Data: nd,
-// 		}
-// 		nodes = append(nodes, nw)
-// 	}
-
-// 	for id, _ := range flow.Spec.LocalOutputRefs {
-// 		nd := &models.NodeData{
-// 			ID:       fmt.Sprintf("%s%d", outputprefix, id),
-// 			NodeType: string(models.Output),
-// 		}
-// 		nw := &models.NodeWrapper{
-// 			Data: nd,
-// 		}
-// 		nodes = append(nodes, nw)
-// 	}
-// 	for id, _ := range flow.Spec.GlobalOutputRefs {
-// 		nd := &models.NodeData{
-// 			ID:       fmt.Sprintf("%s%d", globaloutputprefix, id),
-// 			NodeType: string(models.GlobalOutput),
-// 		}
-// 		nw := &models.NodeWrapper{
-// 			Data: nd,
-// 		}
-// 		nodes = append(nodes, nw)
-// 	}
-// 	for id, _ := range flow.Spec.Match {
-// 		ed := &models.EdgeData{
-// 			ID:     fmt.Sprintf("%s%d", inprefix, id),
-// 			Source: fmt.Sprintf("%s%d", matchprefix, id),
-// 			Target: virtualbox,
-// 		}
-// 		ew := &models.EdgeWrapper{
-// 			Data: ed,
-// 		}
-// 		edges = append(edges, ew)
-// 	}
-// 	for id, _ := range flow.Spec.LocalOutputRefs {
-// 		ed := &models.EdgeData{
-// 			ID:     fmt.Sprintf("%s%d", outprefix, id),
-// 			Target: fmt.Sprintf("%s%d", outputprefix, id),
-// 			Source: virtualbox,
-// 		}
-// 		ew := &models.EdgeWrapper{
-// 			Data: ed,
-// 		}
-// 		edges = append(edges, ew)
-// 	}
-// 	for id, _ := range flow.Spec.GlobalOutputRefs {
-// 		ed := &models.EdgeData{
-// 			ID:     fmt.Sprintf("%s%d", clusteroutprefix, id),
-// 			Target: fmt.Sprintf("%s%d", globaloutputprefix, id),
-// 			Source: virtualbox,
-// 		}
-// 		ew := &models.EdgeWrapper{
-// 			Data: ed,
-// 		}
-// 		edges = append(edges, ew)
-// 	}
-// 	graph := &models.Graph{
-// 		Elements: models.Elements{
-// 			Nodes: nodes,
-// 			Edges: edges,
-// 		},
-// 	}
-// 	return graph
-// }
+type AppLogRate struct {
+	App string `json:"app"`
+	// 1m 1h 内的每秒日志条数, 以及 1d 内的日志总条数
+	AggrValue `json:",inline"`
+}
+
+// Metrics flow 采集的各应用日志速率
+// @Tags        LogOperator
+// @Summary     flow 采集的各应用日志速率
+// @Description flow 采集的各应用日志速率, 依赖 flow 中的 prometheus filter
+// @Accept      json
+// @Produce     json
+// @Param       cluster   path     string                                     true "cluster"
+// @Param       namespace path     string                                     true "namespace"
+// @Param       flowid    path     string                                     true "flow name"
+// @Success     200       {object} handlers.ResponseStruct{Data=[]AppLogRate} "resp"
+// @Router      /v1/logging/cluster/{cluster}/namespaces/{namespace}/flows/{flowid}/metrics [get]
+// @Security    JWT
+func (h *LogOperatorHandler) Metrics(c *gin.Context) {
+	cluster := c.Param("cluster")
+	namespace := c.Param("namespace")
+	flowid := c.Param("flowid")
+	ret := []AppLogRate{}
+	if err := h.Execute(c.Request.Context(), cluster, func(ctx context.Context, cli agents.Client) error {
+		flow := &loggingv1beta1.Flow{}
+		if err := cli.Get(ctx, client.ObjectKey{Namespace: namespace, Name: flowid}, flow); err != nil {
+			return err
+		}
+		pods := corev1.PodList{}
+		if err := cli.List(ctx, &pods, client.InNamespace(namespace), client.HasLabels{gemlabels.LabelApplication}); err != nil {
+			return err
+		}
+		podApp := map[string]string{}
+		for _, p := range pods.Items {
+			podApp[p.Name] = p.Labels[gemlabels.LabelApplication]
+		}
+
+		apps := map[string]*AppLogRate{}
+		for _, interval := range []string{"1m", "1h", "1d"} {
+			vector, err := cli.Extend().PrometheusVector(ctx, appLogRateQuery(namespace, flowid, interval))
+			if err != nil {
+				return err
+			}
+			for _, sample := range vector {
+				app, ok := podApp[string(sample.Metric["pod"])]
+				if !ok {
+					continue
+				}
+				rate, ok := apps[app]
+				if !ok {
+					rate = &AppLogRate{App: app}
+					apps[app] = rate
+				}
+				switch interval {
+				case "1m":
+					rate.Min += float64(sample.Value)
+				case "1h":
+					rate.Hour += float64(sample.Value)
+				case "1d":
+					rate.Day += float64(sample.Value)
+				}
+			}
+		}
+		for _, v := range apps {
+			ret = append(ret, *v)
+		}
+		return nil
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].App < ret[j].App
+	})
+	handlers.OK(c, ret)
+}
+
+const (
+	NodeKindCollector     = "collector"  // fluentbit
+	NodeKindAggregator    = "aggregator" // fluentd
+	NodeKindFlow          = "flow"
+	NodeKindClusterFlow   = "clusterflow"
+	NodeKindOutput        = "output"
+	NodeKindClusterOutput = "clusteroutput"
+
+	MetricRecordsRate       = "recordsRate"       // 每秒日志条数
+	MetricErrorsRate        = "errorsRate"        // 每秒错误数
+	MetricDroppedRate       = "droppedRate"       // 每秒丢弃的日志条数
+	MetricRetries           = "retries"           // 重试次数
+	MetricBufferBytes       = "bufferBytes"       // 缓冲区大小
+	MetricBufferQueueLength = "bufferQueueLength" // 缓冲区队列长度
+
+	collectorNodeID  = "fluentbit"
+	aggregatorNodeID = "fluentd"
+
+	// prometheus filter 中定义的 flow 日志计数
+	flowRecordsMetric = "gems_logging_flow_records_total"
+)
+
+type LogGraphNode struct {
+	ID        string             `json:"id"`
+	Kind      string             `json:"kind"`
+	Name      string             `json:"name"`
+	Namespace string             `json:"namespace,omitempty"`
+	Missing   bool               `json:"missing,omitempty"` // 被引用但不存在的 output
+	Metrics   map[string]float64 `json:"metrics,omitempty"`
+}
+
+type LogGraphEdge struct {
+	ID      string             `json:"id"`
+	Source  string             `json:"source"`
+	Target  string             `json:"target"`
+	Metrics map[string]float64 `json:"metrics,omitempty"`
+}
+
+type LogGraph struct {
+	Nodes []*LogGraphNode `json:"nodes"`
+	Edges []*LogGraphEdge `json:"edges"`
+}
+
+// Graph 租户的日志管道拓扑
+// @Tags        LogOperator
+// @Summary     租户的日志管道拓扑
+// @Description fluentbit -> fluentd -> flow/clusterflow -> output/clusteroutput 的拓扑, 边上带有吞吐, 错误以及缓冲区指标, 用于定位日志丢失的位置
+// @Accept      json
+// @Produce     json
+// @Param       cluster   path     string                                   true "cluster"
+// @Param       tenant_id path     uint                                     true "tenant id"
+// @Success     200       {object} handlers.ResponseStruct{Data=LogGraph} "resp"
+// @Router      /v1/logging/cluster/{cluster}/tenant/{tenant_id}/graph [get]
+// @Security    JWT
+func (h *LogOperatorHandler) Graph(c *gin.Context) {
+	cluster := c.Param("cluster")
+	tenant := c.Param(PrimaryKeyName)
+	ctx := c.Request.Context()
+
+	namespaces := []string{}
+	if err := h.GetDB().WithContext(ctx).Model(&models.Environment{}).
+		Joins("join projects on projects.id = environments.project_id").
+		Joins("join clusters on clusters.id = environments.cluster_id").
+		Where("projects.tenant_id = ? and clusters.cluster_name = ?", tenant, cluster).
+		Pluck("environments.namespace", &namespaces).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	var graph *LogGraph
+	if err := h.Execute(ctx, cluster, func(ctx context.Context, cli agents.Client) error {
+		flows := loggingv1beta1.FlowList{}
+		if err := cli.List(ctx, &flows, client.InNamespace(v1.NamespaceAll), client.MatchingLabels{gemlabels.LabelTenant: tenant}); err != nil {
+			return err
+		}
+		outputs := loggingv1beta1.OutputList{}
+		if err := cli.List(ctx, &outputs, client.InNamespace(v1.NamespaceAll), client.MatchingLabels{gemlabels.LabelTenant: tenant}); err != nil {
+			return err
+		}
+		clusterFlows := loggingv1beta1.ClusterFlowList{}
+		if err := cli.List(ctx, &clusterFlows); err != nil {
+			return err
+		}
+		clusterOutputs := loggingv1beta1.ClusterOutputList{}
+		if err := cli.List(ctx, &clusterOutputs); err != nil {
+			return err
+		}
+		graph = buildLogGraph(flows.Items, outputs.Items, clusterFlows.Items, clusterOutputs.Items)
+		fillLogGraphMetrics(graph, queryLogGraphMetrics(ctx, cli, append(namespaces, flowNamespaces(flows.Items)...)))
+		return nil
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, graph)
+}
+
+func flowNodeID(namespace, name string) string {
+	return NodeKindFlow + "/" + namespace + "/" + name
+}
+
+func clusterFlowNodeID(name string) string {
+	return NodeKindClusterFlow + "/" + name
+}
+
+func outputNodeID(namespace, name string) string {
+	return NodeKindOutput + "/" + namespace + "/" + name
+}
+
+func clusterOutputNodeID(name string) string {
+	return NodeKindClusterOutput + "/" + name
+}
+
+func flowNamespaces(flows []loggingv1beta1.Flow) []string {
+	ret := []string{}
+	for _, flow := range flows {
+		ret = append(ret, flow.Namespace)
+	}
+	return ret
+}
+
+func buildLogGraph(flows []loggingv1beta1.Flow, outputs []loggingv1beta1.Output,
+	clusterFlows []loggingv1beta1.ClusterFlow, clusterOutputs []loggingv1beta1.ClusterOutput,
+) *LogGraph {
+	graph := &LogGraph{
+		Nodes: []*LogGraphNode{
+			{ID: collectorNodeID, Kind: NodeKindCollector, Name: collectorNodeID},
+			{ID: aggregatorNodeID, Kind: NodeKindAggregator, Name: aggregatorNodeID},
+		},
+		Edges: []*LogGraphEdge{
+			{ID: collectorNodeID + "->" + aggregatorNodeID, Source: collectorNodeID, Target: aggregatorNodeID},
+		},
+	}
+	nodes := map[string]*LogGraphNode{}
+	addNode := func(node *LogGraphNode) {
+		if _, ok := nodes[node.ID]; ok {
+			return
+		}
+		nodes[node.ID] = node
+		graph.Nodes = append(graph.Nodes, node)
+	}
+	addEdge := func(source, target string) {
+		graph.Edges = append(graph.Edges, &LogGraphEdge{ID: source + "->" + target, Source: source, Target: target})
+	}
+	for _, output := range outputs {
+		addNode(&LogGraphNode{ID: outputNodeID(output.Namespace, output.Name), Kind: NodeKindOutput, Name: output.Name, Namespace: output.Namespace})
+	}
+	for _, output := range clusterOutputs {
+		addNode(&LogGraphNode{ID: clusterOutputNodeID(output.Name), Kind: NodeKindClusterOutput, Name: output.Name, Namespace: output.Namespace})
+	}
+	// 引用的 output 不存在时日志会被丢弃, 也需要在图中展示
+	refOutput := func(source, namespace, name string) {
+		id := outputNodeID(namespace, name)
+		addNode(&LogGraphNode{ID: id, Kind: NodeKindOutput, Name: name, Namespace: namespace, Missing: true})
+		addEdge(source, id)
+	}
+	refClusterOutput := func(source, name string) {
+		id := clusterOutputNodeID(name)
+		addNode(&LogGraphNode{ID: id, Kind: NodeKindClusterOutput, Name: name, Missing: true})
+		addEdge(source, id)
+	}
+
+	for _, flow := range flows {
+		id := flowNodeID(flow.Namespace, flow.Name)
+		addNode(&LogGraphNode{ID: id, Kind: NodeKindFlow, Name: flow.Name, Namespace: flow.Namespace})
+		addEdge(aggregatorNodeID, id)
+		// outputRefs 已废弃, 与 localOutputRefs 含义相同
+		for _, refs := range [][]string{flow.Spec.LocalOutputRefs, flow.Spec.OutputRefs} {
+			for _, ref := range refs {
+				refOutput(id, flow.Namespace, ref)
+			}
+		}
+		for _, ref := range flow.Spec.GlobalOutputRefs {
+			refClusterOutput(id, ref)
+		}
+	}
+	for _, flow := range clusterFlows {
+		id := clusterFlowNodeID(flow.Name)
+		addNode(&LogGraphNode{ID: id, Kind: NodeKindClusterFlow, Name: flow.Name, Namespace: flow.Namespace})
+		addEdge(aggregatorNodeID, id)
+		for _, refs := range [][]string{flow.Spec.GlobalOutputRefs, flow.Spec.OutputRefs} {
+			for _, ref := range refs {
+				refClusterOutput(id, ref)
+			}
+		}
+	}
+	return graph
+}
+
+// appLogRateQuery 1m 1h 为每秒日志条数, 1d 为一天内的日志总条数
+func appLogRateQuery(namespace, flowid, interval string) string {
+	fn := "rate"
+	if interval == "1d" {
+		fn = "increase"
+	}
+	return fmt.Sprintf(`sum by(pod) (%s(%s{namespace="%s", flow="%s"}[%s]))`, fn, flowRecordsMetric, namespace, flowid, interval)
+}
+
+type logGraphMetrics struct {
+	// fluentbit 汇总指标
+	collector map[string]float64
+	// flow node id -> 指标
+	flows map[string]map[string]float64
+	// fluentd output 插件 id -> 指标
+	outputs map[string]map[string]float64
+}
+
+func queryLogGraphMetrics(ctx context.Context, cli agents.Client, namespaces []string) *logGraphMetrics {
+	ret := &logGraphMetrics{
+		collector: map[string]float64{},
+		flows:     map[string]map[string]float64{},
+		outputs:   map[string]map[string]float64{},
+	}
+	query := func(q string) promemodel.Vector {
+		vector, err := cli.Extend().PrometheusVector(ctx, q)
+		if err != nil {
+			log.Warnf("query %s failed: %v", q, err)
+		}
+		return vector
+	}
+	set := func(m map[string]map[string]float64, key, metric string, value promemodel.SampleValue) {
+		if _, ok := m[key]; !ok {
+			m[key] = map[string]float64{}
+		}
+		m[key][metric] += float64(value)
+	}
+
+	for metric, q := range collectorMetricQueries(namespaces) {
+		for _, sample := range query(q) {
+			ret.collector[metric] += float64(sample.Value)
+		}
+	}
+
+	if len(namespaces) > 0 {
+		q := fmt.Sprintf(`sum by(namespace, flow) (rate(%s{namespace=~"%s"}[5m]))`, flowRecordsMetric, strings.Join(namespaces, "|"))
+		for _, sample := range query(q) {
+			id := flowNodeID(string(sample.Metric["namespace"]), string(sample.Metric["flow"]))
+			set(ret.flows, id, MetricRecordsRate, sample.Value)
+		}
+	}
+
+	for metric, q := range map[string]string{
+		MetricRecordsRate:       `sum by(plugin_id) (rate(fluentd_output_status_emit_records[5m]))`,
+		MetricErrorsRate:        `sum by(plugin_id) (rate(fluentd_output_status_num_errors[5m]))`,
+		MetricRetries:           `sum by(plugin_id) (fluentd_output_status_retry_count)`,
+		MetricBufferBytes:       `sum by(plugin_id) (fluentd_output_status_buffer_total_bytes)`,
+		MetricBufferQueueLength: `sum by(plugin_id) (fluentd_output_status_buffer_queue_length)`,
+	} {
+		for _, sample := range query(q) {
+			set(ret.outputs, string(sample.Metric["plugin_id"]), metric, sample.Value)
+		}
+	}
+	return ret
+}
+
+// collectorMetricQueries fluentbit 指标只统计租户的 namespace, 租户没有 namespace 时不查询
+func collectorMetricQueries(namespaces []string) map[string]string {
+	if len(namespaces) == 0 {
+		return nil
+	}
+	selector := fmt.Sprintf(`{namespace=~"%s"}`, strings.Join(namespaces, "|"))
+	return map[string]string{
+		MetricRecordsRate: `sum(rate(fluentbit_input_records_total` + selector + `[5m]))`,
+		MetricErrorsRate:  `sum(rate(fluentbit_output_errors_total` + selector + `[5m]))`,
+		MetricDroppedRate: `sum(rate(fluentbit_output_dropped_records_total` + selector + `[5m]))`,
+		MetricRetries:     `sum(rate(fluentbit_output_retries_failed_total` + selector + `[5m]))`,
+	}
+}
+
+func fillLogGraphMetrics(graph *LogGraph, metrics *logGraphMetrics) {
+	edges := map[string]*LogGraphEdge{}
+	for _, edge := range graph.Edges {
+		edges[edge.ID] = edge
+	}
+	if len(metrics.collector) > 0 {
+		edges[collectorNodeID+"->"+aggregatorNodeID].Metrics = metrics.collector
+	}
+	for flow, m := range metrics.flows {
+		if edge, ok := edges[aggregatorNodeID+"->"+flow]; ok {
+			edge.Metrics = m
+		}
+	}
+	for pluginID, m := range metrics.outputs {
+		source, target, ok := parseOutputPluginID(pluginID)
+		if !ok {
+			continue
+		}
+		edge, ok := edges[source+"->"+target]
+		if !ok {
+			continue
+		}
+		if edge.Metrics == nil {
+			edge.Metrics = map[string]float64{}
+		}
+		for k, v := range m {
+			edge.Metrics[k] += v
+		}
+	}
+}
+
+// parseOutputPluginID 解析 logging-operator 生成的 fluentd output 插件 id,
+// eg. flow:<namespace>:<flow>:output:<namespace>:<output>, clusterflow:<namespace>:<flow>:clusteroutput:<namespace>:<output>
+func parseOutputPluginID(id string) (source, target string, ok bool) {
+	parts := strings.Split(id, ":")
+	if len(parts) != 6 {
+		return "", "", false
+	}
+	switch parts[0] {
+	case NodeKindFlow:
+		source = flowNodeID(parts[1], parts[2])
+	case NodeKindClusterFlow:
+		source = clusterFlowNodeID(parts[2])
+	default:
+		return "", "", false
+	}
+	switch parts[3] {
+	case NodeKindOutput:
+		target = outputNodeID(parts[4], parts[5])
+	case NodeKindClusterOutput:
+		target = clusterOutputNodeID(parts[5])
+	default:
+		return "", "", false
+	}
+	return source, target, true
+}
@@ -0,0 +1,99 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logoperatorhandler
+
+import (
+	"testing"
+
+	loggingv1beta1 "github.com/banzaicloud/logging-operator/pkg/sdk/logging/api/v1beta1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBuildLogGraph(t *testing.T) {
+	flows := []loggingv1beta1.Flow{
+		{
+			ObjectMeta: v1.ObjectMeta{Namespace: "prod", Name: "default"},
+			Spec: loggingv1beta1.FlowSpec{
+				LocalOutputRefs:  []string{"es", "lost"},
+				GlobalOutputRefs: []string{"console"},
+			},
+		},
+	}
+	outputs := []loggingv1beta1.Output{{ObjectMeta: v1.ObjectMeta{Namespace: "prod", Name: "es"}}}
+	clusterFlows := []loggingv1beta1.ClusterFlow{
+		{
+			ObjectMeta: v1.ObjectMeta{Namespace: "logging", Name: "all"},
+			Spec:       loggingv1beta1.ClusterFlowSpec{GlobalOutputRefs: []string{"console"}},
+		},
+	}
+	clusterOutputs := []loggingv1beta1.ClusterOutput{{ObjectMeta: v1.ObjectMeta{Namespace: "logging", Name: "console"}}}
+
+	graph := buildLogGraph(flows, outputs, clusterFlows, clusterOutputs)
+	nodes := map[string]*LogGraphNode{}
+	for _, node := range graph.Nodes {
+		nodes[node.ID] = node
+	}
+	if len(nodes) != len(graph.Nodes) || len(nodes) != 7 {
+		t.Fatalf("buildLogGraph() nodes = %d, want 7 unique nodes", len(graph.Nodes))
+	}
+	if !nodes["output/prod/lost"].Missing || nodes["output/prod/es"].Missing {
+		t.Errorf("missing output not marked")
+	}
+	if len(graph.Edges) != 7 {
+		t.Errorf("buildLogGraph() edges = %d, want 7", len(graph.Edges))
+	}
+
+	fillLogGraphMetrics(graph, &logGraphMetrics{
+		collector: map[string]float64{MetricDroppedRate: 1},
+		flows:     map[string]map[string]float64{"flow/prod/default": {MetricRecordsRate: 10}},
+		outputs: map[string]map[string]float64{
+			"flow:prod:default:output:prod:es":                      {MetricRecordsRate: 8, MetricErrorsRate: 1},
+			"clusterflow:logging:all:clusteroutput:logging:console": {MetricBufferBytes: 1024},
+			"unknown": {MetricRecordsRate: 1},
+		},
+	})
+	edges := map[string]*LogGraphEdge{}
+	for _, edge := range graph.Edges {
+		edges[edge.ID] = edge
+	}
+	if edges["fluentbit->fluentd"].Metrics[MetricDroppedRate] != 1 {
+		t.Errorf("collector metrics not filled")
+	}
+	if edges["fluentd->flow/prod/default"].Metrics[MetricRecordsRate] != 10 {
+		t.Errorf("flow metrics not filled")
+	}
+	if m := edges["flow/prod/default->output/prod/es"].Metrics; m[MetricRecordsRate] != 8 || m[MetricErrorsRate] != 1 {
+		t.Errorf("output metrics not filled, got %v", m)
+	}
+	if m := edges["clusterflow/all->clusteroutput/console"].Metrics; m[MetricBufferBytes] != 1024 {
+		t.Errorf("cluster output metrics not filled, got %v", m)
+	}
+}
+
+func TestLogMetricQueries(t *testing.T) {
+	if q := collectorMetricQueries(nil); len(q) != 0 {
+		t.Errorf("collectorMetricQueries() without namespaces = %v, want empty", q)
+	}
+	q := collectorMetricQueries([]string{"prod", "dev"})
+	if want := `sum(rate(fluentbit_input_records_total{namespace=~"prod|dev"}[5m]))`; q[MetricRecordsRate] != want {
+		t.Errorf("collectorMetricQueries() = %s, want %s", q[MetricRecordsRate], want)
+	}
+	if got, want := appLogRateQuery("prod", "default", "1h"), `sum by(pod) (rate(`+flowRecordsMetric+`{namespace="prod", flow="default"}[1h]))`; got != want {
+		t.Errorf("appLogRateQuery() = %s, want %s", got, want)
+	}
+	if got, want := appLogRateQuery("prod", "default", "1d"), `sum by(pod) (increase(`+flowRecordsMetric+`{namespace="prod", flow="default"}[1d]))`; got != want {
+		t.Errorf("appLogRateQuery() = %s, want %s", got, want)
+	}
+}
@@ -24,10 +24,10 @@ type LogOperatorHandler struct {
 }
 
 func (h *LogOperatorHandler) RegistRouter(rg *gin.RouterGroup) {
-	// TODO: uncomment graph & nodeagent uri ,it's tested
-	// rg.GET("/logging/cluster/:cluster/namespaces/:namespace/flows/:flowid/graph", h.Graph)
-	// rg.GET("/logging/cluster/:cluster/namespaces/:namespace/flows/:flowid/metircs", h.Metrics)
+	// TODO: uncomment nodeagent uri ,it's tested
+	rg.GET("/logging/cluster/:cluster/namespaces/:namespace/flows/:flowid/metrics", h.CheckByClusterNamespace, h.Metrics)
 	rg.GET("/logging/cluster/:cluster/tenant/:tenant_id/flows", h.Flows)
 	rg.GET("/logging/cluster/:cluster/tenant/:tenant_id/outputs", h.Outputs)
+	rg.GET("/logging/cluster/:cluster/tenant/:tenant_id/graph", h.CheckByTenantID, h.Graph)
 	// rg.POST("/logging/cluster/:cluster/namespaces/:namespace/nodeagent/:name", h.CreateNodeAgentLogCollector)
 }
@@ -48,6 +48,7 @@ import (
 	"kubegems.io/kubegems/pkg/log"
 	"kubegems.io/kubegems/pkg/service/handlers"
 	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/service/observe"
 	"kubegems.io/kubegems/pkg/utils"
 	"kubegems.io/kubegems/pkg/utils/agents"
 	"kubegems.io/kubegems/pkg/utils/database"
@@ -1808,7 +1809,7 @@ func (p *AlertRuleProcessor) GetK8sAlertCfg(ctx context.Context) (map[string]K8s
 	}
 	for k, v := range lokiruleCm.Data {
 		// skip recording rule
-		if k == LokiRecordingRulesKey {
+		if observe.IsLokiRecordingRulesKey(k) {
 			continue
 		}
 		groups := monitoringv1.PrometheusRuleSpec{}
@@ -0,0 +1,374 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observability
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/datatypes"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/service/observe"
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"kubegems.io/kubegems/pkg/utils/database"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+)
+
+// AnomalyProcessor 异常检测, 在 worker 中定时检测并告警, 也用于在页面上展示预期范围
+type AnomalyProcessor struct {
+	db *database.Database
+	cs *agents.ClientSet
+}
+
+func NewAnomalyProcessor(db *database.Database, cs *agents.ClientSet) *AnomalyProcessor {
+	return &AnomalyProcessor{db: db, cs: cs}
+}
+
+// Detect 查询 [start, end] 内的指标及之前一个历史窗口的数据, 计算各点的预期范围
+func (p *AnomalyProcessor) Detect(ctx context.Context, detector *models.AnomalyDetector, env *models.Environment, start, end time.Time) ([]observe.AnomalySeries, error) {
+	if detector.PromqlGenerator == nil {
+		return nil, fmt.Errorf("promqlGenerator is required")
+	}
+	tpl, err := p.db.FindPromqlTpl(detector.PromqlGenerator.Scope, detector.PromqlGenerator.Resource, detector.PromqlGenerator.Rule)
+	if err != nil {
+		return nil, err
+	}
+	detector.PromqlGenerator.Tpl = tpl
+	expr, err := observe.AnomalyExpr(detector, env.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	window, step, _, err := detector.Durations()
+	if err != nil {
+		return nil, err
+	}
+	// 按 step 对齐, 保证季节性检测时各周期同一时刻的点能对应上
+	queryStart := start.Add(-window).Truncate(step).UTC()
+	cli, err := p.cs.ClientOf(ctx, env.Cluster.ClusterName)
+	if err != nil {
+		return nil, err
+	}
+	matrix, err := cli.Extend().PrometheusQueryRange(ctx, expr,
+		queryStart.Format(time.RFC3339), end.UTC().Format(time.RFC3339), strconv.Itoa(int(step.Seconds())))
+	if err != nil {
+		return nil, err
+	}
+	return observe.DetectAnomalies(detector, matrix, start)
+}
+
+// EvaluateDetectors 检测所有开启的异常检测, 状态变化时通过告警 webhook 发送告警和恢复消息
+func (p *AnomalyProcessor) EvaluateDetectors(ctx context.Context, now time.Time) error {
+	detectors := []*models.AnomalyDetector{}
+	if err := p.db.DB().WithContext(ctx).Preload("Environment.Cluster").Find(&detectors).Error; err != nil {
+		return err
+	}
+	for _, detector := range detectors {
+		if detector.Environment == nil || detector.Environment.Cluster == nil {
+			continue
+		}
+		if !detector.Enabled {
+			// 关闭时恢复仍在告警的序列
+			if len(detector.FiringSeries) > 0 {
+				if err := p.Resolve(ctx, detector, detector.Environment, now); err != nil {
+					log.Error(err, "resolve anomaly detector", "detector", detector.Name)
+				}
+			}
+			continue
+		}
+		_, step, _, err := detector.Durations()
+		if err != nil {
+			continue
+		}
+		if detector.LastCheckAt != nil && now.Sub(*detector.LastCheckAt) < step {
+			continue
+		}
+		if err := p.evaluate(ctx, detector, now); err != nil {
+			log.Error(err, "evaluate anomaly detector", "detector", detector.Name)
+		}
+	}
+	return nil
+}
+
+func (p *AnomalyProcessor) evaluate(ctx context.Context, detector *models.AnomalyDetector, now time.Time) error {
+	env := detector.Environment
+	_, step, _, _ := detector.Durations()
+	updates := map[string]interface{}{"last_check_at": now, "last_error": ""}
+	err := func() error {
+		series, err := p.Detect(ctx, detector, env, now.Add(-step), now)
+		if err != nil {
+			return err
+		}
+		alert, firing, err := observe.AnomalyAlerts(detector, env.Cluster.ClusterName, env.Namespace, series, now)
+		if err != nil {
+			return err
+		}
+		if err := p.sendAlert(ctx, env, alert); err != nil {
+			return err
+		}
+		updates["firing_series"] = nil
+		if len(firing) > 0 {
+			firingSeries, _ := json.Marshal(firing)
+			updates["firing_series"] = datatypes.JSON(firingSeries)
+		}
+		return nil
+	}()
+	if err != nil {
+		updates["last_error"] = err.Error()
+	}
+	if dberr := p.db.DB().WithContext(ctx).Model(detector).Updates(updates).Error; dberr != nil {
+		return dberr
+	}
+	return err
+}
+
+// Resolve 恢复异常检测仍在告警的序列, 在关闭或者删除时调用
+func (p *AnomalyProcessor) Resolve(ctx context.Context, detector *models.AnomalyDetector, env *models.Environment, now time.Time) error {
+	alert, _, err := observe.AnomalyAlerts(detector, env.Cluster.ClusterName, env.Namespace, nil, now)
+	if err != nil {
+		return err
+	}
+	if err := p.sendAlert(ctx, env, alert); err != nil {
+		return err
+	}
+	return p.db.DB().WithContext(ctx).Model(detector).Update("firing_series", nil).Error
+}
+
+func (p *AnomalyProcessor) sendAlert(ctx context.Context, env *models.Environment, alert *prometheus.WebhookAlert) error {
+	if len(alert.Alerts) == 0 {
+		return nil
+	}
+	cli, err := p.cs.ClientOf(ctx, env.Cluster.ClusterName)
+	if err != nil {
+		return err
+	}
+	return cli.Extend().SendAlert(ctx, alert)
+}
+
+// ListAnomalyDetectors 异常检测列表
+// @Tags        Observability
+// @Summary     异常检测列表
+// @Description 异常检测列表
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                                 true "环境ID"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]models.AnomalyDetector} "异常检测列表"
+// @Router      /v1/observability/environment/{environment_id}/anomalydetectors [get]
+// @Security    JWT
+func (h *ObservabilityHandler) ListAnomalyDetectors(c *gin.Context) {
+	ret := []models.AnomalyDetector{}
+	if err := h.GetDB().WithContext(c.Request.Context()).Find(&ret, "environment_id = ?", c.Param("environment_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+// CreateAnomalyDetector 创建异常检测
+// @Tags        Observability
+// @Summary     创建异常检测
+// @Description 创建异常检测, 在 worker 中根据 zscore 或者季节性基线检测模板指标的异常并告警
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                               true "环境ID"
+// @Param       form           body     models.AnomalyDetector                               true "异常检测"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.AnomalyDetector} "resp"
+// @Router      /v1/observability/environment/{environment_id}/anomalydetectors [post]
+// @Security    JWT
+func (h *ObservabilityHandler) CreateAnomalyDetector(c *gin.Context) {
+	req := &models.AnomalyDetector{}
+	if err := c.BindJSON(req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	env, err := h.getEnvironmentWithCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	u, exist := h.GetContextUser(c)
+	if !exist {
+		handlers.NotOK(c, fmt.Errorf("not login"))
+		return
+	}
+	req.ID = 0
+	req.EnvironmentID = env.ID
+	req.Creator = u.GetUsername()
+	req.FiringSeries = nil
+	req.LastCheckAt = nil
+	req.LastError = ""
+	if err := h.checkAnomalyDetector(req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	action := i18n.Sprintf(context.TODO(), "create")
+	module := i18n.Sprintf(context.TODO(), "anomaly detector")
+	h.SetAuditData(c, action, module, req.Name)
+	h.SetExtraAuditData(c, models.ResEnvironment, env.ID)
+
+	if err := h.GetDB().WithContext(c.Request.Context()).Create(req).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, req)
+}
+
+// UpdateAnomalyDetector 更新异常检测
+// @Tags        Observability
+// @Summary     更新异常检测
+// @Description 更新异常检测, 告警状态会在下次检测时更新
+// @Accept      json
+// @Produce     json
+// @Param       environment_id      path     string                                               true "环境ID"
+// @Param       anomalydetector_id  path     uint                                                 true "异常检测ID"
+// @Param       form                body     models.AnomalyDetector                               true "异常检测"
+// @Success     200                 {object} handlers.ResponseStruct{Data=models.AnomalyDetector} "resp"
+// @Router      /v1/observability/environment/{environment_id}/anomalydetectors/{anomalydetector_id} [put]
+// @Security    JWT
+func (h *ObservabilityHandler) UpdateAnomalyDetector(c *gin.Context) {
+	req := &models.AnomalyDetector{}
+	if err := c.BindJSON(req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	env, err := h.getEnvironmentWithCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	old := &models.AnomalyDetector{}
+	if err := h.GetDB().WithContext(c.Request.Context()).
+		First(old, "id = ? and environment_id = ?", c.Param("anomalydetector_id"), env.ID).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	// 检测状态由 worker 维护
+	req.ID = old.ID
+	req.EnvironmentID = env.ID
+	req.Creator = old.Creator
+	req.CreatedAt = old.CreatedAt
+	req.FiringSeries = old.FiringSeries
+	req.LastCheckAt = old.LastCheckAt
+	req.LastError = old.LastError
+	if err := h.checkAnomalyDetector(req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	action := i18n.Sprintf(context.TODO(), "update")
+	module := i18n.Sprintf(context.TODO(), "anomaly detector")
+	h.SetAuditData(c, action, module, req.Name)
+	h.SetExtraAuditData(c, models.ResEnvironment, env.ID)
+
+	if err := h.GetDB().WithContext(c.Request.Context()).Save(req).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, req)
+}
+
+// DeleteAnomalyDetector 删除异常检测
+// @Tags        Observability
+// @Summary     删除异常检测
+// @Description 删除异常检测, 同时恢复仍在告警的序列
+// @Accept      json
+// @Produce     json
+// @Param       environment_id      path     string                               true "环境ID"
+// @Param       anomalydetector_id  path     uint                                 true "异常检测ID"
+// @Success     200                 {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/observability/environment/{environment_id}/anomalydetectors/{anomalydetector_id} [delete]
+// @Security    JWT
+func (h *ObservabilityHandler) DeleteAnomalyDetector(c *gin.Context) {
+	env, err := h.getEnvironmentWithCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	detector := &models.AnomalyDetector{}
+	if err := h.GetDB().WithContext(c.Request.Context()).
+		First(detector, "id = ? and environment_id = ?", c.Param("anomalydetector_id"), env.ID).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	action := i18n.Sprintf(context.TODO(), "delete")
+	module := i18n.Sprintf(context.TODO(), "anomaly detector")
+	h.SetAuditData(c, action, module, detector.Name)
+	h.SetExtraAuditData(c, models.ResEnvironment, env.ID)
+
+	if len(detector.FiringSeries) > 0 {
+		if err := h.anomalyProcessor().Resolve(c.Request.Context(), detector, env, time.Now()); err != nil {
+			log.Error(err, "resolve anomaly detector", "detector", detector.Name)
+		}
+	}
+	if err := h.GetDB().WithContext(c.Request.Context()).Delete(detector).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, "ok")
+}
+
+// AnomalyBand 异常检测的预期范围
+// @Tags        Observability
+// @Summary     异常检测的预期范围
+// @Description 查询时间段内各序列的值以及根据基线计算的预期范围, 用于图表展示
+// @Accept      json
+// @Produce     json
+// @Param       environment_id      path     string                                                  true  "环境ID"
+// @Param       anomalydetector_id  path     uint                                                    true  "异常检测ID"
+// @Param       start               query    string                                                  false "开始时间，默认现在-30m"
+// @Param       end                 query    string                                                  false "结束时间，默认现在"
+// @Success     200                 {object} handlers.ResponseStruct{Data=[]observe.AnomalySeries} "resp"
+// @Router      /v1/observability/environment/{environment_id}/anomalydetectors/{anomalydetector_id}/band [get]
+// @Security    JWT
+func (h *ObservabilityHandler) AnomalyBand(c *gin.Context) {
+	env, err := h.getEnvironmentWithCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	detector := &models.AnomalyDetector{}
+	if err := h.GetDB().WithContext(c.Request.Context()).
+		First(detector, "id = ? and environment_id = ?", c.Param("anomalydetector_id"), env.ID).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	start, end := prometheus.ParseRangeTime(c.Query("start"), c.Query("end"), time.UTC)
+	series, err := h.anomalyProcessor().Detect(c.Request.Context(), detector, env, start, end)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, series)
+}
+
+func (h *ObservabilityHandler) anomalyProcessor() *AnomalyProcessor {
+	return NewAnomalyProcessor(h.GetDataBase(), h.GetAgents())
+}
+
+func (h *ObservabilityHandler) checkAnomalyDetector(detector *models.AnomalyDetector) error {
+	if err := detector.Validate(); err != nil {
+		return err
+	}
+	_, err := h.GetDataBase().FindPromqlTpl(detector.PromqlGenerator.Scope, detector.PromqlGenerator.Resource, detector.PromqlGenerator.Rule)
+	return err
+}
@@ -17,6 +17,7 @@ package observability
 import (
 	"github.com/gin-gonic/gin"
 	"kubegems.io/kubegems/pkg/service/handlers/base"
+	"kubegems.io/kubegems/pkg/utils/argo"
 	"kubegems.io/kubegems/pkg/utils/helm"
 )
 
@@ -24,6 +25,7 @@ type ObservabilityHandler struct {
 	base.BaseHandler
 	AppStoreOpt       *helm.Options
 	ChartmuseumClient *helm.ChartmuseumClient
+	Argo              *argo.Client
 }
 
 func (h *ObservabilityHandler) RegistRouter(rg *gin.RouterGroup) {
@@ -51,6 +53,14 @@ func (h *ObservabilityHandler) RegistRouter(rg *gin.RouterGroup) {
 	rg.DELETE("/observability/tenant/:tenant_id/channels/:channel_id", h.CheckByTenantID, h.DeleteChannel)
 	rg.POST("/observability/tenant/:tenant_id/channels/:channel_id/test", h.TestChannel)
 
+	// scheduled report
+	rg.GET("/observability/tenant/:tenant_id/reports", h.CheckByTenantID, h.ListReports)
+	rg.POST("/observability/tenant/:tenant_id/reports", h.CheckByTenantID, h.CreateReport)
+	rg.PUT("/observability/tenant/:tenant_id/reports/:report_id", h.CheckByTenantID, h.UpdateReport)
+	rg.DELETE("/observability/tenant/:tenant_id/reports/:report_id", h.CheckByTenantID, h.DeleteReport)
+	rg.GET("/observability/tenant/:tenant_id/reports/:report_id/preview", h.CheckByTenantID, h.PreviewReport)
+	rg.POST("/observability/tenant/:tenant_id/reports/:report_id/send", h.CheckByTenantID, h.SendReport)
+
 	rg.GET("/observability/cluster/:cluster/namespaces/:namespace/logging/alerts", h.CheckByClusterNamespace, h.ListLoggingAlertRule)
 	rg.GET("/observability/cluster/:cluster/namespaces/:namespace/logging/alerts/_/status", h.CheckByClusterNamespace, h.ListLoggingAlertRulesStatus)
 	rg.GET("/observability/cluster/:cluster/namespaces/:namespace/logging/alerts/:name", h.CheckByClusterNamespace, h.GetLoggingAlertRule)
@@ -94,12 +104,44 @@ func (h *ObservabilityHandler) RegistRouter(rg *gin.RouterGroup) {
 	rg.PUT("/observability/environment/:environment_id/monitor/dashboard/:dashboard_id", h.CheckByEnvironmentID, h.UpdateDashboard)
 	rg.DELETE("/observability/environment/:environment_id/monitor/dashboard/:dashboard_id", h.CheckByEnvironmentID, h.DeleteDashboard)
 	rg.GET("/observability/environment/:environment_id/monitor/dashboard/:dashboard_id/query", h.CheckByEnvironmentID, h.DashboardQuery)
+	rg.POST("/observability/environment/:environment_id/monitor/dashboard/grafana", h.CheckByEnvironmentID, h.ImportGrafanaDashboard)
+	rg.GET("/observability/environment/:environment_id/monitor/dashboard/:dashboard_id/grafana", h.CheckByEnvironmentID, h.ExportGrafanaDashboard)
+
+	// slo
+	rg.GET("/observability/environment/:environment_id/slos", h.CheckByEnvironmentID, h.ListSLOs)
+	rg.POST("/observability/environment/:environment_id/slos", h.CheckByEnvironmentID, h.CreateSLO)
+	rg.PUT("/observability/environment/:environment_id/slos/:slo_id", h.CheckByEnvironmentID, h.UpdateSLO)
+	rg.DELETE("/observability/environment/:environment_id/slos/:slo_id", h.CheckByEnvironmentID, h.DeleteSLO)
+	rg.GET("/observability/environment/:environment_id/slos/:slo_id/budget", h.CheckByEnvironmentID, h.SLOBudget)
+
+	// 异常检测
+	rg.GET("/observability/environment/:environment_id/anomalydetectors", h.CheckByEnvironmentID, h.ListAnomalyDetectors)
+	rg.POST("/observability/environment/:environment_id/anomalydetectors", h.CheckByEnvironmentID, h.CreateAnomalyDetector)
+	rg.PUT("/observability/environment/:environment_id/anomalydetectors/:anomalydetector_id", h.CheckByEnvironmentID, h.UpdateAnomalyDetector)
+	rg.DELETE("/observability/environment/:environment_id/anomalydetectors/:anomalydetector_id", h.CheckByEnvironmentID, h.DeleteAnomalyDetector)
+	rg.GET("/observability/environment/:environment_id/anomalydetectors/:anomalydetector_id/band", h.CheckByEnvironmentID, h.AnomalyBand)
+
+	// recording rule
+	rg.GET("/observability/environment/:environment_id/recordingrules", h.CheckByEnvironmentID, h.ListRecordingRules)
+	rg.POST("/observability/environment/:environment_id/recordingrules", h.CheckByEnvironmentID, h.CreateRecordingRule)
+	rg.GET("/observability/environment/:environment_id/recordingrules/suggestions", h.CheckByEnvironmentID, h.RecordingRuleSuggestions)
+	rg.PUT("/observability/environment/:environment_id/recordingrules/:rule_id", h.CheckByEnvironmentID, h.UpdateRecordingRule)
+	rg.DELETE("/observability/environment/:environment_id/recordingrules/:rule_id", h.CheckByEnvironmentID, h.DeleteRecordingRule)
+
+	// log metric
+	rg.GET("/observability/environment/:environment_id/logmetrics", h.CheckByEnvironmentID, h.ListLogMetrics)
+	rg.POST("/observability/environment/:environment_id/logmetrics", h.CheckByEnvironmentID, h.CreateLogMetric)
+	rg.PUT("/observability/environment/:environment_id/logmetrics/:metric_id", h.CheckByEnvironmentID, h.UpdateLogMetric)
+	rg.DELETE("/observability/environment/:environment_id/logmetrics/:metric_id", h.CheckByEnvironmentID, h.DeleteLogMetric)
+	rg.GET("/observability/environment/:environment_id/logmetrics/:metric_id/query", h.CheckByEnvironmentID, h.QueryLogMetric)
 
 	rg.GET("/observability/template/dashboard", h.ListDashboardTemplates)
 	rg.GET("/observability/template/dashboard/:name", h.GetDashboardTemplate)
 	rg.POST("/observability/template/dashboard", h.CheckIsSysADMIN, h.AddDashboardTemplates)
 	rg.PUT("/observability/template/dashboard/:name", h.CheckIsSysADMIN, h.UpdateDashboardTemplates)
 	rg.DELETE("/observability/template/dashboard/:name", h.CheckIsSysADMIN, h.DeleteDashboardTemplate)
+	rg.POST("/observability/template/dashboard/grafana", h.CheckIsSysADMIN, h.ImportGrafanaDashboardTemplate)
+	rg.GET("/observability/template/dashboard/:name/grafana", h.ExportGrafanaDashboardTemplate)
 
 	// exporter
 	rg.GET("/observability/monitor/exporters/:name/schema", h.ExporterSchema)
@@ -111,4 +153,10 @@ func (h *ObservabilityHandler) RegistRouter(rg *gin.RouterGroup) {
 	rg.GET("/observability/cluster/:cluster/namespaces/:namespace/otel/appmonitor/services/:service_name/operations", h.CheckByClusterNamespace, h.OtelServiceOperations)
 	rg.GET("/observability/cluster/:cluster/namespaces/:namespace/otel/appmonitor/services/:service_name/traces", h.CheckByClusterNamespace, h.OtelServiceTraces)
 	rg.GET("/observability/cluster/:cluster/traces/:trace_id", h.GetTrace)
+
+	// trace log correlation
+	rg.GET("/observability/environment/:environment_id/tracelogconfig", h.CheckByEnvironmentID, h.GetTraceLogConfig)
+	rg.PUT("/observability/environment/:environment_id/tracelogconfig", h.CheckByEnvironmentID, h.SetTraceLogConfig)
+	rg.GET("/observability/environment/:environment_id/traces/:trace_id/logs", h.CheckByEnvironmentID, h.GetTraceLogs)
+	rg.POST("/observability/environment/:environment_id/logs/trace", h.CheckByEnvironmentID, h.GetLogTrace)
 }
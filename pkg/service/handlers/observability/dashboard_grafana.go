@@ -0,0 +1,260 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observability
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/gormdatatypes"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+)
+
+type GrafanaImportReq struct {
+	// 为空时使用 grafana dashboard 的 title
+	Name string `json:"name"`
+	// grafana dashboard json, 支持 grafana api 返回的 {"dashboard": {...}, "meta": {...}}
+	Dashboard json.RawMessage `json:"dashboard" binding:"required"`
+}
+
+type GrafanaImportResp struct {
+	Dashboard interface{}                     `json:"dashboard"`
+	Report    *prometheus.GrafanaImportResult `json:"report"`
+}
+
+// ImportGrafanaDashboard 导入grafana dashboard
+// @Tags        Observability
+// @Summary     导入grafana dashboard
+// @Description 导入grafana dashboard 为监控面板, 引用变量的标签匹配器转换为面板变量, 查询限制在环境的命名空间中, 返回不支持的面板
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                          true "环境ID"
+// @Param       form           body     GrafanaImportReq                                true "grafana dashboard"
+// @Success     200            {object} handlers.ResponseStruct{Data=GrafanaImportResp} "resp"
+// @Router      /v1/observability/environment/{environment_id}/monitor/dashboard/grafana [post]
+// @Security    JWT
+func (h *ObservabilityHandler) ImportGrafanaDashboard(c *gin.Context) {
+	req := GrafanaImportReq{}
+	if err := c.BindJSON(&req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+	result, err := prometheus.ImportGrafanaDashboard(req.Dashboard)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if len(result.Graphs) == 0 {
+		handlers.NotOK(c, fmt.Errorf("no supported panel in grafana dashboard"))
+		return
+	}
+	envid, err := strconv.Atoi(c.Param("environment_id"))
+	if err != nil {
+		handlers.NotOK(c, errors.Wrap(err, "environment_id"))
+		return
+	}
+	env := models.Environment{}
+	if err := h.GetDB().WithContext(ctx).First(&env, "id = ?", envid).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	u, exist := h.GetContextUser(c)
+	if !exist {
+		handlers.NotOK(c, fmt.Errorf("not login"))
+		return
+	}
+
+	uintid := uint(envid)
+	dash := models.MonitorDashboard{
+		Name:          grafanaImportName(req.Name, result),
+		Step:          "30s",
+		Refresh:       result.Refresh,
+		Start:         result.Start,
+		End:           result.End,
+		Creator:       u.GetUsername(),
+		Graphs:        result.Graphs,
+		Variables:     gormdatatypes.JSONMap(result.Variables),
+		EnvironmentID: &uintid,
+	}
+	if dash.Start == "" || dash.End == "" {
+		dash.Start = "now-30m"
+		dash.End = "now"
+	}
+	if dash.Refresh == "" {
+		dash.Refresh = "30s"
+	}
+	// 强制限制在环境的命名空间
+	tplGetter := h.GetDataBase().NewPromqlTplMapperFromDB().FindPromqlTpl
+	if err := models.CheckGraphs(dash.Graphs, env.Namespace, tplGetter); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	action := i18n.Sprintf(context.TODO(), "import")
+	module := i18n.Sprintf(context.TODO(), "monitor dashboard")
+	h.SetAuditData(c, action, module, dash.Name)
+	h.SetExtraAuditData(c, models.ResEnvironment, uintid)
+
+	if err := h.GetDB().WithContext(ctx).Create(&dash).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, GrafanaImportResp{Dashboard: dash, Report: result})
+}
+
+// ExportGrafanaDashboard 导出为grafana dashboard
+// @Tags        Observability
+// @Summary     导出为grafana dashboard
+// @Description 导出监控面板为 grafana dashboard json, 命名空间以及面板变量导出为 grafana 变量
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                                    true "环境ID"
+// @Param       dashboard_id   path     uint                                                      true "dashboard id"
+// @Success     200            {object} handlers.ResponseStruct{Data=prometheus.GrafanaDashboard} "resp"
+// @Router      /v1/observability/environment/{environment_id}/monitor/dashboard/{dashboard_id}/grafana [get]
+// @Security    JWT
+func (h *ObservabilityHandler) ExportGrafanaDashboard(c *gin.Context) {
+	dash := models.MonitorDashboard{}
+	if err := h.GetDB().WithContext(c.Request.Context()).
+		First(&dash, "id = ? and environment_id = ?", c.Param("dashboard_id"), c.Param("environment_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	ret, err := h.exportGrafanaDashboard(&prometheus.DashboardContent{
+		Name:      dash.Name,
+		Refresh:   dash.Refresh,
+		Start:     dash.Start,
+		End:       dash.End,
+		Graphs:    dash.Graphs,
+		Variables: dash.Variables,
+	})
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+// ImportGrafanaDashboardTemplate 导入grafana dashboard为监控面板模板
+// @Tags        Observability
+// @Summary     导入grafana dashboard为监控面板模板
+// @Description 导入grafana dashboard为监控面板模板, 返回不支持的面板
+// @Accept      json
+// @Produce     json
+// @Param       form body     GrafanaImportReq                                true "grafana dashboard"
+// @Success     200  {object} handlers.ResponseStruct{Data=GrafanaImportResp} "resp"
+// @Router      /v1/observability/template/dashboard/grafana [post]
+// @Security    JWT
+func (h *ObservabilityHandler) ImportGrafanaDashboardTemplate(c *gin.Context) {
+	req := GrafanaImportReq{}
+	if err := c.BindJSON(&req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	result, err := prometheus.ImportGrafanaDashboard(req.Dashboard)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if len(result.Graphs) == 0 {
+		handlers.NotOK(c, fmt.Errorf("no supported panel in grafana dashboard"))
+		return
+	}
+	tpl := models.MonitorDashboardTpl{
+		Name:        grafanaImportName(req.Name, result),
+		Description: result.Description,
+		Step:        "30s",
+		Refresh:     result.Refresh,
+		Start:       result.Start,
+		End:         result.End,
+		Graphs:      result.Graphs,
+		Variables:   gormdatatypes.JSONMap(result.Variables),
+	}
+	action := i18n.Sprintf(context.TODO(), "import")
+	module := i18n.Sprintf(context.TODO(), "monitor dashboard template")
+	h.SetAuditData(c, action, module, tpl.Name)
+	tplGetter := h.GetDataBase().NewPromqlTplMapperFromDB().FindPromqlTpl
+	if err := models.CheckGraphs(tpl.Graphs, "", tplGetter); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if err := h.GetDB().WithContext(c.Request.Context()).Create(&tpl).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, GrafanaImportResp{Dashboard: tpl, Report: result})
+}
+
+// ExportGrafanaDashboardTemplate 导出监控面板模板为grafana dashboard
+// @Tags        Observability
+// @Summary     导出监控面板模板为grafana dashboard
+// @Description 导出监控面板模板为grafana dashboard
+// @Accept      json
+// @Produce     json
+// @Param       name path     string                                                    true "模板名"
+// @Success     200  {object} handlers.ResponseStruct{Data=prometheus.GrafanaDashboard} "resp"
+// @Router      /v1/observability/template/dashboard/{name}/grafana [get]
+// @Security    JWT
+func (h *ObservabilityHandler) ExportGrafanaDashboardTemplate(c *gin.Context) {
+	tpl := models.MonitorDashboardTpl{Name: c.Param("name")}
+	if err := h.GetDB().WithContext(c.Request.Context()).First(&tpl).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	ret, err := h.exportGrafanaDashboard(&prometheus.DashboardContent{
+		Name:        tpl.Name,
+		Description: tpl.Description,
+		Refresh:     tpl.Refresh,
+		Start:       tpl.Start,
+		End:         tpl.End,
+		Graphs:      tpl.Graphs,
+		Variables:   tpl.Variables,
+	})
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+func (h *ObservabilityHandler) exportGrafanaDashboard(content *prometheus.DashboardContent) (*prometheus.GrafanaDashboard, error) {
+	tplGetter := h.GetDataBase().NewPromqlTplMapperFromDB().FindPromqlTpl
+	for _, graph := range content.Graphs {
+		for _, target := range graph.Targets {
+			if target.PromqlGenerator.Notpl() {
+				continue
+			}
+			if err := target.PromqlGenerator.SetTpl(tplGetter); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return prometheus.ExportGrafanaDashboard(content)
+}
+
+func grafanaImportName(name string, result *prometheus.GrafanaImportResult) string {
+	if name != "" {
+		return name
+	}
+	return result.Name
+}
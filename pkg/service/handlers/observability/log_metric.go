@@ -0,0 +1,282 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observability
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"kubegems.io/kubegems/pkg/apis/gems"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/service/observe"
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"kubegems.io/kubegems/pkg/utils/loki"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/yaml"
+)
+
+// ListLogMetrics 日志指标列表
+// @Tags        Observability
+// @Summary     日志指标列表
+// @Description 日志指标列表, expr 为在监控面板和告警规则中引用该指标的 promql
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                           true "环境ID"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]models.LogMetric} "日志指标列表"
+// @Router      /v1/observability/environment/{environment_id}/logmetrics [get]
+// @Security    JWT
+func (h *ObservabilityHandler) ListLogMetrics(c *gin.Context) {
+	env := models.Environment{}
+	if err := h.GetDB().WithContext(c.Request.Context()).First(&env, "id = ?", c.Param("environment_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	ret := []models.LogMetric{}
+	if err := h.GetDB().WithContext(c.Request.Context()).Order("name").Find(&ret, "environment_id = ?", env.ID).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	for i := range ret {
+		ret[i].Expr = observe.LogMetricExpr(&ret[i], env.Namespace)
+	}
+	handlers.OK(c, ret)
+}
+
+// CreateLogMetric 创建日志指标
+// @Tags        Observability
+// @Summary     创建日志指标
+// @Description 创建日志指标, 作为 loki recording rule 写入 prometheus
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                         true "环境ID"
+// @Param       form           body     models.LogMetric                               true "日志指标"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.LogMetric} "resp"
+// @Router      /v1/observability/environment/{environment_id}/logmetrics [post]
+// @Security    JWT
+func (h *ObservabilityHandler) CreateLogMetric(c *gin.Context) {
+	req := &models.LogMetric{}
+	if err := c.BindJSON(req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	env, err := h.getEnvironmentWithCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	u, exist := h.GetContextUser(c)
+	if !exist {
+		handlers.NotOK(c, fmt.Errorf("not login"))
+		return
+	}
+	req.ID = 0
+	req.EnvironmentID = env.ID
+	req.Creator = u.GetUsername()
+	if err := req.Validate(); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	action := i18n.Sprintf(context.TODO(), "create")
+	module := i18n.Sprintf(context.TODO(), "log metric")
+	h.SetAuditData(c, action, module, req.Name)
+	h.SetExtraAuditData(c, models.ResEnvironment, env.ID)
+
+	if err := h.GetDB().WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(req).Error; err != nil {
+			return err
+		}
+		return h.syncLogMetrics(c.Request.Context(), tx, env)
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	req.Expr = observe.LogMetricExpr(req, env.Namespace)
+	handlers.OK(c, req)
+}
+
+// UpdateLogMetric 更新日志指标
+// @Tags        Observability
+// @Summary     更新日志指标
+// @Description 更新日志指标, 指标名不可修改
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                         true "环境ID"
+// @Param       metric_id      path     uint                                           true "metric id"
+// @Param       form           body     models.LogMetric                               true "日志指标"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.LogMetric} "resp"
+// @Router      /v1/observability/environment/{environment_id}/logmetrics/{metric_id} [put]
+// @Security    JWT
+func (h *ObservabilityHandler) UpdateLogMetric(c *gin.Context) {
+	req := &models.LogMetric{}
+	if err := c.BindJSON(req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	env, err := h.getEnvironmentWithCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	old := &models.LogMetric{}
+	if err := h.GetDB().WithContext(c.Request.Context()).
+		First(old, "id = ? and environment_id = ?", c.Param("metric_id"), env.ID).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	old.Description = req.Description
+	old.LogqlGenerator = req.LogqlGenerator
+	old.GroupBy = req.GroupBy
+	if err := old.Validate(); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	action := i18n.Sprintf(context.TODO(), "update")
+	module := i18n.Sprintf(context.TODO(), "log metric")
+	h.SetAuditData(c, action, module, old.Name)
+	h.SetExtraAuditData(c, models.ResEnvironment, env.ID)
+
+	if err := h.GetDB().WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Select("description", "logql_generator", "group_by").Updates(old).Error; err != nil {
+			return err
+		}
+		return h.syncLogMetrics(c.Request.Context(), tx, env)
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	old.Expr = observe.LogMetricExpr(old, env.Namespace)
+	handlers.OK(c, old)
+}
+
+// DeleteLogMetric 删除日志指标
+// @Tags        Observability
+// @Summary     删除日志指标
+// @Description 删除日志指标
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                               true "环境ID"
+// @Param       metric_id      path     uint                                 true "metric id"
+// @Success     200            {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/observability/environment/{environment_id}/logmetrics/{metric_id} [delete]
+// @Security    JWT
+func (h *ObservabilityHandler) DeleteLogMetric(c *gin.Context) {
+	env, err := h.getEnvironmentWithCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	metric := &models.LogMetric{}
+	if err := h.GetDB().WithContext(c.Request.Context()).
+		First(metric, "id = ? and environment_id = ?", c.Param("metric_id"), env.ID).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	action := i18n.Sprintf(context.TODO(), "delete")
+	module := i18n.Sprintf(context.TODO(), "log metric")
+	h.SetAuditData(c, action, module, metric.Name)
+	h.SetExtraAuditData(c, models.ResEnvironment, env.ID)
+
+	if err := h.GetDB().WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(metric).Error; err != nil {
+			return err
+		}
+		return h.syncLogMetrics(c.Request.Context(), tx, env)
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, "ok")
+}
+
+// QueryLogMetric 查询日志指标
+// @Tags        Observability
+// @Summary     查询日志指标
+// @Description 直接通过 loki 查询日志指标, 不依赖 recording rule 已经写入的数据, 用于预览
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                                true  "环境ID"
+// @Param       metric_id      path     uint                                                  true  "metric id"
+// @Param       start          query    string                                                false "开始时间，默认现在-30m"
+// @Param       end            query    string                                                false "结束时间，默认现在"
+// @Param       step           query    string                                                false "step, eg. 1m"
+// @Success     200            {object} handlers.ResponseStruct{Data=loki.QueryResponseData} "resp"
+// @Router      /v1/observability/environment/{environment_id}/logmetrics/{metric_id}/query [get]
+// @Security    JWT
+func (h *ObservabilityHandler) QueryLogMetric(c *gin.Context) {
+	env, err := h.getEnvironmentWithCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	metric := &models.LogMetric{}
+	if err := h.GetDB().WithContext(c.Request.Context()).
+		First(metric, "id = ? and environment_id = ?", c.Param("metric_id"), env.ID).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	start, end, _ := getRangeParams(c.Query("start"), c.Query("end"))
+	var ret loki.QueryResponseData
+	if err := h.Execute(c.Request.Context(), env.Cluster.ClusterName, func(ctx context.Context, cli agents.Client) error {
+		ret, err = cli.Extend().LokiQueryRange(ctx, observe.LogMetricLogql(metric, env.Namespace), start, end, c.Query("step"))
+		return err
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+// syncLogMetrics 将环境中的日志指标写入 loki rules configmap
+func (h *ObservabilityHandler) syncLogMetrics(ctx context.Context, tx *gorm.DB, env *models.Environment) error {
+	metrics := []models.LogMetric{}
+	if err := tx.Find(&metrics, "environment_id = ?", env.ID).Error; err != nil {
+		return err
+	}
+	return h.Execute(ctx, env.Cluster.ClusterName, func(ctx context.Context, cli agents.Client) error {
+		lokiRuleCM := &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: gems.NamespaceLogging,
+				Name:      LoggingAlertRuleCMName,
+			},
+		}
+		key := observe.LogMetricRulesKey(env.Namespace)
+		_, err := controllerutil.CreateOrUpdate(ctx, cli, lokiRuleCM, func() error {
+			if len(metrics) == 0 {
+				delete(lokiRuleCM.Data, key)
+				return nil
+			}
+			bts, err := yaml.Marshal(observe.GenerateLogMetricRuleGroups(env.Namespace, metrics))
+			if err != nil {
+				return errors.Wrap(err, "encode log metric rulegroups")
+			}
+			if lokiRuleCM.Data == nil {
+				lokiRuleCM.Data = map[string]string{}
+			}
+			lokiRuleCM.Data[key] = string(bts)
+			return nil
+		})
+		return err
+	})
+}
@@ -0,0 +1,341 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observability
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/service/observe"
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// 查询耗时超过该值的面板查询建议使用预聚合规则
+const defaultSlowQueryThreshold = time.Second
+
+type RecordingRuleSuggestion struct {
+	DashboardID   uint   `json:"dashboardID"`
+	DashboardName string `json:"dashboardName"`
+	GraphName     string `json:"graphName"`
+	TargetName    string `json:"targetName"`
+	Expr          string `json:"expr"`
+	// 查询耗时
+	Duration string `json:"duration"`
+	// 建议的指标名
+	Record string `json:"record"`
+
+	elapsed time.Duration
+}
+
+// ListRecordingRules 预聚合规则列表
+// @Tags        Observability
+// @Summary     预聚合规则列表
+// @Description 预聚合规则列表
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                               true "环境ID"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]models.RecordingRule} "预聚合规则列表"
+// @Router      /v1/observability/environment/{environment_id}/recordingrules [get]
+// @Security    JWT
+func (h *ObservabilityHandler) ListRecordingRules(c *gin.Context) {
+	ret := []models.RecordingRule{}
+	if err := h.GetDB().WithContext(c.Request.Context()).Order("record").Find(&ret, "environment_id = ?", c.Param("environment_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+// CreateRecordingRule 创建预聚合规则
+// @Tags        Observability
+// @Summary     创建预聚合规则
+// @Description 创建预聚合规则, 表达式必须限制在环境的命名空间中
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                             true "环境ID"
+// @Param       form           body     models.RecordingRule                               true "预聚合规则"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.RecordingRule} "resp"
+// @Router      /v1/observability/environment/{environment_id}/recordingrules [post]
+// @Security    JWT
+func (h *ObservabilityHandler) CreateRecordingRule(c *gin.Context) {
+	req := &models.RecordingRule{}
+	if err := c.BindJSON(req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	env, err := h.getEnvironmentWithCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	u, exist := h.GetContextUser(c)
+	if !exist {
+		handlers.NotOK(c, fmt.Errorf("not login"))
+		return
+	}
+	req.ID = 0
+	req.EnvironmentID = env.ID
+	req.Creator = u.GetUsername()
+	if err := observe.CheckRecordingRule(req, env.Namespace); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	action := i18n.Sprintf(context.TODO(), "create")
+	module := i18n.Sprintf(context.TODO(), "recording rule")
+	h.SetAuditData(c, action, module, req.Record)
+	h.SetExtraAuditData(c, models.ResEnvironment, env.ID)
+
+	if err := h.GetDB().WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(req).Error; err != nil {
+			return err
+		}
+		return h.syncRecordingRules(c.Request.Context(), tx, env)
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, req)
+}
+
+// UpdateRecordingRule 更新预聚合规则
+// @Tags        Observability
+// @Summary     更新预聚合规则
+// @Description 更新预聚合规则, 指标名不可修改
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                             true "环境ID"
+// @Param       rule_id        path     uint                                               true "rule id"
+// @Param       form           body     models.RecordingRule                               true "预聚合规则"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.RecordingRule} "resp"
+// @Router      /v1/observability/environment/{environment_id}/recordingrules/{rule_id} [put]
+// @Security    JWT
+func (h *ObservabilityHandler) UpdateRecordingRule(c *gin.Context) {
+	req := &models.RecordingRule{}
+	if err := c.BindJSON(req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	env, err := h.getEnvironmentWithCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	old := &models.RecordingRule{}
+	if err := h.GetDB().WithContext(c.Request.Context()).
+		First(old, "id = ? and environment_id = ?", c.Param("rule_id"), env.ID).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	old.Expr = req.Expr
+	old.Description = req.Description
+	old.Labels = req.Labels
+	if err := observe.CheckRecordingRule(old, env.Namespace); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	action := i18n.Sprintf(context.TODO(), "update")
+	module := i18n.Sprintf(context.TODO(), "recording rule")
+	h.SetAuditData(c, action, module, old.Record)
+	h.SetExtraAuditData(c, models.ResEnvironment, env.ID)
+
+	if err := h.GetDB().WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Select("expr", "description", "labels").Updates(old).Error; err != nil {
+			return err
+		}
+		return h.syncRecordingRules(c.Request.Context(), tx, env)
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, old)
+}
+
+// DeleteRecordingRule 删除预聚合规则
+// @Tags        Observability
+// @Summary     删除预聚合规则
+// @Description 删除预聚合规则
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                               true "环境ID"
+// @Param       rule_id        path     uint                                 true "rule id"
+// @Success     200            {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/observability/environment/{environment_id}/recordingrules/{rule_id} [delete]
+// @Security    JWT
+func (h *ObservabilityHandler) DeleteRecordingRule(c *gin.Context) {
+	env, err := h.getEnvironmentWithCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	rule := &models.RecordingRule{}
+	if err := h.GetDB().WithContext(c.Request.Context()).
+		First(rule, "id = ? and environment_id = ?", c.Param("rule_id"), env.ID).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	action := i18n.Sprintf(context.TODO(), "delete")
+	module := i18n.Sprintf(context.TODO(), "recording rule")
+	h.SetAuditData(c, action, module, rule.Record)
+	h.SetExtraAuditData(c, models.ResEnvironment, env.ID)
+
+	if err := h.GetDB().WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(rule).Error; err != nil {
+			return err
+		}
+		return h.syncRecordingRules(c.Request.Context(), tx, env)
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, "ok")
+}
+
+// RecordingRuleSuggestions 预聚合规则建议
+// @Tags        Observability
+// @Summary     预聚合规则建议
+// @Description 执行环境中所有监控面板的查询, 返回耗时超过阈值且未被预聚合的查询
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                                         true  "环境ID"
+// @Param       threshold      query    string                                                         false "耗时阈值, 默认1s"
+// @Param       start          query    string                                                         false "开始时间，默认现在-30m"
+// @Param       end            query    string                                                         false "结束时间，默认现在"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]RecordingRuleSuggestion} "resp"
+// @Router      /v1/observability/environment/{environment_id}/recordingrules/suggestions [get]
+// @Security    JWT
+func (h *ObservabilityHandler) RecordingRuleSuggestions(c *gin.Context) {
+	threshold := defaultSlowQueryThreshold
+	if t := c.Query("threshold"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			handlers.NotOK(c, errors.Wrap(err, "threshold"))
+			return
+		}
+		threshold = d
+	}
+	env, err := h.getEnvironmentWithCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	dashboards := []models.MonitorDashboard{}
+	if err := h.GetDB().WithContext(c.Request.Context()).Find(&dashboards, "environment_id = ?", env.ID).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	rules := []models.RecordingRule{}
+	if err := h.GetDB().WithContext(c.Request.Context()).Find(&rules, "environment_id = ?", env.ID).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	recorded := map[string]bool{}
+	for _, rule := range rules {
+		recorded[rule.Expr] = true
+	}
+
+	ret := []RecordingRuleSuggestion{}
+	if err := h.Execute(c.Request.Context(), env.Cluster.ClusterName, func(ctx context.Context, cli agents.Client) error {
+		for _, dash := range dashboards {
+			for _, graph := range dash.Graphs {
+				for _, target := range graph.Targets {
+					query := &MetricQueryReq{
+						Cluster:         env.Cluster.ClusterName,
+						Namespace:       env.Namespace,
+						Start:           c.Query("start"),
+						End:             c.Query("end"),
+						Expr:            target.Expr,
+						PromqlGenerator: target.PromqlGenerator,
+						TargetName:      target.TargetName,
+					}
+					if err := h.mutateMetricQueryReq(ctx, query); err != nil {
+						log.Warnf("dashboard %s graph %s: %v", dash.Name, graph.Name, err)
+						continue
+					}
+					if recorded[query.Expr] {
+						continue
+					}
+					start := time.Now()
+					if _, err := cli.Extend().PrometheusQueryRange(ctx, query.Expr, query.Start, query.End, query.Step); err != nil {
+						log.Warnf("query %s failed: %v", query.Expr, err)
+						continue
+					}
+					elapsed := time.Since(start)
+					if elapsed < threshold {
+						continue
+					}
+					record, err := observe.SuggestRecordName(query.Expr)
+					if err != nil {
+						log.Warnf("suggest record name for %s: %v", query.Expr, err)
+					}
+					ret = append(ret, RecordingRuleSuggestion{
+						DashboardID:   dash.ID,
+						DashboardName: dash.Name,
+						GraphName:     graph.Name,
+						TargetName:    target.TargetName,
+						Expr:          query.Expr,
+						Duration:      elapsed.Round(time.Millisecond).String(),
+						Record:        record,
+						elapsed:       elapsed,
+					})
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	// 耗时长的在前
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].elapsed > ret[j].elapsed
+	})
+	handlers.OK(c, ret)
+}
+
+// syncRecordingRules 将环境中的全部预聚合规则写入命名空间的 PrometheusRule, 没有规则时删除
+func (h *ObservabilityHandler) syncRecordingRules(ctx context.Context, tx *gorm.DB, env *models.Environment) error {
+	rules := []models.RecordingRule{}
+	if err := tx.Find(&rules, "environment_id = ?", env.ID).Error; err != nil {
+		return err
+	}
+	return h.Execute(ctx, env.Cluster.ClusterName, func(ctx context.Context, cli agents.Client) error {
+		prule := observe.GetRecordingPrometheusRule(env.Namespace)
+		if len(rules) == 0 {
+			if err := cli.Delete(ctx, prule); err != nil && !kerrors.IsNotFound(err) {
+				return err
+			}
+			return nil
+		}
+		_, err := controllerutil.CreateOrUpdate(ctx, cli, prule, func() error {
+			prule.Spec.Groups = observe.GenerateRecordingRuleGroups(env.Namespace, rules)
+			return nil
+		})
+		return err
+	})
+}
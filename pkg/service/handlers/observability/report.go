@@ -0,0 +1,539 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observability
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	synccommon "github.com/argoproj/gitops-engine/pkg/sync/common"
+	"github.com/gin-gonic/gin"
+	prommodel "github.com/prometheus/common/model"
+	"golang.org/x/sync/errgroup"
+	"gorm.io/gorm"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"kubegems.io/kubegems/pkg/apis/application"
+	"kubegems.io/kubegems/pkg/apis/gems"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/service/observe"
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"kubegems.io/kubegems/pkg/utils/argo"
+	"kubegems.io/kubegems/pkg/utils/database"
+	"kubegems.io/kubegems/pkg/utils/prometheus/channels"
+)
+
+// 报告中展示的告警数量
+const reportTopAlertsLimit = 5
+
+// ReportProcessor 生成并发送定时报告, 在 worker 中定时执行, 也可以在页面上预览和立即发送
+type ReportProcessor struct {
+	db   *database.Database
+	cs   *agents.ClientSet
+	argo *argo.Client // 为空时不统计部署情况
+}
+
+func NewReportProcessor(db *database.Database, cs *agents.ClientSet, argocli *argo.Client) *ReportProcessor {
+	return &ReportProcessor{db: db, cs: cs, argo: argocli}
+}
+
+// Generate 生成截止到 end 的一个周期内的报告
+func (p *ReportProcessor) Generate(ctx context.Context, report *models.ScheduledReport, end time.Time) (*observe.ReportData, error) {
+	db := p.db.DB().WithContext(ctx)
+	tenant := models.Tenant{}
+	if err := db.First(&tenant, "id = ?", report.TenantID).Error; err != nil {
+		return nil, err
+	}
+	query := db.Preload("Cluster").Preload("Project").
+		Where("project_id in (?)", db.Model(&models.Project{}).Select("id").Where("tenant_id = ?", tenant.ID))
+	if report.ProjectID != nil {
+		query = query.Where("project_id = ?", *report.ProjectID)
+	}
+	envs := []models.Environment{}
+	if err := query.Order("project_id, environment_name").Find(&envs).Error; err != nil {
+		return nil, err
+	}
+
+	ret := &observe.ReportData{
+		Title:        fmt.Sprintf("Kubegems %s report: %s", report.Frequency, report.Name),
+		Start:        end.Add(-report.Period()),
+		End:          end,
+		Environments: make([]observe.EnvironmentReport, len(envs)),
+	}
+	// 按 项目/环境 分组的 argo 应用
+	apps := map[string][]argov1alpha1.Application{}
+	var appsErr error
+	if p.argo != nil {
+		applist, err := p.argo.ListArgoApp(ctx, labels.Set{
+			application.LabelFrom: application.LabelValueFromApp,
+			gems.LabelTenant:      tenant.TenantName,
+		}.AsSelector())
+		if err != nil {
+			appsErr = fmt.Errorf("list argo applications: %w", err)
+		} else {
+			for _, app := range applist.Items {
+				key := app.Labels[gems.LabelProject] + "/" + app.Labels[gems.LabelEnvironment]
+				apps[key] = append(apps[key], app)
+			}
+		}
+	}
+
+	eg := errgroup.Group{}
+	eg.SetLimit(5)
+	for i := range envs {
+		i := i
+		eg.Go(func() error {
+			env := &envs[i]
+			envReport := p.environmentReport(ctx, env, ret.Start, ret.End)
+			if appsErr != nil {
+				envReport.Errors = append(envReport.Errors, appsErr.Error())
+			} else {
+				envReport.Deployments, envReport.FailedRollouts = countDeployments(
+					apps[env.Project.ProjectName+"/"+env.EnvironmentName], ret.Start, ret.End)
+			}
+			ret.Environments[i] = envReport
+			return nil
+		})
+	}
+	_ = eg.Wait()
+	return ret, nil
+}
+
+func (p *ReportProcessor) environmentReport(ctx context.Context, env *models.Environment, start, end time.Time) observe.EnvironmentReport {
+	ret := observe.EnvironmentReport{
+		Environment: env.EnvironmentName,
+		Project:     env.Project.ProjectName,
+		Cluster:     env.Cluster.ClusterName,
+		Namespace:   env.Namespace,
+	}
+	addErr := func(item string, err error) {
+		ret.Errors = append(ret.Errors, fmt.Sprintf("%s: %v", item, err))
+	}
+
+	if env.ResourceQuota != nil {
+		quota := corev1.ResourceList{}
+		if err := json.Unmarshal(env.ResourceQuota, &quota); err != nil {
+			addErr("quota", err)
+		}
+		if q, ok := quota[corev1.ResourceLimitsCPU]; ok {
+			ret.CPULimit = q.AsApproximateFloat64()
+		}
+		if q, ok := quota[corev1.ResourceLimitsMemory]; ok {
+			ret.MemoryLimit = q.AsApproximateFloat64()
+		}
+	}
+
+	alerts, err := p.topFiringAlerts(ctx, env, start, end)
+	if err != nil {
+		addErr("alerts", err)
+	}
+	ret.TopAlerts = alerts
+
+	cli, err := p.cs.ClientOf(ctx, env.Cluster.ClusterName)
+	if err != nil {
+		addErr("cluster", err)
+		return ret
+	}
+	dur := prommodel.Duration(end.Sub(start)).String()
+	queries := map[string]string{
+		"cpu":       fmt.Sprintf(`avg_over_time(gems_namespace_cpu_usage_cores{namespace="%s"}[%s])`, env.Namespace, dur),
+		"memory":    fmt.Sprintf(`avg_over_time(gems_namespace_memory_usage_bytes{namespace="%s"}[%s])`, env.Namespace, dur),
+		"errorlogs": fmt.Sprintf(`sum(sum_over_time(gems_loki_error_logs_count_last_1m{namespace="%s"}[%s]))`, env.Namespace, dur),
+	}
+	for _, item := range []string{"cpu", "memory", "errorlogs"} {
+		vector, err := cli.Extend().PrometheusVector(ctx, queries[item])
+		if err != nil {
+			addErr(item, err)
+			continue
+		}
+		v := firstSampleValue(vector)
+		if v == nil {
+			continue
+		}
+		switch item {
+		case "cpu":
+			ret.CPUUsage = *v
+		case "memory":
+			ret.MemoryUsage = *v
+		case "errorlogs":
+			ret.ErrorLogCount = int64(*v)
+		}
+	}
+	return ret
+}
+
+func (p *ReportProcessor) topFiringAlerts(ctx context.Context, env *models.Environment, start, end time.Time) ([]observe.AlertCount, error) {
+	ret := []observe.AlertCount{}
+	err := p.db.DB().WithContext(ctx).Model(&models.AlertMessage{}).
+		Select("alert_infos.name as name, count(*) as count").
+		Joins("join alert_infos on alert_infos.fingerprint = alert_messages.fingerprint").
+		Where("alert_infos.cluster_name = ? and alert_infos.namespace = ?", env.Cluster.ClusterName, env.Namespace).
+		Where("alert_messages.status = ? and alert_messages.created_at between ? and ?", "firing", start, end).
+		Group("alert_infos.name").
+		Order("count desc").
+		Limit(reportTopAlertsLimit).
+		Scan(&ret).Error
+	return ret, err
+}
+
+// countDeployments 统计时间段内的部署次数和失败的部署
+func countDeployments(apps []argov1alpha1.Application, start, end time.Time) (int, int) {
+	inRange := func(t time.Time) bool {
+		return !t.Before(start) && !t.After(end)
+	}
+	deploys, failed := 0, 0
+	for _, app := range apps {
+		for _, history := range app.Status.History {
+			if inRange(history.DeployedAt.Time) {
+				deploys++
+			}
+		}
+		// 失败的同步不会记录到 history 中, 只能从最近一次操作获取
+		op := app.Status.OperationState
+		if op != nil && op.FinishedAt != nil && inRange(op.FinishedAt.Time) &&
+			(op.Phase == synccommon.OperationFailed || op.Phase == synccommon.OperationError) {
+			failed++
+		}
+	}
+	return deploys, failed
+}
+
+// Send 生成报告并发送到报告的所有渠道, 记录发送结果
+func (p *ReportProcessor) Send(ctx context.Context, report *models.ScheduledReport, now time.Time) error {
+	err := p.send(ctx, report, now)
+	report.LastSentAt = &now
+	report.LastError = ""
+	if err != nil {
+		report.LastError = err.Error()
+	}
+	if dberr := p.db.DB().WithContext(ctx).Model(report).
+		Select("last_sent_at", "last_error").Updates(report).Error; dberr != nil {
+		log.Error(dberr, "update scheduled report status", "report", report.Name)
+	}
+	return err
+}
+
+func (p *ReportProcessor) send(ctx context.Context, report *models.ScheduledReport, now time.Time) error {
+	data, err := p.Generate(ctx, report, now)
+	if err != nil {
+		return err
+	}
+	markdown, err := observe.RenderReportMarkdown(data)
+	if err != nil {
+		return err
+	}
+	html, err := observe.RenderReportHTML(data)
+	if err != nil {
+		return err
+	}
+	r := channels.Report{Title: data.Title, Markdown: markdown, HTML: html}
+	errs := []string{}
+	for _, ch := range report.Channels {
+		if err := channels.SendReport(ch.ChannelConfig.ChannelIf, r); err != nil {
+			errs = append(errs, fmt.Sprintf("channel %s: %v", ch.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// SendDueReports 发送所有到期的报告, 单个报告失败不影响其他报告
+func (p *ReportProcessor) SendDueReports(ctx context.Context, now time.Time) error {
+	reports := []*models.ScheduledReport{}
+	if err := p.db.DB().WithContext(ctx).Preload("Channels").Find(&reports, "enabled = ?", true).Error; err != nil {
+		return err
+	}
+	for _, report := range reports {
+		if !report.IsDue(now) {
+			continue
+		}
+		if err := p.Send(ctx, report, now); err != nil {
+			log.Error(err, "send scheduled report", "report", report.Name)
+		}
+	}
+	return nil
+}
+
+// ListReports 定时报告列表
+// @Tags        Observability
+// @Summary     定时报告列表
+// @Description 定时报告列表
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id path     string                                                   true "租户id"
+// @Success     200       {object} handlers.ResponseStruct{Data=[]models.ScheduledReport} "resp"
+// @Router      /v1/observability/tenant/{tenant_id}/reports [get]
+// @Security    JWT
+func (h *ObservabilityHandler) ListReports(c *gin.Context) {
+	ret := []models.ScheduledReport{}
+	if err := h.GetDB().WithContext(c.Request.Context()).Preload("Channels").Preload("Project").
+		Order("id").Find(&ret, "tenant_id = ?", c.Param("tenant_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+// CreateReport 创建定时报告
+// @Tags        Observability
+// @Summary     创建定时报告
+// @Description 创建定时报告, projectID 为空时汇总租户下所有项目, 渠道只需要传 id
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id path     string                                                 true "租户id"
+// @Param       form      body     models.ScheduledReport                                 true "报告"
+// @Success     200       {object} handlers.ResponseStruct{Data=models.ScheduledReport} "resp"
+// @Router      /v1/observability/tenant/{tenant_id}/reports [post]
+// @Security    JWT
+func (h *ObservabilityHandler) CreateReport(c *gin.Context) {
+	req := &models.ScheduledReport{}
+	if err := c.BindJSON(req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	u, exist := h.GetContextUser(c)
+	if !exist {
+		handlers.NotOK(c, fmt.Errorf("not login"))
+		return
+	}
+	req.ID = 0
+	req.Creator = u.GetUsername()
+	req.LastSentAt = nil
+	req.LastError = ""
+	if err := h.checkReport(c, req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	action := i18n.Sprintf(context.TODO(), "create")
+	module := i18n.Sprintf(context.TODO(), "scheduled report")
+	h.SetAuditData(c, action, module, req.Name)
+	h.SetExtraAuditData(c, models.ResTenant, req.TenantID)
+
+	if err := h.GetDB().WithContext(c.Request.Context()).Omit("Channels.*").Create(req).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, req)
+}
+
+// UpdateReport 更新定时报告
+// @Tags        Observability
+// @Summary     更新定时报告
+// @Description 更新定时报告
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id path     string                                                 true "租户id"
+// @Param       report_id path     uint                                                   true "report id"
+// @Param       form      body     models.ScheduledReport                                 true "报告"
+// @Success     200       {object} handlers.ResponseStruct{Data=models.ScheduledReport} "resp"
+// @Router      /v1/observability/tenant/{tenant_id}/reports/{report_id} [put]
+// @Security    JWT
+func (h *ObservabilityHandler) UpdateReport(c *gin.Context) {
+	req := &models.ScheduledReport{}
+	if err := c.BindJSON(req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	old, err := h.getReport(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	old.Name = req.Name
+	old.ProjectID = req.ProjectID
+	old.Frequency = req.Frequency
+	old.Enabled = req.Enabled
+	old.Channels = req.Channels
+	if err := h.checkReport(c, old); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	action := i18n.Sprintf(context.TODO(), "update")
+	module := i18n.Sprintf(context.TODO(), "scheduled report")
+	h.SetAuditData(c, action, module, old.Name)
+	h.SetExtraAuditData(c, models.ResTenant, old.TenantID)
+
+	if err := h.GetDB().WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Select("name", "project_id", "frequency", "enabled").Updates(old).Error; err != nil {
+			return err
+		}
+		return tx.Model(old).Association("Channels").Replace(old.Channels)
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, old)
+}
+
+// DeleteReport 删除定时报告
+// @Tags        Observability
+// @Summary     删除定时报告
+// @Description 删除定时报告
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id path     string                               true "租户id"
+// @Param       report_id path     uint                                 true "report id"
+// @Success     200       {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/observability/tenant/{tenant_id}/reports/{report_id} [delete]
+// @Security    JWT
+func (h *ObservabilityHandler) DeleteReport(c *gin.Context) {
+	report, err := h.getReport(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	action := i18n.Sprintf(context.TODO(), "delete")
+	module := i18n.Sprintf(context.TODO(), "scheduled report")
+	h.SetAuditData(c, action, module, report.Name)
+	h.SetExtraAuditData(c, models.ResTenant, report.TenantID)
+
+	if err := h.GetDB().WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(report).Association("Channels").Clear(); err != nil {
+			return err
+		}
+		return tx.Delete(report).Error
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, "ok")
+}
+
+type ReportPreview struct {
+	Data     *observe.ReportData `json:"data"`
+	Markdown string              `json:"markdown"`
+}
+
+// PreviewReport 预览定时报告
+// @Tags        Observability
+// @Summary     预览定时报告
+// @Description 生成截止到现在的报告, 不发送
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id path     string                                      true "租户id"
+// @Param       report_id path     uint                                        true "report id"
+// @Success     200       {object} handlers.ResponseStruct{Data=ReportPreview} "resp"
+// @Router      /v1/observability/tenant/{tenant_id}/reports/{report_id}/preview [get]
+// @Security    JWT
+func (h *ObservabilityHandler) PreviewReport(c *gin.Context) {
+	report, err := h.getReport(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	data, err := h.reportProcessor().Generate(c.Request.Context(), report, time.Now())
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	markdown, err := observe.RenderReportMarkdown(data)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ReportPreview{Data: data, Markdown: markdown})
+}
+
+// SendReport 立即发送定时报告
+// @Tags        Observability
+// @Summary     立即发送定时报告
+// @Description 立即发送定时报告, 下次定时发送时间从本次开始计算
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id path     string                               true "租户id"
+// @Param       report_id path     uint                                 true "report id"
+// @Success     200       {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/observability/tenant/{tenant_id}/reports/{report_id}/send [post]
+// @Security    JWT
+func (h *ObservabilityHandler) SendReport(c *gin.Context) {
+	report, err := h.getReport(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	action := i18n.Sprintf(context.TODO(), "send")
+	module := i18n.Sprintf(context.TODO(), "scheduled report")
+	h.SetAuditData(c, action, module, report.Name)
+	h.SetExtraAuditData(c, models.ResTenant, report.TenantID)
+
+	if err := h.reportProcessor().Send(c.Request.Context(), report, time.Now()); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, "ok")
+}
+
+func (h *ObservabilityHandler) reportProcessor() *ReportProcessor {
+	return NewReportProcessor(h.GetDataBase(), h.GetAgents(), h.Argo)
+}
+
+func (h *ObservabilityHandler) getReport(c *gin.Context) (*models.ScheduledReport, error) {
+	report := &models.ScheduledReport{}
+	if err := h.GetDB().WithContext(c.Request.Context()).Preload("Channels").
+		First(report, "id = ? and tenant_id = ?", c.Param("report_id"), c.Param("tenant_id")).Error; err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// checkReport 检查报告的项目和渠道属于当前租户, 渠道也可以是系统预置的
+func (h *ObservabilityHandler) checkReport(c *gin.Context, report *models.ScheduledReport) error {
+	tenantID, err := strconv.Atoi(c.Param("tenant_id"))
+	if err != nil {
+		return fmt.Errorf("tenant_id: %w", err)
+	}
+	report.TenantID = uint(tenantID)
+	if err := report.Validate(); err != nil {
+		return err
+	}
+	db := h.GetDB().WithContext(c.Request.Context())
+	if report.ProjectID != nil {
+		project := models.Project{}
+		if err := db.First(&project, "id = ? and tenant_id = ?", *report.ProjectID, report.TenantID).Error; err != nil {
+			return fmt.Errorf("project %d: %w", *report.ProjectID, err)
+		}
+	}
+	ids := []uint{}
+	for _, ch := range report.Channels {
+		ids = append(ids, ch.ID)
+	}
+	chs := []*models.AlertChannel{}
+	if err := db.Find(&chs, "id in (?) and (tenant_id = ? or tenant_id is null)", ids, report.TenantID).Error; err != nil {
+		return err
+	}
+	if len(chs) != len(ids) {
+		return fmt.Errorf("some channels not found in tenant")
+	}
+	for _, ch := range chs {
+		if _, ok := ch.ChannelConfig.ChannelIf.(channels.ReportSender); !ok {
+			return fmt.Errorf("channel %s not support sending report", ch.Name)
+		}
+	}
+	report.Channels = chs
+	return nil
+}
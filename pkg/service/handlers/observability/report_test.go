@@ -0,0 +1,59 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observability
+
+import (
+	"testing"
+	"time"
+
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	synccommon "github.com/argoproj/gitops-engine/pkg/sync/common"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_countDeployments(t *testing.T) {
+	end := time.Date(2022, 10, 10, 10, 0, 0, 0, time.UTC)
+	start := end.Add(-24 * time.Hour)
+	at := func(d time.Duration) *metav1.Time {
+		t := metav1.NewTime(end.Add(d))
+		return &t
+	}
+	apps := []argov1alpha1.Application{
+		{
+			Status: argov1alpha1.ApplicationStatus{
+				History: argov1alpha1.RevisionHistories{
+					{DeployedAt: *at(-48 * time.Hour)},
+					{DeployedAt: *at(-12 * time.Hour)},
+					{DeployedAt: *at(-time.Hour)},
+				},
+				OperationState: &argov1alpha1.OperationState{Phase: synccommon.OperationSucceeded, FinishedAt: at(-time.Hour)},
+			},
+		},
+		{
+			Status: argov1alpha1.ApplicationStatus{
+				OperationState: &argov1alpha1.OperationState{Phase: synccommon.OperationFailed, FinishedAt: at(-2 * time.Hour)},
+			},
+		},
+		{
+			Status: argov1alpha1.ApplicationStatus{
+				OperationState: &argov1alpha1.OperationState{Phase: synccommon.OperationError, FinishedAt: at(-30 * time.Hour)},
+			},
+		},
+	}
+	deploys, failed := countDeployments(apps, start, end)
+	if deploys != 2 || failed != 1 {
+		t.Errorf("countDeployments() = %d, %d, want 2, 1", deploys, failed)
+	}
+}
@@ -0,0 +1,371 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observability
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	prommodel "github.com/prometheus/common/model"
+	"gorm.io/gorm"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/service/observe"
+	"kubegems.io/kubegems/pkg/utils/agents"
+)
+
+type SLOBudgetStatus struct {
+	SLO *models.ServiceLevelObjective `json:"slo"`
+	// 统计窗口内的达标比例
+	SLI *float64 `json:"sli"`
+	// 剩余错误预算比例, 小于0时表示预算已耗尽
+	BudgetRemaining *float64 `json:"budgetRemaining"`
+	// 各窗口的燃烧率, 1 表示恰好在统计窗口结束时耗尽预算
+	BurnRates map[string]*float64 `json:"burnRates"`
+	// 剩余错误预算的变化趋势
+	BudgetHistory prommodel.Matrix `json:"budgetHistory"`
+}
+
+// ListSLOs SLO列表
+// @Tags        Observability
+// @Summary     SLO列表
+// @Description SLO列表
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                                       true "环境ID"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]models.ServiceLevelObjective} "SLO列表"
+// @Router      /v1/observability/environment/{environment_id}/slos [get]
+// @Security    JWT
+func (h *ObservabilityHandler) ListSLOs(c *gin.Context) {
+	ret := []models.ServiceLevelObjective{}
+	if err := h.GetDB().WithContext(c.Request.Context()).Find(&ret, "environment_id = ?", c.Param("environment_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+// CreateSLO 创建SLO
+// @Tags        Observability
+// @Summary     创建SLO
+// @Description 创建SLO, 开启告警时根据错误预算生成多窗口燃烧率告警规则
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                                     true "环境ID"
+// @Param       form           body     models.ServiceLevelObjective                               true "SLO"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.ServiceLevelObjective} "resp"
+// @Router      /v1/observability/environment/{environment_id}/slos [post]
+// @Security    JWT
+func (h *ObservabilityHandler) CreateSLO(c *gin.Context) {
+	req := &models.ServiceLevelObjective{}
+	if err := c.BindJSON(req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	env, err := h.getEnvironmentWithCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	u, exist := h.GetContextUser(c)
+	if !exist {
+		handlers.NotOK(c, fmt.Errorf("not login"))
+		return
+	}
+	req.ID = 0
+	req.EnvironmentID = env.ID
+	req.Creator = u.GetUsername()
+	if err := h.checkSLO(req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	action := i18n.Sprintf(context.TODO(), "create")
+	module := i18n.Sprintf(context.TODO(), "service level objective")
+	h.SetAuditData(c, action, module, req.Name)
+	h.SetExtraAuditData(c, models.ResEnvironment, env.ID)
+
+	if err := h.GetDB().WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(req).Error; err != nil {
+			return err
+		}
+		return h.syncSLOAlertRules(c.Request.Context(), nil, req, env)
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, req)
+}
+
+// UpdateSLO 更新SLO
+// @Tags        Observability
+// @Summary     更新SLO
+// @Description 更新SLO, 同时重新生成燃烧率告警规则
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                                     true "环境ID"
+// @Param       slo_id         path     uint                                                       true "slo id"
+// @Param       form           body     models.ServiceLevelObjective                               true "SLO"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.ServiceLevelObjective} "resp"
+// @Router      /v1/observability/environment/{environment_id}/slos/{slo_id} [put]
+// @Security    JWT
+func (h *ObservabilityHandler) UpdateSLO(c *gin.Context) {
+	req := &models.ServiceLevelObjective{}
+	if err := c.BindJSON(req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	env, err := h.getEnvironmentWithCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	old := &models.ServiceLevelObjective{}
+	if err := h.GetDB().WithContext(c.Request.Context()).
+		First(old, "id = ? and environment_id = ?", c.Param("slo_id"), env.ID).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	// 名称不可修改
+	req.ID = old.ID
+	req.Name = old.Name
+	req.EnvironmentID = old.EnvironmentID
+	req.Creator = old.Creator
+	req.CreatedAt = old.CreatedAt
+	if err := h.checkSLO(req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	action := i18n.Sprintf(context.TODO(), "update")
+	module := i18n.Sprintf(context.TODO(), "service level objective")
+	h.SetAuditData(c, action, module, req.Name)
+	h.SetExtraAuditData(c, models.ResEnvironment, env.ID)
+
+	if err := h.GetDB().WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Select("*").Omit("created_at").Updates(req).Error; err != nil {
+			return err
+		}
+		return h.syncSLOAlertRules(c.Request.Context(), old, req, env)
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, req)
+}
+
+// DeleteSLO 删除SLO
+// @Tags        Observability
+// @Summary     删除SLO
+// @Description 删除SLO以及生成的燃烧率告警规则
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                               true "环境ID"
+// @Param       slo_id         path     uint                                 true "slo id"
+// @Success     200            {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/observability/environment/{environment_id}/slos/{slo_id} [delete]
+// @Security    JWT
+func (h *ObservabilityHandler) DeleteSLO(c *gin.Context) {
+	env, err := h.getEnvironmentWithCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	slo := &models.ServiceLevelObjective{}
+	if err := h.GetDB().WithContext(c.Request.Context()).
+		First(slo, "id = ? and environment_id = ?", c.Param("slo_id"), env.ID).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	action := i18n.Sprintf(context.TODO(), "delete")
+	module := i18n.Sprintf(context.TODO(), "service level objective")
+	h.SetAuditData(c, action, module, slo.Name)
+	h.SetExtraAuditData(c, models.ResEnvironment, env.ID)
+
+	if err := h.GetDB().WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(slo).Error; err != nil {
+			return err
+		}
+		return h.syncSLOAlertRules(c.Request.Context(), slo, nil, env)
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, "ok")
+}
+
+// SLOBudget SLO错误预算
+// @Tags        Observability
+// @Summary     SLO错误预算
+// @Description 查询SLO当前的达标率, 剩余错误预算, 各窗口燃烧率以及剩余预算的变化趋势
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                        true  "环境ID"
+// @Param       slo_id         path     uint                                          true  "slo id"
+// @Param       start          query    string                                        false "开始时间，默认现在-30m"
+// @Param       end            query    string                                        false "结束时间，默认现在"
+// @Param       step           query    int                                           false "step, 单位秒"
+// @Success     200            {object} handlers.ResponseStruct{Data=SLOBudgetStatus} "resp"
+// @Router      /v1/observability/environment/{environment_id}/slos/{slo_id}/budget [get]
+// @Security    JWT
+func (h *ObservabilityHandler) SLOBudget(c *gin.Context) {
+	env, err := h.getEnvironmentWithCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	slo := &models.ServiceLevelObjective{}
+	if err := h.GetDB().WithContext(c.Request.Context()).
+		First(slo, "id = ? and environment_id = ?", c.Param("slo_id"), env.ID).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if err := h.setSLOTpl(slo); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	budget := slo.ErrorBudget()
+	windowExpr, err := observe.SLOErrorRatioExpr(slo, env.Namespace, slo.Window)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	queries := map[string]string{
+		"sli":       fmt.Sprintf("1 - (%s)", windowExpr),
+		"remaining": fmt.Sprintf("1 - (%s) / %s", windowExpr, formatSLOFloat(budget)),
+	}
+	for _, w := range observe.SLOBudgetWindows {
+		expr, err := observe.SLOErrorRatioExpr(slo, env.Namespace, w)
+		if err != nil {
+			handlers.NotOK(c, err)
+			return
+		}
+		queries[w] = fmt.Sprintf("(%s) / %s", expr, formatSLOFloat(budget))
+	}
+
+	start, end, _ := getRangeParams(c.Query("start"), c.Query("end"))
+	ret := SLOBudgetStatus{SLO: slo, BurnRates: map[string]*float64{}}
+	if err := h.Execute(c.Request.Context(), env.Cluster.ClusterName, func(ctx context.Context, cli agents.Client) error {
+		vectors := batchVector(queries, ctx, cli)
+		ret.SLI = firstSampleValue(vectors["sli"])
+		ret.BudgetRemaining = firstSampleValue(vectors["remaining"])
+		for _, w := range observe.SLOBudgetWindows {
+			ret.BurnRates[w] = firstSampleValue(vectors[w])
+		}
+		history, err := cli.Extend().PrometheusQueryRange(ctx, queries["remaining"], start, end, c.Query("step"))
+		if err != nil {
+			return err
+		}
+		ret.BudgetHistory = history
+		return nil
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+func (h *ObservabilityHandler) getEnvironmentWithCluster(c *gin.Context) (*models.Environment, error) {
+	envid, err := strconv.Atoi(c.Param("environment_id"))
+	if err != nil {
+		return nil, errors.Wrap(err, "environment_id")
+	}
+	env := &models.Environment{}
+	if err := h.GetDB().WithContext(c.Request.Context()).Preload("Cluster").First(env, "id = ?", envid).Error; err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+func (h *ObservabilityHandler) checkSLO(slo *models.ServiceLevelObjective) error {
+	if err := slo.Validate(); err != nil {
+		return err
+	}
+	if slo.Source != models.SLOSourcePromql {
+		slo.PromqlGenerator = nil
+		return nil
+	}
+	return h.setSLOTpl(slo)
+}
+
+func (h *ObservabilityHandler) setSLOTpl(slo *models.ServiceLevelObjective) error {
+	if slo.PromqlGenerator == nil {
+		return nil
+	}
+	tpl, err := h.GetDataBase().FindPromqlTpl(slo.PromqlGenerator.Scope, slo.PromqlGenerator.Resource, slo.PromqlGenerator.Rule)
+	if err != nil {
+		return err
+	}
+	slo.PromqlGenerator.Tpl = tpl
+	return nil
+}
+
+// syncSLOAlertRules 删除旧SLO生成的告警规则, 并根据新SLO重新生成
+func (h *ObservabilityHandler) syncSLOAlertRules(ctx context.Context, old, new *models.ServiceLevelObjective, env *models.Environment) error {
+	return h.withAlertRuleProcessor(ctx, env.Cluster.ClusterName, func(ctx context.Context, p *AlertRuleProcessor) error {
+		if old != nil {
+			for _, alert := range observe.SLOBurnRateAlerts {
+				rule := &models.AlertRule{}
+				if err := p.DBWithCtx(ctx).First(rule, "cluster = ? and namespace = ? and name = ?",
+					env.Cluster.ClusterName, env.Namespace, observe.SLOAlertRuleName(old, alert.Suffix)).Error; err != nil {
+					if errors.Is(err, gorm.ErrRecordNotFound) {
+						continue
+					}
+					return err
+				}
+				if err := p.DBWithCtx(ctx).Delete(rule).Error; err != nil {
+					return err
+				}
+				if err := p.deleteMonitorAlertRule(ctx, rule); err != nil {
+					return err
+				}
+			}
+		}
+		if new == nil || !new.AlertEnabled {
+			return nil
+		}
+		rules, err := observe.SLOBurnRateAlertRules(new, env.Cluster.ClusterName, env.Namespace)
+		if err != nil {
+			return err
+		}
+		for _, rule := range rules {
+			if err := p.MutateAlertRule(ctx, rule); err != nil {
+				return err
+			}
+			if err := p.CreateAlertRule(ctx, rule); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func firstSampleValue(vector prommodel.Vector) *float64 {
+	if len(vector) == 0 {
+		return nil
+	}
+	v := float64(vector[0].Value)
+	return &v
+}
+
+func formatSLOFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', 6, 64)
+}
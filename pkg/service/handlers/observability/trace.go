@@ -16,11 +16,18 @@ package observability
 
 import (
 	"context"
+	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"kubegems.io/kubegems/pkg/i18n"
 	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
 	"kubegems.io/kubegems/pkg/service/observe"
 	"kubegems.io/kubegems/pkg/utils/agents"
+	"kubegems.io/kubegems/pkg/utils/loki"
 )
 
 // GetTrace GetTrace by trace_id
@@ -48,3 +55,198 @@ func (h *ObservabilityHandler) GetTrace(c *gin.Context) {
 	}
 	handlers.OK(c, trace)
 }
+
+// GetTraceLogConfig 获取环境的链路日志关联配置
+// @Tags        Observability
+// @Summary     获取环境的链路日志关联配置
+// @Description 获取环境的链路日志关联配置, 未配置时返回默认配置
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                              true "环境ID"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.TraceLogConfig} "resp"
+// @Router      /v1/observability/environment/{environment_id}/tracelogconfig [get]
+// @Security    JWT
+func (h *ObservabilityHandler) GetTraceLogConfig(c *gin.Context) {
+	env, err := h.getEnvironmentWithCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	cfg, err := h.getTraceLogConfig(c.Request.Context(), env.ID)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, cfg)
+}
+
+// SetTraceLogConfig 设置环境的链路日志关联配置
+// @Tags        Observability
+// @Summary     设置环境的链路日志关联配置
+// @Description 设置环境的链路日志关联配置
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                              true "环境ID"
+// @Param       form           body     models.TraceLogConfig                               true "配置"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.TraceLogConfig} "resp"
+// @Router      /v1/observability/environment/{environment_id}/tracelogconfig [put]
+// @Security    JWT
+func (h *ObservabilityHandler) SetTraceLogConfig(c *gin.Context) {
+	req := &models.TraceLogConfig{}
+	if err := c.BindJSON(req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	env, err := h.getEnvironmentWithCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	action := i18n.Sprintf(context.TODO(), "update")
+	module := i18n.Sprintf(context.TODO(), "trace log config")
+	h.SetAuditData(c, action, module, env.EnvironmentName)
+	h.SetExtraAuditData(c, models.ResEnvironment, env.ID)
+
+	cfg := &models.TraceLogConfig{}
+	if err := h.GetDB().WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.FirstOrInit(cfg, "environment_id = ?", env.ID).Error; err != nil {
+			return err
+		}
+		cfg.EnvironmentID = env.ID
+		cfg.TraceIDFields = req.TraceIDFields
+		cfg.ServiceLabel = req.ServiceLabel
+		return tx.Save(cfg).Error
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, cfg)
+}
+
+type TraceLogs struct {
+	TraceID  string                 `json:"traceID"`
+	Services []string               `json:"services"`
+	Logql    string                 `json:"logql"`
+	Start    time.Time              `json:"start"`
+	End      time.Time              `json:"end"`
+	Logs     loki.QueryResponseData `json:"logs"`
+}
+
+// GetTraceLogs 查询链路关联的日志
+// @Tags        Observability
+// @Summary     查询链路关联的日志
+// @Description 查询环境中链路涉及的服务打印的包含该 trace id 的日志, 时间范围为链路的起止时间
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                         true  "环境ID"
+// @Param       trace_id       path     string                                         true  "trace id"
+// @Param       limit          query    int                                            false "日志条数, 默认500"
+// @Success     200            {object} handlers.ResponseStruct{Data=TraceLogs} "resp"
+// @Router      /v1/observability/environment/{environment_id}/traces/{trace_id}/logs [get]
+// @Security    JWT
+func (h *ObservabilityHandler) GetTraceLogs(c *gin.Context) {
+	traceID := c.Param("trace_id")
+	if !observe.IsValidTraceID(traceID) {
+		handlers.NotOK(c, fmt.Errorf("trace id %s not valid", traceID))
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "500"))
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	env, err := h.getEnvironmentWithCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	cfg, err := h.getTraceLogConfig(c.Request.Context(), env.ID)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	ret := TraceLogs{TraceID: traceID}
+	if err := h.Execute(c.Request.Context(), env.Cluster.ClusterName, func(ctx context.Context, cli agents.Client) error {
+		trace, err := observe.NewClient(cli, h.GetDB().WithContext(ctx)).GetTrace(ctx, traceID)
+		if err != nil {
+			return err
+		}
+		ret.Services = observe.TraceServices(trace)
+		ret.Start, ret.End = observe.TraceTimeRange(trace)
+		ret.Logql = observe.TraceLogsLogql(env.Namespace, cfg.ServiceLabel, ret.Services, traceID, cfg.TraceIDFields)
+		ret.Logs, err = cli.Extend().LokiQueryLogs(ctx, ret.Logql,
+			ret.Start.UTC().Format(time.RFC3339), ret.End.UTC().Format(time.RFC3339), limit)
+		return err
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+type LogTraceForm struct {
+	Line string `json:"line" binding:"required"`
+}
+
+type LogTrace struct {
+	TraceID string         `json:"traceID"`
+	Trace   *observe.Trace `json:"trace"`
+}
+
+// GetLogTrace 查询日志关联的链路
+// @Tags        Observability
+// @Summary     查询日志关联的链路
+// @Description 按环境配置的字段从日志中提取 trace id 并查询链路
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                 true "环境ID"
+// @Param       form           body     LogTraceForm                           true "日志"
+// @Success     200            {object} handlers.ResponseStruct{Data=LogTrace} "resp"
+// @Router      /v1/observability/environment/{environment_id}/logs/trace [post]
+// @Security    JWT
+func (h *ObservabilityHandler) GetLogTrace(c *gin.Context) {
+	req := &LogTraceForm{}
+	if err := c.BindJSON(req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	env, err := h.getEnvironmentWithCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	cfg, err := h.getTraceLogConfig(c.Request.Context(), env.ID)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	ret := LogTrace{TraceID: observe.ExtractTraceID(req.Line, cfg.TraceIDFields)}
+	if ret.TraceID == "" {
+		handlers.NotOK(c, fmt.Errorf("no trace id found in log by fields %v", cfg.TraceIDFields))
+		return
+	}
+	if err := h.Execute(c.Request.Context(), env.Cluster.ClusterName, func(ctx context.Context, cli agents.Client) error {
+		ret.Trace, err = observe.NewClient(cli, h.GetDB().WithContext(ctx)).GetTrace(ctx, ret.TraceID)
+		return err
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+func (h *ObservabilityHandler) getTraceLogConfig(ctx context.Context, envid uint) (*models.TraceLogConfig, error) {
+	cfg := &models.TraceLogConfig{}
+	if err := h.GetDB().WithContext(ctx).First(cfg, "environment_id = ?", envid).Error; err != nil {
+		if models.IsNotFound(err) {
+			return models.DefaultTraceLogConfig(envid), nil
+		}
+		return nil, err
+	}
+	return cfg, nil
+}
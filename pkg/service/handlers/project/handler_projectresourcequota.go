@@ -0,0 +1,184 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package projecthandler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	gemsv1beta1 "kubegems.io/kubegems/pkg/apis/gems/v1beta1"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"kubegems.io/kubegems/pkg/utils/resourcequota"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// ProjectResourceQuotaName 项目在集群中的ProjectResourceQuota名称,
+// 使用项目ID命名,避免 租户名-项目名 拼接后不同项目之间重名
+func ProjectResourceQuotaName(projectID uint) string {
+	return fmt.Sprintf("project-%d", projectID)
+}
+
+// GetProjectClusterResourceQuota 获取项目在集群中的资源限制(ProjectResourceQuota)
+// @Tags        Project
+// @Summary     获取项目在集群中的资源限制(ProjectResourceQuota)
+// @Description 获取项目在集群中的资源限制,包含已经划分给各环境的资源;项目未设置资源限制时返回null
+// @Accept      json
+// @Produce     json
+// @Param       project_id path     uint                                                                true "project_id"
+// @Param       cluster_id path     uint                                                                true "cluster_id"
+// @Success     200        {object} handlers.ResponseStruct{Data=gemsv1beta1.ProjectResourceQuota} "ProjectResourceQuota"
+// @Router      /v1/project/{project_id}/cluster/{cluster_id}/projectresourcequota [get]
+// @Security    JWT
+func (h *ProjectHandler) GetProjectClusterResourceQuota(c *gin.Context) {
+	proj, cluster, err := h.getProjectAndCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+	var ret *gemsv1beta1.ProjectResourceQuota
+	err = h.Execute(ctx, cluster.ClusterName, func(ctx context.Context, cli agents.Client) error {
+		prq := &gemsv1beta1.ProjectResourceQuota{}
+		if err := cli.Get(ctx, client.ObjectKey{Name: ProjectResourceQuotaName(proj.ID)}, prq); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		ret = prq
+		return nil
+	})
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+// PutProjectClusterResourceQuota 设置项目在集群中的资源限制(ProjectResourceQuota)
+// @Tags        Tenant
+// @Summary     设置项目在集群中的资源限制(ProjectResourceQuota)
+// @Description 从租户在集群中的资源中为项目划分资源,项目下的环境资源总和不能超过该限制
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     uint                                                                true "tenant_id"
+// @Param       project_id path     uint                                                                true "project_id"
+// @Param       cluster_id path     uint                                                                true "cluster_id"
+// @Param       param      body     v1.ResourceList                                                     true "hard"
+// @Success     200        {object} handlers.ResponseStruct{Data=gemsv1beta1.ProjectResourceQuota} "ProjectResourceQuota"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/cluster/{cluster_id}/projectresourcequota [put]
+// @Security    JWT
+func (h *ProjectHandler) PutProjectClusterResourceQuota(c *gin.Context) {
+	hard := v1.ResourceList{}
+	if err := c.BindJSON(&hard); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	resourcequota.SetSameRequestWithLimit(hard)
+
+	proj, cluster, err := h.getProjectAndCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	action := i18n.Sprintf(context.TODO(), "update")
+	module := i18n.Sprintf(context.TODO(), "project resource quota")
+	h.SetAuditData(c, action, module, i18n.Sprintf(c, "project %s / cluster %s", proj.ProjectName, cluster.ClusterName))
+	h.SetExtraAuditData(c, models.ResProject, proj.ID)
+
+	prq := &gemsv1beta1.ProjectResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: ProjectResourceQuotaName(proj.ID)},
+	}
+	err = h.Execute(c.Request.Context(), cluster.ClusterName, func(ctx context.Context, cli agents.Client) error {
+		_, err := controllerutil.CreateOrUpdate(ctx, cli, prq, func() error {
+			prq.Spec = gemsv1beta1.ProjectResourceQuotaSpec{
+				Tenant:  proj.Tenant.TenantName,
+				Project: proj.ProjectName,
+				Hard:    hard,
+			}
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, prq)
+}
+
+// DeleteProjectClusterResourceQuota 删除项目在集群中的资源限制(ProjectResourceQuota)
+// @Tags        Tenant
+// @Summary     删除项目在集群中的资源限制(ProjectResourceQuota)
+// @Description 删除后项目下的环境仅受租户资源限制
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id  path     uint                                  true "tenant_id"
+// @Param       project_id path     uint                                  true "project_id"
+// @Param       cluster_id path     uint                                  true "cluster_id"
+// @Success     200        {object} handlers.ResponseStruct{Data=string} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/cluster/{cluster_id}/projectresourcequota [delete]
+// @Security    JWT
+func (h *ProjectHandler) DeleteProjectClusterResourceQuota(c *gin.Context) {
+	proj, cluster, err := h.getProjectAndCluster(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	action := i18n.Sprintf(context.TODO(), "delete")
+	module := i18n.Sprintf(context.TODO(), "project resource quota")
+	h.SetAuditData(c, action, module, i18n.Sprintf(c, "project %s / cluster %s", proj.ProjectName, cluster.ClusterName))
+	h.SetExtraAuditData(c, models.ResProject, proj.ID)
+
+	err = h.Execute(c.Request.Context(), cluster.ClusterName, func(ctx context.Context, cli agents.Client) error {
+		prq := &gemsv1beta1.ProjectResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: ProjectResourceQuotaName(proj.ID)},
+		}
+		if err := cli.Delete(ctx, prq); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, "ok")
+}
+
+// getProjectAndCluster 获取项目和集群,项目须属于路径中的租户,且租户在该集群中有资源
+func (h *ProjectHandler) getProjectAndCluster(c *gin.Context) (*models.Project, *models.Cluster, error) {
+	ctx := c.Request.Context()
+	proj := &models.Project{}
+	if err := h.GetDB().WithContext(ctx).Preload("Tenant").First(proj, "id = ?", c.Param("project_id")).Error; err != nil {
+		return nil, nil, err
+	}
+	if tenantID := c.Param("tenant_id"); tenantID != "" && tenantID != fmt.Sprint(proj.TenantID) {
+		return nil, nil, i18n.Errorf(c, "project %s doesn't belong to the tenant", proj.ProjectName)
+	}
+	trq := &models.TenantResourceQuota{}
+	if err := h.GetDB().WithContext(ctx).Preload("Cluster").
+		First(trq, "tenant_id = ? and cluster_id = ?", proj.TenantID, c.Param("cluster_id")).Error; err != nil {
+		return nil, nil, i18n.Errorf(c, "the tenant of project %s has no resource in this cluster", proj.ProjectName)
+	}
+	return proj, trq.Cluster, nil
+}
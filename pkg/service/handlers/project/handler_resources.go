@@ -37,6 +37,7 @@ package projecthandler
 
 import (
 	"context"
+	"encoding/json"
 	"strconv"
 	"sync"
 	"time"
@@ -659,7 +660,7 @@ func (h *ProjectHandler) getProjectAggretateQuota(ctx context.Context, projectId
 // PostProjectEnvironment 创建一个属于 Project 的Environment
 // @Tags        Project
 // @Summary     创建一个属于 Project 的Environment
-// @Description 创建一个属于 Project 的Environment
+// @Description 创建一个属于 Project 的Environment, 未指定集群(clusterID为0)时根据集群放置评估自动选择评分最高的集群
 // @Accept      json
 // @Produce     json
 // @Param       project_id path     uint                                             true "project_id"
@@ -680,6 +681,14 @@ func (h *ProjectHandler) PostProjectEnvironment(c *gin.Context) {
 		return
 	}
 	user, _ := h.GetContextUser(c)
+	if env.ClusterID == 0 {
+		clusterID, err := h.autoPlaceEnvironment(ctx, &obj, &env)
+		if err != nil {
+			handlers.NotOK(c, err)
+			return
+		}
+		env.ClusterID = clusterID
+	}
 	var cluster models.Cluster
 	if err := h.GetDB().WithContext(ctx).First(&cluster, env.ClusterID).Error; err != nil {
 		handlers.NotOK(c, err)
@@ -720,6 +729,25 @@ func (h *ProjectHandler) PostProjectEnvironment(c *gin.Context) {
 	handlers.OK(c, env)
 }
 
+// autoPlaceEnvironment 为未指定集群的环境选择评分最高的集群
+func (h *ProjectHandler) autoPlaceEnvironment(ctx context.Context, proj *models.Project, env *models.Environment) (uint, error) {
+	req := &environment.PlacementRequest{}
+	if env.ResourceQuota != nil {
+		if err := json.Unmarshal(env.ResourceQuota, &req.ResourceQuota); err != nil {
+			return 0, err
+		}
+	}
+	placements, err := environment.PlaceEnvironment(ctx, h.BaseHandler, proj, req)
+	if err != nil {
+		return 0, err
+	}
+	best, err := environment.BestPlacement(ctx, placements)
+	if err != nil {
+		return 0, err
+	}
+	return best.ClusterID, nil
+}
+
 // ProjectEnvironments 获取项目下环境列表,按照集群聚合,同时获取集群的下的租户网络策略
 // @Tags        Project
 // @Summary     获取项目下环境列表,按照集群聚合,同时获取集群的下的租户网络策略
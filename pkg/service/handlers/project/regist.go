@@ -60,4 +60,10 @@ func (h *ProjectHandler) RegistRouter(rg *gin.RouterGroup) {
 	rg.GET("/project/:project_id/environment/:environment_id/quotas", h.CheckByProjectID, h.GetEnvironmentResourceQuotas)
 
 	rg.GET("/tenant/:tenant_id/projectquotas", h.CheckByTenantID, h.TenantProjectListResourceQuotas)
+
+	rg.GET("/project/:project_id/cluster/:cluster_id/projectresourcequota", h.CheckByProjectID, h.GetProjectClusterResourceQuota)
+	rg.PUT("/tenant/:tenant_id/project/:project_id/cluster/:cluster_id/projectresourcequota",
+		h.CheckByTenantID, h.PutProjectClusterResourceQuota)
+	rg.DELETE("/tenant/:tenant_id/project/:project_id/cluster/:cluster_id/projectresourcequota",
+		h.CheckByTenantID, h.DeleteProjectClusterResourceQuota)
 }
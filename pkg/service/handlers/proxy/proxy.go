@@ -69,6 +69,11 @@ func (h *ProxyHandler) ProxyHTTP(c *gin.Context) {
 		if c.IsAborted() {
 			return
 		}
+		// 冻结窗口内禁止修改资源
+		if err := h.checkFreeze(c, cluster, proxyobj.Namespace); err != nil {
+			handlers.Forbidden(c, err)
+			return
+		}
 	}
 	v, err := h.GetAgents().ClientOf(c.Request.Context(), cluster)
 	if err != nil {
@@ -78,6 +83,18 @@ func (h *ProxyHandler) ProxyHTTP(c *gin.Context) {
 	h.ReverseProxyOn(v).ServeHTTP(c.Writer, c.Request)
 }
 
+func (h *ProxyHandler) checkFreeze(c *gin.Context, cluster, namespace string) error {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	}
+	env := h.ModelCache().FindEnvironment(cluster, namespace)
+	if env == nil {
+		return nil
+	}
+	return h.CheckEnvironmentFreeze(c, env.GetID())
+}
+
 func (h *ProxyHandler) ReverseProxyOn(cli agents.Client) *httputil.ReverseProxy {
 	return &httputil.ReverseProxy{
 		Director: func(req *http.Request) {
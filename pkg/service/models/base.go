@@ -210,6 +210,34 @@ func MigrateModels(db *gorm.DB) error {
 		&PromqlTplScope{}, &PromqlTplResource{}, &PromqlTplRule{},
 		// 公告
 		&Announcement{},
+		// 审批策略和审批请求
+		&ApprovalPolicy{}, &ApprovalRequest{}, &ApprovalRecord{},
+		// 应用环境提升
+		&PromotionPipeline{}, &ApplicationPromotion{},
+		// 应用部署依赖
+		&ApplicationDependencyGraph{},
+		// 预览环境
+		&PreviewEnvironment{}, &PreviewWebhook{},
+		// 镜像自动更新
+		&ImageUpdatePolicy{}, &ImageUpdateHistory{},
+		// 部署冻结窗口
+		&FreezeWindow{},
+		// 应用备份恢复
+		&BackupPlan{}, &ApplicationBackup{}, &ApplicationRestore{},
+		// 服务 SLO
+		&ServiceLevelObjective{},
+		// 预聚合规则
+		&RecordingRule{},
+		// 日志指标
+		&LogMetric{},
+		// 链路日志关联
+		&TraceLogConfig{},
+		// 定时报告
+		&ScheduledReport{},
+		// 费用
+		&CostPrice{}, &CostRecord{},
+		// 异常检测
+		&AnomalyDetector{},
 	)
 }
 
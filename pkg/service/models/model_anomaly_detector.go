@@ -0,0 +1,159 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	prommodel "github.com/prometheus/common/model"
+	"gorm.io/datatypes"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+)
+
+const (
+	// 滚动 z-score: 以前一个窗口内的均值和标准差作为基线
+	AnomalyAlgorithmZScore = "zscore"
+	// 季节性: 以之前各个周期同一时刻的均值和标准差作为基线
+	AnomalyAlgorithmSeasonal = "seasonal"
+
+	AnomalyDirectionBoth = "both"
+	AnomalyDirectionUp   = "up"
+	AnomalyDirectionDown = "down"
+
+	DefaultAnomalySensitivity = 3
+)
+
+// AnomalyDetector 环境中 promql 模板指标的异常检测, 在 worker 中学习基线并在超出预期范围时告警
+type AnomalyDetector struct {
+	ID            uint         `gorm:"primarykey" json:"id"`
+	EnvironmentID uint         `gorm:"uniqueIndex:uniq_idx_env_anomaly" json:"environmentID"`
+	Environment   *Environment `gorm:"constraint:OnUpdate:RESTRICT,OnDelete:CASCADE;" json:"environment,omitempty"`
+	// 同时作为告警名称的一部分
+	Name            string           `gorm:"type:varchar(30);uniqueIndex:uniq_idx_env_anomaly" binding:"required" json:"name"`
+	Description     string           `json:"description"`
+	PromqlGenerator *PromqlGenerator `json:"promqlGenerator"`
+	// zscore 或者 seasonal
+	Algorithm string `gorm:"type:varchar(20)" json:"algorithm"`
+	// 学习基线使用的历史窗口, zscore 如 1h, seasonal 如 7d
+	Window string `gorm:"type:varchar(20)" json:"window"`
+	// 季节周期, 只用于 seasonal, 如 1d
+	Season string `gorm:"type:varchar(20)" json:"season"`
+	// 采样间隔, 同时也是检测间隔的下限, 如 5m
+	Step string `gorm:"type:varchar(20)" json:"step"`
+	// 偏离预期值超过多少倍标准差时认为异常
+	Sensitivity float64 `json:"sensitivity"`
+	// both, up, down
+	Direction string `gorm:"type:varchar(10)" json:"direction"`
+	// error 或者 critical
+	Severity string `gorm:"type:varchar(10)" json:"severity"`
+	Enabled  bool   `json:"enabled"`
+
+	// 正在告警的序列 map[fingerprint]labels, 用于发送恢复消息
+	FiringSeries datatypes.JSON `json:"firingSeries"`
+	LastCheckAt  *time.Time     `json:"lastCheckAt"`
+	LastError    string         `json:"lastError"`
+
+	Creator   string     `gorm:"type:varchar(50)" json:"creator"`
+	CreatedAt *time.Time `json:"createdAt"`
+	UpdatedAt *time.Time `json:"updatedAt"`
+}
+
+func (a *AnomalyDetector) Validate() error {
+	if errs := validation.IsDNS1035Label(a.Name); len(errs) > 0 || len(a.Name) > 30 {
+		return fmt.Errorf("anomaly detector name %s not valid, must be a DNS-1035 label no more than 30 characters", a.Name)
+	}
+	if a.PromqlGenerator == nil || a.PromqlGenerator.Resource == "" {
+		return fmt.Errorf("promqlGenerator is required")
+	}
+	if a.Sensitivity == 0 {
+		a.Sensitivity = DefaultAnomalySensitivity
+	}
+	if a.Sensitivity < 0 {
+		return fmt.Errorf("sensitivity can't be negative")
+	}
+	if a.Direction == "" {
+		a.Direction = AnomalyDirectionBoth
+	}
+	switch a.Direction {
+	case AnomalyDirectionBoth, AnomalyDirectionUp, AnomalyDirectionDown:
+	default:
+		return fmt.Errorf("unknown direction %s", a.Direction)
+	}
+	if a.Severity == "" {
+		a.Severity = prometheus.SeverityError
+	}
+	if a.Severity != prometheus.SeverityError && a.Severity != prometheus.SeverityCritical {
+		return fmt.Errorf("severity must be error or critical")
+	}
+	window, step, season, err := a.Durations()
+	if err != nil {
+		return err
+	}
+	if step < time.Minute {
+		return fmt.Errorf("step must be at least 1m")
+	}
+	if window < 10*step {
+		return fmt.Errorf("window must contain at least 10 steps")
+	}
+	switch a.Algorithm {
+	case AnomalyAlgorithmZScore:
+	case AnomalyAlgorithmSeasonal:
+		if season < step || season%step != 0 {
+			return fmt.Errorf("season must be a multiple of step")
+		}
+		if window < 2*season {
+			return fmt.Errorf("window must contain at least 2 seasons")
+		}
+	default:
+		return fmt.Errorf("unknown algorithm %s", a.Algorithm)
+	}
+	return nil
+}
+
+// Durations 返回历史窗口, 采样间隔以及季节周期
+func (a *AnomalyDetector) Durations() (window, step, season time.Duration, err error) {
+	parse := func(name, s string) (time.Duration, error) {
+		if s == "" {
+			return 0, nil
+		}
+		d, err := prommodel.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %s: %w", name, s, err)
+		}
+		return time.Duration(d), nil
+	}
+	if window, err = parse("window", a.Window); err != nil {
+		return
+	}
+	if step, err = parse("step", a.Step); err != nil {
+		return
+	}
+	season, err = parse("season", a.Season)
+	return
+}
+
+func (a *AnomalyDetector) Firing() (map[string]map[string]string, error) {
+	ret := map[string]map[string]string{}
+	if len(a.FiringSeries) == 0 {
+		return ret, nil
+	}
+	if err := json.Unmarshal(a.FiringSeries, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
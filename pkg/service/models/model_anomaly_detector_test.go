@@ -0,0 +1,60 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import "testing"
+
+func TestAnomalyDetectorValidate(t *testing.T) {
+	valid := func() *AnomalyDetector {
+		return &AnomalyDetector{
+			Name:            "cpu",
+			PromqlGenerator: &PromqlGenerator{Scope: "containers", Resource: "container", Rule: "cpuUsage"},
+			Algorithm:       AnomalyAlgorithmSeasonal,
+			Window:          "7d",
+			Season:          "1d",
+			Step:            "5m",
+		}
+	}
+	d := valid()
+	if err := d.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if d.Sensitivity != DefaultAnomalySensitivity || d.Direction != AnomalyDirectionBoth || d.Severity == "" {
+		t.Errorf("defaults not set: %+v", d)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(d *AnomalyDetector)
+	}{
+		{name: "invalid name", mutate: func(d *AnomalyDetector) { d.Name = "CPU_usage" }},
+		{name: "no template", mutate: func(d *AnomalyDetector) { d.PromqlGenerator = nil }},
+		{name: "unknown algorithm", mutate: func(d *AnomalyDetector) { d.Algorithm = "arima" }},
+		{name: "step too small", mutate: func(d *AnomalyDetector) { d.Step = "30s" }},
+		{name: "season not multiple of step", mutate: func(d *AnomalyDetector) { d.Season = "7m" }},
+		{name: "window less than 2 seasons", mutate: func(d *AnomalyDetector) { d.Window = "1d" }},
+		{name: "window too short", mutate: func(d *AnomalyDetector) { d.Algorithm = AnomalyAlgorithmZScore; d.Window = "30m" }},
+		{name: "invalid direction", mutate: func(d *AnomalyDetector) { d.Direction = "left" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := valid()
+			tt.mutate(d)
+			if err := d.Validate(); err == nil {
+				t.Errorf("expect error")
+			}
+		})
+	}
+}
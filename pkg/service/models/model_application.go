@@ -35,3 +35,49 @@ type Application struct {
 	Creator         string         // 创建人
 	CreatedAt       time.Time      `sql:"DEFAULT:'current_timestamp'"` // 创建时间
 }
+
+const (
+	PromotionStatusSuccess = "success"
+	PromotionStatusFailed  = "failed"
+)
+
+// PromotionPipeline 项目下应用在环境间提升的顺序
+type PromotionPipeline struct {
+	ID        uint     `gorm:"primarykey"`
+	ProjectID uint     `gorm:"uniqueIndex"`
+	Project   *Project `json:",omitempty" gorm:"constraint:OnUpdate:RESTRICT,OnDelete:CASCADE;"`
+	// 按提升顺序排列的环境名称,例如 ["dev","staging","prod"]
+	Stages datatypes.JSON
+	// 提升前要求源环境中的应用健康且已同步
+	RequireHealthy bool
+	UpdatedAt      time.Time
+}
+
+// ApplicationPromotion 应用编排版本在环境间的提升记录
+type ApplicationPromotion struct {
+	ID              uint   `gorm:"primarykey"`
+	ProjectID       uint   `gorm:"index"`
+	ApplicationName string `gorm:"type:varchar(50);index"`
+	FromEnvironment string `gorm:"type:varchar(50)"`
+	ToEnvironment   string `gorm:"type:varchar(50)"`
+	// 源环境中编排的 git commit
+	Revision string `gorm:"type:varchar(64)"`
+	// 提升时编排中的镜像
+	Images    datatypes.JSON
+	Creator   string
+	Status    string `gorm:"type:varchar(30)"`
+	Message   string
+	CreatedAt time.Time
+}
+
+// ApplicationDependencyGraph 项目下应用之间的部署依赖,批量部署和环境克隆时按照依赖分批次部署
+type ApplicationDependencyGraph struct {
+	ID        uint     `gorm:"primarykey"`
+	ProjectID uint     `gorm:"uniqueIndex"`
+	Project   *Project `json:",omitempty" gorm:"constraint:OnUpdate:RESTRICT,OnDelete:CASCADE;"`
+	// 应用及其依赖的应用,例如 {"api":["mysql"],"web":["api"]}
+	Dependencies datatypes.JSON
+	// 每个批次等待应用健康的超时时间,单位秒,为 0 时使用默认值
+	HealthTimeout int
+	UpdatedAt     time.Time
+}
@@ -0,0 +1,150 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// 需要审批的操作
+const (
+	ApprovalActionSync               = "application-sync"
+	ApprovalActionUpdateImage        = "update-image"
+	ApprovalActionPromote            = "strategy-promote"
+	ApprovalActionDeleteEnvironment  = "delete-environment"
+	ApprovalActionPromoteEnvironment = "environment-promote"
+)
+
+// 审批人角色
+const (
+	ApproverProjectAdmin        = "project-admin"
+	ApproverEnvironmentOperator = "environment-operator"
+	ApproverTenantAdmin         = "tenant-admin"
+)
+
+const (
+	ApprovalStatusPending  = "pending"
+	ApprovalStatusApproved = "approved"
+	ApprovalStatusRejected = "rejected"
+	ApprovalStatusExpired  = "expired"
+	ApprovalStatusFailed   = "failed"
+
+	DefaultApprovalExpireHours = 24
+)
+
+// ApprovalPolicy 环境下敏感操作的审批策略, 同一环境同一操作只有一个策略
+type ApprovalPolicy struct {
+	ID            uint         `gorm:"primarykey"`
+	EnvironmentID uint         `gorm:"uniqueIndex:uniq_idx_env_action"`
+	Environment   *Environment `json:",omitempty" gorm:"constraint:OnUpdate:RESTRICT,OnDelete:CASCADE;"`
+	// 操作(application-sync,update-image,strategy-promote,delete-environment,environment-promote)
+	Action string `gorm:"type:varchar(50);uniqueIndex:uniq_idx_env_action" binding:"required,oneof=application-sync update-image strategy-promote delete-environment environment-promote"`
+	// 审批人角色(project-admin,environment-operator,tenant-admin)
+	Approvers string `gorm:"type:varchar(50)" binding:"required,oneof=project-admin environment-operator tenant-admin"`
+	// 需要多少人批准
+	RequiredApprovals int `binding:"gte=1"`
+	// 审批过期时间(小时),为0时使用默认值
+	ExpireHours int `binding:"gte=0"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// ApprovalRequest 待审批的操作,审批通过后提交 Task 中的异步任务
+type ApprovalRequest struct {
+	ID            uint         `gorm:"primarykey"`
+	EnvironmentID uint         `gorm:"index"`
+	Environment   *Environment `json:",omitempty" gorm:"constraint:OnUpdate:RESTRICT,OnDelete:CASCADE;"`
+	Action        string       `gorm:"type:varchar(50)"`
+	// 操作对象,例如应用名称
+	Target string
+	// 创建时的审批策略
+	Approvers         string `gorm:"type:varchar(50)"`
+	RequiredApprovals int
+	// 审批通过后提交的异步任务
+	Task     datatypes.JSON `json:"-"`
+	Username string         `gorm:"type:varchar(255)"`
+	Status   string         `gorm:"type:varchar(30);index"`
+	Message  string
+	// 审批记录
+	Approvals []*ApprovalRecord `gorm:"constraint:OnUpdate:RESTRICT,OnDelete:CASCADE;"`
+	ExpiredAt time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// ApprovalRecord 单个审批人的审批记录
+type ApprovalRecord struct {
+	ID                uint   `gorm:"primarykey"`
+	ApprovalRequestID uint   `gorm:"uniqueIndex:uniq_idx_request_user"`
+	Username          string `gorm:"type:varchar(255);uniqueIndex:uniq_idx_request_user"`
+	Passed            bool
+	Comment           string
+	CreatedAt         time.Time
+}
+
+// FindApprovalPolicy 返回环境下操作的审批策略, 没有配置时返回空
+func FindApprovalPolicy(db *gorm.DB, envid uint, action string) (*ApprovalPolicy, error) {
+	policy := &ApprovalPolicy{}
+	if err := db.First(policy, "environment_id = ? and action = ?", envid, action).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return policy, nil
+}
+
+// ExpireDuration 返回策略的审批过期时间
+func (p *ApprovalPolicy) ExpireDuration() time.Duration {
+	if p.ExpireHours <= 0 {
+		return DefaultApprovalExpireHours * time.Hour
+	}
+	return time.Duration(p.ExpireHours) * time.Hour
+}
+
+// Decide 根据审批记录计算请求的状态, 任一审批人拒绝即为拒绝, 批准人数足够即为通过
+func (r *ApprovalRequest) Decide(now time.Time) string {
+	if r.Status != ApprovalStatusPending {
+		return r.Status
+	}
+	passed := 0
+	for _, record := range r.Approvals {
+		if !record.Passed {
+			return ApprovalStatusRejected
+		}
+		passed++
+	}
+	if passed >= r.RequiredApprovals {
+		return ApprovalStatusApproved
+	}
+	if !r.ExpiredAt.IsZero() && now.After(r.ExpiredAt) {
+		return ApprovalStatusExpired
+	}
+	return ApprovalStatusPending
+}
+
+// ApprovedBy 用户是否已经审批过该请求
+func (r *ApprovalRequest) ApprovedBy(username string) bool {
+	for _, record := range r.Approvals {
+		if record.Username == username {
+			return true
+		}
+	}
+	return false
+}
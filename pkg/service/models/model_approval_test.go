@@ -0,0 +1,76 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApprovalRequest_Decide(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		request ApprovalRequest
+		want    string
+	}{
+		{
+			name:    "no approvals",
+			request: ApprovalRequest{Status: ApprovalStatusPending, RequiredApprovals: 1, ExpiredAt: now.Add(time.Hour)},
+			want:    ApprovalStatusPending,
+		},
+		{
+			name: "not enough approvals",
+			request: ApprovalRequest{
+				Status: ApprovalStatusPending, RequiredApprovals: 2, ExpiredAt: now.Add(time.Hour),
+				Approvals: []*ApprovalRecord{{Username: "a", Passed: true}},
+			},
+			want: ApprovalStatusPending,
+		},
+		{
+			name: "approved",
+			request: ApprovalRequest{
+				Status: ApprovalStatusPending, RequiredApprovals: 2, ExpiredAt: now.Add(time.Hour),
+				Approvals: []*ApprovalRecord{{Username: "a", Passed: true}, {Username: "b", Passed: true}},
+			},
+			want: ApprovalStatusApproved,
+		},
+		{
+			name: "rejected by any approver",
+			request: ApprovalRequest{
+				Status: ApprovalStatusPending, RequiredApprovals: 2, ExpiredAt: now.Add(time.Hour),
+				Approvals: []*ApprovalRecord{{Username: "a", Passed: true}, {Username: "b", Passed: false}},
+			},
+			want: ApprovalStatusRejected,
+		},
+		{
+			name:    "expired",
+			request: ApprovalRequest{Status: ApprovalStatusPending, RequiredApprovals: 1, ExpiredAt: now.Add(-time.Minute)},
+			want:    ApprovalStatusExpired,
+		},
+		{
+			name:    "finished status kept",
+			request: ApprovalRequest{Status: ApprovalStatusFailed, RequiredApprovals: 1, ExpiredAt: now.Add(-time.Minute)},
+			want:    ApprovalStatusFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.request.Decide(now); got != tt.want {
+				t.Errorf("ApprovalRequest.Decide() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
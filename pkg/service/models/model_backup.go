@@ -0,0 +1,170 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"gorm.io/datatypes"
+)
+
+const (
+	BackupStatusRunning   = "running"
+	BackupStatusCompleted = "completed"
+	BackupStatusFailed    = "failed"
+)
+
+const (
+	// 在创建卷快照之前执行,例如冻结文件系统或者刷新数据库缓冲
+	BackupHookPre = "pre"
+	// 在卷快照创建之后执行,快照失败时也会执行
+	BackupHookPost = "post"
+)
+
+// BackupPlan 应用在环境中的备份计划,备份内容包括编排的 git 版本以及应用使用的 PVC 的卷快照
+type BackupPlan struct {
+	ID            uint         `gorm:"primarykey"`
+	EnvironmentID uint         `gorm:"uniqueIndex:uniq_idx_env_app_backupplan"`
+	Environment   *Environment `json:",omitempty" gorm:"constraint:OnUpdate:RESTRICT,OnDelete:CASCADE;"`
+	// 应用名称
+	ApplicationName string `gorm:"type:varchar(50);uniqueIndex:uniq_idx_env_app_backupplan"`
+	Name            string `gorm:"type:varchar(50);uniqueIndex:uniq_idx_env_app_backupplan"`
+	// 标准的5段 cron 表达式,为空时仅手动备份
+	Schedule string `gorm:"type:varchar(100)"`
+	// 保留最近的备份数量,为 0 时不清理
+	Retention int
+	// 是否对应用使用的 PVC 创建卷快照
+	SnapshotVolumes bool
+	// 备份钩子 []BackupHook
+	Hooks   datatypes.JSON
+	Enabled bool
+	// 最近一次备份的结果
+	LastBackupAt *time.Time
+	LastMessage  string
+	Creator      string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// BackupHook 在应用的 pod 中执行的命令,用于保证卷快照的应用一致性
+type BackupHook struct {
+	Name string `json:"name"`
+	// pre 或者 post
+	Phase string `json:"phase"`
+	// 执行命令的 pod 的 label
+	Selector  map[string]string `json:"selector"`
+	Container string            `json:"container"`
+	Command   []string          `json:"command"`
+	// 执行失败时继续备份
+	ContinueOnError bool `json:"continueOnError"`
+}
+
+func (p *BackupPlan) Validate() error {
+	if p.Retention < 0 {
+		return fmt.Errorf("invalid retention %d", p.Retention)
+	}
+	if p.Schedule != "" {
+		if _, err := cron.ParseStandard(p.Schedule); err != nil {
+			return fmt.Errorf("invalid schedule %s: %w", p.Schedule, err)
+		}
+	}
+	hooks, err := p.BackupHooks()
+	if err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		if hook.Phase != BackupHookPre && hook.Phase != BackupHookPost {
+			return fmt.Errorf("invalid phase %s of hook %s, must be pre or post", hook.Phase, hook.Name)
+		}
+		if len(hook.Selector) == 0 {
+			return fmt.Errorf("selector is required for hook %s", hook.Name)
+		}
+		if len(hook.Command) == 0 {
+			return fmt.Errorf("command is required for hook %s", hook.Name)
+		}
+	}
+	return nil
+}
+
+// BackupHooks 解析备份钩子
+func (p *BackupPlan) BackupHooks() ([]BackupHook, error) {
+	hooks := []BackupHook{}
+	if len(p.Hooks) == 0 {
+		return hooks, nil
+	}
+	if err := json.Unmarshal(p.Hooks, &hooks); err != nil {
+		return nil, fmt.Errorf("invalid hooks: %w", err)
+	}
+	return hooks, nil
+}
+
+// ScheduledAt 返回 now 时是否需要执行定时备份
+func (p *BackupPlan) ScheduledAt(now time.Time) bool {
+	if !p.Enabled || p.Schedule == "" {
+		return false
+	}
+	schedule, err := cron.ParseStandard(p.Schedule)
+	if err != nil {
+		return false
+	}
+	last := p.CreatedAt
+	if p.LastBackupAt != nil {
+		last = *p.LastBackupAt
+	}
+	return !schedule.Next(last).After(now)
+}
+
+// ApplicationBackup 应用的一次备份
+type ApplicationBackup struct {
+	ID              uint   `gorm:"primarykey"`
+	PlanID          uint   `gorm:"index"`
+	EnvironmentID   uint   `gorm:"index"`
+	ApplicationName string `gorm:"type:varchar(50);index"`
+	// 备份名称,同时作为卷快照名称的前缀
+	Name string `gorm:"type:varchar(100)"`
+	// 备份时编排的 git commit
+	Revision string `gorm:"type:varchar(64)"`
+	// 卷快照 []BackupVolume
+	Volumes     datatypes.JSON
+	Status      string `gorm:"type:varchar(20)"`
+	Message     string
+	Creator     string
+	CreatedAt   time.Time
+	CompletedAt *time.Time
+}
+
+// BackupVolume PVC 及其卷快照, 卷快照与 PVC 在同一个命名空间中
+type BackupVolume struct {
+	PersistentVolumeClaim string `json:"persistentVolumeClaim"`
+	VolumeSnapshot        string `json:"volumeSnapshot"`
+}
+
+// ApplicationRestore 从备份恢复应用的记录
+type ApplicationRestore struct {
+	ID              uint   `gorm:"primarykey"`
+	BackupID        uint   `gorm:"index"`
+	EnvironmentID   uint   `gorm:"index"`
+	ApplicationName string `gorm:"type:varchar(50)"`
+	// 备份所在的环境
+	FromEnvironment string `gorm:"type:varchar(50)"`
+	Status          string `gorm:"type:varchar(20)"`
+	Message         string
+	Creator         string
+	CreatedAt       time.Time
+	CompletedAt     *time.Time
+}
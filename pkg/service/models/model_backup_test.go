@@ -0,0 +1,105 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackupPlanValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		plan    BackupPlan
+		wantErr bool
+	}{
+		{name: "manual", plan: BackupPlan{}},
+		{name: "scheduled", plan: BackupPlan{Schedule: "0 2 * * *", Retention: 7}},
+		{name: "invalid schedule", plan: BackupPlan{Schedule: "every day"}, wantErr: true},
+		{name: "invalid retention", plan: BackupPlan{Retention: -1}, wantErr: true},
+		{
+			name: "hooks",
+			plan: BackupPlan{Hooks: []byte(`[{"name":"flush","phase":"pre","selector":{"app":"mysql"},"command":["sync"]}]`)},
+		},
+		{
+			name:    "hook without selector",
+			plan:    BackupPlan{Hooks: []byte(`[{"name":"flush","phase":"pre","command":["sync"]}]`)},
+			wantErr: true,
+		},
+		{
+			name:    "hook with invalid phase",
+			plan:    BackupPlan{Hooks: []byte(`[{"name":"flush","phase":"before","selector":{"app":"mysql"},"command":["sync"]}]`)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.plan.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("BackupPlan.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBackupPlanScheduledAt(t *testing.T) {
+	created := time.Date(2022, 10, 1, 0, 30, 0, 0, time.UTC)
+	last := time.Date(2022, 10, 2, 2, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		plan BackupPlan
+		now  time.Time
+		want bool
+	}{
+		{
+			name: "first backup not due",
+			plan: BackupPlan{Enabled: true, Schedule: "0 2 * * *", CreatedAt: created},
+			now:  time.Date(2022, 10, 1, 1, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "first backup due",
+			plan: BackupPlan{Enabled: true, Schedule: "0 2 * * *", CreatedAt: created},
+			now:  time.Date(2022, 10, 1, 2, 0, 30, 0, time.UTC),
+			want: true,
+		},
+		{
+			name: "already backed up",
+			plan: BackupPlan{Enabled: true, Schedule: "0 2 * * *", CreatedAt: created, LastBackupAt: &last},
+			now:  time.Date(2022, 10, 2, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "next day",
+			plan: BackupPlan{Enabled: true, Schedule: "0 2 * * *", CreatedAt: created, LastBackupAt: &last},
+			now:  time.Date(2022, 10, 3, 2, 1, 0, 0, time.UTC),
+			want: true,
+		},
+		{
+			name: "disabled",
+			plan: BackupPlan{Schedule: "0 2 * * *", CreatedAt: created},
+			now:  time.Date(2022, 10, 3, 2, 1, 0, 0, time.UTC),
+		},
+		{
+			name: "manual only",
+			plan: BackupPlan{Enabled: true, CreatedAt: created},
+			now:  time.Date(2022, 10, 3, 2, 1, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.plan.ScheduledAt(tt.now); got != tt.want {
+				t.Errorf("BackupPlan.ScheduledAt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
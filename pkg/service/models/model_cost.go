@@ -0,0 +1,154 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/datatypes"
+	"k8s.io/apimachinery/pkg/labels"
+	"kubegems.io/kubegems/pkg/utils/gormdatatypes"
+)
+
+// CostPrice 资源价格, 按集群和节点池配置, 集群为空时作为所有集群的默认价格
+type CostPrice struct {
+	ID          uint   `gorm:"primarykey" json:"id"`
+	Name        string `gorm:"type:varchar(50)" binding:"required" json:"name"`
+	ClusterName string `gorm:"type:varchar(50)" json:"clusterName"`
+	// 节点池的节点标签, 为空时作为集群的默认价格
+	NodeSelector  gormdatatypes.JSONMap `json:"nodeSelector"`
+	CPUCoreHour   float64               `json:"cpuCoreHour"`
+	MemoryGiBHour float64               `json:"memoryGiBHour"`
+	GPUHour       float64               `json:"gpuHour"`
+	// 每种存储类每 GiB 每小时的价格 map[string]float64, 只在集群默认价格中生效
+	StorageClassGiBHour datatypes.JSON `json:"storageClassGiBHour"`
+	UpdatedAt           *time.Time     `json:"updatedAt"`
+}
+
+func (p *CostPrice) Validate() error {
+	if p.CPUCoreHour < 0 || p.MemoryGiBHour < 0 || p.GPUHour < 0 {
+		return fmt.Errorf("price can't be negative")
+	}
+	if p.ClusterName == "" && len(p.NodeSelector) > 0 {
+		return fmt.Errorf("node selector requires cluster")
+	}
+	if _, err := labels.ValidatedSelectorFromSet(labels.Set(p.NodeSelector)); err != nil {
+		return err
+	}
+	prices, err := p.StoragePrices()
+	if err != nil {
+		return err
+	}
+	for class, price := range prices {
+		if price < 0 {
+			return fmt.Errorf("storage class %s price can't be negative", class)
+		}
+	}
+	return nil
+}
+
+func (p *CostPrice) StoragePrices() (map[string]float64, error) {
+	ret := map[string]float64{}
+	if len(p.StorageClassGiBHour) == 0 {
+		return ret, nil
+	}
+	if err := json.Unmarshal(p.StorageClassGiBHour, &ret); err != nil {
+		return nil, fmt.Errorf("invalid storage class price: %w", err)
+	}
+	return ret, nil
+}
+
+// MatchNodePrice 节点的价格, 集群的价格优先于全局价格, 节点标签匹配越多越优先
+func MatchNodePrice(prices []CostPrice, cluster string, nodeLabels map[string]string) *CostPrice {
+	var ret *CostPrice
+	score := -1
+	for i := range prices {
+		p := &prices[i]
+		if p.ClusterName != "" && p.ClusterName != cluster {
+			continue
+		}
+		if !labels.SelectorFromSet(labels.Set(p.NodeSelector)).Matches(labels.Set(nodeLabels)) {
+			continue
+		}
+		s := len(p.NodeSelector)
+		if p.ClusterName != "" {
+			s += 1000
+		}
+		if s > score {
+			ret, score = p, s
+		}
+	}
+	return ret
+}
+
+// MatchStoragePrice 存储类的价格, 集群默认价格中未配置时使用全局价格
+func MatchStoragePrice(prices []CostPrice, cluster, storageClass string) float64 {
+	var global *float64
+	for i := range prices {
+		p := &prices[i]
+		if len(p.NodeSelector) > 0 || (p.ClusterName != "" && p.ClusterName != cluster) {
+			continue
+		}
+		classPrices, err := p.StoragePrices()
+		if err != nil {
+			continue
+		}
+		price, ok := classPrices[storageClass]
+		if !ok {
+			continue
+		}
+		if p.ClusterName == cluster {
+			return price
+		}
+		global = &price
+	}
+	if global != nil {
+		return *global
+	}
+	return 0
+}
+
+// CostRecord 每天每个工作负载的资源用量和费用, 存储等无法归属到工作负载的费用记录在工作负载为空的记录中
+type CostRecord struct {
+	ID   uint      `gorm:"primarykey" json:"id"`
+	Date time.Time `gorm:"index" json:"date"` // 统计日期的 0 点
+
+	ClusterName     string `gorm:"type:varchar(50);index" json:"clusterName"`
+	Namespace       string `gorm:"type:varchar(50)" json:"namespace"`
+	TenantID        uint   `gorm:"index" json:"tenantID"`
+	TenantName      string `gorm:"type:varchar(50)" json:"tenantName"`
+	ProjectID       uint   `gorm:"index" json:"projectID"`
+	ProjectName     string `gorm:"type:varchar(50)" json:"projectName"`
+	EnvironmentID   uint   `gorm:"index" json:"environmentID"`
+	EnvironmentName string `gorm:"type:varchar(50)" json:"environmentName"`
+	WorkloadKind    string `gorm:"type:varchar(50)" json:"workloadKind"`
+	WorkloadName    string `gorm:"type:varchar(255)" json:"workloadName"`
+
+	CPURequestCoreHours   float64 `json:"cpuRequestCoreHours"`
+	CPUUsageCoreHours     float64 `json:"cpuUsageCoreHours"`
+	MemoryRequestGiBHours float64 `json:"memoryRequestGiBHours"`
+	MemoryUsageGiBHours   float64 `json:"memoryUsageGiBHours"`
+	GPUHours              float64 `json:"gpuHours"`
+	StorageGiBHours       float64 `json:"storageGiBHours"`
+
+	// 按请求量和使用量中较大的计费
+	CPUCost     float64 `json:"cpuCost"`
+	MemoryCost  float64 `json:"memoryCost"`
+	GPUCost     float64 `json:"gpuCost"`
+	StorageCost float64 `json:"storageCost"`
+	TotalCost   float64 `json:"totalCost"`
+}
@@ -0,0 +1,88 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+
+	"gorm.io/datatypes"
+	"kubegems.io/kubegems/pkg/utils/gormdatatypes"
+)
+
+func TestMatchNodePrice(t *testing.T) {
+	prices := []CostPrice{
+		{Name: "global", CPUCoreHour: 1},
+		{Name: "cluster-a", ClusterName: "a", CPUCoreHour: 2},
+		{Name: "gpu-pool", ClusterName: "a", NodeSelector: gormdatatypes.JSONMap{"pool": "gpu"}, CPUCoreHour: 3},
+		{Name: "cluster-b-gpu", ClusterName: "b", NodeSelector: gormdatatypes.JSONMap{"pool": "gpu"}, CPUCoreHour: 4},
+	}
+	tests := []struct {
+		name    string
+		cluster string
+		labels  map[string]string
+		want    string
+	}{
+		{name: "node pool", cluster: "a", labels: map[string]string{"pool": "gpu", "zone": "z1"}, want: "gpu-pool"},
+		{name: "cluster default", cluster: "a", labels: map[string]string{"pool": "cpu"}, want: "cluster-a"},
+		{name: "global default", cluster: "b", labels: map[string]string{"pool": "cpu"}, want: "global"},
+		{name: "pool without cluster default", cluster: "b", labels: map[string]string{"pool": "gpu"}, want: "cluster-b-gpu"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MatchNodePrice(prices, tt.cluster, tt.labels)
+			if got == nil || got.Name != tt.want {
+				t.Errorf("MatchNodePrice() = %v, want %s", got, tt.want)
+			}
+		})
+	}
+	if got := MatchNodePrice(prices[1:2], "b", nil); got != nil {
+		t.Errorf("MatchNodePrice() = %v, want nil", got)
+	}
+}
+
+func TestMatchStoragePrice(t *testing.T) {
+	prices := []CostPrice{
+		{Name: "global", StorageClassGiBHour: datatypes.JSON(`{"local":0.1,"ceph":0.2}`)},
+		{Name: "cluster-a", ClusterName: "a", StorageClassGiBHour: datatypes.JSON(`{"ceph":0.3}`)},
+		{Name: "gpu-pool", ClusterName: "a", NodeSelector: gormdatatypes.JSONMap{"pool": "gpu"}, StorageClassGiBHour: datatypes.JSON(`{"ceph":9}`)},
+	}
+	tests := []struct {
+		cluster string
+		class   string
+		want    float64
+	}{
+		{cluster: "a", class: "ceph", want: 0.3},
+		{cluster: "a", class: "local", want: 0.1},
+		{cluster: "b", class: "ceph", want: 0.2},
+		{cluster: "b", class: "nfs", want: 0},
+	}
+	for _, tt := range tests {
+		if got := MatchStoragePrice(prices, tt.cluster, tt.class); got != tt.want {
+			t.Errorf("MatchStoragePrice(%s, %s) = %v, want %v", tt.cluster, tt.class, got, tt.want)
+		}
+	}
+}
+
+func TestCostPriceValidate(t *testing.T) {
+	if err := (&CostPrice{Name: "a", NodeSelector: gormdatatypes.JSONMap{"pool": "gpu"}}).Validate(); err == nil {
+		t.Error("node selector without cluster should be invalid")
+	}
+	if err := (&CostPrice{Name: "a", StorageClassGiBHour: datatypes.JSON(`{"ceph":-1}`)}).Validate(); err == nil {
+		t.Error("negative storage price should be invalid")
+	}
+	if err := (&CostPrice{Name: "a", ClusterName: "a", CPUCoreHour: 1, StorageClassGiBHour: datatypes.JSON(`{"ceph":1}`)}).Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
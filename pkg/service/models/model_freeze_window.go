@@ -0,0 +1,137 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+)
+
+const (
+	FreezeScopeTenant      = "tenant"
+	FreezeScopeProject     = "project"
+	FreezeScopeEnvironment = "environment"
+)
+
+// FreezeWindow 部署冻结窗口,窗口内禁止应用同步,镜像更新,灰度控制以及通过代理修改资源.
+// 一次性窗口使用 StartAt,EndAt; 周期窗口使用 cron 表达式描述开始时间并持续 DurationMinutes 分钟
+type FreezeWindow struct {
+	ID   uint   `gorm:"primarykey"`
+	Name string `gorm:"type:varchar(50)" binding:"required"`
+	// tenant,project,environment
+	Scope   string `gorm:"type:varchar(20);index:idx_freeze_scope"`
+	ScopeID uint   `gorm:"index:idx_freeze_scope"`
+	// 冻结原因,例如节假日,故障处理中
+	Reason string
+	// 一次性窗口
+	StartAt *time.Time
+	EndAt   *time.Time
+	// 周期窗口,标准的5段 cron 表达式,例如 "0 18 * * 5" 表示每周五18点开始
+	Schedule        string `gorm:"type:varchar(100)"`
+	DurationMinutes int
+	// 周期窗口使用的时区,例如 Asia/Shanghai,为空时使用 UTC
+	Timezone string `gorm:"type:varchar(64)"`
+	// 创建时未指定则默认启用
+	Enabled   bool
+	Creator   string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (w *FreezeWindow) Validate() error {
+	if w.Schedule == "" {
+		if w.StartAt == nil || w.EndAt == nil {
+			return fmt.Errorf("startAt and endAt are required for one-off freeze window")
+		}
+		if !w.EndAt.After(*w.StartAt) {
+			return fmt.Errorf("endAt must be after startAt")
+		}
+		return nil
+	}
+	if w.DurationMinutes <= 0 {
+		return fmt.Errorf("durationMinutes is required for recurring freeze window")
+	}
+	if _, err := w.location(); err != nil {
+		return err
+	}
+	if _, err := cron.ParseStandard(w.Schedule); err != nil {
+		return fmt.Errorf("invalid schedule %s: %w", w.Schedule, err)
+	}
+	return nil
+}
+
+func (w *FreezeWindow) location() (*time.Location, error) {
+	if w.Timezone == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(w.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %s: %w", w.Timezone, err)
+	}
+	return loc, nil
+}
+
+// ActiveAt 返回 now 是否在冻结窗口内,以及本次窗口的结束时间
+func (w *FreezeWindow) ActiveAt(now time.Time) (bool, time.Time) {
+	if !w.Enabled {
+		return false, time.Time{}
+	}
+	if w.Schedule == "" {
+		if w.StartAt == nil || w.EndAt == nil {
+			return false, time.Time{}
+		}
+		return !now.Before(*w.StartAt) && now.Before(*w.EndAt), *w.EndAt
+	}
+	loc, err := w.location()
+	if err != nil {
+		return false, time.Time{}
+	}
+	schedule, err := cron.ParseStandard(w.Schedule)
+	if err != nil {
+		return false, time.Time{}
+	}
+	duration := time.Duration(w.DurationMinutes) * time.Minute
+	// 在 (now-duration, now] 之间开始的窗口仍然生效
+	start := schedule.Next(now.In(loc).Add(-duration))
+	if start.After(now) {
+		return false, time.Time{}
+	}
+	return true, start.Add(duration)
+}
+
+// ActiveFreezeWindow 返回环境当前生效的冻结窗口,包括所属租户和项目的窗口. env 需要预加载 Project
+func ActiveFreezeWindow(db *gorm.DB, env *Environment, now time.Time) (*FreezeWindow, error) {
+	if env.Project == nil {
+		return nil, fmt.Errorf("project of environment %s not loaded", env.EnvironmentName)
+	}
+	windows := []FreezeWindow{}
+	if err := db.Where("enabled = ?", true).
+		Where("(scope = ? and scope_id = ?) or (scope = ? and scope_id = ?) or (scope = ? and scope_id = ?)",
+			FreezeScopeTenant, env.Project.TenantID,
+			FreezeScopeProject, env.ProjectID,
+			FreezeScopeEnvironment, env.ID).
+		Find(&windows).Error; err != nil {
+		return nil, err
+	}
+	for i := range windows {
+		if active, _ := windows[i].ActiveAt(now); active {
+			return &windows[i], nil
+		}
+	}
+	return nil, nil
+}
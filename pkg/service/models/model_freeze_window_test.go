@@ -0,0 +1,108 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFreezeWindowActiveAt(t *testing.T) {
+	mustTime := func(s string) time.Time {
+		ts, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	start, end := mustTime("2022-10-01T00:00:00Z"), mustTime("2022-10-08T00:00:00Z")
+	tests := []struct {
+		name      string
+		window    FreezeWindow
+		now       time.Time
+		want      bool
+		wantUntil time.Time
+	}{
+		{
+			name:      "one-off in window",
+			window:    FreezeWindow{Enabled: true, StartAt: &start, EndAt: &end},
+			now:       mustTime("2022-10-03T12:00:00Z"),
+			want:      true,
+			wantUntil: end,
+		},
+		{
+			name:   "one-off after window",
+			window: FreezeWindow{Enabled: true, StartAt: &start, EndAt: &end},
+			now:    end,
+			want:   false,
+		},
+		{
+			name:   "disabled",
+			window: FreezeWindow{Enabled: false, StartAt: &start, EndAt: &end},
+			now:    mustTime("2022-10-03T12:00:00Z"),
+			want:   false,
+		},
+		{
+			// 每周五 18:00(Asia/Shanghai) 开始冻结 3 天
+			name:      "recurring in window with timezone",
+			window:    FreezeWindow{Enabled: true, Schedule: "0 18 * * 5", DurationMinutes: 3 * 24 * 60, Timezone: "Asia/Shanghai"},
+			now:       mustTime("2022-10-15T02:00:00Z"), // 周六 10:00 +08
+			want:      true,
+			wantUntil: mustTime("2022-10-17T10:00:00Z"),
+		},
+		{
+			name:   "recurring before window with timezone",
+			window: FreezeWindow{Enabled: true, Schedule: "0 18 * * 5", DurationMinutes: 3 * 24 * 60, Timezone: "Asia/Shanghai"},
+			now:    mustTime("2022-10-14T09:59:00Z"), // 周五 17:59 +08
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, until := tt.window.ActiveAt(tt.now)
+			if got != tt.want {
+				t.Errorf("FreezeWindow.ActiveAt() = %v, want %v", got, tt.want)
+			}
+			if tt.want && !until.Equal(tt.wantUntil) {
+				t.Errorf("FreezeWindow.ActiveAt() until = %v, want %v", until, tt.wantUntil)
+			}
+		})
+	}
+}
+
+func TestFreezeWindowValidate(t *testing.T) {
+	start := time.Now()
+	end := start.Add(time.Hour)
+	tests := []struct {
+		name    string
+		window  FreezeWindow
+		wantErr bool
+	}{
+		{name: "one-off", window: FreezeWindow{StartAt: &start, EndAt: &end}},
+		{name: "one-off missing end", window: FreezeWindow{StartAt: &start}, wantErr: true},
+		{name: "one-off end before start", window: FreezeWindow{StartAt: &end, EndAt: &start}, wantErr: true},
+		{name: "recurring", window: FreezeWindow{Schedule: "0 18 * * 5", DurationMinutes: 60, Timezone: "Asia/Shanghai"}},
+		{name: "recurring without duration", window: FreezeWindow{Schedule: "0 18 * * 5"}, wantErr: true},
+		{name: "invalid schedule", window: FreezeWindow{Schedule: "every friday", DurationMinutes: 60}, wantErr: true},
+		{name: "invalid timezone", window: FreezeWindow{Schedule: "0 18 * * 5", DurationMinutes: 60, Timezone: "Mars/Base"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.window.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("FreezeWindow.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import "time"
+
+const (
+	// 选择满足 semver 范围的最高版本
+	ImagePolicySemver = "semver"
+	// 选择匹配正则的 tag 中最新推送的
+	ImagePolicyRegex = "regex"
+	// 选择最新推送的 tag
+	ImagePolicyLatest = "latest"
+)
+
+const (
+	ImageUpdateStatusSuccess = "success"
+	ImageUpdateStatusFailed  = "failed"
+	ImageUpdateStatusDryRun  = "dryrun"
+	// 环境处于冻结窗口或者需要审批时不自动更新
+	ImageUpdateStatusSkipped = "skipped"
+)
+
+// ImageUpdatePolicy 应用在环境中的镜像自动更新策略, 定期从镜像仓库中选出新的 tag 并更新到编排
+type ImageUpdatePolicy struct {
+	ID            uint         `gorm:"primarykey"`
+	EnvironmentID uint         `gorm:"uniqueIndex:uniq_idx_env_app_image"`
+	Environment   *Environment `json:",omitempty" gorm:"constraint:OnUpdate:RESTRICT,OnDelete:CASCADE;"`
+	// 应用名称
+	ApplicationName string `gorm:"type:varchar(50);uniqueIndex:uniq_idx_env_app_image"`
+	// 不包含 tag 的镜像名称, 例如 harbor.example.com/library/nginx
+	Image string `gorm:"type:varchar(191);uniqueIndex:uniq_idx_env_app_image"`
+	// 策略类型 semver,regex,latest
+	Policy string `gorm:"type:varchar(20)"`
+	// semver 范围,例如 >=1.2.0 <2.0.0
+	Range string
+	// regex 策略匹配的 tag 正则
+	Pattern string
+	// 更新编排后同步到集群
+	Sync bool
+	// 仅记录将要更新的版本,不修改编排
+	DryRun  bool
+	Enabled bool
+	// 最近一次检查的结果
+	LastCheckedAt *time.Time
+	LastMessage   string
+	Creator       string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// ImageUpdateHistory 镜像自动更新历史
+type ImageUpdateHistory struct {
+	ID              uint   `gorm:"primarykey"`
+	PolicyID        uint   `gorm:"index"`
+	EnvironmentID   uint   `gorm:"index"`
+	ApplicationName string `gorm:"type:varchar(50)"`
+	FromImage       string
+	ToImage         string
+	Status          string `gorm:"type:varchar(20)"`
+	Message         string
+	CreatedAt       time.Time
+}
@@ -0,0 +1,68 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	prommodel "github.com/prometheus/common/model"
+	"kubegems.io/kubegems/pkg/utils/gormdatatypes"
+)
+
+// LogMetric 从日志中提取的指标, 作为 loki recording rule 写入 prometheus, 可以在监控面板和告警规则中使用
+type LogMetric struct {
+	ID            uint         `gorm:"primarykey" json:"id"`
+	EnvironmentID uint         `gorm:"uniqueIndex:uniq_idx_env_log_metric" json:"environmentID"`
+	Environment   *Environment `gorm:"constraint:OnUpdate:RESTRICT,OnDelete:CASCADE;" json:"environment,omitempty"`
+	// 生成的指标名
+	Name        string `gorm:"type:varchar(200);uniqueIndex:uniq_idx_env_log_metric" binding:"required" json:"name"`
+	Description string `json:"description"`
+	// 统计 Duration 内匹配 Match 的日志条数
+	LogqlGenerator *LogqlGenerator         `json:"logqlGenerator"`
+	GroupBy        gormdatatypes.JSONSlice `json:"groupBy"` // 按标签分组, 为空时只统计总数
+	Creator        string                  `gorm:"type:varchar(50)" json:"creator"`
+	CreatedAt      *time.Time              `json:"createdAt"`
+	UpdatedAt      *time.Time              `json:"updatedAt"`
+
+	// 在监控面板和告警规则中引用该指标的 promql
+	Expr string `gorm:"-" json:"expr"`
+}
+
+func (m *LogMetric) Validate() error {
+	if !prommodel.IsValidMetricName(prommodel.LabelValue(m.Name)) {
+		return fmt.Errorf("name %s is not a valid metric name", m.Name)
+	}
+	if m.LogqlGenerator == nil || m.LogqlGenerator.Match == "" {
+		return fmt.Errorf("logqlGenerator match can't be empty")
+	}
+	dur, err := prommodel.ParseDuration(m.LogqlGenerator.Duration)
+	if err != nil {
+		return fmt.Errorf("duration %s not valid: %w", m.LogqlGenerator.Duration, err)
+	}
+	if time.Duration(dur) > 10*time.Minute {
+		return fmt.Errorf("duration can't be longer than 10m")
+	}
+	if _, err := regexp.Compile(m.LogqlGenerator.Match); err != nil {
+		return fmt.Errorf("match %s not valid: %w", m.LogqlGenerator.Match, err)
+	}
+	for _, label := range m.GroupBy {
+		if !prommodel.LabelName(label).IsValid() {
+			return fmt.Errorf("group by label %s not valid", label)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+const (
+	DefaultPreviewTTLHours = 24
+	MaxPreviewTTLHours     = 24 * 7
+)
+
+// PreviewEnvironment 应用分支或 PR 的临时预览环境, 过期或者 PR 关闭后删除.
+// 同一项目下同一应用的同一分支只有一个预览环境
+type PreviewEnvironment struct {
+	ID            uint         `gorm:"primarykey"`
+	EnvironmentID uint         `gorm:"uniqueIndex"`
+	Environment   *Environment `json:",omitempty" gorm:"constraint:OnUpdate:RESTRICT,OnDelete:CASCADE;"`
+	ProjectID     uint         `gorm:"uniqueIndex:uniq_idx_project_app_branch"`
+	// 应用名称
+	ApplicationName string `gorm:"type:varchar(50);uniqueIndex:uniq_idx_project_app_branch"`
+	// 分支名称
+	Branch string `gorm:"type:varchar(191);uniqueIndex:uniq_idx_project_app_branch"`
+	// PR 编号,为 0 时表示直接从分支创建
+	PullRequest int
+	// 复制编排的源环境
+	SourceEnvironment string `gorm:"type:varchar(50)"`
+	// 源环境编排的 git commit
+	Revision string `gorm:"type:varchar(64)"`
+	// 覆盖的镜像
+	Images datatypes.JSON
+	// 通过租户网关访问的域名
+	Host      string
+	Creator   string
+	ExpiredAt time.Time `gorm:"index"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// PreviewWebhook 应用接收 PR webhook 时校验签名使用的密钥, 同一项目下的每个应用一个.
+// webhook 以生成密钥的用户的身份创建和删除预览环境
+type PreviewWebhook struct {
+	ID              uint   `gorm:"primarykey"`
+	ProjectID       uint   `gorm:"uniqueIndex:uniq_idx_project_app"`
+	ApplicationName string `gorm:"type:varchar(50);uniqueIndex:uniq_idx_project_app"`
+	Secret          string `gorm:"type:varchar(64)" json:"-"`
+	CreatorID       uint
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
@@ -0,0 +1,51 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"fmt"
+	"time"
+
+	prommodel "github.com/prometheus/common/model"
+	"kubegems.io/kubegems/pkg/utils/gormdatatypes"
+)
+
+// RecordingRule 环境中的 prometheus 预聚合规则, 同一环境的规则写入命名空间下同一个 PrometheusRule
+type RecordingRule struct {
+	ID            uint         `gorm:"primarykey" json:"id"`
+	EnvironmentID uint         `gorm:"uniqueIndex:uniq_idx_env_record" json:"environmentID"`
+	Environment   *Environment `gorm:"constraint:OnUpdate:RESTRICT,OnDelete:CASCADE;" json:"environment,omitempty"`
+	// 生成的指标名, 建议遵循 level:metric:operations 格式
+	Record      string `gorm:"type:varchar(200);uniqueIndex:uniq_idx_env_record" binding:"required" json:"record"`
+	Expr        string `binding:"required" json:"expr"` // promql, 必须限制在环境的命名空间
+	Description string `json:"description"`
+	// 额外添加的标签, namespace 标签会被强制设置为环境的命名空间
+	Labels    gormdatatypes.JSONMap `json:"labels"`
+	Creator   string                `gorm:"type:varchar(50)" json:"creator"`
+	CreatedAt *time.Time            `json:"createdAt"`
+	UpdatedAt *time.Time            `json:"updatedAt"`
+}
+
+func (r *RecordingRule) Validate() error {
+	if !prommodel.IsValidMetricName(prommodel.LabelValue(r.Record)) {
+		return fmt.Errorf("record %s is not a valid metric name", r.Record)
+	}
+	for k := range r.Labels {
+		if !prommodel.LabelName(k).IsValid() {
+			return fmt.Errorf("label name %s not valid", k)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	ReportFrequencyDaily  = "daily"
+	ReportFrequencyWeekly = "weekly"
+)
+
+// ScheduledReport 定时汇总租户或项目下环境的资源使用, 告警, 错误日志和部署情况, 通过告警渠道发送
+type ScheduledReport struct {
+	ID       uint    `gorm:"primarykey" json:"id"`
+	Name     string  `gorm:"type:varchar(50)" binding:"required" json:"name"`
+	TenantID uint    `json:"tenantID"`
+	Tenant   *Tenant `gorm:"constraint:OnUpdate:RESTRICT,OnDelete:CASCADE;" json:"tenant,omitempty"`
+	// 为空时汇总租户下所有项目
+	ProjectID *uint    `json:"projectID"`
+	Project   *Project `gorm:"constraint:OnUpdate:RESTRICT,OnDelete:CASCADE;" json:"project,omitempty"`
+	// daily 或 weekly
+	Frequency string          `gorm:"type:varchar(20)" json:"frequency"`
+	Channels  []*AlertChannel `gorm:"many2many:scheduled_report_channels;" json:"channels"`
+	Enabled   bool            `json:"enabled"`
+	// 最近一次发送的结果
+	LastSentAt *time.Time `json:"lastSentAt"`
+	LastError  string     `json:"lastError"`
+	Creator    string     `gorm:"type:varchar(50)" json:"creator"`
+	CreatedAt  *time.Time `json:"createdAt"`
+	UpdatedAt  *time.Time `json:"updatedAt"`
+}
+
+func (r *ScheduledReport) Validate() error {
+	if r.Frequency != ReportFrequencyDaily && r.Frequency != ReportFrequencyWeekly {
+		return fmt.Errorf("invalid frequency %s, must be one of %s, %s", r.Frequency, ReportFrequencyDaily, ReportFrequencyWeekly)
+	}
+	if len(r.Channels) == 0 {
+		return fmt.Errorf("at least one channel required")
+	}
+	return nil
+}
+
+// Period 报告统计的时间段
+func (r *ScheduledReport) Period() time.Duration {
+	if r.Frequency == ReportFrequencyWeekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// IsDue 是否需要发送, 按小时检查, 下次发送时间取整到小时避免发送时间逐渐后移
+func (r *ScheduledReport) IsDue(now time.Time) bool {
+	if !r.Enabled {
+		return false
+	}
+	if r.LastSentAt == nil {
+		return true
+	}
+	return !now.Before(r.LastSentAt.Add(r.Period()).Truncate(time.Hour))
+}
@@ -0,0 +1,52 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduledReportIsDue(t *testing.T) {
+	now := time.Date(2022, 10, 10, 10, 3, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	tests := []struct {
+		name   string
+		report ScheduledReport
+		want   bool
+	}{
+		{name: "disabled", report: ScheduledReport{Frequency: ReportFrequencyDaily}, want: false},
+		{name: "never sent", report: ScheduledReport{Frequency: ReportFrequencyDaily, Enabled: true}, want: true},
+		{name: "daily sent yesterday", report: ScheduledReport{Frequency: ReportFrequencyDaily, Enabled: true, LastSentAt: at(-24 * time.Hour)}, want: true},
+		{
+			name:   "daily sent yesterday a little later",
+			report: ScheduledReport{Frequency: ReportFrequencyDaily, Enabled: true, LastSentAt: at(-24*time.Hour + 30*time.Minute)},
+			want:   true,
+		},
+		{name: "daily sent today", report: ScheduledReport{Frequency: ReportFrequencyDaily, Enabled: true, LastSentAt: at(-time.Hour)}, want: false},
+		{name: "weekly sent yesterday", report: ScheduledReport{Frequency: ReportFrequencyWeekly, Enabled: true, LastSentAt: at(-24 * time.Hour)}, want: false},
+		{name: "weekly sent last week", report: ScheduledReport{Frequency: ReportFrequencyWeekly, Enabled: true, LastSentAt: at(-7 * 24 * time.Hour)}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.report.IsDue(now); got != tt.want {
+				t.Errorf("ScheduledReport.IsDue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	prommodel "github.com/prometheus/common/model"
+	"gorm.io/datatypes"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// 可用性: 请求的成功率
+	SLOTypeAvailability = "availability"
+	// 延迟: 请求耗时(或模板指标)满足阈值的比例
+	SLOTypeLatency = "latency"
+
+	// 使用 otel span metrics 中服务的请求指标
+	SLOSourceOtel = "otel"
+	// 使用 promql 模板, 统计指标满足阈值的时间比例
+	SLOSourcePromql = "promql"
+)
+
+// ServiceLevelObjective 环境中服务的 SLO, 根据错误预算生成多窗口燃烧率告警
+type ServiceLevelObjective struct {
+	ID            uint         `gorm:"primarykey" json:"id"`
+	EnvironmentID uint         `gorm:"uniqueIndex:uniq_idx_env_slo" json:"environmentID"`
+	Environment   *Environment `gorm:"constraint:OnUpdate:RESTRICT,OnDelete:CASCADE;" json:"environment,omitempty"`
+	// 同时作为告警规则名称的一部分
+	Name        string `gorm:"type:varchar(30);uniqueIndex:uniq_idx_env_slo" binding:"required" json:"name"`
+	Description string `json:"description"`
+	// availability 或者 latency
+	Type string `gorm:"type:varchar(20)" json:"type"`
+	// otel 或者 promql
+	Source string `gorm:"type:varchar(20)" json:"source"`
+	// otel 中的 service_name
+	Service string `gorm:"type:varchar(100)" json:"service"`
+	// 目标百分比, eg. 99.9
+	Objective float64 `json:"objective"`
+	// 统计窗口, eg. 28d
+	Window string `gorm:"type:varchar(20)" json:"window"`
+	// otel 延迟阈值(ms), 需要与 latency 直方图的 bucket 边界一致
+	LatencyThreshold float64 `json:"latencyThreshold"`
+	// promql 模板, 模板指标满足 CompareOp Threshold 时为达标
+	PromqlGenerator *PromqlGenerator `json:"promqlGenerator"`
+	CompareOp       string           `gorm:"type:varchar(5)" json:"compareOp"`
+	Threshold       float64          `json:"threshold"`
+	// 是否生成燃烧率告警
+	AlertEnabled bool `json:"alertEnabled"`
+	// 告警接收器 []SLOAlertReceiver, 为空时使用默认接收器
+	AlertReceivers datatypes.JSON `json:"alertReceivers"`
+	Creator        string         `gorm:"type:varchar(50)" json:"creator"`
+	CreatedAt      *time.Time     `json:"createdAt"`
+	UpdatedAt      *time.Time     `json:"updatedAt"`
+}
+
+type SLOAlertReceiver struct {
+	AlertChannelID uint   `json:"alertChannelID"`
+	Interval       string `json:"interval"`
+}
+
+func (s *ServiceLevelObjective) Validate() error {
+	if errs := validation.IsDNS1035Label(s.Name); len(errs) > 0 || len(s.Name) > 30 {
+		return fmt.Errorf("slo name %s not valid, must be a DNS-1035 label no more than 30 characters", s.Name)
+	}
+	if s.Objective <= 0 || s.Objective >= 100 {
+		return fmt.Errorf("objective must be between 0 and 100")
+	}
+	window, err := s.WindowDuration()
+	if err != nil {
+		return err
+	}
+	if window < 24*time.Hour {
+		return fmt.Errorf("window must be at least 1d")
+	}
+	switch s.Source {
+	case SLOSourceOtel:
+		if s.Service == "" {
+			return fmt.Errorf("service is required for otel slo")
+		}
+		switch s.Type {
+		case SLOTypeAvailability:
+		case SLOTypeLatency:
+			if s.LatencyThreshold <= 0 {
+				return fmt.Errorf("latencyThreshold is required for latency slo")
+			}
+		default:
+			return fmt.Errorf("unknown slo type %s", s.Type)
+		}
+	case SLOSourcePromql:
+		if s.Type != SLOTypeAvailability && s.Type != SLOTypeLatency {
+			return fmt.Errorf("unknown slo type %s", s.Type)
+		}
+		if s.PromqlGenerator == nil || s.PromqlGenerator.Resource == "" {
+			return fmt.Errorf("promqlGenerator is required for promql slo")
+		}
+		switch s.CompareOp {
+		case "<", "<=", ">", ">=":
+		default:
+			return fmt.Errorf("compareOp %s not valid, must be one of <, <=, >, >=", s.CompareOp)
+		}
+	default:
+		return fmt.Errorf("unknown slo source %s", s.Source)
+	}
+	_, err = s.SLOAlertReceivers()
+	return err
+}
+
+func (s *ServiceLevelObjective) WindowDuration() (time.Duration, error) {
+	d, err := prommodel.ParseDuration(s.Window)
+	if err != nil {
+		return 0, fmt.Errorf("invalid window %s: %w", s.Window, err)
+	}
+	return time.Duration(d), nil
+}
+
+// ErrorBudget 错误预算,即允许不达标的比例
+func (s *ServiceLevelObjective) ErrorBudget() float64 {
+	return 1 - s.Objective/100
+}
+
+func (s *ServiceLevelObjective) SLOAlertReceivers() ([]SLOAlertReceiver, error) {
+	receivers := []SLOAlertReceiver{}
+	if len(s.AlertReceivers) == 0 {
+		return receivers, nil
+	}
+	if err := json.Unmarshal(s.AlertReceivers, &receivers); err != nil {
+		return nil, fmt.Errorf("invalid alert receivers: %w", err)
+	}
+	return receivers, nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	prommodel "github.com/prometheus/common/model"
+	"kubegems.io/kubegems/pkg/utils/gormdatatypes"
+)
+
+var DefaultTraceIDFields = []string{"trace_id", "traceID"}
+
+const DefaultTraceServiceLabel = "app"
+
+var traceIDFieldReg = regexp.MustCompile(`^[\w.-]+$`)
+
+// TraceLogConfig 环境的链路日志关联配置, 不存在时使用默认配置
+type TraceLogConfig struct {
+	ID            uint         `gorm:"primarykey" json:"id"`
+	EnvironmentID uint         `gorm:"uniqueIndex" json:"environmentID"`
+	Environment   *Environment `gorm:"constraint:OnUpdate:RESTRICT,OnDelete:CASCADE;" json:"environment,omitempty"`
+	// 日志中记录 trace id 的字段名, 支持 json 和 key=value 格式
+	TraceIDFields gormdatatypes.JSONSlice `json:"traceIDFields"`
+	// loki 中对应链路服务名的标签, 为空时不按服务过滤
+	ServiceLabel string     `gorm:"type:varchar(100)" json:"serviceLabel"`
+	UpdatedAt    *time.Time `json:"updatedAt"`
+}
+
+func DefaultTraceLogConfig(envid uint) *TraceLogConfig {
+	return &TraceLogConfig{
+		EnvironmentID: envid,
+		TraceIDFields: append(gormdatatypes.JSONSlice{}, DefaultTraceIDFields...),
+		ServiceLabel:  DefaultTraceServiceLabel,
+	}
+}
+
+func (c *TraceLogConfig) Validate() error {
+	if len(c.TraceIDFields) == 0 {
+		return fmt.Errorf("traceIDFields can't be empty")
+	}
+	for _, field := range c.TraceIDFields {
+		if !traceIDFieldReg.MatchString(field) {
+			return fmt.Errorf("trace id field %s not valid", field)
+		}
+	}
+	if c.ServiceLabel != "" && !prommodel.LabelName(c.ServiceLabel).IsValid() {
+		return fmt.Errorf("service label %s not valid", c.ServiceLabel)
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observe
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	prommodel "github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"kubegems.io/kubegems/pkg/apis/gems"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+	"kubegems.io/kubegems/pkg/utils/prometheus/promql"
+)
+
+const (
+	// zscore 基线至少需要的历史点数
+	minZScoreBaselinePoints = 10
+	// seasonal 基线至少需要的历史周期数
+	minSeasonalBaselinePoints = 2
+	// 标准差的下限为预期值的比例, 避免平稳序列的预期范围过窄
+	minAnomalySigmaRatio = 0.01
+	minAnomalySigma      = 1e-6
+
+	anomalyAlertSource = gems.NamespaceMonitor + "/anomaly-detector"
+)
+
+// AnomalyPoint 序列上的一个点以及根据基线计算的预期范围
+type AnomalyPoint struct {
+	Timestamp prommodel.Time `json:"timestamp"`
+	Value     float64        `json:"value"`
+	Expected  float64        `json:"expected"`
+	Lower     float64        `json:"lower"`
+	Upper     float64        `json:"upper"`
+	Anomaly   bool           `json:"anomaly"`
+}
+
+type AnomalySeries struct {
+	Metric prommodel.Metric `json:"metric"`
+	// 只包含历史数据足够计算基线的点
+	Points []AnomalyPoint `json:"points"`
+}
+
+func AnomalyAlertName(detector *models.AnomalyDetector) string {
+	return "anomaly-" + detector.Name
+}
+
+// AnomalyExpr 异常检测的 promql, 强制加上环境的 namespace
+func AnomalyExpr(detector *models.AnomalyDetector, namespace string) (string, error) {
+	if detector.PromqlGenerator == nil || detector.PromqlGenerator.Tpl == nil {
+		return "", fmt.Errorf("promql template of anomaly detector %s not loaded", detector.Name)
+	}
+	q, err := promql.New(detector.PromqlGenerator.Tpl.Expr)
+	if err != nil {
+		return "", err
+	}
+	q.AddLabelMatchers(&labels.Matcher{
+		Type:  labels.MatchEqual,
+		Name:  prometheus.PromqlNamespaceKey,
+		Value: namespace,
+	})
+	for _, m := range detector.PromqlGenerator.LabelMatchers {
+		q.AddLabelMatchers(m.ToPromqlLabelMatcher())
+	}
+	return q.String(), nil
+}
+
+// DetectAnomalies 计算 from 之后各点的预期范围, matrix 需要包含 from 之前一个历史窗口的数据
+func DetectAnomalies(detector *models.AnomalyDetector, matrix prommodel.Matrix, from time.Time) ([]AnomalySeries, error) {
+	window, _, season, err := detector.Durations()
+	if err != nil {
+		return nil, err
+	}
+	ret := []AnomalySeries{}
+	for _, stream := range matrix {
+		values := stream.Values
+		sort.Slice(values, func(i, j int) bool { return values[i].Timestamp < values[j].Timestamp })
+
+		var baseline func(i int) ([]float64, bool)
+		switch detector.Algorithm {
+		case models.AnomalyAlgorithmSeasonal:
+			byTime := make(map[prommodel.Time]float64, len(values))
+			for _, v := range values {
+				byTime[v.Timestamp] = float64(v.Value)
+			}
+			baseline = func(i int) ([]float64, bool) {
+				history := []float64{}
+				for offset := season; offset <= window; offset += season {
+					if v, ok := byTime[values[i].Timestamp.Add(-offset)]; ok && isValidSample(v) {
+						history = append(history, v)
+					}
+				}
+				return history, len(history) >= minSeasonalBaselinePoints
+			}
+		default:
+			start := 0
+			baseline = func(i int) ([]float64, bool) {
+				from := values[i].Timestamp.Add(-window)
+				for start < i && values[start].Timestamp < from {
+					start++
+				}
+				history := make([]float64, 0, i-start)
+				for _, v := range values[start:i] {
+					if isValidSample(float64(v.Value)) {
+						history = append(history, float64(v.Value))
+					}
+				}
+				return history, len(history) >= minZScoreBaselinePoints
+			}
+		}
+
+		series := AnomalySeries{Metric: stream.Metric, Points: []AnomalyPoint{}}
+		fromTime := prommodel.TimeFromUnixNano(from.UnixNano())
+		for i, v := range values {
+			value := float64(v.Value)
+			if v.Timestamp < fromTime || !isValidSample(value) {
+				continue
+			}
+			history, ok := baseline(i)
+			if !ok {
+				continue
+			}
+			series.Points = append(series.Points, anomalyPoint(v.Timestamp, value, history, detector.Sensitivity, detector.Direction))
+		}
+		ret = append(ret, series)
+	}
+	return ret, nil
+}
+
+func anomalyPoint(ts prommodel.Time, value float64, history []float64, sensitivity float64, direction string) AnomalyPoint {
+	mean, std := meanStd(history)
+	sigma := math.Max(std, math.Max(math.Abs(mean)*minAnomalySigmaRatio, minAnomalySigma))
+	point := AnomalyPoint{
+		Timestamp: ts,
+		Value:     value,
+		Expected:  mean,
+		Lower:     mean - sensitivity*sigma,
+		Upper:     mean + sensitivity*sigma,
+	}
+	switch direction {
+	case models.AnomalyDirectionUp:
+		point.Anomaly = value > point.Upper
+	case models.AnomalyDirectionDown:
+		point.Anomaly = value < point.Lower
+	default:
+		point.Anomaly = value > point.Upper || value < point.Lower
+	}
+	return point
+}
+
+func meanStd(values []float64) (float64, float64) {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
+
+func isValidSample(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// AnomalyAlerts 根据各序列最后一个点的检测结果生成新触发和已恢复的告警, 返回当前仍在告警的序列
+func AnomalyAlerts(detector *models.AnomalyDetector, cluster, namespace string, series []AnomalySeries, now time.Time) (*prometheus.WebhookAlert, map[string]map[string]string, error) {
+	lastFiring, err := detector.Firing()
+	if err != nil {
+		return nil, nil, err
+	}
+	alertname := AnomalyAlertName(detector)
+	commonLabels := map[string]string{
+		prometheus.AlertNameLabel:      alertname,
+		prometheus.AlertNamespaceLabel: namespace,
+		prometheus.AlertClusterKey:     cluster,
+		prometheus.AlertFromLabel:      prometheus.AlertTypeMonitor,
+		prometheus.SeverityLabel:       detector.Severity,
+		"prometheus":                   anomalyAlertSource,
+	}
+	showName := detector.Name
+	if detector.PromqlGenerator != nil && detector.PromqlGenerator.Tpl != nil && detector.PromqlGenerator.Tpl.RuleShowName != "" {
+		showName = detector.PromqlGenerator.Tpl.RuleShowName
+	}
+
+	ret := &prometheus.WebhookAlert{
+		Receiver:     anomalyAlertSource,
+		Status:       "resolved",
+		Alerts:       []prometheus.Alert{},
+		CommonLabels: commonLabels,
+	}
+	firing := map[string]map[string]string{}
+	for _, s := range series {
+		if len(s.Points) == 0 {
+			continue
+		}
+		last := s.Points[len(s.Points)-1]
+		if !last.Anomaly {
+			continue
+		}
+		ls := map[string]string{}
+		for k, v := range s.Metric {
+			if k != prommodel.MetricNameLabel {
+				ls[string(k)] = string(v)
+			}
+		}
+		for k, v := range commonLabels {
+			ls[k] = v
+		}
+		fingerprint := fingerprintOf(ls)
+		firing[fingerprint] = ls
+		if _, ok := lastFiring[fingerprint]; ok {
+			continue
+		}
+		startsAt := last.Timestamp.Time()
+		ret.Status = "firing"
+		ret.Alerts = append(ret.Alerts, prometheus.Alert{
+			Status: "firing",
+			Labels: ls,
+			Annotations: map[string]string{
+				prometheus.MessageAnnotationsKey: fmt.Sprintf("%s %s anomaly detected, value: %.2f, expected: [%.2f, %.2f]",
+					showName, s.Metric.String(), last.Value, last.Lower, last.Upper),
+				prometheus.ValueAnnotationKey: fmt.Sprintf("%.2f", last.Value),
+			},
+			StartsAt:    &startsAt,
+			Fingerprint: fingerprint,
+		})
+	}
+	for fingerprint, ls := range lastFiring {
+		if _, ok := firing[fingerprint]; ok {
+			continue
+		}
+		endsAt := now
+		ret.Alerts = append(ret.Alerts, prometheus.Alert{
+			Status: "resolved",
+			Labels: ls,
+			Annotations: map[string]string{
+				prometheus.MessageAnnotationsKey: fmt.Sprintf("%s %s back to normal", showName, toLabelSet(ls).String()),
+			},
+			EndsAt:      &endsAt,
+			Fingerprint: fingerprint,
+		})
+	}
+	return ret, firing, nil
+}
+
+func toLabelSet(ls map[string]string) prommodel.LabelSet {
+	ret := prommodel.LabelSet{}
+	for k, v := range ls {
+		ret[prommodel.LabelName(k)] = prommodel.LabelValue(v)
+	}
+	return ret
+}
+
+func fingerprintOf(ls map[string]string) string {
+	return toLabelSet(ls).Fingerprint().String()
+}
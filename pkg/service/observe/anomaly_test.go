@@ -0,0 +1,138 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observe
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	prommodel "github.com/prometheus/common/model"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+	"kubegems.io/kubegems/pkg/utils/prometheus/templates"
+)
+
+func anomalyMatrix(start time.Time, step time.Duration, values []float64) prommodel.Matrix {
+	stream := &prommodel.SampleStream{Metric: prommodel.Metric{"namespace": "prod", "pod": "web-0"}}
+	for i, v := range values {
+		stream.Values = append(stream.Values, prommodel.SamplePair{
+			Timestamp: prommodel.TimeFromUnixNano(start.Add(time.Duration(i) * step).UnixNano()),
+			Value:     prommodel.SampleValue(v),
+		})
+	}
+	return prommodel.Matrix{stream}
+}
+
+func TestDetectAnomaliesZScore(t *testing.T) {
+	detector := &models.AnomalyDetector{
+		Name: "cpu", Algorithm: models.AnomalyAlgorithmZScore, Window: "1h", Step: "5m",
+		Sensitivity: 3, Direction: models.AnomalyDirectionBoth,
+	}
+	start := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	values := []float64{}
+	for i := 0; i < 12; i++ {
+		values = append(values, 10+float64(i%2))
+	}
+	values = append(values, 10.5, 30)
+	series, err := DetectAnomalies(detector, anomalyMatrix(start, 5*time.Minute, values), start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 1 || len(series[0].Points) != 2 {
+		t.Fatalf("unexpected series %+v", series)
+	}
+	normal, anomaly := series[0].Points[0], series[0].Points[1]
+	if normal.Anomaly || math.Abs(normal.Expected-10.5) > 1e-9 || math.Abs(normal.Upper-12) > 1e-9 {
+		t.Errorf("unexpected normal point %+v", normal)
+	}
+	if !anomaly.Anomaly {
+		t.Errorf("expect anomaly %+v", anomaly)
+	}
+
+	detector.Direction = models.AnomalyDirectionDown
+	series, _ = DetectAnomalies(detector, anomalyMatrix(start, 5*time.Minute, values), start.Add(time.Hour))
+	if series[0].Points[1].Anomaly {
+		t.Errorf("up spike should be ignored when direction is down")
+	}
+}
+
+func TestDetectAnomaliesSeasonal(t *testing.T) {
+	detector := &models.AnomalyDetector{
+		Name: "qps", Algorithm: models.AnomalyAlgorithmSeasonal, Window: "3h", Season: "1h", Step: "30m",
+		Sensitivity: 3, Direction: models.AnomalyDirectionBoth,
+	}
+	start := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	// 每小时的前半小时为 100, 后半小时为 10, 第 4 个小时的后半小时仍为 100
+	values := []float64{100, 10, 101, 11, 99, 9, 100, 100}
+	series, err := DetectAnomalies(detector, anomalyMatrix(start, 30*time.Minute, values), start.Add(3*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	points := series[0].Points
+	if len(points) != 2 {
+		t.Fatalf("unexpected points %+v", points)
+	}
+	if points[0].Anomaly || points[0].Expected != 100 {
+		t.Errorf("unexpected point %+v", points[0])
+	}
+	if !points[1].Anomaly || points[1].Expected != 10 {
+		t.Errorf("unexpected point %+v", points[1])
+	}
+}
+
+func TestAnomalyAlerts(t *testing.T) {
+	detector := &models.AnomalyDetector{
+		Name:     "cpu",
+		Severity: prometheus.SeverityError,
+		PromqlGenerator: &models.PromqlGenerator{
+			Tpl: &templates.PromqlTpl{RuleShowName: "cpu usage"},
+		},
+	}
+	now := time.Date(2022, 10, 1, 1, 0, 0, 0, time.UTC)
+	series := []AnomalySeries{{
+		Metric: prommodel.Metric{"pod": "web-0"},
+		Points: []AnomalyPoint{{Timestamp: prommodel.TimeFromUnixNano(now.UnixNano()), Value: 30, Expected: 10, Lower: 7, Upper: 13, Anomaly: true}},
+	}}
+
+	alert, firing, err := AnomalyAlerts(detector, "c1", "prod", series, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alert.Status != "firing" || len(alert.Alerts) != 1 || len(firing) != 1 {
+		t.Fatalf("unexpected alert %+v", alert)
+	}
+	labels := alert.Alerts[0].Labels
+	if labels[prometheus.AlertNameLabel] != "anomaly-cpu" || labels[prometheus.AlertNamespaceLabel] != "prod" ||
+		labels[prometheus.AlertClusterKey] != "c1" || labels["pod"] != "web-0" {
+		t.Errorf("unexpected labels %v", labels)
+	}
+
+	// 仍在告警时不重复发送
+	firingSeries, _ := json.Marshal(firing)
+	detector.FiringSeries = firingSeries
+	alert, _, _ = AnomalyAlerts(detector, "c1", "prod", series, now)
+	if len(alert.Alerts) != 0 {
+		t.Errorf("expect no alerts, got %+v", alert.Alerts)
+	}
+
+	// 恢复
+	series[0].Points[0].Anomaly = false
+	alert, firing, _ = AnomalyAlerts(detector, "c1", "prod", series, now)
+	if alert.Status != "resolved" || len(alert.Alerts) != 1 || alert.Alerts[0].Status != "resolved" || len(firing) != 0 {
+		t.Errorf("unexpected resolved alert %+v", alert)
+	}
+}
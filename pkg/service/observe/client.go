@@ -224,7 +224,7 @@ func (c *ObserveClient) ListLoggingAlertRules(ctx context.Context, namespace str
 	groupNamespaceMap := map[string]*rulefmt.RuleGroups{}
 	for k, v := range cm.Data {
 		// skip recording rule
-		if k == LokiRecordingRulesKey {
+		if IsLokiRecordingRulesKey(k) {
 			continue
 		}
 		if namespace != v1.NamespaceAll && namespace != k {
@@ -0,0 +1,81 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observe
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+)
+
+// 日志指标按命名空间写入 loki rules configmap 的独立 key, 与告警规则分开
+const logMetricRulesKeySuffix = ".log-metrics.yaml"
+
+func LogMetricRulesKey(namespace string) string {
+	return namespace + logMetricRulesKeySuffix
+}
+
+// IsLokiRecordingRulesKey loki rules configmap 中存放 recording rule 的 key, 解析告警规则时需要跳过
+func IsLokiRecordingRulesKey(key string) bool {
+	return key == LokiRecordingRulesKey || strings.HasSuffix(key, logMetricRulesKeySuffix)
+}
+
+// LogMetricLogql 统计日志条数的 logql
+func LogMetricLogql(m *models.LogMetric, namespace string) string {
+	labelvalues := []string{}
+	for _, v := range m.LogqlGenerator.LabelMatchers {
+		if v.Name == prometheus.PromqlNamespaceKey {
+			continue
+		}
+		labelvalues = append(labelvalues, v.String())
+	}
+	sort.Strings(labelvalues)
+	labelvalues = append(labelvalues, fmt.Sprintf(`namespace="%s"`, namespace))
+	agg := "sum"
+	if len(m.GroupBy) > 0 {
+		agg = fmt.Sprintf("sum by(%s)", strings.Join(m.GroupBy, ", "))
+	}
+	return fmt.Sprintf("%s (count_over_time({%s} |~ `%s` [%s]))",
+		agg, strings.Join(labelvalues, ", "), m.LogqlGenerator.Match, m.LogqlGenerator.Duration)
+}
+
+// LogMetricExpr 引用日志指标的 promql
+func LogMetricExpr(m *models.LogMetric, namespace string) string {
+	return fmt.Sprintf(`%s{namespace="%s"}`, m.Name, namespace)
+}
+
+// GenerateLogMetricRuleGroups 生成命名空间中日志指标的 loki recording rule,
+// 需要 loki ruler 配置 remote write 到 prometheus
+func GenerateLogMetricRuleGroups(namespace string, metrics []models.LogMetric) monitoringv1.PrometheusRuleSpec {
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].Name < metrics[j].Name
+	})
+	group := monitoringv1.RuleGroup{Name: "kubegems-log-metrics"}
+	for i := range metrics {
+		group.Rules = append(group.Rules, monitoringv1.Rule{
+			Record: metrics[i].Name,
+			Expr:   intstr.FromString(LogMetricLogql(&metrics[i], namespace)),
+			Labels: map[string]string{
+				prometheus.PromqlNamespaceKey: namespace,
+			},
+		})
+	}
+	return monitoringv1.PrometheusRuleSpec{Groups: []monitoringv1.RuleGroup{group}}
+}
@@ -0,0 +1,73 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observe
+
+import (
+	"testing"
+
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/gormdatatypes"
+	"kubegems.io/kubegems/pkg/utils/prometheus/promql"
+)
+
+func TestLogMetricLogql(t *testing.T) {
+	metric := &models.LogMetric{
+		Name: "payment_failed_total",
+		LogqlGenerator: &models.LogqlGenerator{
+			Duration: "1m",
+			Match:    "payment failed",
+			LabelMatchers: []promql.LabelMatcher{
+				{Type: promql.MatchRegexp, Name: "pod", Value: "payment-.*"},
+				{Type: promql.MatchEqual, Name: "namespace", Value: "other"},
+				{Type: promql.MatchEqual, Name: "container", Value: "app"},
+			},
+		},
+	}
+	want := "sum (count_over_time({container=\"app\", pod=~\"payment-.*\", namespace=\"prod\"} |~ `payment failed` [1m]))"
+	if got := LogMetricLogql(metric, "prod"); got != want {
+		t.Errorf("LogMetricLogql() = %s, want %s", got, want)
+	}
+	metric.GroupBy = gormdatatypes.JSONSlice{"pod"}
+	want = "sum by(pod) (count_over_time({container=\"app\", pod=~\"payment-.*\", namespace=\"prod\"} |~ `payment failed` [1m]))"
+	if got := LogMetricLogql(metric, "prod"); got != want {
+		t.Errorf("LogMetricLogql() = %s, want %s", got, want)
+	}
+	if got := LogMetricExpr(metric, "prod"); got != `payment_failed_total{namespace="prod"}` {
+		t.Errorf("LogMetricExpr() = %s", got)
+	}
+	if err := CheckQueryExprNamespace(LogMetricExpr(metric, "prod"), "prod"); err != nil {
+		t.Errorf("log metric expr should be allowed in the environment: %v", err)
+	}
+
+	spec := GenerateLogMetricRuleGroups("prod", []models.LogMetric{*metric})
+	if len(spec.Groups) != 1 || len(spec.Groups[0].Rules) != 1 {
+		t.Fatalf("GenerateLogMetricRuleGroups() = %v", spec)
+	}
+	if rule := spec.Groups[0].Rules[0]; rule.Record != "payment_failed_total" || rule.Labels["namespace"] != "prod" {
+		t.Errorf("unexpected rule %v", rule)
+	}
+}
+
+func TestIsLokiRecordingRulesKey(t *testing.T) {
+	for key, want := range map[string]bool{
+		LokiRecordingRulesKey:     true,
+		LogMetricRulesKey("prod"): true,
+		"prod":                    false,
+	} {
+		if got := IsLokiRecordingRulesKey(key); got != want {
+			t.Errorf("IsLokiRecordingRulesKey(%s) = %v, want %v", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observe
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	prommodel "github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"kubegems.io/kubegems/pkg/apis/gems"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+)
+
+const (
+	// 预聚合规则的 PrometheusRule 类型, 不带 name 标签, 避免被当做告警规则
+	PrometheusRuleTypeRecording = "recording"
+	// 每个命名空间的预聚合规则都写入这个 PrometheusRule
+	RecordingRuleCRDName   = "kubegems-recording-rules"
+	recordingRuleGroupName = "kubegems-recording-rules"
+)
+
+// CheckRecordingRule 检查表达式合法且限制在命名空间中
+func CheckRecordingRule(rule *models.RecordingRule, namespace string) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	if _, err := parser.ParseExpr(rule.Expr); err != nil {
+		return errors.Wrapf(err, "parse expr %s", rule.Expr)
+	}
+	return CheckQueryExprNamespace(rule.Expr, namespace)
+}
+
+func GetRecordingPrometheusRule(namespace string) *monitoringv1.PrometheusRule {
+	return &monitoringv1.PrometheusRule{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: monitoringv1.SchemeGroupVersion.String(),
+			Kind:       monitoringv1.PrometheusRuleKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      RecordingRuleCRDName,
+			Namespace: namespace,
+			Labels: map[string]string{
+				gems.LabelPrometheusRuleType: PrometheusRuleTypeRecording,
+			},
+		},
+	}
+}
+
+// GenerateRecordingRuleGroups 生成命名空间的预聚合规则组, 生成的指标都带有 namespace 标签
+func GenerateRecordingRuleGroups(namespace string, rules []models.RecordingRule) []monitoringv1.RuleGroup {
+	if len(rules) == 0 {
+		return nil
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Record < rules[j].Record
+	})
+	group := monitoringv1.RuleGroup{Name: recordingRuleGroupName}
+	for _, rule := range rules {
+		labels := map[string]string{}
+		for k, v := range rule.Labels {
+			labels[k] = v
+		}
+		labels[prometheus.PromqlNamespaceKey] = namespace
+		group.Rules = append(group.Rules, monitoringv1.Rule{
+			Record: rule.Record,
+			Expr:   intstr.FromString(rule.Expr),
+			Labels: labels,
+		})
+	}
+	return []monitoringv1.RuleGroup{group}
+}
+
+// SuggestRecordName 根据表达式生成 level:metric:operations 格式的指标名
+// eg. sum by(pod) (rate(http_requests_total[5m])) => pod:http_requests_total:sum_rate5m
+func SuggestRecordName(expr string) (string, error) {
+	e, err := parser.ParseExpr(expr)
+	if err != nil {
+		return "", err
+	}
+	level := ""
+	metric := ""
+	operations := []string{}
+	parser.Inspect(e, func(node parser.Node, _ []parser.Node) error {
+		switch n := node.(type) {
+		case *parser.AggregateExpr:
+			if level == "" && len(n.Grouping) > 0 && !n.Without {
+				level = strings.Join(n.Grouping, "_")
+			}
+			operations = append(operations, n.Op.String())
+		case *parser.Call:
+			op := n.Func.Name
+			for _, arg := range n.Args {
+				if m, ok := arg.(*parser.MatrixSelector); ok {
+					op += prommodel.Duration(m.Range).String()
+				}
+			}
+			operations = append(operations, op)
+		case *parser.VectorSelector:
+			if metric == "" {
+				metric = n.Name
+			}
+		}
+		return nil
+	})
+	if level == "" {
+		level = prometheus.PromqlNamespaceKey
+	}
+	if metric == "" {
+		return "", fmt.Errorf("no metric found in expr %s", expr)
+	}
+	if len(operations) == 0 {
+		return fmt.Sprintf("%s:%s", level, metric), nil
+	}
+	return fmt.Sprintf("%s:%s:%s", level, metric, strings.Join(operations, "_")), nil
+}
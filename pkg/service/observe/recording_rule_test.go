@@ -0,0 +1,102 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observe
+
+import (
+	"testing"
+
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/gormdatatypes"
+)
+
+func TestCheckRecordingRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    *models.RecordingRule
+		wantErr bool
+	}{
+		{
+			name: "ok",
+			rule: &models.RecordingRule{Record: "pod:http_requests_total:sum_rate5m", Expr: `sum by(pod) (rate(http_requests_total{namespace="prod"}[5m]))`},
+		},
+		{
+			name:    "invalid record",
+			rule:    &models.RecordingRule{Record: "pod-requests", Expr: `sum(http_requests_total{namespace="prod"})`},
+			wantErr: true,
+		},
+		{
+			name:    "invalid label",
+			rule:    &models.RecordingRule{Record: "requests", Expr: `sum(http_requests_total{namespace="prod"})`, Labels: gormdatatypes.JSONMap{"a-b": "c"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid expr",
+			rule:    &models.RecordingRule{Record: "requests", Expr: `sum(http_requests_total{namespace="prod"}`},
+			wantErr: true,
+		},
+		{
+			name:    "other namespace",
+			rule:    &models.RecordingRule{Record: "requests", Expr: `sum(http_requests_total{namespace="test"})`},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckRecordingRule(tt.rule, "prod"); (err != nil) != tt.wantErr {
+				t.Errorf("CheckRecordingRule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGenerateRecordingRuleGroups(t *testing.T) {
+	if groups := GenerateRecordingRuleGroups("prod", nil); len(groups) != 0 {
+		t.Errorf("GenerateRecordingRuleGroups() = %v, want empty", groups)
+	}
+	groups := GenerateRecordingRuleGroups("prod", []models.RecordingRule{
+		{Record: "b", Expr: `sum(b{namespace="prod"})`, Labels: gormdatatypes.JSONMap{"team": "x", "namespace": "other"}},
+		{Record: "a", Expr: `sum(a{namespace="prod"})`},
+	})
+	if len(groups) != 1 || len(groups[0].Rules) != 2 {
+		t.Fatalf("GenerateRecordingRuleGroups() = %v", groups)
+	}
+	if groups[0].Rules[0].Record != "a" {
+		t.Errorf("rules not sorted, got %s first", groups[0].Rules[0].Record)
+	}
+	if labels := groups[0].Rules[1].Labels; labels["namespace"] != "prod" || labels["team"] != "x" {
+		t.Errorf("unexpected labels %v", labels)
+	}
+}
+
+func TestSuggestRecordName(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{expr: `sum by(pod) (rate(http_requests_total{namespace="prod"}[5m]))`, want: "pod:http_requests_total:sum_rate5m"},
+		{expr: `histogram_quantile(0.9, sum by(le, service) (rate(latency_bucket{namespace="prod"}[1h])))`, want: "le_service:latency_bucket:histogram_quantile_sum_rate1h"},
+		{expr: `sum(container_memory_working_set_bytes{namespace="prod"})`, want: "namespace:container_memory_working_set_bytes:sum"},
+		{expr: `up{namespace="prod"}`, want: "namespace:up"},
+	}
+	for _, tt := range tests {
+		got, err := SuggestRecordName(tt.expr)
+		if err != nil {
+			t.Fatalf("SuggestRecordName(%s) error = %v", tt.expr, err)
+		}
+		if got != tt.want {
+			t.Errorf("SuggestRecordName(%s) = %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observe
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"text/template"
+	"time"
+
+	"kubegems.io/kubegems/pkg/utils"
+)
+
+// ReportData 定时报告的内容
+type ReportData struct {
+	Title        string              `json:"title"`
+	Start        time.Time           `json:"start"`
+	End          time.Time           `json:"end"`
+	Environments []EnvironmentReport `json:"environments"`
+}
+
+type EnvironmentReport struct {
+	Environment string `json:"environment"`
+	Project     string `json:"project"`
+	Cluster     string `json:"cluster"`
+	Namespace   string `json:"namespace"`
+
+	CPUUsage    float64 `json:"cpuUsage"`    // core
+	CPULimit    float64 `json:"cpuLimit"`    // 环境配额 limits.cpu, 0 表示未设置
+	MemoryUsage float64 `json:"memoryUsage"` // bytes
+	MemoryLimit float64 `json:"memoryLimit"` // 环境配额 limits.memory, 0 表示未设置
+
+	TopAlerts      []AlertCount `json:"topAlerts"`
+	ErrorLogCount  int64        `json:"errorLogCount"`
+	Deployments    int          `json:"deployments"`
+	FailedRollouts int          `json:"failedRollouts"`
+
+	// 采集失败的项, 不影响其他项的统计
+	Errors []string `json:"errors,omitempty"`
+}
+
+type AlertCount struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+var reportFuncs = map[string]interface{}{
+	"percent": func(used, total float64) string {
+		if total <= 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f%%", used/total*100)
+	},
+	"cores": func(v float64) string {
+		return fmt.Sprintf("%.2f", v)
+	},
+	"bytes": func(v float64) string {
+		if v <= 0 {
+			return "-"
+		}
+		return utils.ConvertBytes(v)
+	},
+	"datetime": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04")
+	},
+}
+
+const reportMarkdownTpl = `**{{ .Title }}**
+
+{{ datetime .Start }} ~ {{ datetime .End }}
+{{ range .Environments }}
+---
+**{{ .Project }}/{{ .Environment }}** ({{ .Cluster }}/{{ .Namespace }})
+
+- CPU: {{ cores .CPUUsage }} / {{ if .CPULimit }}{{ cores .CPULimit }}{{ else }}-{{ end }} core ({{ percent .CPUUsage .CPULimit }})
+- Memory: {{ bytes .MemoryUsage }} / {{ bytes .MemoryLimit }} ({{ percent .MemoryUsage .MemoryLimit }})
+- Error logs: {{ .ErrorLogCount }}
+- Deployments: {{ .Deployments }}, failed rollouts: {{ .FailedRollouts }}
+{{- if .TopAlerts }}
+- Top firing alerts:
+{{- range .TopAlerts }}
+  - {{ .Name }}: {{ .Count }}
+{{- end }}
+{{- end }}
+{{- range .Errors }}
+- Warning: {{ . }}
+{{- end }}
+{{ end }}`
+
+const reportHTMLTpl = `<html>
+<body>
+<h2>{{ .Title }}</h2>
+<p>{{ datetime .Start }} ~ {{ datetime .End }}</p>
+<table border="1" cellspacing="0" cellpadding="4">
+<tr><th>Environment</th><th>CPU (core)</th><th>Memory</th><th>Error logs</th><th>Deployments</th><th>Failed rollouts</th><th>Top firing alerts</th></tr>
+{{- range .Environments }}
+<tr>
+<td>{{ .Project }}/{{ .Environment }}<br/>{{ .Cluster }}/{{ .Namespace }}</td>
+<td>{{ cores .CPUUsage }} / {{ if .CPULimit }}{{ cores .CPULimit }}{{ else }}-{{ end }} ({{ percent .CPUUsage .CPULimit }})</td>
+<td>{{ bytes .MemoryUsage }} / {{ bytes .MemoryLimit }} ({{ percent .MemoryUsage .MemoryLimit }})</td>
+<td>{{ .ErrorLogCount }}</td>
+<td>{{ .Deployments }}</td>
+<td>{{ .FailedRollouts }}</td>
+<td>{{ range .TopAlerts }}{{ .Name }}: {{ .Count }}<br/>{{ end }}{{ range .Errors }}<i>{{ . }}</i><br/>{{ end }}</td>
+</tr>
+{{- end }}
+</table>
+</body>
+</html>
+`
+
+var (
+	reportMarkdown = template.Must(template.New("report").Funcs(reportFuncs).Parse(reportMarkdownTpl))
+	reportHTML     = htmltemplate.Must(htmltemplate.New("report").Funcs(reportFuncs).Parse(reportHTMLTpl))
+)
+
+func RenderReportMarkdown(data *ReportData) (string, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := reportMarkdown.Execute(buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func RenderReportHTML(data *ReportData) (string, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := reportHTML.Execute(buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observe
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderReport(t *testing.T) {
+	data := &ReportData{
+		Title: "Kubegems daily report: <demo>",
+		Start: time.Date(2022, 10, 9, 10, 0, 0, 0, time.UTC),
+		End:   time.Date(2022, 10, 10, 10, 0, 0, 0, time.UTC),
+		Environments: []EnvironmentReport{
+			{
+				Environment:    "dev",
+				Project:        "demo",
+				Cluster:        "local",
+				Namespace:      "demo-dev",
+				CPUUsage:       0.5,
+				CPULimit:       2,
+				MemoryUsage:    512 * 1024 * 1024,
+				ErrorLogCount:  12,
+				Deployments:    3,
+				FailedRollouts: 1,
+				TopAlerts:      []AlertCount{{Name: "pod-restart", Count: 4}},
+			},
+		},
+	}
+	markdown, err := RenderReportMarkdown(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"2022-10-09 10:00 ~ 2022-10-10 10:00",
+		"**demo/dev** (local/demo-dev)",
+		"- CPU: 0.50 / 2.00 core (25.0%)",
+		"(-)", // 未设置内存配额
+		"- Error logs: 12",
+		"- Deployments: 3, failed rollouts: 1",
+		"  - pod-restart: 4",
+	} {
+		if !strings.Contains(markdown, want) {
+			t.Errorf("markdown report missing %q:\n%s", want, markdown)
+		}
+	}
+
+	html, err := RenderReportHTML(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(html, "&lt;demo&gt;") || !strings.Contains(html, "pod-restart: 4") {
+		t.Errorf("unexpected html report:\n%s", html)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observe
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	prommodel "github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+	"kubegems.io/kubegems/pkg/utils/prometheus/promql"
+)
+
+// BurnRateWindow 长短窗口的燃烧率同时超过阈值时告警, 短窗口用于在问题恢复后尽快停止告警
+type BurnRateWindow struct {
+	Long  string
+	Short string
+	// 在长窗口内消耗的错误预算比例
+	BudgetConsumed float64
+}
+
+// SLOBurnRateAlert 一条燃烧率告警规则, 任一窗口组合满足时触发
+type SLOBurnRateAlert struct {
+	Suffix   string
+	Severity string
+	For      string
+	Windows  []BurnRateWindow
+}
+
+// SLOBurnRateAlerts 参考 https://sre.google/workbook/alerting-on-slos/ 的多窗口多燃烧率告警.
+// 以 30d 窗口为例, page 对应燃烧率 14.4 和 6, ticket 对应燃烧率 3 和 1
+var SLOBurnRateAlerts = []SLOBurnRateAlert{
+	{
+		Suffix:   "page",
+		Severity: prometheus.SeverityCritical,
+		For:      "2m",
+		Windows: []BurnRateWindow{
+			{Long: "1h", Short: "5m", BudgetConsumed: 0.02},
+			{Long: "6h", Short: "30m", BudgetConsumed: 0.05},
+		},
+	},
+	{
+		Suffix:   "ticket",
+		Severity: prometheus.SeverityError,
+		For:      "15m",
+		Windows: []BurnRateWindow{
+			{Long: "1d", Short: "2h", BudgetConsumed: 0.1},
+			{Long: "3d", Short: "6h", BudgetConsumed: 0.1},
+		},
+	},
+}
+
+// SLOBudgetWindows 预算面板中展示燃烧率的窗口
+var SLOBudgetWindows = []string{"1h", "6h", "1d", "3d"}
+
+// SLOAlertRuleName 燃烧率告警规则名称
+func SLOAlertRuleName(slo *models.ServiceLevelObjective, suffix string) string {
+	return fmt.Sprintf("slo-%s-%s", slo.Name, suffix)
+}
+
+// SLOErrorRatioExpr 返回 window 内不达标的比例. promql 数据源需要预先设置 PromqlGenerator.Tpl
+func SLOErrorRatioExpr(slo *models.ServiceLevelObjective, namespace, window string) (string, error) {
+	switch slo.Source {
+	case models.SLOSourceOtel:
+		selector := fmt.Sprintf(`namespace="%s", service_name="%s"`, namespace, slo.Service)
+		if slo.Type == models.SLOTypeLatency {
+			threshold := strconv.FormatFloat(slo.LatencyThreshold, 'f', -1, 64)
+			return fmt.Sprintf(`1 - (sum(rate(latency_bucket{%[1]s, le="%[2]s"}[%[3]s])) / sum(rate(latency_count{%[1]s}[%[3]s])))`,
+				selector, threshold, window), nil
+		}
+		return fmt.Sprintf(`sum(rate(calls_total{%[1]s, status_code="STATUS_CODE_ERROR"}[%[2]s])) / sum(rate(calls_total{%[1]s}[%[2]s]))`,
+			selector, window), nil
+	case models.SLOSourcePromql:
+		expr, err := sloTplExpr(slo, namespace)
+		if err != nil {
+			return "", err
+		}
+		// 统计窗口内指标满足阈值的时间比例
+		threshold := strconv.FormatFloat(slo.Threshold, 'f', -1, 64)
+		return fmt.Sprintf(`1 - avg(avg_over_time((%s %s bool %s)[%s:%s]))`,
+			expr, slo.CompareOp, threshold, window, sloResolution(window)), nil
+	default:
+		return "", fmt.Errorf("unknown slo source %s", slo.Source)
+	}
+}
+
+func sloTplExpr(slo *models.ServiceLevelObjective, namespace string) (string, error) {
+	if slo.PromqlGenerator == nil || slo.PromqlGenerator.Tpl == nil {
+		return "", fmt.Errorf("promql template of slo %s not loaded", slo.Name)
+	}
+	q, err := promql.New(slo.PromqlGenerator.Tpl.Expr)
+	if err != nil {
+		return "", err
+	}
+	q.AddLabelMatchers(&labels.Matcher{
+		Type:  labels.MatchEqual,
+		Name:  prometheus.PromqlNamespaceKey,
+		Value: namespace,
+	})
+	for _, m := range slo.PromqlGenerator.LabelMatchers {
+		q.AddLabelMatchers(m.ToPromqlLabelMatcher())
+	}
+	return q.String(), nil
+}
+
+// 子查询的精度, 窗口较长时降低精度
+func sloResolution(window string) string {
+	if d, err := parseSLODuration(window); err == nil && d <= 24*time.Hour {
+		return "1m"
+	}
+	return "5m"
+}
+
+// SLOBurnRateThreshold 长窗口内消耗 consumed 比例的预算对应的燃烧率
+func SLOBurnRateThreshold(slo *models.ServiceLevelObjective, w BurnRateWindow) (float64, error) {
+	window, err := slo.WindowDuration()
+	if err != nil {
+		return 0, err
+	}
+	long, err := parseSLODuration(w.Long)
+	if err != nil {
+		return 0, err
+	}
+	return w.BudgetConsumed * float64(window) / float64(long), nil
+}
+
+// SLOBurnRateExpr 燃烧率告警的条件表达式,超过 SLO 窗口的燃烧率窗口被忽略
+func SLOBurnRateExpr(slo *models.ServiceLevelObjective, namespace string, alert SLOBurnRateAlert) (string, error) {
+	window, err := slo.WindowDuration()
+	if err != nil {
+		return "", err
+	}
+	budget := slo.ErrorBudget()
+	conditions := []string{}
+	for _, w := range alert.Windows {
+		long, err := parseSLODuration(w.Long)
+		if err != nil {
+			return "", err
+		}
+		if long > window {
+			continue
+		}
+		factor, err := SLOBurnRateThreshold(slo, w)
+		if err != nil {
+			return "", err
+		}
+		threshold := strconv.FormatFloat(factor*budget, 'g', 6, 64)
+		longExpr, err := SLOErrorRatioExpr(slo, namespace, w.Long)
+		if err != nil {
+			return "", err
+		}
+		shortExpr, err := SLOErrorRatioExpr(slo, namespace, w.Short)
+		if err != nil {
+			return "", err
+		}
+		conditions = append(conditions, fmt.Sprintf("((%s) > %s and (%s) > %s)", longExpr, threshold, shortExpr, threshold))
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	// 整体加上括号, 最终的比较条件由告警级别追加
+	return "(" + strings.Join(conditions, " or ") + ")", nil
+}
+
+// SLOBurnRateAlertRules 生成 SLO 的燃烧率告警规则, 告警值为长窗口的错误率.
+// 比较条件放在告警级别中(> 0), 保证从 PrometheusRule 读回时能正确拆分出查询表达式
+func SLOBurnRateAlertRules(slo *models.ServiceLevelObjective, cluster, namespace string) ([]*models.AlertRule, error) {
+	receivers, err := slo.SLOAlertReceivers()
+	if err != nil {
+		return nil, err
+	}
+	if len(receivers) == 0 {
+		receivers = append(receivers, models.SLOAlertReceiver{AlertChannelID: models.DefaultChannel.ID, Interval: "1h"})
+	}
+	ret := []*models.AlertRule{}
+	for _, alert := range SLOBurnRateAlerts {
+		expr, err := SLOBurnRateExpr(slo, namespace, alert)
+		if err != nil {
+			return nil, err
+		}
+		if expr == "" {
+			continue
+		}
+		rule := &models.AlertRule{
+			Cluster:   cluster,
+			Namespace: namespace,
+			Name:      SLOAlertRuleName(slo, alert.Suffix),
+			AlertType: prometheus.AlertTypeMonitor,
+			Expr:      expr,
+			For:       alert.For,
+			Message: fmt.Sprintf("%s: [cluster:{{ $externalLabels.%s }}] SLO %s (%s%% in %s) is burning error budget too fast, error ratio: %s",
+				SLOAlertRuleName(slo, alert.Suffix), prometheus.AlertClusterKey, slo.Name,
+				strconv.FormatFloat(slo.Objective, 'f', -1, 64), slo.Window, prometheus.ValueAnnotationExpr),
+			AlertLevels: models.AlertLevels{{CompareOp: ">", CompareValue: "0", Severity: alert.Severity}},
+			IsOpen:      true,
+		}
+		for _, r := range receivers {
+			rule.Receivers = append(rule.Receivers, &models.AlertReceiver{AlertChannelID: r.AlertChannelID, Interval: r.Interval})
+		}
+		ret = append(ret, rule)
+	}
+	return ret, nil
+}
+
+func parseSLODuration(s string) (time.Duration, error) {
+	d, err := prommodel.ParseDuration(s)
+	return time.Duration(d), err
+}
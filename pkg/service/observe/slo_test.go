@@ -0,0 +1,164 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observe
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/prometheus/promql/parser"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+	"kubegems.io/kubegems/pkg/utils/prometheus/templates"
+)
+
+func TestSLOErrorRatioExpr(t *testing.T) {
+	tests := []struct {
+		name    string
+		slo     *models.ServiceLevelObjective
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "otel availability",
+			slo:  &models.ServiceLevelObjective{Source: models.SLOSourceOtel, Type: models.SLOTypeAvailability, Service: "cart"},
+			want: `sum(rate(calls_total{namespace="prod", service_name="cart", status_code="STATUS_CODE_ERROR"}[1h])) / sum(rate(calls_total{namespace="prod", service_name="cart"}[1h]))`,
+		},
+		{
+			name: "otel latency",
+			slo:  &models.ServiceLevelObjective{Source: models.SLOSourceOtel, Type: models.SLOTypeLatency, Service: "cart", LatencyThreshold: 250},
+			want: `1 - (sum(rate(latency_bucket{namespace="prod", service_name="cart", le="250"}[1h])) / sum(rate(latency_count{namespace="prod", service_name="cart"}[1h])))`,
+		},
+		{
+			name: "promql",
+			slo: &models.ServiceLevelObjective{
+				Source:    models.SLOSourcePromql,
+				Type:      models.SLOTypeLatency,
+				CompareOp: "<",
+				Threshold: 0.5,
+				PromqlGenerator: &models.PromqlGenerator{
+					Scope: "system", Resource: "http", Rule: "latency",
+					Tpl: &templates.PromqlTpl{Expr: "gems_http_latency_seconds"},
+				},
+			},
+			want: `1 - avg(avg_over_time((gems_http_latency_seconds{namespace="prod"} < bool 0.5)[1h:1m]))`,
+		},
+		{
+			name:    "promql tpl not loaded",
+			slo:     &models.ServiceLevelObjective{Source: models.SLOSourcePromql, PromqlGenerator: &models.PromqlGenerator{}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SLOErrorRatioExpr(tt.slo, "prod", "1h")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SLOErrorRatioExpr() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("SLOErrorRatioExpr() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSLOBurnRateThreshold(t *testing.T) {
+	slo := &models.ServiceLevelObjective{Window: "30d"}
+	want := []float64{14.4, 6, 3, 1}
+	got := []float64{}
+	for _, alert := range SLOBurnRateAlerts {
+		for _, w := range alert.Windows {
+			factor, err := SLOBurnRateThreshold(slo, w)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, factor)
+		}
+	}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-9 {
+			t.Errorf("SLOBurnRateThreshold() = %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestSLOBurnRateAlertRules(t *testing.T) {
+	slo := &models.ServiceLevelObjective{
+		Name:      "cart-availability",
+		Source:    models.SLOSourceOtel,
+		Type:      models.SLOTypeAvailability,
+		Service:   "cart",
+		Objective: 99.9,
+		Window:    "30d",
+	}
+	rules, err := SLOBurnRateAlertRules(slo, "cluster-a", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("SLOBurnRateAlertRules() got %d rules, want 2", len(rules))
+	}
+	page := rules[0]
+	if page.Name != "slo-cart-availability-page" || page.AlertLevels[0].Severity != prometheus.SeverityCritical {
+		t.Errorf("unexpected page rule %s %v", page.Name, page.AlertLevels)
+	}
+	// 14.4 * 0.001
+	if !strings.Contains(page.Expr, "> 0.0144 and") || strings.Count(page.Expr, " or ") != 1 {
+		t.Errorf("unexpected page expr %s", page.Expr)
+	}
+	if !strings.Contains(page.Expr, `namespace="prod"`) {
+		t.Errorf("expr must contains namespace, got %s", page.Expr)
+	}
+	if len(page.Receivers) != 1 || page.Receivers[0].AlertChannelID != models.DefaultChannel.ID {
+		t.Errorf("unexpected receivers %v", page.Receivers)
+	}
+
+	// 写入 PrometheusRule 后能够正确读回查询表达式
+	level := page.AlertLevels[0]
+	group, err := monitorAlertRuleToRaw(MonitorAlertRule{BaseAlertRule: BaseAlertRule{
+		Namespace:   page.Namespace,
+		Name:        page.Name,
+		Expr:        page.Expr,
+		AlertLevels: []AlertLevel{{CompareOp: level.CompareOp, CompareValue: level.CompareValue, Severity: level.Severity}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readback, err := rawToMonitorAlertRule(page.Namespace, group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readback.Expr != page.Expr {
+		t.Errorf("read back expr %s, want %s", readback.Expr, page.Expr)
+	}
+	if _, err := parser.ParseExpr(readback.Expr); err != nil {
+		t.Errorf("read back expr %s is invalid: %v", readback.Expr, err)
+	}
+	if got := readback.AlertLevels[0]; got.CompareOp != ">" || got.CompareValue != "0" {
+		t.Errorf("read back alert level %v", got)
+	}
+
+	// 2d 的窗口忽略 3d 的燃烧率窗口
+	slo.Window = "2d"
+	rules, err = SLOBurnRateAlertRules(slo, "cluster-a", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || strings.Contains(rules[1].Expr, "[3d]") || strings.Contains(rules[1].Expr, " or ") {
+		t.Errorf("unexpected ticket expr %s", rules[1].Expr)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observe
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 日志时间和链路时间可能有偏差, 查询日志时前后各扩展一段时间
+const traceLogTimePadding = time.Minute
+
+var traceIDReg = regexp.MustCompile(`^[0-9a-zA-Z-]+$`)
+
+func IsValidTraceID(traceID string) bool {
+	return traceIDReg.MatchString(traceID)
+}
+
+// TraceServices 链路中涉及的服务名
+func TraceServices(trace *Trace) []string {
+	set := map[string]struct{}{}
+	for _, p := range trace.Processes {
+		if p.ServiceName != "" {
+			set[p.ServiceName] = struct{}{}
+		}
+	}
+	for _, span := range trace.Spans {
+		if span.Process != nil && span.Process.ServiceName != "" {
+			set[span.Process.ServiceName] = struct{}{}
+		}
+	}
+	ret := make([]string, 0, len(set))
+	for k := range set {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// TraceTimeRange 链路的起止时间
+func TraceTimeRange(trace *Trace) (time.Time, time.Time) {
+	var start, end uint64
+	for _, span := range trace.Spans {
+		if start == 0 || span.StartTime < start {
+			start = span.StartTime
+		}
+		if span.StartTime+span.Duration > end {
+			end = span.StartTime + span.Duration
+		}
+	}
+	return time.UnixMicro(int64(start)).Add(-traceLogTimePadding), time.UnixMicro(int64(end)).Add(traceLogTimePadding)
+}
+
+// traceIDFieldPattern 匹配 json 格式 "trace_id":"xxx" 和 key=value 格式 trace_id=xxx
+func traceIDFieldPattern(fields []string) string {
+	quoted := make([]string, len(fields))
+	for i, field := range fields {
+		quoted[i] = regexp.QuoteMeta(field)
+	}
+	return fmt.Sprintf(`(?:%s)["']?\s*[:=]\s*["']?`, strings.Join(quoted, "|"))
+}
+
+// TraceLogsLogql 查询命名空间中指定服务打印的包含 trace id 字段的日志
+func TraceLogsLogql(namespace, serviceLabel string, services []string, traceID string, fields []string) string {
+	matchers := []string{fmt.Sprintf(`namespace="%s"`, namespace)}
+	if serviceLabel != "" && len(services) > 0 {
+		quoted := []string{}
+		for _, svc := range services {
+			if strings.Contains(svc, "`") {
+				continue
+			}
+			quoted = append(quoted, regexp.QuoteMeta(svc))
+		}
+		if len(quoted) > 0 {
+			matchers = append(matchers, fmt.Sprintf("%s=~`%s`", serviceLabel, strings.Join(quoted, "|")))
+		}
+	}
+	// 先用 |= 快速过滤, 再用正则确认是 trace id 字段而非其他内容
+	return fmt.Sprintf("{%s} |= `%s` |~ `%s%s`",
+		strings.Join(matchers, ", "), traceID, traceIDFieldPattern(fields), traceID)
+}
+
+// ExtractTraceID 从日志中提取 trace id, 优先按 json 解析, 否则按 key=value 格式匹配
+func ExtractTraceID(line string, fields []string) string {
+	obj := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(line), &obj); err == nil {
+		for _, field := range fields {
+			if v, ok := obj[field].(string); ok && IsValidTraceID(v) {
+				return v
+			}
+		}
+	}
+	reg, err := regexp.Compile(`(?:^|[^\w.])` + traceIDFieldPattern(fields) + `([0-9a-zA-Z-]+)`)
+	if err != nil {
+		return ""
+	}
+	if matches := reg.FindStringSubmatch(line); len(matches) == 2 {
+		return matches[1]
+	}
+	return ""
+}
@@ -0,0 +1,94 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observe
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractTraceID(t *testing.T) {
+	fields := []string{"trace_id", "traceID"}
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{
+			name: "json",
+			line: `{"level":"info","msg":"hello","traceID":"4bf92f3577b34da6a3ce929d0e0e4736"}`,
+			want: "4bf92f3577b34da6a3ce929d0e0e4736",
+		},
+		{
+			name: "logfmt",
+			line: `level=info msg=hello trace_id=4bf92f3577b34da6 span_id=00f067aa0ba902b7`,
+			want: "4bf92f3577b34da6",
+		},
+		{
+			name: "text",
+			line: `2022-10-10 10:00:00 INFO [traceID: abc123] hello`,
+			want: "abc123",
+		},
+		{
+			name: "other field with same suffix",
+			line: `level=info parent_trace_id=abc123`,
+			want: "",
+		},
+		{
+			name: "not found",
+			line: `level=info msg=hello`,
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExtractTraceID(tt.line, fields); got != tt.want {
+				t.Errorf("ExtractTraceID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTraceServicesAndLogql(t *testing.T) {
+	trace := &Trace{
+		TraceID: "abc123",
+		Spans: []Span{
+			{StartTime: 2000000, Duration: 1000},
+			{StartTime: 1000000, Duration: 5000000},
+		},
+		Processes: map[ProcessID]Process{
+			"p1": {ServiceName: "frontend"},
+			"p2": {ServiceName: "cart.v1"},
+			"p3": {ServiceName: "frontend"},
+		},
+	}
+	services := TraceServices(trace)
+	if !reflect.DeepEqual(services, []string{"cart.v1", "frontend"}) {
+		t.Errorf("TraceServices() = %v", services)
+	}
+	start, end := TraceTimeRange(trace)
+	if start.UnixMicro() != 1000000-traceLogTimePadding.Microseconds() || end.UnixMicro() != 6000000+traceLogTimePadding.Microseconds() {
+		t.Errorf("TraceTimeRange() = %v, %v", start, end)
+	}
+
+	want := "{namespace=\"ns\", app=~`cart\\.v1|frontend`} |= `abc123` |~ `(?:trace_id|traceID)[\"']?\\s*[:=]\\s*[\"']?abc123`"
+	if got := TraceLogsLogql("ns", "app", services, "abc123", []string{"trace_id", "traceID"}); got != want {
+		t.Errorf("TraceLogsLogql() = %v, want %v", got, want)
+	}
+	want = "{namespace=\"ns\"} |= `abc123` |~ `(?:trace_id)[\"']?\\s*[:=]\\s*[\"']?abc123`"
+	if got := TraceLogsLogql("ns", "", services, "abc123", []string{"trace_id"}); got != want {
+		t.Errorf("TraceLogsLogql() = %v, want %v", got, want)
+	}
+}
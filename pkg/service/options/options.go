@@ -15,6 +15,7 @@
 package options
 
 import (
+	application "kubegems.io/kubegems/pkg/service/handlers/application/options"
 	microservice "kubegems.io/kubegems/pkg/service/handlers/microservice/options"
 	"kubegems.io/kubegems/pkg/utils/argo"
 	"kubegems.io/kubegems/pkg/utils/database"
@@ -42,6 +43,7 @@ type Options struct {
 	Mysql        *database.Options                 `json:"mysql,omitempty"`
 	Redis        *redis.Options                    `json:"redis,omitempty"`
 	Microservice *microservice.MicroserviceOptions `json:"microservice,omitempty"`
+	Analysis     *application.AnalysisOptions      `json:"analysis,omitempty"`
 	Mongo        *mongo.Options                    `json:"mongo,omitempty"`
 	Models       *ModelsOptions                    `json:"models,omitempty"`
 	Edge         *EdgeOptions                      `json:"edge,omitempty"`
@@ -82,6 +84,7 @@ func DefaultOptions() *Options {
 		Redis:        redis.NewDefaultOptions(),
 		System:       system.NewDefaultOptions(),
 		Microservice: microservice.NewDefaultOptions(),
+		Analysis:     application.NewDefaultAnalysisOptions(),
 		Mongo:        mongo.DefaultOptions(),
 		Models:       NewDefaultModelsOptions(),
 		Edge:         NewDefaultEdgeOptions(),
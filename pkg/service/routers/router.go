@@ -45,8 +45,10 @@ import (
 	authsource "kubegems.io/kubegems/pkg/service/handlers/authsource"
 	"kubegems.io/kubegems/pkg/service/handlers/base"
 	clusterhandler "kubegems.io/kubegems/pkg/service/handlers/cluster"
+	costhandler "kubegems.io/kubegems/pkg/service/handlers/cost"
 	environmenthandler "kubegems.io/kubegems/pkg/service/handlers/environment"
 	eventhandler "kubegems.io/kubegems/pkg/service/handlers/event"
+	freezehandler "kubegems.io/kubegems/pkg/service/handlers/freeze"
 	loginhandler "kubegems.io/kubegems/pkg/service/handlers/login"
 	logoperatorhandler "kubegems.io/kubegems/pkg/service/handlers/logoperator"
 	logqueryhandler "kubegems.io/kubegems/pkg/service/handlers/logquery"
@@ -77,6 +79,7 @@ import (
 	"kubegems.io/kubegems/pkg/utils/prometheus/exporter"
 	"kubegems.io/kubegems/pkg/utils/redis"
 	"kubegems.io/kubegems/pkg/utils/system"
+	"kubegems.io/kubegems/pkg/utils/workflow"
 	"kubegems.io/kubegems/pkg/version"
 )
 
@@ -273,9 +276,20 @@ func (r *Router) Complete(ctx context.Context) error {
 	messageHandler.RegistRouter(rg)
 
 	// 消息
-	approveHandler := &approveHandler.ApproveHandler{BaseHandler: basehandler}
+	approveHandler := &approveHandler.ApproveHandler{
+		BaseHandler: basehandler,
+		Workflowcli: workflow.NewClientFromRedisClient(r.Redis.Client),
+	}
 	approveHandler.RegistRouter(rg)
 
+	// 部署冻结窗口
+	freezeHandler := &freezehandler.FreezeHandler{BaseHandler: basehandler}
+	freezeHandler.RegistRouter(rg)
+
+	// 费用
+	costHandler := &costhandler.CostHandler{BaseHandler: basehandler}
+	costHandler.RegistRouter(rg)
+
 	// 日志
 	lokilogHandler := &lokiloghandler.LogHandler{BaseHandler: basehandler}
 	lokilogHandler.RegistRouter(rg)
@@ -335,8 +349,9 @@ func (r *Router) Complete(ctx context.Context) error {
 	selHandler.RegistRouter(rg)
 
 	// app handler
-	appHandler := applicationhandler.MustNewApplicationDeployHandler(r.Opts.Git, r.Argo, basehandler)
+	appHandler := applicationhandler.MustNewApplicationDeployHandler(r.Opts.Git, r.Argo, r.Opts.Analysis, basehandler)
 	appHandler.RegistRouter(rg)
+	appHandler.RegistHookRouter(router.Group("v1"))
 
 	// authsource
 	authSourceHandler.RegistRouter(rg)
@@ -351,7 +366,7 @@ func (r *Router) Complete(ctx context.Context) error {
 	logoperatorHandler.RegistRouter(rg)
 
 	// observability handler
-	(&observability.ObservabilityHandler{BaseHandler: basehandler, AppStoreOpt: r.Opts.Appstore}).RegistRouter(rg)
+	(&observability.ObservabilityHandler{BaseHandler: basehandler, AppStoreOpt: r.Opts.Appstore, Argo: r.Argo}).RegistRouter(rg)
 
 	(&announcement.AnnouncementHandler{BaseHandler: basehandler}).RegistRouter(rg)
 
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 
 	monitoringv1alpha1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1alpha1"
@@ -75,6 +76,15 @@ func (c *ExtendClient) CheckAlertmanagerConfig(ctx context.Context, data *monito
 	})
 }
 
+// SendAlert 发送告警到 agent 的告警 webhook, 与 alertmanager 发送的告警一样由 msgbus 保存并通知用户
+func (c *ExtendClient) SendAlert(ctx context.Context, alert *prometheus.WebhookAlert) error {
+	return c.Inner.DoRequest(ctx, Request{
+		Method: http.MethodPost,
+		Path:   "/alert",
+		Body:   alert,
+	})
+}
+
 func (c *ExtendClient) GetPromeAlertRules(ctx context.Context, name string) (map[string]prometheus.RealTimeAlertRule, error) {
 	ret := map[string]prometheus.RealTimeAlertRule{}
 	if err := c.Inner.DoRequest(ctx, Request{
@@ -164,9 +174,19 @@ func (c *ExtendClient) PrometheusQueryRange(ctx context.Context, query, start, e
 }
 
 func (c *ExtendClient) PrometheusVector(ctx context.Context, query string) (prommodel.Vector, error) {
+	return c.prometheusVector(ctx, query, url.Values{})
+}
+
+// PrometheusVectorAt 在指定时间点执行查询
+func (c *ExtendClient) PrometheusVectorAt(ctx context.Context, query string, t time.Time) (prommodel.Vector, error) {
+	values := url.Values{}
+	values.Add("time", t.UTC().Format(time.RFC3339))
+	return c.prometheusVector(ctx, query, values)
+}
+
+func (c *ExtendClient) prometheusVector(ctx context.Context, query string, values url.Values) (prommodel.Vector, error) {
 	log.Debugf("query vector: %s", query)
 	ret := prommodel.Vector{}
-	values := url.Values{}
 	values.Add("query", query)
 	if err := c.Inner.DoRequest(ctx, Request{
 		Path:  "/custom/prometheus/v1/vector",
@@ -216,3 +236,63 @@ func (c *ExtendClient) LokiQuery(ctx context.Context, logql string) (loki.QueryR
 	}
 	return ret, nil
 }
+
+func (c *ExtendClient) LokiQueryRange(ctx context.Context, logql, start, end, step string) (loki.QueryResponseData, error) {
+	ret := loki.QueryResponseData{}
+	values := url.Values{}
+	values.Add("query", logql)
+	values.Add("start", start)
+	values.Add("end", end)
+	if step != "" {
+		values.Add("step", step)
+	}
+	if err := c.Inner.DoRequest(ctx, Request{
+		Path:  "/custom/loki/v1/queryrange",
+		Query: values,
+		Into:  WrappedResponse(&ret),
+	}); err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
+// LokiQueryLogs 按时间正序查询日志
+func (c *ExtendClient) LokiQueryLogs(ctx context.Context, logql, start, end string, limit int) (loki.QueryResponseData, error) {
+	ret := loki.QueryResponseData{}
+	values := url.Values{}
+	values.Add("query", logql)
+	values.Add("start", start)
+	values.Add("end", end)
+	values.Add("direction", "forward")
+	values.Add("limit", strconv.Itoa(limit))
+	if err := c.Inner.DoRequest(ctx, Request{
+		Path:  "/custom/loki/v1/queryrange",
+		Query: values,
+		Into:  WrappedResponse(&ret),
+	}); err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
+type ExecResult struct {
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+}
+
+// ExecPod 在容器中执行一次命令, 命令退出码不为 0 时返回错误
+func (c *ExtendClient) ExecPod(ctx context.Context, namespace, name, container string, command []string) (*ExecResult, error) {
+	ret := &ExecResult{}
+	if err := c.Inner.DoRequest(ctx, Request{
+		Method: http.MethodPost,
+		Path:   fmt.Sprintf("/custom/core/v1/namespaces/%s/pods/%s/actions/exec", namespace, name),
+		Body: map[string]interface{}{
+			"container": container,
+			"command":   command,
+		},
+		Into: WrappedResponse(ret),
+	}); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
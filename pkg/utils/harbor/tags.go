@@ -0,0 +1,53 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package harbor
+
+import (
+	"context"
+	"time"
+)
+
+type ImageTag struct {
+	Name string `json:"name"`
+	// 仅 harbor 中可以获取到推送时间
+	PushTime time.Time `json:"pushTime,omitempty"`
+}
+
+// ListImageTags 列举镜像的所有 tag, 优先使用 harbor api 以获取推送时间, 非 harbor 仓库使用 OCI Distribution 接口
+func ListImageTags(ctx context.Context, server, username, password, image string) ([]ImageTag, error) {
+	cli := NewClient(server, username, password)
+	if _, err := cli.SystemInfo(ctx); err != nil {
+		tags, err := NewOCIDistributionClient(server, username, password).ListTags(ctx, image)
+		if err != nil {
+			return nil, err
+		}
+		ret := make([]ImageTag, 0, len(tags.Tags))
+		for _, tag := range tags.Tags {
+			ret = append(ret, ImageTag{Name: tag})
+		}
+		return ret, nil
+	}
+	arts, err := cli.ListArtifact(ctx, image, GetArtifactOptions{WithTag: true})
+	if err != nil {
+		return nil, err
+	}
+	ret := []ImageTag{}
+	for _, art := range arts {
+		for _, tag := range art.Tags {
+			ret = append(ret, ImageTag{Name: tag.Name, PushTime: tag.PushTime})
+		}
+	}
+	return ret, nil
+}
@@ -49,6 +49,7 @@ const (
 	User         ResourceType = "user"
 
 	TenantResourceQuota ResourceType = "tenant-resource-quota"
+	ApprovalRequest     ResourceType = "approval-request"
 )
 
 type InvolvedObject struct {
@@ -0,0 +1,253 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package networkpolicy evaluates NetworkPolicy objects to tell whether a connection
+// between two endpoints is allowed, without sending any traffic.
+package networkpolicy
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Endpoint is the source or destination of a connection.
+type Endpoint struct {
+	Namespace       string            `json:"namespace"`
+	NamespaceLabels map[string]string `json:"namespaceLabels,omitempty"`
+	// Pod is the name of the pod, empty means any pod with Labels in the namespace.
+	Pod    string            `json:"pod,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	IP     string            `json:"ip,omitempty"`
+	// Ports are the container ports of the pod, used to resolve named ports.
+	Ports []corev1.ContainerPort `json:"-"`
+}
+
+// Verdict is the result of one direction.
+type Verdict struct {
+	Allowed bool `json:"allowed"`
+	// Isolated is true if any policy selects the endpoint in this direction.
+	Isolated bool `json:"isolated"`
+	// Policies are the policies selecting the endpoint, or the ones allowing the connection if allowed.
+	Policies []string `json:"policies,omitempty"`
+	Reason   string   `json:"reason"`
+}
+
+// Result is the result of a simulated connection.
+type Result struct {
+	Source      Endpoint        `json:"source"`
+	Destination Endpoint        `json:"destination"`
+	Protocol    corev1.Protocol `json:"protocol"`
+	Port        int32           `json:"port"`
+	Allowed     bool            `json:"allowed"`
+	Egress      Verdict         `json:"egress"`
+	Ingress     Verdict         `json:"ingress"`
+}
+
+// Simulate evaluates the policies for a connection from src to dst on protocol/port.
+// A zero port matches any port rule.
+func Simulate(policies []netv1.NetworkPolicy, src, dst Endpoint, protocol corev1.Protocol, port int32) Result {
+	if protocol == "" {
+		protocol = corev1.ProtocolTCP
+	}
+	result := Result{Source: src, Destination: dst, Protocol: protocol, Port: port}
+	result.Egress = evaluate(policies, src, dst, netv1.PolicyTypeEgress, protocol, port)
+	result.Ingress = evaluate(policies, dst, src, netv1.PolicyTypeIngress, protocol, port)
+	result.Allowed = result.Egress.Allowed && result.Ingress.Allowed
+	return result
+}
+
+// evaluate checks the policies selecting target in the direction, peer is the other side.
+func evaluate(policies []netv1.NetworkPolicy, target, peer Endpoint, direction netv1.PolicyType, protocol corev1.Protocol, port int32) Verdict {
+	selecting, allowing := []string{}, []string{}
+	// destination is the one receiving traffic, used to resolve named ports
+	destination := peer
+	if direction == netv1.PolicyTypeIngress {
+		destination = target
+	}
+	for _, policy := range policies {
+		if policy.Namespace != target.Namespace || !hasPolicyType(&policy, direction) {
+			continue
+		}
+		if !selectorMatches(&policy.Spec.PodSelector, target.Labels) {
+			continue
+		}
+		name := policy.Namespace + "/" + policy.Name
+		selecting = append(selecting, name)
+
+		if direction == netv1.PolicyTypeIngress {
+			for _, rule := range policy.Spec.Ingress {
+				if peersMatch(rule.From, policy.Namespace, peer) && portsMatch(rule.Ports, destination, protocol, port) {
+					allowing = append(allowing, name)
+					break
+				}
+			}
+		} else {
+			for _, rule := range policy.Spec.Egress {
+				if peersMatch(rule.To, policy.Namespace, peer) && portsMatch(rule.Ports, destination, protocol, port) {
+					allowing = append(allowing, name)
+					break
+				}
+			}
+		}
+	}
+	sort.Strings(selecting)
+	sort.Strings(allowing)
+
+	dir := "ingress"
+	if direction == netv1.PolicyTypeEgress {
+		dir = "egress"
+	}
+	switch {
+	case len(selecting) == 0:
+		return Verdict{Allowed: true, Reason: fmt.Sprintf("no policy selects the pod for %s, allowed by default", dir)}
+	case len(allowing) > 0:
+		return Verdict{Allowed: true, Isolated: true, Policies: allowing, Reason: fmt.Sprintf("%s allowed by policies", dir)}
+	default:
+		return Verdict{Allowed: false, Isolated: true, Policies: selecting, Reason: fmt.Sprintf("pod is isolated for %s and no rule of the selecting policies matches", dir)}
+	}
+}
+
+// hasPolicyType 未设置 PolicyTypes 时, Ingress 总是生效, Egress 仅在有 egress 规则时生效
+func hasPolicyType(policy *netv1.NetworkPolicy, t netv1.PolicyType) bool {
+	if len(policy.Spec.PolicyTypes) == 0 {
+		return t == netv1.PolicyTypeIngress || len(policy.Spec.Egress) > 0
+	}
+	for _, pt := range policy.Spec.PolicyTypes {
+		if pt == t {
+			return true
+		}
+	}
+	return false
+}
+
+// peersMatch 规则中未设置peer时匹配所有
+func peersMatch(peers []netv1.NetworkPolicyPeer, policyNamespace string, ep Endpoint) bool {
+	if len(peers) == 0 {
+		return true
+	}
+	for _, peer := range peers {
+		if peerMatches(peer, policyNamespace, ep) {
+			return true
+		}
+	}
+	return false
+}
+
+func peerMatches(peer netv1.NetworkPolicyPeer, policyNamespace string, ep Endpoint) bool {
+	if peer.IPBlock != nil {
+		return ipBlockMatches(peer.IPBlock, ep.IP)
+	}
+	if peer.NamespaceSelector == nil {
+		if ep.Namespace != policyNamespace {
+			return false
+		}
+	} else if !selectorMatches(peer.NamespaceSelector, ep.NamespaceLabels) {
+		return false
+	}
+	if peer.PodSelector == nil {
+		return true
+	}
+	return selectorMatches(peer.PodSelector, ep.Labels)
+}
+
+func ipBlockMatches(block *netv1.IPBlock, ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	_, cidr, err := net.ParseCIDR(block.CIDR)
+	if err != nil || !cidr.Contains(addr) {
+		return false
+	}
+	for _, except := range block.Except {
+		if _, exceptnet, err := net.ParseCIDR(except); err == nil && exceptnet.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// portsMatch 规则中未设置端口时匹配所有端口, port 为0时忽略端口
+func portsMatch(ports []netv1.NetworkPolicyPort, destination Endpoint, protocol corev1.Protocol, port int32) bool {
+	if len(ports) == 0 {
+		return true
+	}
+	for _, p := range ports {
+		pprotocol := corev1.ProtocolTCP
+		if p.Protocol != nil {
+			pprotocol = *p.Protocol
+		}
+		if pprotocol != protocol {
+			continue
+		}
+		if port == 0 || p.Port == nil {
+			return true
+		}
+		start := resolvePort(*p.Port, destination, protocol)
+		if start == 0 {
+			continue
+		}
+		end := start
+		if p.EndPort != nil {
+			end = *p.EndPort
+		}
+		if port >= start && port <= end {
+			return true
+		}
+	}
+	return false
+}
+
+func resolvePort(port intstr.IntOrString, destination Endpoint, protocol corev1.Protocol) int32 {
+	if port.Type == intstr.Int {
+		return port.IntVal
+	}
+	for _, cp := range destination.Ports {
+		cprotocol := cp.Protocol
+		if cprotocol == "" {
+			cprotocol = corev1.ProtocolTCP
+		}
+		if cp.Name == port.StrVal && cprotocol == protocol {
+			return cp.ContainerPort
+		}
+	}
+	return 0
+}
+
+func selectorMatches(selector *metav1.LabelSelector, lbs map[string]string) bool {
+	sel, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false
+	}
+	return sel.Matches(labels.Set(lbs))
+}
+
+// Matrix simulates connections between every pair of the endpoints, the result is indexed as [source][destination].
+func Matrix(policies []netv1.NetworkPolicy, endpoints []Endpoint, protocol corev1.Protocol, port int32) [][]Result {
+	matrix := make([][]Result, len(endpoints))
+	for i, src := range endpoints {
+		matrix[i] = make([]Result, len(endpoints))
+		for j, dst := range endpoints {
+			matrix[i][j] = Simulate(policies, src, dst, protocol, port)
+		}
+	}
+	return matrix
+}
@@ -0,0 +1,91 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networkpolicy
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestSimulate(t *testing.T) {
+	tcp := corev1.ProtocolTCP
+	http := intstr.FromString("http")
+	p443 := intstr.FromInt(443)
+
+	// namespace b 仅允许带 tenant=t1 的namespace访问 http 端口
+	denyAllB := netv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "b", Name: "default"},
+		Spec: netv1.NetworkPolicySpec{
+			PolicyTypes: []netv1.PolicyType{netv1.PolicyTypeIngress},
+			Ingress: []netv1.NetworkPolicyIngressRule{{
+				From:  []netv1.NetworkPolicyPeer{{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "t1"}}}},
+				Ports: []netv1.NetworkPolicyPort{{Protocol: &tcp, Port: &http}},
+			}},
+		},
+	}
+	// namespace a 默认拒绝出口,仅允许访问 10.0.0.0/8:443
+	egressA := netv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "a", Name: "rules"},
+		Spec: netv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			PolicyTypes: []netv1.PolicyType{netv1.PolicyTypeEgress},
+			Egress: []netv1.NetworkPolicyEgressRule{{
+				To:    []netv1.NetworkPolicyPeer{{IPBlock: &netv1.IPBlock{CIDR: "10.0.0.0/8", Except: []string{"10.1.0.0/16"}}}},
+				Ports: []netv1.NetworkPolicyPort{{Port: &p443}},
+			}},
+		},
+	}
+	policies := []netv1.NetworkPolicy{denyAllB, egressA}
+
+	web := Endpoint{Namespace: "a", NamespaceLabels: map[string]string{"tenant": "t1"}, Labels: map[string]string{"app": "web"}}
+	worker := Endpoint{Namespace: "a", NamespaceLabels: map[string]string{"tenant": "t1"}, Labels: map[string]string{"app": "worker"}}
+	other := Endpoint{Namespace: "c", NamespaceLabels: map[string]string{"tenant": "t2"}}
+	api := Endpoint{
+		Namespace: "b", NamespaceLabels: map[string]string{"tenant": "t1"}, Labels: map[string]string{"app": "api"}, IP: "10.2.0.5",
+		Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}},
+	}
+	external := Endpoint{Namespace: "", IP: "10.1.3.4"}
+
+	tests := []struct {
+		name         string
+		src, dst     Endpoint
+		port         int32
+		want         bool
+		wantPolicies []string
+	}{
+		{name: "same tenant on named port", src: worker, dst: api, port: 8080, want: true, wantPolicies: []string{"b/default"}},
+		{name: "same tenant wrong port", src: worker, dst: api, port: 9090, want: false, wantPolicies: []string{"b/default"}},
+		{name: "other tenant", src: other, dst: api, port: 8080, want: false, wantPolicies: []string{"b/default"}},
+		{name: "egress denied by port", src: web, dst: api, port: 8080, want: false},
+		{name: "egress to excepted cidr", src: web, dst: external, port: 443, want: false},
+		{name: "not isolated", src: api, dst: worker, port: 80, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Simulate(policies, tt.src, tt.dst, corev1.ProtocolTCP, tt.port)
+			if got.Allowed != tt.want {
+				t.Errorf("Simulate() allowed = %v, want %v, egress: %s, ingress: %s", got.Allowed, tt.want, got.Egress.Reason, got.Ingress.Reason)
+			}
+			if tt.wantPolicies != nil && !reflect.DeepEqual(got.Ingress.Policies, tt.wantPolicies) {
+				t.Errorf("Simulate() ingress policies = %v, want %v", got.Ingress.Policies, tt.wantPolicies)
+			}
+		})
+	}
+}
@@ -64,6 +64,10 @@ func (f *Dingding) Test(alert prometheus.WebhookAlert) error {
 	return testAlertproxy(f.formatURL(), alert)
 }
 
+func (f *Dingding) SendReport(r Report) error {
+	return postJSON(reportClient, f.formatURL(), reportToAlert(r))
+}
+
 func (f *Dingding) String() string {
 	return f.formatURL()
 }
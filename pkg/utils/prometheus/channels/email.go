@@ -17,7 +17,9 @@ package channels
 import (
 	"bytes"
 	"encoding/json"
+	"mime"
 	"strings"
+	"time"
 
 	"github.com/emersion/go-sasl"
 	"github.com/emersion/go-smtp"
@@ -95,6 +97,20 @@ func (e *Email) Test(alert prometheus.WebhookAlert) error {
 	return smtp.SendMail(e.SMTPServer, auth, e.From, receivers, buf)
 }
 
+func (e *Email) SendReport(r Report) error {
+	auth := sasl.NewPlainClient("", e.From, e.AuthPassword)
+	receivers := strings.Split(e.To, ",")
+	buf := bytes.NewBufferString("From: " + e.From + "\r\n" +
+		"To: " + e.To + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", r.Title) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/html; charset=UTF-8\r\n" +
+		"\r\n" +
+		r.HTML)
+	return smtp.SendMail(e.SMTPServer, auth, e.From, receivers, buf)
+}
+
 func (e *Email) String() string {
 	return e.SMTPServer + e.From + e.To
 }
@@ -64,6 +64,10 @@ func (f *Feishu) Test(alert prometheus.WebhookAlert) error {
 	return testAlertproxy(f.formatURL(), alert)
 }
 
+func (f *Feishu) SendReport(r Report) error {
+	return postJSON(reportClient, f.formatURL(), reportToAlert(r))
+}
+
 func (f *Feishu) String() string {
 	return f.formatURL()
 }
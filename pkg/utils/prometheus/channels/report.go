@@ -0,0 +1,92 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package channels
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+)
+
+// Report 定时报告, 邮件发送 HTML, 其他渠道发送 Markdown
+type Report struct {
+	Type     string `json:"type"` // 固定为 report, 便于 webhook 接收方区分告警
+	Title    string `json:"title"`
+	Markdown string `json:"markdown"`
+	HTML     string `json:"html"`
+}
+
+const reportAlertName = "kubegems-report"
+
+// reportTimeout 发送报告的超时时间, 避免渠道无响应时阻塞定时任务
+const reportTimeout = 30 * time.Second
+
+var reportClient = &http.Client{Timeout: reportTimeout}
+
+// ReportSender 支持发送定时报告的渠道
+type ReportSender interface {
+	SendReport(r Report) error
+}
+
+func SendReport(ch ChannelIf, r Report) error {
+	sender, ok := ch.(ReportSender)
+	if !ok {
+		return fmt.Errorf("channel %T not support sending report", ch)
+	}
+	r.Type = "report"
+	return sender.SendReport(r)
+}
+
+// reportToAlert 飞书, 钉钉通过 alertproxy 发送, 需要转换为告警格式, 报告内容放在 message 中
+func reportToAlert(r Report) prometheus.WebhookAlert {
+	now := time.Now()
+	return prometheus.WebhookAlert{
+		Status: "firing",
+		Alerts: []prometheus.Alert{
+			{
+				Status: "firing",
+				Labels: map[string]string{
+					prometheus.AlertNameLabel: reportAlertName,
+				},
+				Annotations: map[string]string{
+					prometheus.MessageAnnotationsKey: r.Title + "\n\n" + r.Markdown,
+				},
+				StartsAt: &now,
+			},
+		},
+	}
+}
+
+func postJSON(cli *http.Client, u string, obj interface{}) error {
+	buf := bytes.NewBuffer(nil)
+	if err := json.NewEncoder(buf).Encode(obj); err != nil {
+		return err
+	}
+	resp, err := cli.Post(u, "application/json", buf)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		bts, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("post %s failed, status: %d, resp: %s", u, resp.StatusCode, string(bts))
+	}
+	return nil
+}
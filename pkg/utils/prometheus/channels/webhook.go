@@ -87,6 +87,18 @@ func (w *Webhook) Test(alert prometheus.WebhookAlert) error {
 	return nil
 }
 
+func (w *Webhook) SendReport(r Report) error {
+	cli := &http.Client{Timeout: reportTimeout}
+	if w.InsecureSkipVerify {
+		cli.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+		}
+	}
+	return postJSON(cli, w.URL, r)
+}
+
 func (w *Webhook) String() string {
 	return w.URL
 }
@@ -0,0 +1,604 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"kubegems.io/kubegems/pkg/utils/prometheus/promql"
+)
+
+const (
+	// grafana 的 $__interval, $__rate_interval 等变量替换的值
+	GrafanaDefaultInterval = "5m"
+	// 导出时 grafana 的数据源变量
+	GrafanaDatasourceVariable = "datasource"
+
+	grafanaSchemaVersion = 36
+)
+
+var (
+	// $var ${var} ${var:format} [[var]] [[var:format]]
+	grafanaVariableReg = regexp.MustCompile(`\$\{(\w+)(?::[^}]*)?\}|\[\[(\w+)(?::[^\]]*)?\]\]|\$(\w+)`)
+
+	// 能够以时间序列展示的面板
+	grafanaSupportedPanels = map[string]bool{
+		"graph":      true,
+		"timeseries": true,
+		"stat":       true,
+		"singlestat": true,
+		"gauge":      true,
+		"bargauge":   true,
+	}
+
+	// 由环境的命名空间以及集群决定,导入时忽略
+	grafanaScopeLabels = map[string]bool{
+		PromqlNamespaceKey: true,
+		"cluster":          true,
+	}
+
+	// grafana unit -> kubegems unit, 原始值单位相同的直接对应,其他的使用 custom 单位
+	grafanaUnits = map[string]string{
+		"":            "",
+		"none":        "",
+		"short":       "short",
+		"bytes":       "bytes-B",
+		"decbytes":    "bytes-B",
+		"Bps":         "bytes/sec-B/s",
+		"binBps":      "bytes/sec-B/s",
+		"s":           "duration-s",
+		"percent":     "percent-0-100",
+		"percentunit": "percent-0.0-1.0",
+		"kbytes":      "custom-KB",
+		"mbytes":      "custom-MB",
+		"gbytes":      "custom-GB",
+		"KBs":         "custom-KB/s",
+		"MBs":         "custom-MB/s",
+		"ms":          "custom-ms",
+		"µs":          "custom-us",
+		"ns":          "custom-ns",
+		"reqps":       "custom-req/s",
+		"ops":         "custom-ops/s",
+	}
+)
+
+type GrafanaDashboard struct {
+	ID            *int              `json:"id"`
+	UID           string            `json:"uid,omitempty"`
+	Title         string            `json:"title"`
+	Description   string            `json:"description,omitempty"`
+	Tags          []string          `json:"tags,omitempty"`
+	Time          GrafanaTimeRange  `json:"time"`
+	Refresh       interface{}       `json:"refresh,omitempty"` // string 或者 false
+	SchemaVersion int               `json:"schemaVersion"`
+	Templating    GrafanaTemplating `json:"templating"`
+	Panels        []GrafanaPanel    `json:"panels"`
+	// schemaVersion 16 之前的面板在 rows 中
+	Rows []GrafanaRow `json:"rows,omitempty"`
+}
+
+type GrafanaTimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type GrafanaTemplating struct {
+	List []GrafanaVariable `json:"list"`
+}
+
+type GrafanaRow struct {
+	Title  string         `json:"title"`
+	Panels []GrafanaPanel `json:"panels"`
+}
+
+type GrafanaPanel struct {
+	ID          int                 `json:"id"`
+	Type        string              `json:"type"`
+	Title       string              `json:"title"`
+	Description string              `json:"description,omitempty"`
+	Datasource  interface{}         `json:"datasource,omitempty"` // 数据源名称或者 {"type": "prometheus", "uid": "xxx"}
+	GridPos     *GrafanaGridPos     `json:"gridPos,omitempty"`
+	Targets     []GrafanaTarget     `json:"targets,omitempty"`
+	FieldConfig *GrafanaFieldConfig `json:"fieldConfig,omitempty"`
+	// graph 面板的单位
+	Yaxes []GrafanaYAxis `json:"yaxes,omitempty"`
+	// singlestat 面板的单位
+	Format string `json:"format,omitempty"`
+	// 折叠的 row 中的面板
+	Panels []GrafanaPanel `json:"panels,omitempty"`
+}
+
+type GrafanaGridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type GrafanaFieldConfig struct {
+	Defaults  GrafanaFieldDefaults `json:"defaults"`
+	Overrides []interface{}        `json:"overrides"`
+}
+
+type GrafanaFieldDefaults struct {
+	Unit string `json:"unit,omitempty"`
+}
+
+type GrafanaYAxis struct {
+	Format string `json:"format,omitempty"`
+}
+
+type GrafanaTarget struct {
+	RefID        string      `json:"refId"`
+	Expr         string      `json:"expr"`
+	LegendFormat string      `json:"legendFormat,omitempty"`
+	Hide         bool        `json:"hide,omitempty"`
+	Datasource   interface{} `json:"datasource,omitempty"`
+}
+
+type GrafanaVariable struct {
+	Name       string                  `json:"name"`
+	Label      string                  `json:"label,omitempty"`
+	Type       string                  `json:"type"`
+	Query      interface{}             `json:"query"` // string 或者 {"query": "xxx"}
+	Datasource interface{}             `json:"datasource,omitempty"`
+	Current    GrafanaVariableOption   `json:"current"`
+	Options    []GrafanaVariableOption `json:"options"`
+	Multi      bool                    `json:"multi,omitempty"`
+	IncludeAll bool                    `json:"includeAll,omitempty"`
+	AllValue   string                  `json:"allValue,omitempty"`
+	Refresh    int                     `json:"refresh,omitempty"`
+	Hide       int                     `json:"hide,omitempty"`
+}
+
+type GrafanaVariableOption struct {
+	Text     interface{} `json:"text"`  // string 或者 []string
+	Value    interface{} `json:"value"` // string 或者 []string
+	Selected bool        `json:"selected,omitempty"`
+}
+
+// DashboardContent 监控面板以及面板模板共有的内容
+type DashboardContent struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Refresh     string            `json:"refresh"`
+	Start       string            `json:"start"`
+	End         string            `json:"end"`
+	Graphs      MonitorGraphs     `json:"graphs"`
+	Variables   map[string]string `json:"variables"`
+}
+
+// GrafanaImportResult grafana dashboard 导入结果
+type GrafanaImportResult struct {
+	DashboardContent
+	// grafana 变量 -> 监控面板变量(标签名)
+	VariableMapping map[string]string `json:"variableMapping"`
+	// 不支持的面板
+	UnsupportedPanels []GrafanaUnsupportedPanel `json:"unsupportedPanels"`
+	// 未使用或者无法映射为标签的变量
+	IgnoredVariables []string `json:"ignoredVariables"`
+}
+
+type GrafanaUnsupportedPanel struct {
+	ID     int    `json:"id"`
+	Title  string `json:"title"`
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// ImportGrafanaDashboard 将 grafana dashboard json 转换为监控面板.
+// 引用变量的标签匹配器转换为面板的变量(查询时作为 labelpairs),命名空间和集群相关的匹配器被移除,由环境限制查询范围
+func ImportGrafanaDashboard(data []byte) (*GrafanaImportResult, error) {
+	dashboard, err := decodeGrafanaDashboard(data)
+	if err != nil {
+		return nil, err
+	}
+	importer := &grafanaImporter{
+		constants: map[string]string{
+			"__interval":      GrafanaDefaultInterval,
+			"__rate_interval": GrafanaDefaultInterval,
+		},
+		mapping: map[string]string{},
+	}
+	for _, v := range dashboard.Templating.List {
+		switch v.Type {
+		case "constant":
+			importer.constants[v.Name] = grafanaQueryString(v.Query)
+		case "interval":
+			value := grafanaOptionValue(v.Current.Value)
+			if value == "" || strings.HasPrefix(value, "$__auto") || value == "auto" {
+				value = GrafanaDefaultInterval
+			}
+			importer.constants[v.Name] = value
+		}
+	}
+
+	ret := &GrafanaImportResult{
+		DashboardContent: DashboardContent{
+			Name:        dashboard.Title,
+			Description: dashboard.Description,
+			Start:       dashboard.Time.From,
+			End:         dashboard.Time.To,
+			Graphs:      MonitorGraphs{},
+			Variables:   map[string]string{},
+		},
+		VariableMapping:   importer.mapping,
+		UnsupportedPanels: []GrafanaUnsupportedPanel{},
+		IgnoredVariables:  []string{},
+	}
+	if refresh, ok := dashboard.Refresh.(string); ok {
+		ret.Refresh = refresh
+	}
+
+	panels := dashboard.Panels
+	for _, row := range dashboard.Rows {
+		panels = append(panels, row.Panels...)
+	}
+	for _, panel := range flattenGrafanaPanels(panels) {
+		graph, err := importer.convertPanel(panel)
+		if err != nil {
+			ret.UnsupportedPanels = append(ret.UnsupportedPanels, GrafanaUnsupportedPanel{
+				ID:     panel.ID,
+				Title:  panel.Title,
+				Type:   panel.Type,
+				Reason: err.Error(),
+			})
+			continue
+		}
+		ret.Graphs = append(ret.Graphs, *graph)
+	}
+
+	for _, label := range importer.mapping {
+		if label != "" {
+			ret.Variables[label] = ""
+		}
+	}
+	for _, v := range dashboard.Templating.List {
+		switch v.Type {
+		case "constant", "interval", "datasource":
+			continue
+		}
+		if _, ok := importer.mapping[v.Name]; !ok {
+			ret.IgnoredVariables = append(ret.IgnoredVariables, v.Name)
+		}
+	}
+	return ret, nil
+}
+
+func decodeGrafanaDashboard(data []byte) (*GrafanaDashboard, error) {
+	// 兼容 grafana api 返回的 {"dashboard": {...}, "meta": {...}}
+	wrapped := struct {
+		Dashboard *GrafanaDashboard `json:"dashboard"`
+	}{}
+	if err := json.Unmarshal(data, &wrapped); err == nil && wrapped.Dashboard != nil {
+		return wrapped.Dashboard, nil
+	}
+	dashboard := &GrafanaDashboard{}
+	if err := json.Unmarshal(data, dashboard); err != nil {
+		return nil, fmt.Errorf("invalid grafana dashboard: %w", err)
+	}
+	return dashboard, nil
+}
+
+func flattenGrafanaPanels(panels []GrafanaPanel) []GrafanaPanel {
+	ret := []GrafanaPanel{}
+	for _, panel := range panels {
+		if panel.Type == "row" {
+			ret = append(ret, flattenGrafanaPanels(panel.Panels)...)
+			continue
+		}
+		ret = append(ret, panel)
+	}
+	return ret
+}
+
+type grafanaImporter struct {
+	// 导入时直接替换的变量
+	constants map[string]string
+	// grafana 变量 -> 标签名, 命名空间等范围变量映射为空
+	mapping map[string]string
+}
+
+func (i *grafanaImporter) convertPanel(panel GrafanaPanel) (*MetricGraph, error) {
+	if !grafanaSupportedPanels[panel.Type] {
+		return nil, fmt.Errorf("panel type %s not supported", panel.Type)
+	}
+	graph := &MetricGraph{
+		Name:    panel.Title,
+		Unit:    grafanaUnitToUnit(grafanaPanelUnit(panel)),
+		Targets: []Target{},
+	}
+	if graph.Name == "" {
+		graph.Name = fmt.Sprintf("Panel %d", panel.ID)
+	}
+	names := map[string]bool{}
+	for _, target := range panel.Targets {
+		if target.Hide {
+			continue
+		}
+		datasource := target.Datasource
+		if datasource == nil {
+			datasource = panel.Datasource
+		}
+		if !isGrafanaPrometheusDatasource(datasource) {
+			return nil, fmt.Errorf("datasource %v not supported", datasource)
+		}
+		if target.Expr == "" {
+			return nil, fmt.Errorf("target %s has no promql", target.RefID)
+		}
+		expr, err := i.convertExpr(target.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("target %s: %w", target.RefID, err)
+		}
+		name := target.RefID
+		for n := 0; name == "" || names[name]; n++ {
+			name = string(rune('A' + n))
+		}
+		names[name] = true
+		graph.Targets = append(graph.Targets, Target{TargetName: name, Expr: expr})
+	}
+	if len(graph.Targets) == 0 {
+		return nil, fmt.Errorf("no prometheus query in panel")
+	}
+	return graph, nil
+}
+
+func (i *grafanaImporter) convertExpr(expr string) (string, error) {
+	expr = grafanaVariableReg.ReplaceAllStringFunc(expr, func(ref string) string {
+		if value, ok := i.constants[grafanaVariableName(ref)]; ok {
+			return value
+		}
+		return ref
+	})
+	parsed, err := parser.ParseExpr(expr)
+	if err != nil {
+		return "", fmt.Errorf("parse promql failed: %w", err)
+	}
+	parser.Inspect(parsed, func(node parser.Node, _ []parser.Node) error {
+		vs, ok := node.(*parser.VectorSelector)
+		if !ok {
+			return nil
+		}
+		matchers := []*labels.Matcher{}
+		for _, matcher := range vs.LabelMatchers {
+			refs := grafanaVariableReg.FindAllString(matcher.Value, -1)
+			if len(refs) == 0 || matcher.Name == labels.MetricName {
+				matchers = append(matchers, matcher)
+				continue
+			}
+			// 引用变量的匹配器移除, 正向匹配的标签作为面板变量
+			label := matcher.Name
+			if grafanaScopeLabels[label] ||
+				(matcher.Type != labels.MatchEqual && matcher.Type != labels.MatchRegexp) {
+				label = ""
+			}
+			for _, ref := range refs {
+				name := grafanaVariableName(ref)
+				if existing, ok := i.mapping[name]; !ok || existing == "" {
+					i.mapping[name] = label
+				}
+			}
+		}
+		vs.LabelMatchers = matchers
+		return nil
+	})
+	ret := parsed.String()
+	if refs := grafanaVariableReg.FindAllString(ret, -1); len(refs) > 0 {
+		return "", fmt.Errorf("unresolved variables %v", refs)
+	}
+	return ret, nil
+}
+
+func grafanaVariableName(ref string) string {
+	for _, match := range grafanaVariableReg.FindStringSubmatch(ref)[1:] {
+		if match != "" {
+			return match
+		}
+	}
+	return ""
+}
+
+func grafanaPanelUnit(panel GrafanaPanel) string {
+	if panel.FieldConfig != nil && panel.FieldConfig.Defaults.Unit != "" {
+		return panel.FieldConfig.Defaults.Unit
+	}
+	if len(panel.Yaxes) > 0 && panel.Yaxes[0].Format != "" {
+		return panel.Yaxes[0].Format
+	}
+	return panel.Format
+}
+
+func grafanaUnitToUnit(unit string) string {
+	if ret, ok := grafanaUnits[unit]; ok {
+		return ret
+	}
+	if strings.HasPrefix(unit, "suffix:") {
+		return "custom-" + strings.TrimPrefix(unit, "suffix:")
+	}
+	return "custom-" + unit
+}
+
+func unitToGrafanaUnit(unit string) string {
+	switch {
+	case unit == "" || unit == "short":
+		return "short"
+	case strings.HasPrefix(unit, "bytes/sec-"):
+		return "Bps"
+	case strings.HasPrefix(unit, "bytes-"):
+		return "bytes"
+	case strings.HasPrefix(unit, "duration-"):
+		return "s"
+	case unit == "percent-0-100":
+		return "percent"
+	case unit == "percent-0.0-1.0":
+		return "percentunit"
+	}
+	for grafanaUnit, u := range grafanaUnits {
+		if u == unit && strings.HasPrefix(u, "custom-") {
+			return grafanaUnit
+		}
+	}
+	return "suffix:" + strings.TrimPrefix(unit, "custom-")
+}
+
+func isGrafanaPrometheusDatasource(datasource interface{}) bool {
+	switch v := datasource.(type) {
+	case nil:
+		// 默认数据源
+		return true
+	case string:
+		return v != "-- Grafana --" && v != "-- Mixed --" && v != "-- Dashboard --"
+	case map[string]interface{}:
+		typ, _ := v["type"].(string)
+		return typ == "" || typ == "prometheus"
+	}
+	return false
+}
+
+func grafanaQueryString(query interface{}) string {
+	switch v := query.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		q, _ := v["query"].(string)
+		return q
+	}
+	return ""
+}
+
+func grafanaOptionValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []interface{}:
+		if len(v) > 0 {
+			s, _ := v[0].(string)
+			return s
+		}
+	}
+	return ""
+}
+
+// ExportGrafanaDashboard 将监控面板导出为 grafana dashboard json.
+// 查询增加命名空间以及面板变量的标签匹配器,对应 grafana 的变量. 使用模板的查询需要预先设置 Tpl
+func ExportGrafanaDashboard(content *DashboardContent) (*GrafanaDashboard, error) {
+	datasource := map[string]interface{}{"type": "prometheus", "uid": "${" + GrafanaDatasourceVariable + "}"}
+	dashboard := &GrafanaDashboard{
+		Title:         content.Name,
+		Description:   content.Description,
+		Time:          GrafanaTimeRange{From: content.Start, To: content.End},
+		SchemaVersion: grafanaSchemaVersion,
+		Panels:        []GrafanaPanel{},
+	}
+	if content.Refresh != "" {
+		dashboard.Refresh = content.Refresh
+	}
+	if dashboard.Time.From == "" || dashboard.Time.To == "" {
+		dashboard.Time = GrafanaTimeRange{From: "now-30m", To: "now"}
+	}
+
+	variables := make([]string, 0, len(content.Variables))
+	for label := range content.Variables {
+		variables = append(variables, label)
+	}
+	sort.Strings(variables)
+
+	dashboard.Templating.List = append(dashboard.Templating.List, GrafanaVariable{
+		Name:    GrafanaDatasourceVariable,
+		Label:   "Datasource",
+		Type:    "datasource",
+		Query:   "prometheus",
+		Current: GrafanaVariableOption{},
+		Options: []GrafanaVariableOption{},
+	})
+	for _, label := range append([]string{PromqlNamespaceKey}, variables...) {
+		current := GrafanaVariableOption{Text: "All", Value: "$__all"}
+		if value := content.Variables[label]; value != "" && value != "_all" {
+			current = GrafanaVariableOption{Text: value, Value: value}
+		}
+		dashboard.Templating.List = append(dashboard.Templating.List, GrafanaVariable{
+			Name:       label,
+			Type:       "query",
+			Query:      fmt.Sprintf("label_values(%s)", label),
+			Datasource: datasource,
+			Current:    current,
+			Options:    []GrafanaVariableOption{},
+			Multi:      true,
+			IncludeAll: true,
+			AllValue:   ".*",
+			Refresh:    2,
+		})
+	}
+
+	for i, graph := range content.Graphs {
+		panel := GrafanaPanel{
+			ID:         i + 1,
+			Type:       "timeseries",
+			Title:      graph.Name,
+			Datasource: datasource,
+			GridPos:    &GrafanaGridPos{H: 8, W: 12, X: (i % 2) * 12, Y: (i / 2) * 8},
+			FieldConfig: &GrafanaFieldConfig{
+				Defaults:  GrafanaFieldDefaults{Unit: unitToGrafanaUnit(graph.Unit)},
+				Overrides: []interface{}{},
+			},
+		}
+		for _, target := range graph.Targets {
+			expr, err := exportGrafanaExpr(target, variables)
+			if err != nil {
+				return nil, fmt.Errorf("graph %s target %s: %w", graph.Name, target.TargetName, err)
+			}
+			panel.Targets = append(panel.Targets, GrafanaTarget{
+				RefID:      target.TargetName,
+				Expr:       expr,
+				Datasource: datasource,
+			})
+		}
+		dashboard.Panels = append(dashboard.Panels, panel)
+	}
+	return dashboard, nil
+}
+
+func exportGrafanaExpr(target Target, variables []string) (string, error) {
+	expr := target.Expr
+	var sumby []string
+	if !target.PromqlGenerator.Notpl() {
+		if target.PromqlGenerator.Tpl == nil {
+			return "", fmt.Errorf("template %s not loaded", target.PromqlGenerator.TplString())
+		}
+		generated, err := target.PromqlGenerator.ToPromql("")
+		if err != nil {
+			return "", err
+		}
+		expr, sumby = generated, target.PromqlGenerator.Tpl.Labels
+	}
+	query, err := promql.New(expr)
+	if err != nil {
+		return "", err
+	}
+	for _, label := range append([]string{PromqlNamespaceKey}, variables...) {
+		query.AddLabelMatchers(&labels.Matcher{
+			Type:  labels.MatchRegexp,
+			Name:  label,
+			Value: "$" + label,
+		})
+	}
+	return query.Sumby(sumby...).String(), nil
+}
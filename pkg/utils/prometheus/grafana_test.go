@@ -0,0 +1,208 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"kubegems.io/kubegems/pkg/utils/prometheus/templates"
+)
+
+const testGrafanaDashboard = `{
+  "dashboard": {
+    "title": "Pods",
+    "refresh": "1m",
+    "time": {"from": "now-6h", "to": "now"},
+    "templating": {
+      "list": [
+        {"name": "datasource", "type": "datasource", "query": "prometheus"},
+        {"name": "namespace", "type": "query", "query": "label_values(kube_pod_info, namespace)"},
+        {"name": "pod", "type": "query", "query": {"query": "label_values(kube_pod_info{namespace=\"$namespace\"}, pod)"}},
+        {"name": "window", "type": "interval", "current": {"text": "10m", "value": "10m"}},
+        {"name": "quantile", "type": "constant", "query": "0.99"},
+        {"name": "node", "type": "query", "query": "label_values(node)"}
+      ]
+    },
+    "panels": [
+      {
+        "id": 1, "type": "timeseries", "title": "CPU",
+        "datasource": {"type": "prometheus", "uid": "${datasource}"},
+        "fieldConfig": {"defaults": {"unit": "percentunit"}},
+        "targets": [
+          {"refId": "A", "expr": "sum(rate(container_cpu_usage_seconds_total{namespace=\"$namespace\", pod=~\"${pod:regex}\", container!=\"\"}[$__rate_interval])) by (pod)"},
+          {"refId": "B", "expr": "up", "hide": true}
+        ]
+      },
+      {
+        "id": 2, "type": "row", "title": "Memory", "collapsed": true,
+        "panels": [
+          {
+            "id": 3, "type": "graph", "title": "Memory",
+            "yaxes": [{"format": "bytes"}],
+            "targets": [{"refId": "", "expr": "sum(container_memory_working_set_bytes{namespace=~\"[[namespace]]\", pod=~\"$pod\"}) by (pod)"}]
+          }
+        ]
+      },
+      {
+        "id": 4, "type": "stat", "title": "Latency",
+        "fieldConfig": {"defaults": {"unit": "ms"}},
+        "targets": [{"refId": "A", "expr": "histogram_quantile($quantile, sum(rate(http_request_duration_seconds_bucket{pod=~\"$pod\"}[$window])) by (le))"}]
+      },
+      {"id": 5, "type": "text", "title": "Readme"},
+      {
+        "id": 6, "type": "timeseries", "title": "Logs",
+        "datasource": {"type": "loki", "uid": "loki"},
+        "targets": [{"refId": "A", "expr": "{app=\"nginx\"}"}]
+      },
+      {
+        "id": 7, "type": "timeseries", "title": "Range",
+        "targets": [{"refId": "A", "expr": "increase(http_requests_total[$__range])"}]
+      }
+    ]
+  },
+  "meta": {}
+}`
+
+func TestImportGrafanaDashboard(t *testing.T) {
+	got, err := ImportGrafanaDashboard([]byte(testGrafanaDashboard))
+	if err != nil {
+		t.Fatalf("ImportGrafanaDashboard() error = %v", err)
+	}
+	if got.Name != "Pods" || got.Start != "now-6h" || got.End != "now" || got.Refresh != "1m" {
+		t.Errorf("ImportGrafanaDashboard() content = %+v", got.DashboardContent)
+	}
+	wantGraphs := MonitorGraphs{
+		{
+			Name: "CPU",
+			Unit: "percent-0.0-1.0",
+			Targets: []Target{
+				{TargetName: "A", Expr: `sum by(pod) (rate(container_cpu_usage_seconds_total{container!=""}[5m]))`},
+			},
+		},
+		{
+			Name: "Memory",
+			Unit: "bytes-B",
+			Targets: []Target{
+				{TargetName: "A", Expr: `sum by(pod) (container_memory_working_set_bytes)`},
+			},
+		},
+		{
+			Name: "Latency",
+			Unit: "custom-ms",
+			Targets: []Target{
+				{TargetName: "A", Expr: `histogram_quantile(0.99, sum by(le) (rate(http_request_duration_seconds_bucket[10m])))`},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got.Graphs, wantGraphs) {
+		gotjson, _ := json.MarshalIndent(got.Graphs, "", "  ")
+		t.Errorf("ImportGrafanaDashboard() graphs = %s", gotjson)
+	}
+	if want := map[string]string{"pod": ""}; !reflect.DeepEqual(got.Variables, want) {
+		t.Errorf("ImportGrafanaDashboard() variables = %v, want %v", got.Variables, want)
+	}
+	if want := map[string]string{"namespace": "", "pod": "pod"}; !reflect.DeepEqual(got.VariableMapping, want) {
+		t.Errorf("ImportGrafanaDashboard() variableMapping = %v, want %v", got.VariableMapping, want)
+	}
+	if want := []string{"node"}; !reflect.DeepEqual(got.IgnoredVariables, want) {
+		t.Errorf("ImportGrafanaDashboard() ignoredVariables = %v, want %v", got.IgnoredVariables, want)
+	}
+	unsupported := map[int]bool{}
+	for _, panel := range got.UnsupportedPanels {
+		unsupported[panel.ID] = true
+	}
+	if want := map[int]bool{5: true, 6: true, 7: true}; !reflect.DeepEqual(unsupported, want) {
+		t.Errorf("ImportGrafanaDashboard() unsupportedPanels = %+v", got.UnsupportedPanels)
+	}
+}
+
+func TestExportGrafanaDashboard(t *testing.T) {
+	content := &DashboardContent{
+		Name:    "Pods",
+		Refresh: "30s",
+		Start:   "now-30m",
+		End:     "now",
+		Graphs: MonitorGraphs{
+			{
+				Name: "Memory",
+				Unit: "bytes-MB",
+				Targets: []Target{
+					{TargetName: "A", Expr: `sum(container_memory_working_set_bytes{namespace="prod"}) by (pod)`},
+				},
+			},
+			{
+				Name: "CPU",
+				Unit: "custom-ms",
+				Targets: []Target{
+					{
+						TargetName: "A",
+						PromqlGenerator: &PromqlGenerator{
+							Scope: "containers", Resource: "container", Rule: "cpuUsage",
+							Tpl: &templates.PromqlTpl{Expr: "gems_container_cpu_usage_cores", Labels: []string{"pod"}},
+						},
+					},
+				},
+			},
+		},
+		Variables: map[string]string{"pod": ""},
+	}
+	dashboard, err := ExportGrafanaDashboard(content)
+	if err != nil {
+		t.Fatalf("ExportGrafanaDashboard() error = %v", err)
+	}
+	if len(dashboard.Panels) != 2 {
+		t.Fatalf("ExportGrafanaDashboard() got %d panels", len(dashboard.Panels))
+	}
+	if unit := dashboard.Panels[0].FieldConfig.Defaults.Unit; unit != "bytes" {
+		t.Errorf("ExportGrafanaDashboard() unit = %s, want bytes", unit)
+	}
+	if unit := dashboard.Panels[1].FieldConfig.Defaults.Unit; unit != "ms" {
+		t.Errorf("ExportGrafanaDashboard() unit = %s, want ms", unit)
+	}
+	if expr := dashboard.Panels[0].Targets[0].Expr; expr != `sum by(pod) (container_memory_working_set_bytes{namespace=~"$namespace",pod=~"$pod"})` {
+		t.Errorf("ExportGrafanaDashboard() expr = %s", expr)
+	}
+	if expr := dashboard.Panels[1].Targets[0].Expr; expr != `sum(gems_container_cpu_usage_cores{namespace=~"$namespace",pod=~"$pod"})by(pod)` {
+		t.Errorf("ExportGrafanaDashboard() expr = %s", expr)
+	}
+	names := []string{}
+	for _, v := range dashboard.Templating.List {
+		names = append(names, v.Name)
+	}
+	if want := []string{"datasource", "namespace", "pod"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ExportGrafanaDashboard() variables = %v, want %v", names, want)
+	}
+
+	// 导出的 dashboard 能够重新导入
+	data, err := json.Marshal(dashboard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := ImportGrafanaDashboard(data)
+	if err != nil {
+		t.Fatalf("ImportGrafanaDashboard() error = %v", err)
+	}
+	if len(imported.UnsupportedPanels) != 0 || len(imported.Graphs) != 2 {
+		t.Errorf("reimport got %d graphs, unsupported %+v", len(imported.Graphs), imported.UnsupportedPanels)
+	}
+	if want := map[string]string{"pod": ""}; !reflect.DeepEqual(imported.Variables, want) {
+		t.Errorf("reimport variables = %v, want %v", imported.Variables, want)
+	}
+	if expr := imported.Graphs[0].Targets[0].Expr; expr != `sum by(pod) (container_memory_working_set_bytes)` {
+		t.Errorf("reimport expr = %s", expr)
+	}
+}
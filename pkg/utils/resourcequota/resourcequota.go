@@ -206,6 +206,25 @@ func ResourceIsEnough(total, used, need corev1.ResourceList, resources []corev1.
 	return ret, msgs
 }
 
+// ResourceOverAllocated 检查划分给子级的资源总和是否超过上级的限制，超过给出超出的错误项
+func ResourceOverAllocated(hard, allocated corev1.ResourceList, resources []corev1.ResourceName) (bool, []string) {
+	over := false
+	msgs := []string{}
+	for _, resource := range resources {
+		hardv, hardExist := hard[resource]
+		allocatedv, allocatedExist := allocated[resource]
+		if !hardExist || !allocatedExist {
+			continue
+		}
+		if hardv.Cmp(allocatedv) == -1 {
+			over = true
+			msg := fmt.Sprintf("%s over allocated, limit %s but allocated %s", resource, hardv.String(), allocatedv.String())
+			msgs = append(msgs, msg)
+		}
+	}
+	return over, msgs
+}
+
 // SubResource 用新的值去减去旧的，得到差
 func SubResource(oldres, newres corev1.ResourceList) corev1.ResourceList {
 	retres := corev1.ResourceList{}
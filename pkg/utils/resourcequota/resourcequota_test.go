@@ -131,3 +131,61 @@ func TestResourceEnough(t *testing.T) {
 		})
 	}
 }
+
+func TestResourceOverAllocated(t *testing.T) {
+	resources := []corev1.ResourceName{corev1.ResourceLimitsCPU, corev1.ResourceLimitsMemory}
+	tests := []struct {
+		name      string
+		hard      corev1.ResourceList
+		allocated corev1.ResourceList
+		want      bool
+		want1     []string
+	}{
+		{
+			name: "enough",
+			hard: corev1.ResourceList{
+				corev1.ResourceLimitsCPU:    resource.MustParse("10"),
+				corev1.ResourceLimitsMemory: resource.MustParse("10Gi"),
+			},
+			allocated: corev1.ResourceList{
+				corev1.ResourceLimitsCPU:    resource.MustParse("10"),
+				corev1.ResourceLimitsMemory: resource.MustParse("8Gi"),
+			},
+			want:  false,
+			want1: []string{},
+		},
+		{
+			name: "over allocated",
+			hard: corev1.ResourceList{
+				corev1.ResourceLimitsCPU:    resource.MustParse("10"),
+				corev1.ResourceLimitsMemory: resource.MustParse("10Gi"),
+			},
+			allocated: corev1.ResourceList{
+				corev1.ResourceLimitsCPU:    resource.MustParse("12"),
+				corev1.ResourceLimitsMemory: resource.MustParse("8Gi"),
+			},
+			want:  true,
+			want1: []string{"limits.cpu over allocated, limit 10 but allocated 12"},
+		},
+		{
+			name: "not limited",
+			hard: corev1.ResourceList{},
+			allocated: corev1.ResourceList{
+				corev1.ResourceLimitsCPU: resource.MustParse("12"),
+			},
+			want:  false,
+			want1: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, got1 := ResourceOverAllocated(tt.hard, tt.allocated, resources)
+			if got != tt.want {
+				t.Errorf("ResourceOverAllocated() got = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(got1, tt.want1) {
+				t.Errorf("ResourceOverAllocated() got1 = %v, want %v", got1, tt.want1)
+			}
+		})
+	}
+}
@@ -15,6 +15,7 @@
 package worker
 
 import (
+	application "kubegems.io/kubegems/pkg/service/handlers/application/options"
 	"kubegems.io/kubegems/pkg/utils/argo"
 	"kubegems.io/kubegems/pkg/utils/database"
 	"kubegems.io/kubegems/pkg/utils/git"
@@ -25,20 +26,22 @@ import (
 )
 
 type Options struct {
-	AppStore *helm.Options               `json:"appStore,omitempty"`
-	Argo     *argo.Options               `json:"argo,omitempty"`
-	Dump     *dump.DumpOptions           `json:"dump,omitempty"`
-	Exporter *prometheus.ExporterOptions `json:"exporter,omitempty"`
-	Git      *git.Options                `json:"git,omitempty"`
-	LogLevel string                      `json:"logLevel,omitempty"`
-	Mysql    *database.Options           `json:"mysql,omitempty"`
-	Redis    *redis.Options              `json:"redis,omitempty"`
+	AppStore *helm.Options                `json:"appStore,omitempty"`
+	Argo     *argo.Options                `json:"argo,omitempty"`
+	Analysis *application.AnalysisOptions `json:"analysis,omitempty"`
+	Dump     *dump.DumpOptions            `json:"dump,omitempty"`
+	Exporter *prometheus.ExporterOptions  `json:"exporter,omitempty"`
+	Git      *git.Options                 `json:"git,omitempty"`
+	LogLevel string                       `json:"logLevel,omitempty"`
+	Mysql    *database.Options            `json:"mysql,omitempty"`
+	Redis    *redis.Options               `json:"redis,omitempty"`
 }
 
 func DefaultOptions() *Options {
 	return &Options{
 		AppStore: helm.NewDefaultOptions(),
 		Argo:     argo.NewDefaultArgoOptions(),
+		Analysis: application.NewDefaultAnalysisOptions(),
 		Dump:     dump.NewDefaultDumpOptions(),
 		Exporter: prometheus.DefaultExporterOptions(),
 		Git:      git.NewDefaultOptions(),
@@ -44,5 +44,12 @@ func (c *ResourceCache) Start() {
 	}); err != nil {
 		log.Error(err, "environment sync")
 	}
+	if _, err := cron.AddFunc("@daily", func() {
+		if err := c.CostSync(); err != nil {
+			log.Error(err, "cost sync")
+		}
+	}); err != nil {
+		log.Error(err, "cost sync")
+	}
 	cron.Start()
 }
@@ -0,0 +1,268 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resourcelist
+
+import (
+	"context"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	promemodel "github.com/prometheus/common/model"
+	v1 "k8s.io/api/core/v1"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils"
+	"kubegems.io/kubegems/pkg/utils/agents"
+)
+
+// 按 5m 采样, 除以 12 得到小时数
+const (
+	podCPURequestCoreHours_LastDay   = `sum(sum_over_time(kube_pod_container_resource_requests{resource="cpu"}[1d:5m]))by(namespace, pod) / 12`
+	podMemoryRequestGiBHours_LastDay = `sum(sum_over_time(kube_pod_container_resource_requests{resource="memory"}[1d:5m]))by(namespace, pod) / 12 / 1073741824`
+	podGPURequestHours_LastDay       = `sum(sum_over_time(kube_pod_container_resource_requests{resource="nvidia_com_gpu"}[1d:5m]))by(namespace, pod) / 12`
+	podCPUUsageCoreHours_LastDay     = `sum(sum_over_time(rate(container_cpu_usage_seconds_total{container!="", image!=""}[5m])[1d:5m]))by(namespace, pod) / 12`
+	podMemoryUsageGiBHours_LastDay   = `sum(sum_over_time(container_memory_working_set_bytes{container!="", image!=""}[1d:5m]))by(namespace, pod) / 12 / 1073741824`
+	podNode_LastDay                  = `max(max_over_time(kube_pod_info{node!=""}[1d]))by(namespace, pod, node)`
+	podWorkload_LastDay              = `max(max_over_time(gems_pod_workload[1d]))by(namespace, pod, workload)`
+	pvcStorageGiBHours_LastDay       = `sum(sum_over_time(kube_persistentvolumeclaim_resource_requests_storage_bytes[1d:5m]))by(namespace, persistentvolumeclaim) / 12 / 1073741824`
+	pvcStorageClass_LastDay          = `max(max_over_time(kube_persistentvolumeclaim_info[1d]))by(namespace, persistentvolumeclaim, storageclass)`
+
+	PVCKey          = "persistentvolumeclaim"
+	NodeKey         = "node"
+	StorageClassKey = "storageclass"
+)
+
+type podCost struct {
+	Namespace    string
+	Pod          string
+	Node         string
+	WorkloadKind string
+	WorkloadName string
+
+	CPURequest    float64
+	CPUUsage      float64
+	MemoryRequest float64
+	MemoryUsage   float64
+	GPURequest    float64
+}
+
+type pvcCost struct {
+	Namespace    string
+	StorageClass string
+	Storage      float64
+}
+
+// CostSync 统计前一天各工作负载的费用, 在每天 0 点执行
+func (c *ResourceCache) CostSync() error {
+	log.Info("start cost sync")
+	start := time.Now()
+	date := utils.DayStartTime(start.Add(-time.Hour))
+
+	prices := []models.CostPrice{}
+	if err := c.DB.DB().Find(&prices).Error; err != nil {
+		return err
+	}
+	if err := c.Agents.ExecuteInEachCluster(context.Background(), func(ctx context.Context, cli agents.Client) error {
+		envs := []models.Environment{}
+		if err := c.DB.DB().Preload("Project.Tenant").
+			Where("cluster_id = (?)", c.DB.DB().Model(&models.Cluster{}).Select("id").Where("cluster_name = ?", cli.Name())).
+			Find(&envs).Error; err != nil {
+			return err
+		}
+		nsEnvs := map[string]*models.Environment{}
+		for i := range envs {
+			nsEnvs[envs[i].Namespace] = &envs[i]
+		}
+
+		nodes := v1.NodeList{}
+		if err := cli.List(ctx, &nodes); err != nil {
+			return err
+		}
+		nodeLabels := map[string]map[string]string{}
+		for _, node := range nodes.Items {
+			nodeLabels[node.Name] = node.Labels
+		}
+
+		// 在统计日期的结束时间查询, 任务延迟执行时也只统计这一天
+		pods, pvcs, err := queryCosts(ctx, cli, date.AddDate(0, 0, 1))
+		if err != nil {
+			return err
+		}
+		records := calculateCostRecords(date, cli.Name(), nsEnvs, pods, pvcs, nodeLabels, prices)
+
+		// 重复执行时覆盖当天的记录
+		if err := c.DB.DB().Where("date = ? and cluster_name = ?", date, cli.Name()).Delete(&models.CostRecord{}).Error; err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return nil
+		}
+		return c.DB.DB().CreateInBatches(records, 100).Error
+	}); err != nil {
+		return err
+	}
+	log.Info("finish cost sync", "duration", time.Since(start).String())
+	return nil
+}
+
+func queryCosts(ctx context.Context, cli agents.Client, end time.Time) ([]*podCost, []*pvcCost, error) {
+	vectors := map[string]promemodel.Vector{}
+	for _, query := range []string{
+		podCPURequestCoreHours_LastDay, podMemoryRequestGiBHours_LastDay, podGPURequestHours_LastDay,
+		podCPUUsageCoreHours_LastDay, podMemoryUsageGiBHours_LastDay, podNode_LastDay, podWorkload_LastDay,
+		pvcStorageGiBHours_LastDay, pvcStorageClass_LastDay,
+	} {
+		vector, err := cli.Extend().PrometheusVectorAt(ctx, query, end)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to exec promql")
+		}
+		vectors[query] = vector
+	}
+
+	podMap := map[string]*podCost{}
+	getPod := func(sample *promemodel.Sample) *podCost {
+		ns, pod := string(sample.Metric[NamespaceKey]), string(sample.Metric[PodKey])
+		key := ns + "/" + pod
+		p, ok := podMap[key]
+		if !ok {
+			p = &podCost{Namespace: ns, Pod: pod}
+			podMap[key] = p
+		}
+		return p
+	}
+	setPodValue := func(query string, set func(p *podCost, v float64)) {
+		for _, sample := range vectors[query] {
+			if v := float64(sample.Value); !math.IsNaN(v) {
+				set(getPod(sample), v)
+			}
+		}
+	}
+	setPodValue(podCPURequestCoreHours_LastDay, func(p *podCost, v float64) { p.CPURequest = v })
+	setPodValue(podMemoryRequestGiBHours_LastDay, func(p *podCost, v float64) { p.MemoryRequest = v })
+	setPodValue(podGPURequestHours_LastDay, func(p *podCost, v float64) { p.GPURequest = v })
+	setPodValue(podCPUUsageCoreHours_LastDay, func(p *podCost, v float64) { p.CPUUsage = v })
+	setPodValue(podMemoryUsageGiBHours_LastDay, func(p *podCost, v float64) { p.MemoryUsage = v })
+	// 只补充有用量的 pod 的信息
+	for _, sample := range vectors[podNode_LastDay] {
+		if p, ok := podMap[string(sample.Metric[NamespaceKey])+"/"+string(sample.Metric[PodKey])]; ok {
+			p.Node = string(sample.Metric[NodeKey])
+		}
+	}
+	for _, sample := range vectors[podWorkload_LastDay] {
+		if p, ok := podMap[string(sample.Metric[NamespaceKey])+"/"+string(sample.Metric[PodKey])]; ok {
+			// eg. Deployment:nginx
+			if kind, name, found := strings.Cut(string(sample.Metric[WorkloadNameKey]), ":"); found {
+				p.WorkloadKind, p.WorkloadName = kind, name
+			}
+		}
+	}
+
+	storageClasses := map[string]string{}
+	for _, sample := range vectors[pvcStorageClass_LastDay] {
+		storageClasses[string(sample.Metric[NamespaceKey])+"/"+string(sample.Metric[PVCKey])] = string(sample.Metric[StorageClassKey])
+	}
+	pvcs := []*pvcCost{}
+	for _, sample := range vectors[pvcStorageGiBHours_LastDay] {
+		if math.IsNaN(float64(sample.Value)) {
+			continue
+		}
+		ns := string(sample.Metric[NamespaceKey])
+		pvcs = append(pvcs, &pvcCost{
+			Namespace:    ns,
+			StorageClass: storageClasses[ns+"/"+string(sample.Metric[PVCKey])],
+			Storage:      float64(sample.Value),
+		})
+	}
+
+	pods := make([]*podCost, 0, len(podMap))
+	for _, p := range podMap {
+		pods = append(pods, p)
+	}
+	return pods, pvcs, nil
+}
+
+// calculateCostRecords 按工作负载汇总环境中 pod 和 pvc 的费用, 不属于环境的命名空间不统计
+func calculateCostRecords(date time.Time, cluster string, nsEnvs map[string]*models.Environment,
+	pods []*podCost, pvcs []*pvcCost, nodeLabels map[string]map[string]string, prices []models.CostPrice,
+) []*models.CostRecord {
+	records := map[string]*models.CostRecord{}
+	unpricedNodes := map[string]bool{}
+	getRecord := func(ns, kind, name string) *models.CostRecord {
+		env, ok := nsEnvs[ns]
+		if !ok {
+			return nil
+		}
+		key := ns + "/" + kind + "/" + name
+		r, ok := records[key]
+		if !ok {
+			r = &models.CostRecord{
+				Date:            date,
+				ClusterName:     cluster,
+				Namespace:       ns,
+				TenantID:        env.Project.TenantID,
+				TenantName:      env.Project.Tenant.TenantName,
+				ProjectID:       env.ProjectID,
+				ProjectName:     env.Project.ProjectName,
+				EnvironmentID:   env.ID,
+				EnvironmentName: env.EnvironmentName,
+				WorkloadKind:    kind,
+				WorkloadName:    name,
+			}
+			records[key] = r
+		}
+		return r
+	}
+
+	for _, p := range pods {
+		kind, name := p.WorkloadKind, p.WorkloadName
+		if name == "" {
+			kind, name = "Pod", p.Pod
+		}
+		r := getRecord(p.Namespace, kind, name)
+		if r == nil {
+			continue
+		}
+		r.CPURequestCoreHours += p.CPURequest
+		r.CPUUsageCoreHours += p.CPUUsage
+		r.MemoryRequestGiBHours += p.MemoryRequest
+		r.MemoryUsageGiBHours += p.MemoryUsage
+		r.GPUHours += p.GPURequest
+		if price := models.MatchNodePrice(prices, cluster, nodeLabels[p.Node]); price != nil {
+			r.CPUCost += math.Max(p.CPURequest, p.CPUUsage) * price.CPUCoreHour
+			r.MemoryCost += math.Max(p.MemoryRequest, p.MemoryUsage) * price.MemoryGiBHour
+			r.GPUCost += p.GPURequest * price.GPUHour
+		} else if !unpricedNodes[p.Node] {
+			unpricedNodes[p.Node] = true
+			log.Warnf("node %s in cluster %s matches no cost price, cpu, memory and gpu cost of its pods not counted", p.Node, cluster)
+		}
+	}
+	for _, pvc := range pvcs {
+		r := getRecord(pvc.Namespace, "", "")
+		if r == nil {
+			continue
+		}
+		r.StorageGiBHours += pvc.Storage
+		r.StorageCost += pvc.Storage * models.MatchStoragePrice(prices, cluster, pvc.StorageClass)
+	}
+
+	ret := make([]*models.CostRecord, 0, len(records))
+	for _, r := range records {
+		r.TotalCost = r.CPUCost + r.MemoryCost + r.GPUCost + r.StorageCost
+		ret = append(ret, r)
+	}
+	return ret
+}
@@ -0,0 +1,50 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"time"
+
+	"kubegems.io/kubegems/pkg/service/handlers/observability"
+	"kubegems.io/kubegems/pkg/utils/workflow"
+)
+
+const TaskFunction_EvaluateAnomalyDetectors = "evaluate-anomaly-detectors"
+
+// AnomalyTasker 定时执行异常检测, 每个检测按照自己的 step 间隔执行
+type AnomalyTasker struct {
+	*observability.AnomalyProcessor
+}
+
+func (t *AnomalyTasker) ProvideFuntions() map[string]interface{} {
+	return map[string]interface{}{
+		TaskFunction_EvaluateAnomalyDetectors: t.EvaluateAnomalyDetectors,
+	}
+}
+
+func (t *AnomalyTasker) Crontasks() map[string]Task {
+	return map[string]Task{
+		"@every 1m": {
+			Name:  "evaluate anomaly detectors",
+			Group: "anomaly",
+			Steps: []workflow.Step{{Function: TaskFunction_EvaluateAnomalyDetectors}},
+		},
+	}
+}
+
+func (t *AnomalyTasker) EvaluateAnomalyDetectors(ctx context.Context) error {
+	return t.EvaluateDetectors(ctx, time.Now())
+}
@@ -16,22 +16,39 @@ package task
 
 import (
 	"kubegems.io/kubegems/pkg/service/handlers/application"
+	"kubegems.io/kubegems/pkg/service/handlers/application/options"
 	"kubegems.io/kubegems/pkg/utils/agents"
 	"kubegems.io/kubegems/pkg/utils/argo"
 	"kubegems.io/kubegems/pkg/utils/database"
 	"kubegems.io/kubegems/pkg/utils/git"
 	"kubegems.io/kubegems/pkg/utils/redis"
+	"kubegems.io/kubegems/pkg/utils/workflow"
 )
 
 type ApplicationTasker struct {
 	*application.ApplicationProcessor
 }
 
-func MustNewApplicationTasker(db *database.Database, gitp *git.SimpleLocalProvider, argo *argo.Client, redis *redis.Client, agents *agents.ClientSet) *ApplicationTasker {
-	app := application.NewApplicationProcessor(db, gitp, argo, redis, agents)
+func MustNewApplicationTasker(db *database.Database, gitp *git.SimpleLocalProvider, argo *argo.Client, redis *redis.Client, agents *agents.ClientSet, analysis *options.AnalysisOptions) *ApplicationTasker {
+	app := application.NewApplicationProcessor(db, gitp, argo, redis, agents, analysis)
 	return &ApplicationTasker{ApplicationProcessor: app}
 }
 
 func (t *ApplicationTasker) ProvideFuntions() map[string]interface{} {
 	return t.ApplicationProcessor.ProvideFuntions()
 }
+
+func (t *ApplicationTasker) Crontasks() map[string]Task {
+	return map[string]Task{
+		"@every 5m": {
+			Name:  "check image update policies",
+			Group: "application",
+			Steps: []workflow.Step{{Function: application.TaskFunction_Application_CheckImagePolicies}},
+		},
+		"@every 1m": {
+			Name:  "run scheduled backup plans",
+			Group: "application",
+			Steps: []workflow.Step{{Function: application.TaskFunction_Application_RunBackupPlans}},
+		},
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"time"
+
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/handlers/application"
+	"kubegems.io/kubegems/pkg/service/models/cache"
+	"kubegems.io/kubegems/pkg/utils/workflow"
+)
+
+const TaskFunction_CleanupExpiredPreviews = "cleanup-expired-previews"
+
+// PreviewEnvironmentTasker 清理过期的预览环境
+type PreviewEnvironmentTasker struct {
+	*application.ApplicationProcessor
+	ModelCache *cache.ModelCache
+}
+
+func (t *PreviewEnvironmentTasker) ProvideFuntions() map[string]interface{} {
+	return map[string]interface{}{
+		TaskFunction_CleanupExpiredPreviews: t.CleanupExpiredPreviews,
+	}
+}
+
+func (t *PreviewEnvironmentTasker) Crontasks() map[string]Task {
+	return map[string]Task{
+		"@every 5m": {
+			Name:  "cleanup expired preview environments",
+			Group: "preview",
+			Steps: []workflow.Step{{Function: TaskFunction_CleanupExpiredPreviews}},
+		},
+	}
+}
+
+func (t *PreviewEnvironmentTasker) CleanupExpiredPreviews(ctx context.Context) error {
+	log := log.FromContextOrDiscard(ctx)
+	previews, err := t.ExpiredPreviews(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	for i := range previews {
+		preview := &previews[i]
+		if err := t.TeardownPreview(ctx, preview); err != nil {
+			// 单个失败不影响其他预览环境的清理,下次继续重试
+			log.Error(err, "teardown preview environment", "preview", preview.ID, "branch", preview.Branch)
+			continue
+		}
+		env := preview.Environment
+		if err := t.ModelCache.DelEnvironment(env.ProjectID, env.ID, env.Cluster.ClusterName, env.Namespace); err != nil {
+			log.Error(err, "delete environment cache", "environment", env.EnvironmentName)
+		}
+		log.Info("preview environment expired and removed", "environment", env.EnvironmentName)
+	}
+	return nil
+}
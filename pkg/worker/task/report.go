@@ -0,0 +1,50 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"time"
+
+	"kubegems.io/kubegems/pkg/service/handlers/observability"
+	"kubegems.io/kubegems/pkg/utils/workflow"
+)
+
+const TaskFunction_SendScheduledReports = "send-scheduled-reports"
+
+// ReportTasker 发送到期的定时报告
+type ReportTasker struct {
+	*observability.ReportProcessor
+}
+
+func (t *ReportTasker) ProvideFuntions() map[string]interface{} {
+	return map[string]interface{}{
+		TaskFunction_SendScheduledReports: t.SendScheduledReports,
+	}
+}
+
+func (t *ReportTasker) Crontasks() map[string]Task {
+	return map[string]Task{
+		"@every 1h": {
+			Name:  "send scheduled reports",
+			Group: "report",
+			Steps: []workflow.Step{{Function: TaskFunction_SendScheduledReports}},
+		},
+	}
+}
+
+func (t *ReportTasker) SendScheduledReports(ctx context.Context) error {
+	return t.SendDueReports(ctx, time.Now())
+}
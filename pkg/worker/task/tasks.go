@@ -22,6 +22,9 @@ import (
 	"github.com/go-redsync/redsync/v4/redis/goredis/v8"
 	"golang.org/x/sync/errgroup"
 	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/handlers/application/options"
+	"kubegems.io/kubegems/pkg/service/handlers/observability"
+	"kubegems.io/kubegems/pkg/service/models/cache"
 	"kubegems.io/kubegems/pkg/utils/agents"
 	"kubegems.io/kubegems/pkg/utils/argo"
 	"kubegems.io/kubegems/pkg/utils/database"
@@ -53,6 +56,7 @@ func Run(ctx context.Context, rediscli *redis.Client,
 	gitp *git.SimpleLocalProvider,
 	argocd *argo.Client,
 	helmOptions *helm.Options,
+	analysisOptions *options.AnalysisOptions,
 	agents *agents.ClientSet,
 ) error {
 
@@ -64,12 +68,18 @@ func Run(ctx context.Context, rediscli *redis.Client,
 		Logger:    log.FromContextOrDiscard(ctx),
 	}
 
+	apptasker := MustNewApplicationTasker(db, gitp, argocd, rediscli, agents, analysisOptions)
 	// 注册支持的处理函数
 	taskers := []Tasker{
 		// 示例
 		&SampleTasker{},
 		// application 应用部署相关
-		MustNewApplicationTasker(db, gitp, argocd, rediscli, agents),
+		apptasker,
+		// preview 清理过期的预览环境
+		&PreviewEnvironmentTasker{
+			ApplicationProcessor: apptasker.ApplicationProcessor,
+			ModelCache:           &cache.ModelCache{DB: db.DB(), Redis: rediscli},
+		},
 		// task-archive 持久化过期任务至database
 		NewTaskArchiverTasker(db, rediscli),
 		// chart-sync 同步helmchart
@@ -78,6 +88,10 @@ func Run(ctx context.Context, rediscli *redis.Client,
 		&ClusterSyncTasker{DB: db, cs: agents},
 		// alertrule
 		&AlertRuleSyncTasker{DB: db, cs: agents},
+		// report 发送定时报告
+		&ReportTasker{ReportProcessor: observability.NewReportProcessor(db, agents, argocd)},
+		// anomaly 异常检测
+		&AnomalyTasker{AnomalyProcessor: observability.NewAnomalyProcessor(db, agents)},
 	}
 	if err := p.RegisterTasker(taskers...); err != nil {
 		return err